@@ -0,0 +1,1030 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-redis/ae"
+	"go-redis/http"
+	"go-redis/obj"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 用户标识
+const (
+	USER_FLAG_ENABLED  = 1 << 0
+	USER_FLAG_DISABLED = 1 << 1
+	USER_FLAG_NOPASS   = 1 << 2 // 任何密码都可以认证
+)
+
+// 键模式的权限
+const (
+	ACL_READ_PERMISSION  = 1 << 0
+	ACL_WRITE_PERMISSION = 1 << 1
+	ACL_ALL_PERMISSION   = ACL_READ_PERMISSION | ACL_WRITE_PERMISSION
+)
+
+// 权限检查的结果
+const (
+	ACL_OK = iota
+	ACL_DENIED_CMD
+	ACL_DENIED_KEY
+	ACL_DENIED_AUTH
+	ACL_DENIED_CHANNEL
+)
+
+// 与 redis 一致，相同的拒绝在 60 秒内合并为一条日志
+const ACL_LOG_GROUPING_MAX_TIME_DELTA = 60000
+
+type aclKeyPattern struct {
+	pattern string
+	flags   int // ACL_READ_PERMISSION | ACL_WRITE_PERMISSION
+}
+
+// aclUser 只有一个选择器，命令、键与频道的权限直接保存在用户上
+type aclUser struct {
+	name            string
+	flags           int
+	passwords       []string // 密码的 sha256，十六进制小写
+	allowedCommands []bool   // 下标为命令编号
+	cmdRules        []string // 命令规则的描述，例如 -@all +get
+	allkeys         bool
+	patterns        []aclKeyPattern
+	allchannels     bool
+	channels        []string
+}
+
+type aclLogEntry struct {
+	count      int64
+	reason     int
+	context    string
+	object     string
+	username   string
+	ctime      int64 // 毫秒
+	cinfo      string
+	entryId    int64
+	createTime int64 // 毫秒
+	updateTime int64 // 毫秒
+}
+
+// newACLUser 新用户默认关闭，没有任何权限
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:            name,
+		flags:           USER_FLAG_DISABLED,
+		allowedCommands: make([]bool, commandCount),
+		cmdRules:        []string{"-@all"},
+	}
+}
+
+func (u *aclUser) dup() *aclUser {
+	n := *u
+	n.passwords = append([]string(nil), u.passwords...)
+	n.allowedCommands = append([]bool(nil), u.allowedCommands...)
+	n.cmdRules = append([]string(nil), u.cmdRules...)
+	n.patterns = append([]aclKeyPattern(nil), u.patterns...)
+	n.channels = append([]string(nil), u.channels...)
+	return &n
+}
+
+// createDefaultUser default 用户默认开启，不需要密码，拥有全部权限
+func createDefaultUser() *aclUser {
+	u := newACLUser("default")
+	for _, op := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		aclSetUser(u, op)
+	}
+	return u
+}
+
+// aclInit 创建用户表与 default 用户，requirepass 设置 default 用户的密码
+func aclInit(requirepass string) {
+	server.users = make(map[string]*aclUser)
+	server.defaultUser = createDefaultUser()
+	server.users["default"] = server.defaultUser
+	if requirepass != "" {
+		aclSetUser(server.defaultUser, "resetpass")
+		aclSetUser(server.defaultUser, ">"+requirepass)
+	}
+	server.aclLog = nil
+	server.aclLogEntryCount = 0
+}
+
+func aclHashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isValidPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if !(hash[i] >= '0' && hash[i] <= '9') && !(hash[i] >= 'a' && hash[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// aclSetCommand 设置命令及其子命令是否允许执行
+func aclSetCommand(u *aclUser, cmd *RedisCommand, allow bool) {
+	u.allowedCommands[cmd.id] = allow
+	for i := range cmd.subcommands {
+		u.allowedCommands[cmd.subcommands[i].id] = allow
+	}
+}
+
+// aclSetCommandCategory 设置类别中所有命令是否允许执行，cat 为 0 表示 @all
+func aclSetCommandCategory(u *aclUser, cat int, allow bool) {
+	for _, cmd := range commandTable {
+		if cat == 0 || cmd.aclCategories&cat != 0 {
+			u.allowedCommands[cmd.id] = allow
+		}
+		for i := range cmd.subcommands {
+			if cat == 0 || cmd.subcommands[i].aclCategories&cat != 0 {
+				u.allowedCommands[cmd.subcommands[i].id] = allow
+			}
+		}
+	}
+}
+
+func aclRemovePassword(u *aclUser, hash string) bool {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// aclSetUser 按 redis 的规则修改用户，出错时用户可能已被部分修改，需要在副本上执行
+func aclSetUser(u *aclUser, op string) error {
+	lop := strings.ToLower(op)
+	switch {
+	case lop == "on":
+		u.flags = u.flags&^USER_FLAG_DISABLED | USER_FLAG_ENABLED
+	case lop == "off":
+		u.flags = u.flags&^USER_FLAG_ENABLED | USER_FLAG_DISABLED
+	case lop == "nopass":
+		u.flags |= USER_FLAG_NOPASS
+		u.passwords = nil
+	case lop == "resetpass":
+		u.flags &^= USER_FLAG_NOPASS
+		u.passwords = nil
+	case lop == "allkeys" || op == "~*":
+		u.allkeys = true
+		u.patterns = nil
+	case lop == "resetkeys":
+		u.allkeys = false
+		u.patterns = nil
+	case lop == "allchannels" || op == "&*":
+		u.allchannels = true
+		u.channels = nil
+	case lop == "resetchannels":
+		u.allchannels = false
+		u.channels = nil
+	case lop == "allcommands" || lop == "+@all":
+		aclSetCommandCategory(u, 0, true)
+		u.cmdRules = []string{"+@all"}
+	case lop == "nocommands" || lop == "-@all":
+		aclSetCommandCategory(u, 0, false)
+		u.cmdRules = []string{"-@all"}
+	case lop == "reset":
+		for _, o := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			aclSetUser(u, o)
+		}
+	case op[0] == '>' || op[0] == '#':
+		hash := op[1:]
+		if op[0] == '>' {
+			hash = aclHashPassword(op[1:])
+		} else if !isValidPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		aclRemovePassword(u, hash)
+		u.passwords = append(u.passwords, hash)
+		u.flags &^= USER_FLAG_NOPASS
+	case op[0] == '<' || op[0] == '!':
+		hash := op[1:]
+		if op[0] == '<' {
+			hash = aclHashPassword(op[1:])
+		} else if !isValidPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		if !aclRemovePassword(u, hash) {
+			return errors.New("The password you are trying to remove from the user does not exist")
+		}
+	case op[0] == '~' || op[0] == '%':
+		// ~pattern 可读写，%R~、%W~、%RW~ 分别指定读、写权限
+		flags, pattern := ACL_ALL_PERMISSION, op[1:]
+		if op[0] == '%' {
+			i := strings.IndexByte(op, '~')
+			if i < 0 {
+				return errors.New("Syntax error")
+			}
+			flags = 0
+			for _, p := range strings.ToUpper(op[1:i]) {
+				switch p {
+				case 'R':
+					flags |= ACL_READ_PERMISSION
+				case 'W':
+					flags |= ACL_WRITE_PERMISSION
+				default:
+					return errors.New("Syntax error")
+				}
+			}
+			if flags == 0 {
+				return errors.New("Syntax error")
+			}
+			pattern = op[i+1:]
+		}
+		if u.allkeys {
+			return errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. " +
+				"Try 'resetkeys' to start with an empty list of patterns")
+		}
+		if flags == ACL_ALL_PERMISSION && pattern == "*" {
+			return aclSetUser(u, "allkeys")
+		}
+		u.patterns = append(u.patterns, aclKeyPattern{pattern: pattern, flags: flags})
+	case op[0] == '&':
+		if u.allchannels {
+			return errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. " +
+				"Try 'resetchannels' to start with an empty list of channels")
+		}
+		u.channels = append(u.channels, op[1:])
+	case (op[0] == '+' || op[0] == '-') && len(op) > 1:
+		allow := op[0] == '+'
+		if op[1] == '@' {
+			cat := aclGetCategoryByName(op[2:])
+			if cat == 0 {
+				return errors.New("Unknown command or category name in ACL")
+			}
+			aclSetCommandCategory(u, cat, allow)
+		} else {
+			cmd := lookupCommandByFullname(op[1:])
+			if cmd == nil {
+				return errors.New("Unknown command or category name in ACL")
+			}
+			aclSetCommand(u, cmd, allow)
+		}
+		u.cmdRules = append(u.cmdRules, lop)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// aclSetUserRules 在副本上执行全部规则，都成功后才生效，客户端持有的用户指针保持不变
+func aclSetUserRules(u *aclUser, rules []string) (string, error) {
+	n := u.dup()
+	for _, op := range rules {
+		if op == "" {
+			return op, errors.New("Syntax error")
+		}
+		if err := aclSetUser(n, op); err != nil {
+			return op, err
+		}
+	}
+	*u = *n
+	return "", nil
+}
+
+func aclDescribeKeys(u *aclUser) string {
+	if u.allkeys {
+		return "~*"
+	}
+	var parts []string
+	for _, p := range u.patterns {
+		switch p.flags {
+		case ACL_ALL_PERMISSION:
+			parts = append(parts, "~"+p.pattern)
+		case ACL_READ_PERMISSION:
+			parts = append(parts, "%R~"+p.pattern)
+		case ACL_WRITE_PERMISSION:
+			parts = append(parts, "%W~"+p.pattern)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func aclDescribeChannels(u *aclUser) string {
+	if u.allchannels {
+		return "&*"
+	}
+	parts := make([]string, len(u.channels))
+	for i, ch := range u.channels {
+		parts[i] = "&" + ch
+	}
+	return strings.Join(parts, " ")
+}
+
+// aclDescribeUser 以规则的形式描述用户，ACL LIST 与 ACL SAVE 使用
+func aclDescribeUser(u *aclUser) string {
+	parts := []string{"user", u.name}
+	if u.flags&USER_FLAG_ENABLED != 0 {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.flags&USER_FLAG_NOPASS != 0 {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if keys := aclDescribeKeys(u); keys != "" {
+		parts = append(parts, keys)
+	}
+	if !u.allchannels {
+		parts = append(parts, "resetchannels")
+	}
+	if channels := aclDescribeChannels(u); channels != "" {
+		parts = append(parts, channels)
+	}
+	parts = append(parts, u.cmdRules...)
+	return strings.Join(parts, " ")
+}
+
+// aclCheckUserCredentials 检查用户名与密码，成功时返回用户
+func aclCheckUserCredentials(username, password string) *aclUser {
+	u := server.users[username]
+	if u == nil || u.flags&USER_FLAG_DISABLED != 0 {
+		return nil
+	}
+	if u.flags&USER_FLAG_NOPASS != 0 {
+		return u
+	}
+	hash := aclHashPassword(password)
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// authRequired default 用户需要密码或者被关闭，且客户端还没有认证时返回 true，主节点的连接不需要认证
+func authRequired(c *RedisClient) bool {
+	return (server.defaultUser.flags&USER_FLAG_NOPASS == 0 || server.defaultUser.flags&USER_FLAG_DISABLED != 0) &&
+		!c.authenticated && c.flags&CLIENT_MASTER == 0
+}
+
+// authenticateClient 认证成功时切换用户，失败时回复错误并记录日志
+func authenticateClient(c *RedisClient, username, password string) bool {
+	u := aclCheckUserCredentials(username, password)
+	if u == nil {
+		addACLLogEntry(c, ACL_DENIED_AUTH, 0, username, "")
+		c.AddReplyError("-WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+	c.user = u
+	c.authenticated = true
+	return true
+}
+
+func aclUserCheckKeyPerm(u *aclUser, key string, flags int) bool {
+	if u.allkeys {
+		return true
+	}
+	for _, p := range u.patterns {
+		if p.flags&flags == flags && stringMatch(p.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclUserCheckChannelPerm 订阅模式时要求与频道模式完全相同，避免通过更宽的模式收到没有权限的消息
+func aclUserCheckChannelPerm(u *aclUser, channel string, isPattern bool) bool {
+	if u.allchannels {
+		return true
+	}
+	for _, p := range u.channels {
+		if (isPattern && p == channel) || (!isPattern && stringMatch(p, channel, false)) {
+			return true
+		}
+	}
+	return false
+}
+
+// getChannelsFromCommand 返回命令中频道的位置，以及是否为模式
+func getChannelsFromCommand(cmd *RedisCommand, args []*obj.RedisObj) ([]int, bool) {
+	var idx []int
+	switch cmd.name {
+	case "publish":
+		idx = []int{1}
+	case "subscribe", "psubscribe":
+		for i := 1; i < len(args); i++ {
+			idx = append(idx, i)
+		}
+	}
+	return idx, cmd.name == "psubscribe"
+}
+
+// aclCheckAllUserPerm 检查用户能否执行命令，拒绝时返回原因与出错参数的位置
+func aclCheckAllUserPerm(u *aclUser, cmd *RedisCommand, args []*obj.RedisObj) (int, int) {
+	if !u.allowedCommands[cmd.id] {
+		return ACL_DENIED_CMD, 0
+	}
+	if !u.allkeys {
+		flags := commandKeySpecFlags(cmd)
+		perm := 0
+		if flags&CMD_KEY_ACCESS != 0 {
+			perm |= ACL_READ_PERMISSION
+		}
+		if flags&(CMD_KEY_INSERT|CMD_KEY_DELETE|CMD_KEY_UPDATE) != 0 {
+			perm |= ACL_WRITE_PERMISSION
+		}
+		for _, i := range getKeysFromCommand(cmd, args) {
+			if !aclUserCheckKeyPerm(u, args[i].StrVal(), perm) {
+				return ACL_DENIED_KEY, i
+			}
+		}
+	}
+	if !u.allchannels {
+		idx, isPattern := getChannelsFromCommand(cmd, args)
+		for _, i := range idx {
+			if !aclUserCheckChannelPerm(u, args[i].StrVal(), isPattern) {
+				return ACL_DENIED_CHANNEL, i
+			}
+		}
+	}
+	return ACL_OK, 0
+}
+
+// aclCheckAllPerm 检查客户端能否执行当前命令，主节点的连接不受限制
+func aclCheckAllPerm(c *RedisClient, cmd *RedisCommand) (int, int) {
+	if c.flags&CLIENT_MASTER != 0 {
+		return ACL_OK, 0
+	}
+	return aclCheckAllUserPerm(c.user, cmd, c.args)
+}
+
+// rejectCommandByACL 回复 NOPERM 并记录日志
+func rejectCommandByACL(c *RedisClient, cmd *RedisCommand, reason, argpos int) {
+	addACLLogEntry(c, reason, argpos, c.user.name, "")
+	switch reason {
+	case ACL_DENIED_CMD:
+		rejectCommand(c, fmt.Sprintf("-NOPERM User %s has no permissions to run the '%s' command", c.user.name, cmd.fullname))
+	case ACL_DENIED_KEY:
+		rejectCommand(c, "-NOPERM No permissions to access a key")
+	case ACL_DENIED_CHANNEL:
+		rejectCommand(c, "-NOPERM No permissions to access a channel")
+	}
+}
+
+// aclDryRunMessage ACL DRYRUN 中拒绝的原因
+func aclDryRunMessage(cmd *RedisCommand, args []*obj.RedisObj, reason, argpos int) string {
+	switch reason {
+	case ACL_DENIED_CMD:
+		return fmt.Sprintf("This user has no permissions to run the '%s' command", cmd.fullname)
+	case ACL_DENIED_KEY:
+		return fmt.Sprintf("This user has no permissions to access the '%s' key", args[argpos].StrVal())
+	default:
+		return fmt.Sprintf("This user has no permissions to access the '%s' channel", args[argpos].StrVal())
+	}
+}
+
+func aclLogReasonName(reason int) string {
+	switch reason {
+	case ACL_DENIED_CMD:
+		return "command"
+	case ACL_DENIED_KEY:
+		return "key"
+	case ACL_DENIED_CHANNEL:
+		return "channel"
+	case ACL_DENIED_AUTH:
+		return "auth"
+	}
+	return "unknown"
+}
+
+// addACLLogEntry 记录被拒绝的命令或认证，相近的记录合并计数
+func addACLLogEntry(c *RedisClient, reason, argpos int, username, object string) {
+	if object == "" {
+		switch reason {
+		case ACL_DENIED_CMD:
+			object = c.cmd.fullname
+		case ACL_DENIED_AUTH:
+			object = "AUTH"
+		default:
+			object = c.args[argpos].StrVal()
+		}
+	}
+	context := "toplevel"
+	if c.flags&CLIENT_MULTI != 0 {
+		context = "multi"
+	}
+	now := ae.GetMsTime()
+	for _, e := range server.aclLog {
+		if e.reason == reason && e.context == context && e.object == object && e.username == username &&
+			now-e.ctime < ACL_LOG_GROUPING_MAX_TIME_DELTA {
+			e.count++
+			e.ctime = now
+			e.updateTime = now
+			e.cinfo = catClientInfoString(c)
+			return
+		}
+	}
+	server.aclLogEntryCount++
+	e := &aclLogEntry{
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		ctime:      now,
+		cinfo:      catClientInfoString(c),
+		entryId:    server.aclLogEntryCount,
+		createTime: now,
+		updateTime: now,
+	}
+	// 最新的记录在前
+	server.aclLog = append([]*aclLogEntry{e}, server.aclLog...)
+	if len(server.aclLog) > server.acllogMaxLen {
+		server.aclLog = server.aclLog[:server.acllogMaxLen]
+	}
+}
+
+// aclKillUserClients 用户被删除或替换后，断开以该用户认证的连接
+func aclKillUserClients(c *RedisClient, u *aclUser) {
+	for _, cl := range server.clients {
+		if cl.user != u {
+			continue
+		}
+		if cl == c {
+			c.flags |= CLIENT_CLOSE_AFTER_REPLY
+		} else {
+			freeClientAsync(cl)
+		}
+	}
+	if c.fd < 0 && c.user == u {
+		c.flags |= CLIENT_CLOSE_AFTER_REPLY
+	}
+}
+
+// aclLoadFromFile 每行为 user <username> <rules...>，全部解析成功后才替换用户表
+func aclLoadFromFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %v", filename, err)
+	}
+	defer f.Close()
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(f)
+	var errs []string
+	for linenum := 1; scanner.Scan(); linenum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		argv, err := splitArgs([]byte(line))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s:%d: unbalanced quotes in acl line.", filename, linenum))
+			continue
+		}
+		if len(argv) < 2 || argv[0] != "user" {
+			errs = append(errs, fmt.Sprintf("%s:%d should start with user keyword.", filename, linenum))
+			continue
+		}
+		if users[argv[1]] != nil {
+			errs = append(errs, fmt.Sprintf("%s:%d: Duplicate user '%s' found.", filename, linenum, argv[1]))
+			continue
+		}
+		u := newACLUser(argv[1])
+		if op, err := aclSetUserRules(u, argv[2:]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s:%d: %s. Error in user declaration '%s': %v", filename, linenum, argv[1], op, err))
+			continue
+		}
+		users[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, " "))
+	}
+	if users["default"] == nil {
+		users["default"] = createDefaultUser()
+	}
+	// 已认证的客户端切换到同名的新用户，用户不存在时断开
+	for _, c := range server.clients {
+		if nu := users[c.user.name]; nu != nil {
+			c.user = nu
+		} else {
+			freeClientAsync(c)
+		}
+	}
+	server.users = users
+	server.defaultUser = users["default"]
+	return nil
+}
+
+// aclSaveToFile 先写临时文件再重命名，保证文件完整
+func aclSaveToFile(filename string) error {
+	var b strings.Builder
+	for _, name := range aclSortedUserNames() {
+		b.WriteString(aclDescribeUser(server.users[name]))
+		b.WriteString("\n")
+	}
+	tmp := fmt.Sprintf("%s.tmp-%d", filename, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func aclSortedUserNames() []string {
+	names := make([]string, 0, len(server.users))
+	for name := range server.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// httpAuth HTTP 请求在各自的 goroutine 中认证，用户由 ACL 命令在事件循环中修改，检查同样交给事件循环
+func httpAuth(username, password, path string, args []string) error {
+	var err error
+	runInEventLoop(func() {
+		err = checkHttpRequest(username, password, path, args)
+	})
+	return err
+}
+
+// checkHttpRequest HTTP 接口的认证与权限检查，没有提供用户名时使用 default 用户
+func checkHttpRequest(username, password, path string, args []string) error {
+	if username == "" {
+		username = "default"
+	}
+	u := aclCheckUserCredentials(username, password)
+	if u == nil {
+		return http.ErrUnauthorized
+	}
+	argv := []*obj.RedisObj{obj.CreateObject(obj.STR, strings.TrimPrefix(path, "/key/"))}
+	for _, a := range args {
+		argv = append(argv, obj.CreateObject(obj.STR, a))
+	}
+	cmd := lookupCommandByArgs(argv)
+	if cmd == nil || !commandCheckArity(cmd, len(argv)) {
+		return nil
+	}
+	if reason, argpos := aclCheckAllUserPerm(u, cmd, argv); reason != ACL_OK {
+		return errors.New("NOPERM " + aclDryRunMessage(cmd, argv, reason, argpos))
+	}
+	return nil
+}
+
+// authCommand AUTH [username] password
+func authCommand(c *RedisClient) {
+	if len(c.args) > 3 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	username, password := "default", c.args[1].StrVal()
+	if len(c.args) == 3 {
+		username, password = c.args[1].StrVal(), c.args[2].StrVal()
+	}
+	for j := 1; j < len(c.args); j++ {
+		redactClientCommandArgument(c, j)
+	}
+	if len(c.args) == 2 && server.defaultUser.flags&USER_FLAG_NOPASS != 0 {
+		c.AddReplyError("AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+		return
+	}
+	if authenticateClient(c, username, password) {
+		c.AddReplyStr("+OK\r\n")
+	}
+}
+
+// helloCommand HELLO [protover [AUTH username password] [SETNAME clientname]]，只支持 RESP2
+func helloCommand(c *RedisClient) {
+	if len(c.args) >= 2 {
+		ver, err := strconv.ParseInt(c.args[1].StrVal(), 10, 64)
+		if err != nil {
+			c.AddReplyError("Protocol version is not an integer or out of range")
+			return
+		}
+		if ver != 2 {
+			c.AddReplyError("-NOPROTO unsupported protocol version")
+			return
+		}
+	}
+	var username, password, clientname string
+	auth, setname := false, false
+	for j := 2; j < len(c.args); j++ {
+		moreargs := len(c.args) - 1 - j
+		opt := c.args[j].StrVal()
+		if strings.EqualFold(opt, "auth") && moreargs >= 2 {
+			auth = true
+			username, password = c.args[j+1].StrVal(), c.args[j+2].StrVal()
+			redactClientCommandArgument(c, j+1)
+			redactClientCommandArgument(c, j+2)
+			j += 2
+		} else if strings.EqualFold(opt, "setname") && moreargs >= 1 {
+			setname = true
+			clientname = c.args[j+1].StrVal()
+			j++
+		} else {
+			c.AddReplyError("Syntax error in HELLO option '" + opt + "'")
+			return
+		}
+	}
+	if auth && !authenticateClient(c, username, password) {
+		return
+	}
+	if authRequired(c) {
+		c.AddReplyError("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> " +
+			"AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+	if setname && !clientSetNameOrReply(c, clientname) {
+		return
+	}
+	role := "master"
+	if server.masterhost != "" {
+		role = "replica"
+	}
+	c.AddReplyArrayLen(14)
+	c.AddReplyBulk("server")
+	c.AddReplyBulk("redis")
+	c.AddReplyBulk("version")
+	c.AddReplyBulk(REDIS_VERSION)
+	c.AddReplyBulk("proto")
+	c.AddReplyInt(2)
+	c.AddReplyBulk("id")
+	c.AddReplyInt(c.id)
+	c.AddReplyBulk("mode")
+	c.AddReplyBulk("standalone")
+	c.AddReplyBulk("role")
+	c.AddReplyBulk(role)
+	c.AddReplyBulk("modules")
+	c.AddReplyArrayLen(0)
+}
+
+func addReplyBulks(c *RedisClient, strs []string) {
+	c.AddReplyArrayLen(len(strs))
+	for _, s := range strs {
+		c.AddReplyBulk(s)
+	}
+}
+
+func addReplyACLLog(c *RedisClient, count int) {
+	entries := server.aclLog
+	if count < len(entries) {
+		entries = entries[:count]
+	}
+	now := ae.GetMsTime()
+	c.AddReplyArrayLen(len(entries))
+	for _, e := range entries {
+		c.AddReplyArrayLen(20)
+		c.AddReplyBulk("count")
+		c.AddReplyInt(e.count)
+		c.AddReplyBulk("reason")
+		c.AddReplyBulk(aclLogReasonName(e.reason))
+		c.AddReplyBulk("context")
+		c.AddReplyBulk(e.context)
+		c.AddReplyBulk("object")
+		c.AddReplyBulk(e.object)
+		c.AddReplyBulk("username")
+		c.AddReplyBulk(e.username)
+		c.AddReplyBulk("age-seconds")
+		c.AddReplyBulk(strconv.FormatFloat(float64(now-e.ctime)/1000, 'f', 3, 64))
+		c.AddReplyBulk("client-info")
+		c.AddReplyBulk(e.cinfo)
+		c.AddReplyBulk("entry-id")
+		c.AddReplyInt(e.entryId)
+		c.AddReplyBulk("timestamp-created")
+		c.AddReplyInt(e.createTime)
+		c.AddReplyBulk("timestamp-last-updated")
+		c.AddReplyInt(e.updateTime)
+	}
+}
+
+// aclCommand ACL <subcommand> [<arg> ...]
+func aclCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "setuser" && len(c.args) >= 3:
+		username := c.args[2].StrVal()
+		if strings.ContainsAny(username, " \x00") {
+			c.AddReplyError("Usernames can't contain spaces or null characters")
+			return
+		}
+		u := server.users[username]
+		created := u == nil
+		if created {
+			u = newACLUser(username)
+		}
+		rules := make([]string, 0, len(c.args)-3)
+		for _, arg := range c.args[3:] {
+			rules = append(rules, arg.StrVal())
+		}
+		if op, err := aclSetUserRules(u, rules); err != nil {
+			c.AddReplyError(fmt.Sprintf("Error in ACL SETUSER modifier '%s': %v", op, err))
+			return
+		}
+		if created {
+			server.users[username] = u
+		}
+		c.AddReplyStr("+OK\r\n")
+	case sub == "deluser" && len(c.args) >= 3:
+		deleted := 0
+		for _, arg := range c.args[2:] {
+			if arg.StrVal() == "default" {
+				c.AddReplyError("The 'default' user cannot be removed")
+				return
+			}
+		}
+		for _, arg := range c.args[2:] {
+			if u := server.users[arg.StrVal()]; u != nil {
+				delete(server.users, u.name)
+				aclKillUserClients(c, u)
+				deleted++
+			}
+		}
+		c.AddReplyInt(int64(deleted))
+	case sub == "getuser" && len(c.args) == 3:
+		u := server.users[c.args[2].StrVal()]
+		if u == nil {
+			c.AddReplyStr("$-1\r\n")
+			return
+		}
+		var flags []string
+		if u.flags&USER_FLAG_ENABLED != 0 {
+			flags = append(flags, "on")
+		} else {
+			flags = append(flags, "off")
+		}
+		if u.flags&USER_FLAG_NOPASS != 0 {
+			flags = append(flags, "nopass")
+		}
+		c.AddReplyArrayLen(12)
+		c.AddReplyBulk("flags")
+		addReplyBulks(c, flags)
+		c.AddReplyBulk("passwords")
+		addReplyBulks(c, u.passwords)
+		c.AddReplyBulk("commands")
+		c.AddReplyBulk(strings.Join(u.cmdRules, " "))
+		c.AddReplyBulk("keys")
+		c.AddReplyBulk(aclDescribeKeys(u))
+		c.AddReplyBulk("channels")
+		c.AddReplyBulk(aclDescribeChannels(u))
+		c.AddReplyBulk("selectors")
+		c.AddReplyArrayLen(0)
+	case (sub == "list" || sub == "users") && len(c.args) == 2:
+		names := aclSortedUserNames()
+		if sub == "list" {
+			for i, name := range names {
+				names[i] = aclDescribeUser(server.users[name])
+			}
+		}
+		addReplyBulks(c, names)
+	case sub == "whoami" && len(c.args) == 2:
+		c.AddReplyBulk(c.user.name)
+	case sub == "cat" && len(c.args) == 2:
+		names := make([]string, len(aclCategoryNames))
+		for i, cat := range aclCategoryNames {
+			names[i] = cat.name
+		}
+		addReplyBulks(c, names)
+	case sub == "cat" && len(c.args) == 3:
+		cat := aclGetCategoryByName(c.args[2].StrVal())
+		if cat == 0 {
+			c.AddReplyError(fmt.Sprintf("Unknown category '%s'", c.args[2].StrVal()))
+			return
+		}
+		var names []string
+		for _, cmd := range sortedCommands() {
+			if cmd.aclCategories&cat != 0 {
+				names = append(names, cmd.fullname)
+			}
+			for i := range cmd.subcommands {
+				if cmd.subcommands[i].aclCategories&cat != 0 {
+					names = append(names, cmd.subcommands[i].fullname)
+				}
+			}
+		}
+		addReplyBulks(c, names)
+	case sub == "log" && len(c.args) <= 3:
+		count := len(server.aclLog)
+		if len(c.args) == 3 {
+			if strings.EqualFold(c.args[2].StrVal(), "reset") {
+				server.aclLog = nil
+				c.AddReplyStr("+OK\r\n")
+				return
+			}
+			n, ok := getLongFromObjectOrReply(c, c.args[2], "")
+			if !ok {
+				return
+			}
+			if n < 0 {
+				c.AddReplyError("value is out of range, must be positive")
+				return
+			}
+			if int(n) < count {
+				count = int(n)
+			}
+		}
+		addReplyACLLog(c, count)
+	case sub == "dryrun" && len(c.args) >= 4:
+		u := server.users[c.args[2].StrVal()]
+		if u == nil {
+			c.AddReplyError(fmt.Sprintf("User '%s' not found", c.args[2].StrVal()))
+			return
+		}
+		args := c.args[3:]
+		cmd := lookupCommandByArgs(args)
+		if cmd == nil {
+			c.AddReplyError(fmt.Sprintf("Command '%s' not found", args[0].StrVal()))
+			return
+		}
+		if !commandCheckArity(cmd, len(args)) {
+			c.AddReplyError(fmt.Sprintf("wrong number of arguments for '%s' command", cmd.fullname))
+			return
+		}
+		if reason, argpos := aclCheckAllUserPerm(u, cmd, args); reason != ACL_OK {
+			c.AddReplyBulk(aclDryRunMessage(cmd, args, reason, argpos))
+			return
+		}
+		c.AddReplyStr("+OK\r\n")
+	case sub == "genpass" && len(c.args) <= 3:
+		bits := int64(256)
+		if len(c.args) == 3 {
+			var ok bool
+			if bits, ok = getLongFromObjectOrReply(c, c.args[2], ""); !ok {
+				return
+			}
+			if bits <= 0 || bits > 4096 {
+				c.AddReplyError("ACL GENPASS argument must be the number of bits for the output password, a positive number up to 4096")
+				return
+			}
+		}
+		buf := make([]byte, (bits+7)/8)
+		if _, err := rand.Read(buf); err != nil {
+			c.AddReplyError(err.Error())
+			return
+		}
+		c.AddReplyBulk(hex.EncodeToString(buf)[:(bits+3)/4])
+	case (sub == "load" || sub == "save") && len(c.args) == 2:
+		if server.aclFilename == "" {
+			c.AddReplyError("This Redis instance is not configured to use an ACL file. You may want to specify users via the " +
+				"ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+			return
+		}
+		var err error
+		if sub == "load" {
+			err = aclLoadFromFile(server.aclFilename)
+		} else {
+			err = aclSaveToFile(server.aclFilename)
+		}
+		if err != nil {
+			c.AddReplyError(err.Error())
+			return
+		}
+		c.AddReplyStr("+OK\r\n")
+	case sub == "help" && len(c.args) == 2:
+		addReplyHelp(c, []string{
+			"CAT [<category>]",
+			"    List all commands that belong to <category>, or all command categories",
+			"    when no category is specified.",
+			"DELUSER <username> [<username> ...]",
+			"    Delete a list of users.",
+			"DRYRUN <username> <command> [<arg> ...]",
+			"    Returns whether the user can execute the given command without executing the command.",
+			"GETUSER <username>",
+			"    Get the user's details.",
+			"GENPASS [<bits>]",
+			"    Generate a secure 256-bit user password. The optional `bits` argument can",
+			"    be used to specify a different size.",
+			"LIST",
+			"    Show users details in config file format.",
+			"LOAD",
+			"    Reload users from the ACL file.",
+			"LOG [<count> | RESET]",
+			"    Show the ACL log entries.",
+			"SAVE",
+			"    Save the current config to the ACL file.",
+			"SETUSER <username> <attribs> [<attribs> ...]",
+			"    Create or modify a user with the specified attributes.",
+			"USERS",
+			"    List all the registered usernames.",
+			"WHOAMI",
+			"    Return the current connection username.",
+		})
+	default:
+		addReplySubcommandSyntaxError(c)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"go-redis/conf"
+	"go-redis/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestAuthRequirepass(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.Requirepass = "secret"
+	initServer(cfg)
+	c := CreateClient(-1)
+	// 未认证时只能执行 AUTH、HELLO 与 QUIT，未知命令与参数错误优先回复
+	ReadQuery(c, "get k\r\nnosuchcmd\r\nauth wrong\r\nauth nouser secret\r\nhello 2\r\nauth secret\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n-ERR unknown command 'nosuchcmd'\r\n"+
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n"+
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n"+
+		"-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> "+
+		"AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n"+
+		"+OK\r\n$-1\r\n", allReplies(c))
+
+	// 指定 default 用户认证，HTTP 接口使用同样的密码
+	c = CreateClient(-1)
+	ReadQuery(c, "auth default secret\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n", allReplies(c))
+	assert.True(t, c.authenticated)
+	assert.Nil(t, checkHttpRequest("", "secret", "/key/get", []string{"k"}))
+	assert.Equal(t, http.ErrUnauthorized, checkHttpRequest("", "wrong", "/key/get", []string{"k"}))
+}
+
+func TestAuthWithoutRequirepass(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "auth foo\r\nauth default foo\r\nauth a b c\r\nset k v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR AUTH <password> called without any password configured for the default user. "+
+		"Are you sure your configuration is correct?\r\n+OK\r\n-ERR syntax error\r\n+OK\r\n", allReplies(c))
+}
+
+func TestHello(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.Requirepass = "secret"
+	initServer(cfg)
+	c := CreateClient(-1)
+	ReadQuery(c, "hello 3\r\nhello x\r\nhello 2 foo\r\nhello 2 auth default secret setname myconn\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n-ERR Protocol version is not an integer or out of range\r\n"+
+		"-ERR Syntax error in HELLO option 'foo'\r\n"+
+		"*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n"+REDIS_VERSION+"\r\n$5\r\nproto\r\n:2\r\n"+
+		"$2\r\nid\r\n:"+strconv.FormatInt(c.id, 10)+"\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n",
+		allReplies(c))
+	assert.True(t, c.authenticated)
+	assert.Equal(t, "myconn", c.name)
+}
+
+func TestACLKeyPatterns(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	admin := CreateClient(-1)
+	ReadQuery(admin, "acl setuser svc on >pw ~svc:* %R~shared:* +@all -del\r\nacl setuser svc foo\r\n")
+	assert.Nil(t, ProcessQueryBuf(admin))
+	assert.Equal(t, "+OK\r\n-ERR Error in ACL SETUSER modifier 'foo': Syntax error\r\n", allReplies(admin))
+
+	// 只能读写自己的前缀，共享前缀只读，DEL 被禁止
+	c, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	ReadQuery(c, "auth svc pw\r\nset svc:a 1\r\nset other 1\r\nget shared:x\r\nset shared:x 1\r\ndel svc:a\r\nacl whoami\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n+OK\r\n-NOPERM No permissions to access a key\r\n$-1\r\n"+
+		"-NOPERM No permissions to access a key\r\n-NOPERM User svc has no permissions to run the 'del' command\r\n$3\r\nsvc\r\n",
+		allReplies(c))
+
+	ReadQuery(admin, "acl dryrun svc get other\r\nacl dryrun svc get svc:a\r\nacl log 1\r\n")
+	assert.Nil(t, ProcessQueryBuf(admin))
+	replies := allReplies(admin)
+	assert.True(t, strings.HasPrefix(replies, "$54\r\nThis user has no permissions to access the 'other' key\r\n+OK\r\n*1\r\n*20\r\n"+
+		"$5\r\ncount\r\n:1\r\n$6\r\nreason\r\n$7\r\ncommand\r\n$7\r\ncontext\r\n$8\r\ntoplevel\r\n$6\r\nobject\r\n$3\r\ndel\r\n"), replies)
+	assert.Equal(t, "user svc on #"+aclHashPassword("pw")+" ~svc:* %R~shared:* resetchannels +@all -del",
+		aclDescribeUser(server.users["svc"]))
+
+	// 删除用户后断开其连接
+	ReadQuery(admin, "acl deluser svc default\r\nacl deluser svc nosuch\r\n")
+	assert.Nil(t, ProcessQueryBuf(admin))
+	assert.Equal(t, "-ERR The 'default' user cannot be removed\r\n:1\r\n", allReplies(admin))
+	assert.NotZero(t, c.flags&CLIENT_CLOSE_ASAP)
+}
+
+func TestACLLoadFromFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.acl")
+	assert.Nil(t, os.WriteFile(filename, []byte("user default on nopass ~* &* +@all\nuser reader on >pw ~* -@all +@read\n"), 0644))
+	cfg := conf.DefaultConfig()
+	cfg.Aclfile = filename
+	assert.Nil(t, initServer(cfg))
+	assert.Nil(t, checkHttpRequest("reader", "pw", "/key/get", []string{"k"}))
+	assert.Equal(t, "NOPERM This user has no permissions to run the 'set' command",
+		checkHttpRequest("reader", "pw", "/key/set", []string{"k", "v"}).Error())
+
+	c := CreateClient(-1)
+	ReadQuery(c, "acl setuser writer on nopass ~w:* +set\r\nacl save\r\nacl users\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n+OK\r\n*3\r\n$7\r\ndefault\r\n$6\r\nreader\r\n$6\r\nwriter\r\n", allReplies(c))
+	assert.Nil(t, aclLoadFromFile(filename))
+	assert.Equal(t, "user writer on nopass ~w:* resetchannels -@all +set", aclDescribeUser(server.users["writer"]))
+
+	assert.Nil(t, os.WriteFile(filename, []byte("user bad on +nosuchcmd\n"), 0644))
+	assert.NotNil(t, aclLoadFromFile(filename))
+	assert.NotNil(t, server.users["writer"])
+}
+
+func TestHttpAuthInEventLoop(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	assert.Nil(t, initHttpJobs())
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		go func() {
+			errs <- httpAuth("u", "pw", "/key/get", []string{"k"})
+		}()
+	}
+	// 认证与 ACL SETUSER/DELUSER 交替在事件循环中执行
+	for done := 0; done < 100; {
+		c := CreateClient(-1)
+		ReadQuery(c, "acl setuser u on >pw ~* +@all\r\nacl deluser u\r\n")
+		assert.Nil(t, ProcessQueryBuf(c))
+		allReplies(c)
+		processHttpJobs(server.aeLoop, -1, nil)
+		select {
+		case err := <-errs:
+			assert.Equal(t, http.ErrUnauthorized, err)
+			done++
+		default:
+		}
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+type BeforeSleepProc func(loop *AeLoop)
+
+type AfterSleepProc func(loop *AeLoop)
+
 // 事件循环
 type AeLoop struct {
 	FileEvents      map[int]*AeFileEvent // 文件事件
@@ -13,6 +17,8 @@ type AeLoop struct {
 	fileEventFd     int
 	timeEventNextId int
 	stop            bool
+	beforeSleep     BeforeSleepProc // 每轮等待事件前执行
+	afterSleep      AfterSleepProc  // 每轮等待事件后、处理事件前执行
 }
 
 func getFeKey(fd int, mask FileType) int {
@@ -114,15 +120,35 @@ func (loop *AeLoop) AeProcess(tes []*AeTimeEvent, fes []*AeFileEvent) {
 	if len(fes) > 0 {
 		log.Println("ae is processing file events")
 		for _, fe := range fes {
+			// 同一轮中前面的回调可能已移除或替换了该事件
+			if loop.FileEvents[getFeKey(fe.fd, fe.mask)] != fe {
+				continue
+			}
 			fe.proc(loop, fe.fd, fe.extra)
 		}
 	}
 }
 
+// SetBeforeSleepProc 设置每轮等待事件前的回调
+func (loop *AeLoop) SetBeforeSleepProc(proc BeforeSleepProc) {
+	loop.beforeSleep = proc
+}
+
+// SetAfterSleepProc 设置每轮等待事件后的回调
+func (loop *AeLoop) SetAfterSleepProc(proc AfterSleepProc) {
+	loop.afterSleep = proc
+}
+
 // AeMain 主循环
 func (loop *AeLoop) AeMain() {
 	for !loop.stop {
+		if loop.beforeSleep != nil {
+			loop.beforeSleep(loop)
+		}
 		tes, fes := loop.AeWait()
+		if loop.afterSleep != nil {
+			loop.afterSleep(loop)
+		}
 		loop.AeProcess(tes, fes)
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import "sync"
+
+// bio 后台任务队列，由一个 goroutine 按顺序执行，目前只用于惰性释放
+var bio struct {
+	once    sync.Once
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []func()
+	pending int // 已提交未完成的任务数，包括正在执行的
+}
+
+// bioInit 启动后台 goroutine，多次调用只启动一次
+func bioInit() {
+	bio.once.Do(func() {
+		bio.cond = sync.NewCond(&bio.mu)
+		go bioProcessBackgroundJobs()
+	})
+}
+
+// bioCreateLazyFreeJob 提交惰性释放任务，job 只能访问已从数据库中摘除的数据
+func bioCreateLazyFreeJob(job func()) {
+	bio.mu.Lock()
+	bio.jobs = append(bio.jobs, job)
+	bio.pending++
+	bio.cond.Broadcast()
+	bio.mu.Unlock()
+}
+
+func bioProcessBackgroundJobs() {
+	for {
+		bio.mu.Lock()
+		for len(bio.jobs) == 0 {
+			bio.cond.Wait()
+		}
+		job := bio.jobs[0]
+		bio.jobs[0] = nil
+		bio.jobs = bio.jobs[1:]
+		bio.mu.Unlock()
+
+		job()
+
+		bio.mu.Lock()
+		bio.pending--
+		bio.cond.Broadcast()
+		bio.mu.Unlock()
+	}
+}
+
+// bioPendingJobs 尚未完成的任务数
+func bioPendingJobs() int {
+	bio.mu.Lock()
+	defer bio.mu.Unlock()
+	return bio.pending
+}
+
+// bioDrainWorker 等待所有已提交的任务完成
+func bioDrainWorker() {
+	bio.mu.Lock()
+	for bio.pending > 0 {
+		bio.cond.Wait()
+	}
+	bio.mu.Unlock()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"go-redis/ae"
+	"go-redis/obj"
+	"math"
+	"strconv"
+)
+
+type BlockType int
+
+const (
+	BLOCKED_NONE     BlockType = iota
+	BLOCKED_WAIT               // WAIT
+	BLOCKED_WAITAOF            // WAITAOF
+	BLOCKED_LIST               // BLPOP 等
+	BLOCKED_ZSET               // BZPOPMIN 等
+	BLOCKED_STREAM             // XREAD 等
+	BLOCKED_POSTPONE           // CLIENT PAUSE 期间推迟执行
+)
+
+const (
+	UNIT_SECONDS int = iota
+	UNIT_MILLISECONDS
+)
+
+// 阻塞状态
+type blockingState struct {
+	timeout     int64 // 超时时间点 ms，0 表示永不超时
+	timerId     int   // 超时定时器，0 表示没有
+	numreplicas int   // 需要确认的从节点数量
+	numlocal    int   // WAITAOF 需要本地 fsync
+	reploffset  int64 // 需要确认的复制偏移量
+
+	// 等待键的阻塞命令
+	db             *redisDB
+	keys           []*obj.RedisObj
+	cmd            *RedisCommand // 键就绪后重新执行的命令
+	unblockOnNokey bool          // 键被删除时以错误解除阻塞，例如 XREADGROUP
+
+	// XREAD 等待的 ID 之后的消息，XREADGROUP 等待消费组的新消息
+	xreadIds   map[string]obj.StreamID
+	xreadGroup string
+}
+
+// 有新数据写入、可能让阻塞客户端继续执行的键
+type readyKey struct {
+	db  *redisDB
+	key *obj.RedisObj
+}
+
+// getTimeoutFromObjectOrReply 解析超时参数，返回超时时间点 ms
+func getTimeoutFromObjectOrReply(c *RedisClient, o *obj.RedisObj, unit int) (int64, bool) {
+	var tval int64
+	if unit == UNIT_SECONDS {
+		f, err := strconv.ParseFloat(o.StrVal(), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			c.AddReplyError("timeout is not a float or out of range")
+			return 0, false
+		}
+		tval = int64(math.Ceil(f * 1000))
+	} else {
+		var err error
+		tval, err = strconv.ParseInt(o.StrVal(), 10, 64)
+		if err != nil {
+			c.AddReplyError("timeout is not an integer or out of range")
+			return 0, false
+		}
+	}
+	if tval < 0 {
+		c.AddReplyError("timeout is negative")
+		return 0, false
+	}
+	if tval > 0 {
+		tval += ae.GetMsTime()
+	}
+	return tval, true
+}
+
+// blockClient 挂起客户端，后续命令保留在 queryBuf 中直到解除阻塞
+func blockClient(c *RedisClient, btype BlockType) {
+	c.flags |= CLIENT_BLOCKED
+	c.btype = btype
+	server.blockedClients++
+	if c.bpop.timeout != 0 {
+		interval := c.bpop.timeout - ae.GetMsTime()
+		if interval < 0 {
+			interval = 0
+		}
+		c.bpop.timerId = server.aeLoop.AddTimeEvent(ae.TE_ONCE, interval, blockedClientTimeout, c)
+	}
+}
+
+func blockedClientTimeout(loop *ae.AeLoop, id int, extra interface{}) {
+	c := extra.(*RedisClient)
+	c.bpop.timerId = 0
+	if c.flags&CLIENT_BLOCKED == 0 {
+		return
+	}
+	replyToBlockedClientTimedOut(c)
+	unblockClient(c)
+}
+
+func replyToBlockedClientTimedOut(c *RedisClient) {
+	switch c.btype {
+	case BLOCKED_WAIT:
+		c.AddReplyInt(int64(replicationCountAcksByOffset(c.bpop.reploffset)))
+	case BLOCKED_WAITAOF:
+		addReplyWaitaof(c, 0, replicationCountAOFAcksByOffset(c.bpop.reploffset))
+	case BLOCKED_LIST, BLOCKED_ZSET, BLOCKED_STREAM:
+		c.AddReplyStr("*-1\r\n")
+	}
+}
+
+// unblockClient 解除阻塞，不负责回复客户端
+func unblockClient(c *RedisClient) {
+	switch c.btype {
+	case BLOCKED_WAIT, BLOCKED_WAITAOF:
+		unblockClientWaitingReplicas(c)
+	case BLOCKED_LIST, BLOCKED_ZSET, BLOCKED_STREAM:
+		unblockClientWaitingData(c)
+	case BLOCKED_POSTPONE:
+		server.postponedClients = removeClient(server.postponedClients, c)
+		// 被推迟的命令在 processUnblockedClients 中重新执行
+		c.flags |= CLIENT_PENDING_COMMAND
+	}
+	if c.bpop.timerId != 0 {
+		server.aeLoop.RemoveTimeEvent(c.bpop.timerId)
+		c.bpop.timerId = 0
+	}
+	c.flags &^= CLIENT_BLOCKED
+	c.btype = BLOCKED_NONE
+	c.bpop = blockingState{}
+	server.blockedClients--
+	// 阻塞期间积压的命令在 beforeSleep 中继续处理
+	server.unblockedClients = append(server.unblockedClients, c)
+}
+
+func processUnblockedClients() {
+	for len(server.unblockedClients) > 0 {
+		c := server.unblockedClients[0]
+		server.unblockedClients = server.unblockedClients[1:]
+		if c.flags&(CLIENT_BLOCKED|CLIENT_CLOSED) != 0 {
+			continue
+		}
+		if c.flags&CLIENT_PENDING_COMMAND != 0 {
+			c.flags &^= CLIENT_PENDING_COMMAND
+			ProcessCommand(c)
+			if c.flags&(CLIENT_BLOCKED|CLIENT_CLOSED) != 0 {
+				continue
+			}
+		}
+		if c.queryLen == 0 {
+			continue
+		}
+		if err := ProcessQueryBuf(c); err != nil {
+			freeClient(c)
+		}
+	}
+}
+
+// blockPostponeClient 暂停期间挂起客户端，当前命令在解除暂停后重新执行
+func blockPostponeClient(c *RedisClient) {
+	c.bpop.timeout = 0
+	blockClient(c, BLOCKED_POSTPONE)
+	server.postponedClients = append(server.postponedClients, c)
+}
+
+// blockForKeys 阻塞客户端直到 keys 中的某个键可用，键就绪后重新执行当前命令
+func blockForKeys(c *RedisClient, btype BlockType, keys []*obj.RedisObj, timeout int64, unblockOnNokey bool) {
+	c.bpop.timeout = timeout
+	c.bpop.db = c.db
+	c.bpop.cmd = c.cmd
+	c.bpop.unblockOnNokey = unblockOnNokey
+	for _, key := range keys {
+		dup := false
+		for _, k := range c.bpop.keys {
+			if k.StrVal() == key.StrVal() {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		c.bpop.keys = append(c.bpop.keys, key)
+		// 按阻塞的先后顺序服务
+		c.db.blockingKeys[key.StrVal()] = append(c.db.blockingKeys[key.StrVal()], c)
+	}
+	blockClient(c, btype)
+}
+
+func unblockClientWaitingData(c *RedisClient) {
+	db := c.bpop.db
+	for _, key := range c.bpop.keys {
+		clients := removeClient(db.blockingKeys[key.StrVal()], c)
+		if len(clients) == 0 {
+			delete(db.blockingKeys, key.StrVal())
+		} else {
+			db.blockingKeys[key.StrVal()] = clients
+		}
+	}
+}
+
+// signalKeyAsReady 键有了新数据，在 handleClientsBlockedOnKeys 中服务阻塞的客户端
+func signalKeyAsReady(db *redisDB, key *obj.RedisObj, typ obj.RedisType) {
+	if getBlockedTypeByType(typ) == BLOCKED_NONE {
+		return
+	}
+	if len(db.blockingKeys[key.StrVal()]) == 0 {
+		return
+	}
+	if _, ok := db.readyKeys[key.StrVal()]; ok {
+		return
+	}
+	db.readyKeys[key.StrVal()] = struct{}{}
+	server.readyKeys = append(server.readyKeys, readyKey{db: db, key: key})
+}
+
+func getBlockedTypeByType(typ obj.RedisType) BlockType {
+	switch typ {
+	case obj.LIST:
+		return BLOCKED_LIST
+	case obj.ZSET:
+		return BLOCKED_ZSET
+	case obj.STREAM:
+		return BLOCKED_STREAM
+	default:
+		return BLOCKED_NONE
+	}
+}
+
+// handleClientsBlockedOnKeys 在命令执行完成后调用，服务过程中可能产生新的就绪键
+func handleClientsBlockedOnKeys() {
+	for len(server.readyKeys) > 0 {
+		readyKeys := server.readyKeys
+		server.readyKeys = nil
+		for _, rk := range readyKeys {
+			delete(rk.db.readyKeys, rk.key.StrVal())
+			handleClientsBlockedOnKey(rk)
+		}
+	}
+}
+
+func handleClientsBlockedOnKey(rk readyKey) {
+	clients := append([]*RedisClient(nil), rk.db.blockingKeys[rk.key.StrVal()]...)
+	for _, receiver := range clients {
+		if receiver.flags&CLIENT_BLOCKED == 0 {
+			continue
+		}
+		// 键可能已被删除、过期或者类型改变
+		o := rk.db.data.Get(rk.key)
+		if o != nil && keyIsExpired(rk.db, rk.key) {
+			o = nil
+		}
+		if receiver.bpop.unblockOnNokey && (o == nil || o.Type != obj.STREAM) {
+			receiver.AddReplyError("-UNBLOCKED the stream key no longer exists")
+			unblockClient(receiver)
+			continue
+		}
+		// 其余情况客户端继续等待
+		if o == nil || getBlockedTypeByType(o.Type) != receiver.btype {
+			continue
+		}
+		if receiver.btype == BLOCKED_STREAM && !streamKeyIsReady(receiver, rk.key, o.Val.(*obj.Stream)) {
+			continue
+		}
+		unblockClientOnKey(receiver)
+	}
+}
+
+// unblockClientOnKey 解除阻塞并重新执行命令，此时键一定可以满足命令
+func unblockClientOnKey(c *RedisClient) {
+	cmd := c.bpop.cmd
+	unblockClient(c)
+	c.flags |= CLIENT_REPROCESSING_COMMAND
+	call(c, cmd)
+	c.flags &^= CLIENT_REPROCESSING_COMMAND
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"go-redis/conf"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockingPop(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c1 := CreateClient(-1)
+	c2 := CreateClient(-2)
+	ReadQuery(c1, "blpop q1 q2 0\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c1))
+	ReadQuery(c2, "brpop q2 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c2))
+	assert.NotEqual(t, 0, c1.flags&CLIENT_BLOCKED)
+	assert.Equal(t, 2, len(server.db[0].blockingKeys["q2"]))
+
+	// 类型不匹配时继续等待
+	p := CreateClient(-3)
+	ReadQuery(p, "set q2 str\r\ndel q2\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.NotEqual(t, 0, c1.flags&CLIENT_BLOCKED)
+
+	// 先阻塞的客户端先被服务
+	ReadQuery(p, "rpush q2 a b\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, 0, c1.flags&CLIENT_BLOCKED)
+	assert.Equal(t, 0, c2.flags&CLIENT_BLOCKED)
+	assert.Equal(t, "$1\r\na\r\n", lastReply(c1))
+	assert.Equal(t, "$1\r\nb\r\n", lastReply(c2))
+	assert.Nil(t, server.db[0].data.Get(createStrArgs("q2")[0]))
+	assert.Equal(t, 0, len(server.db[0].blockingKeys))
+
+	// 积压的命令在 beforeSleep 中处理
+	beforeSleep(server.aeLoop)
+	assert.Equal(t, "$-1\r\n", lastReply(c1))
+}
+
+func TestBlockingZpopAndUnblock(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "bzpopmin z 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	p := CreateClient(-2)
+	ReadQuery(p, "zadd z 2 b 1 a\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, "$1\r\n1\r\n", lastReply(c))
+	// 阻塞的命令以非阻塞的形式传播
+	assert.Equal(t, "ZPOPMIN", c.args[0].StrVal())
+
+	ReadQuery(c, "blmove src dst left right 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.NotEqual(t, 0, c.flags&CLIENT_BLOCKED)
+	linkClient(c)
+	ReadQuery(p, "client unblock "+strconv.FormatInt(c.id, 10)+" error\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, ":1\r\n", lastReply(p))
+	assert.Equal(t, "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n", lastReply(c))
+	assert.Equal(t, 0, len(server.db[0].blockingKeys))
+
+	// EXEC 中不阻塞
+	ReadQuery(c, "multi\r\nblpop nokey 0\r\nexec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*-1\r\n", lastReply(c))
+}
+
+func TestRdbListZset(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "rpush l a b c\r\nzadd z 1.5 x -2 y\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+	emptyData()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db))
+	freeReplyList(c)
+	ReadQuery(c, "lrange l 0 -1\r\nzrange z 0 -1 withscores\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	var replies []string
+	for n := c.reply.Head; n != nil; n = n.Next() {
+		replies = append(replies, n.Val.StrVal())
+	}
+	assert.Equal(t, []string{"*3\r\n", "$1\r\na\r\n", "$1\r\nb\r\n", "$1\r\nc\r\n",
+		"*4\r\n", "$1\r\ny\r\n", "$2\r\n-2\r\n", "$1\r\nx\r\n", "$3\r\n1.5\r\n"}, replies)
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/ae"
+	"go-redis/net"
+	"go-redis/obj"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CLIENT PAUSE 的类型，ALL 包含 WRITE
+const (
+	CLIENT_PAUSE_OFF = iota
+	CLIENT_PAUSE_WRITE
+	CLIENT_PAUSE_ALL
+)
+
+// linkClient 加入客户端表与 ID 索引
+func linkClient(c *RedisClient) {
+	server.clients[c.fd] = c
+	server.clientsIndex[c.id] = c
+}
+
+func unlinkClient(c *RedisClient) {
+	if server.clients[c.fd] == c {
+		delete(server.clients, c.fd)
+	}
+	delete(server.clientsIndex, c.id)
+}
+
+// redactClientCommandArgument 隐藏参数中的敏感信息，例如密码，MONITOR 与慢日志中只显示 (redacted)
+func redactClientCommandArgument(c *RedisClient, argc int) {
+	c.args[argc] = obj.CreateObject(obj.STR, "(redacted)")
+}
+
+func lookupClientByID(id int64) *RedisClient {
+	return server.clientsIndex[id]
+}
+
+// sortedClients 按 ID 排序，即按连接的先后顺序
+func sortedClients() []*RedisClient {
+	clients := make([]*RedisClient, 0, len(server.clientsIndex))
+	for _, c := range server.clientsIndex {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// getClientPeerId 对端地址 ip:port，第一次调用时获取并缓存
+func getClientPeerId(c *RedisClient) string {
+	if c.peerid == "" && c.fd >= 0 {
+		c.peerid = net.PeerName(c.fd)
+	}
+	if c.peerid == "" {
+		return "?:0"
+	}
+	return c.peerid
+}
+
+// getClientSockname 本端地址 ip:port，第一次调用时获取并缓存
+func getClientSockname(c *RedisClient) string {
+	if c.sockname == "" && c.fd >= 0 {
+		c.sockname = net.SockName(c.fd)
+	}
+	if c.sockname == "" {
+		return "?:0"
+	}
+	return c.sockname
+}
+
+// clientFlagsString 与 redis 一致，每个标识对应一个字符，没有标识时为 N
+func clientFlagsString(c *RedisClient) string {
+	var b strings.Builder
+	if c.flags&CLIENT_MONITOR != 0 {
+		b.WriteByte('O')
+	}
+	if c.flags&CLIENT_SLAVE != 0 {
+		b.WriteByte('S')
+	}
+	if c.flags&CLIENT_MASTER != 0 {
+		b.WriteByte('M')
+	}
+	if c.flags&CLIENT_PUBSUB != 0 {
+		b.WriteByte('P')
+	}
+	if c.flags&CLIENT_MULTI != 0 {
+		b.WriteByte('x')
+	}
+	if c.flags&CLIENT_BLOCKED != 0 {
+		b.WriteByte('b')
+	}
+	if c.flags&CLIENT_DIRTY_CAS != 0 {
+		b.WriteByte('d')
+	}
+	if c.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+		b.WriteByte('c')
+	}
+	if c.flags&CLIENT_CLOSE_ASAP != 0 {
+		b.WriteByte('A')
+	}
+	if c.flags&CLIENT_NO_EVICT != 0 {
+		b.WriteByte('e')
+	}
+	if b.Len() == 0 {
+		b.WriteByte('N')
+	}
+	return b.String()
+}
+
+// catClientInfoString 以 CLIENT LIST 的格式描述客户端，ACL LOG 中也记录该描述
+func catClientInfoString(c *RedisClient) string {
+	multi := -1
+	if c.flags&CLIENT_MULTI != 0 {
+		multi = len(c.mstate.commands)
+	}
+	events := ""
+	if c.fd >= 0 && c.flags&CLIENT_CLOSED == 0 {
+		events = "r"
+		if c.reply.Length > 0 {
+			events += "w"
+		}
+	}
+	cmd := "NULL"
+	if c.cmd != nil {
+		cmd = c.cmd.fullname
+	}
+	now := time.Now().Unix()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=%d name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d "+
+		"qbuf=%d qbuf-free=%d oll=%d omem=%d events=%s cmd=%s user=%s resp=2 tot-cmds=%d tot-net-in=%d tot-net-out=%d",
+		c.id, getClientPeerId(c), getClientSockname(c), c.fd, c.name, now-c.ctime, now-c.lastinteraction,
+		clientFlagsString(c), c.db.id, len(c.pubsubChannels), len(c.pubsubPatterns), multi,
+		c.queryLen, len(c.queryBuf)-c.queryLen, c.reply.Length, c.replyBytes, events, cmd, c.user.name,
+		c.commandsProcessed, c.netInputBytes, c.netOutputBytes)
+}
+
+// clientTypeMatches CLIENT LIST 与 CLIENT KILL 的 TYPE 过滤，主节点单独作为一类
+func clientTypeMatches(c *RedisClient, typ int) bool {
+	if c.flags&CLIENT_MASTER != 0 {
+		return typ == CLIENT_TYPE_MASTER
+	}
+	return getClientType(c) == typ
+}
+
+// clientTypeByNameOrReply 解析 normal、master、replica、pubsub
+func clientTypeByNameOrReply(c *RedisClient, name string) (int, bool) {
+	if strings.EqualFold(name, "master") {
+		return CLIENT_TYPE_MASTER, true
+	}
+	typ := getClientTypeByName(name)
+	if typ == -1 {
+		c.AddReplyError(fmt.Sprintf("Unknown client type '%s'", name))
+		return 0, false
+	}
+	return typ, true
+}
+
+// validateClientName 名称不能包含空格、换行等特殊字符
+func validateClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientSetNameOrReply 设置客户端名称，空字符串表示清除
+func clientSetNameOrReply(c *RedisClient, name string) bool {
+	if !validateClientName(name) {
+		c.AddReplyError("Client names cannot contain spaces, newlines or special characters.")
+		return false
+	}
+	c.name = name
+	return true
+}
+
+// pauseClients 暂停期间只会延长或者加强，不会缩短或者减弱
+func pauseClients(end int64, typ int) {
+	if typ > server.clientPauseType {
+		server.clientPauseType = typ
+	}
+	if end > server.clientPauseEndTime {
+		server.clientPauseEndTime = end
+	}
+}
+
+// unpauseClients 解除暂停，被推迟的客户端在 beforeSleep 中继续执行
+func unpauseClients() {
+	server.clientPauseType = CLIENT_PAUSE_OFF
+	server.clientPauseEndTime = 0
+	clients := append([]*RedisClient(nil), server.postponedClients...)
+	for _, c := range clients {
+		unblockClient(c)
+	}
+	if len(server.postponedHttpJobs) > 0 {
+		wakeHttpJobs()
+	}
+}
+
+// checkClientPauseTimeoutAndReturnIfPaused 暂停到期时解除暂停，返回是否仍在暂停中
+func checkClientPauseTimeoutAndReturnIfPaused() bool {
+	if server.clientPauseType == CLIENT_PAUSE_OFF {
+		return false
+	}
+	if server.clientPauseEndTime <= ae.GetMsTime() {
+		unpauseClients()
+		return false
+	}
+	return true
+}
+
+// isMayReplicateCommand 写命令以及 PUBLISH 会产生复制流，CLIENT PAUSE WRITE 期间推迟执行
+func isMayReplicateCommand(c *RedisClient, cmd *RedisCommand) bool {
+	if cmd.flags&CMD_WRITE != 0 || cmd.name == "publish" {
+		return true
+	}
+	return cmd.name == "exec" && c.mstate.cmdFlags&CMD_WRITE != 0
+}
+
+// clientKillFilter CLIENT KILL 的过滤条件，零值表示不过滤
+type clientKillFilter struct {
+	id     int64
+	typ    int
+	user   string
+	addr   string
+	laddr  string
+	maxage int64
+	skipme bool
+}
+
+// parseClientKillFilterOrReply CLIENT KILL <filter> <value> ... [SKIPME yes|no]
+func parseClientKillFilterOrReply(c *RedisClient) (*clientKillFilter, bool) {
+	f := &clientKillFilter{typ: -1, skipme: true}
+	if (len(c.args)-2)%2 != 0 {
+		c.AddReplyError("syntax error")
+		return nil, false
+	}
+	for i := 2; i < len(c.args); i += 2 {
+		opt, val := strings.ToLower(c.args[i].StrVal()), c.args[i+1].StrVal()
+		switch opt {
+		case "id":
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || id <= 0 {
+				c.AddReplyError("client-id should be greater than 0")
+				return nil, false
+			}
+			f.id = id
+		case "type":
+			typ, ok := clientTypeByNameOrReply(c, val)
+			if !ok {
+				return nil, false
+			}
+			f.typ = typ
+		case "user":
+			if server.users[val] == nil {
+				c.AddReplyError(fmt.Sprintf("No such user '%s'", val))
+				return nil, false
+			}
+			f.user = val
+		case "addr":
+			f.addr = val
+		case "laddr":
+			f.laddr = val
+		case "maxage":
+			age, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || age <= 0 {
+				c.AddReplyError("maxage should be greater than 0")
+				return nil, false
+			}
+			f.maxage = age
+		case "skipme":
+			switch strings.ToLower(val) {
+			case "yes":
+				f.skipme = true
+			case "no":
+				f.skipme = false
+			default:
+				c.AddReplyError("syntax error")
+				return nil, false
+			}
+		default:
+			c.AddReplyError("syntax error")
+			return nil, false
+		}
+	}
+	return f, true
+}
+
+func clientMatchesFilter(c *RedisClient, f *clientKillFilter, me *RedisClient) bool {
+	if f.id != 0 && c.id != f.id {
+		return false
+	}
+	if f.typ != -1 && !clientTypeMatches(c, f.typ) {
+		return false
+	}
+	if f.user != "" && c.user.name != f.user {
+		return false
+	}
+	if f.addr != "" && getClientPeerId(c) != f.addr {
+		return false
+	}
+	if f.laddr != "" && getClientSockname(c) != f.laddr {
+		return false
+	}
+	if f.maxage != 0 && time.Now().Unix()-c.ctime < f.maxage {
+		return false
+	}
+	return !(f.skipme && c == me)
+}
+
+// killClient 当前客户端在回复后关闭，其他客户端在 beforeSleep 中释放
+func killClient(c *RedisClient, target *RedisClient) {
+	if target == c {
+		c.flags |= CLIENT_CLOSE_AFTER_REPLY
+	} else {
+		freeClientAsync(target)
+	}
+}
+
+// clientKillCommand CLIENT KILL <ip:port> 或者 CLIENT KILL <filter> <value> ...
+func clientKillCommand(c *RedisClient) {
+	if len(c.args) == 3 {
+		// 旧的格式，只按地址匹配，包括当前客户端
+		addr := c.args[2].StrVal()
+		for _, target := range sortedClients() {
+			if target.flags&CLIENT_CLOSE_ASAP == 0 && getClientPeerId(target) == addr {
+				c.AddReplyStr("+OK\r\n")
+				killClient(c, target)
+				return
+			}
+		}
+		c.AddReplyError("No such client")
+		return
+	}
+	f, ok := parseClientKillFilterOrReply(c)
+	if !ok {
+		return
+	}
+	killed := 0
+	for _, target := range sortedClients() {
+		if target.flags&CLIENT_CLOSE_ASAP == 0 && clientMatchesFilter(target, f, c) {
+			killClient(c, target)
+			killed++
+		}
+	}
+	c.AddReplyInt(int64(killed))
+}
+
+// clientListCommand CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id ...]
+func clientListCommand(c *RedisClient) {
+	clients := sortedClients()
+	if len(c.args) == 4 && strings.EqualFold(c.args[2].StrVal(), "type") {
+		typ, ok := clientTypeByNameOrReply(c, c.args[3].StrVal())
+		if !ok {
+			return
+		}
+		filtered := clients[:0]
+		for _, cl := range clients {
+			if clientTypeMatches(cl, typ) {
+				filtered = append(filtered, cl)
+			}
+		}
+		clients = filtered
+	} else if len(c.args) >= 4 && strings.EqualFold(c.args[2].StrVal(), "id") {
+		clients = clients[:0]
+		for _, arg := range c.args[3:] {
+			id, err := strconv.ParseInt(arg.StrVal(), 10, 64)
+			if err != nil || id <= 0 {
+				c.AddReplyError("Invalid client ID")
+				return
+			}
+			if cl := lookupClientByID(id); cl != nil {
+				clients = append(clients, cl)
+			}
+		}
+	} else if len(c.args) != 2 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	var b strings.Builder
+	for _, cl := range clients {
+		b.WriteString(catClientInfoString(cl))
+		b.WriteByte('\n')
+	}
+	c.AddReplyBulk(b.String())
+}
+
+// clientCommand CLIENT <subcommand> [<arg> ...]
+func clientCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "id" && len(c.args) == 2:
+		c.AddReplyInt(c.id)
+	case sub == "info" && len(c.args) == 2:
+		c.AddReplyBulk(catClientInfoString(c) + "\n")
+	case sub == "list":
+		clientListCommand(c)
+	case sub == "kill" && len(c.args) >= 3:
+		clientKillCommand(c)
+	case sub == "setname" && len(c.args) == 3:
+		if clientSetNameOrReply(c, c.args[2].StrVal()) {
+			c.AddReplyStr("+OK\r\n")
+		}
+	case sub == "getname" && len(c.args) == 2:
+		if c.name == "" {
+			c.AddReplyStr("$-1\r\n")
+		} else {
+			c.AddReplyBulk(c.name)
+		}
+	case sub == "pause" && (len(c.args) == 3 || len(c.args) == 4):
+		// CLIENT PAUSE <timeout> [WRITE|ALL]
+		typ := CLIENT_PAUSE_ALL
+		if len(c.args) == 4 {
+			switch strings.ToLower(c.args[3].StrVal()) {
+			case "write":
+				typ = CLIENT_PAUSE_WRITE
+			case "all":
+			default:
+				c.AddReplyError("CLIENT PAUSE mode must be WRITE or ALL")
+				return
+			}
+		}
+		end, ok := getTimeoutFromObjectOrReply(c, c.args[2], UNIT_MILLISECONDS)
+		if !ok {
+			return
+		}
+		pauseClients(end, typ)
+		c.AddReplyStr("+OK\r\n")
+	case sub == "unpause" && len(c.args) == 2:
+		unpauseClients()
+		c.AddReplyStr("+OK\r\n")
+	case sub == "no-evict" && len(c.args) == 3:
+		switch strings.ToLower(c.args[2].StrVal()) {
+		case "on":
+			c.flags |= CLIENT_NO_EVICT
+		case "off":
+			c.flags &^= CLIENT_NO_EVICT
+		default:
+			c.AddReplyError("syntax error")
+			return
+		}
+		c.AddReplyStr("+OK\r\n")
+	case sub == "reply" && len(c.args) == 3:
+		switch strings.ToLower(c.args[2].StrVal()) {
+		case "on":
+			c.flags &^= CLIENT_REPLY_SKIP | CLIENT_REPLY_OFF
+			c.AddReplyStr("+OK\r\n")
+		case "off":
+			c.flags |= CLIENT_REPLY_OFF
+		case "skip":
+			if c.flags&CLIENT_REPLY_OFF == 0 {
+				c.flags |= CLIENT_REPLY_SKIP_NEXT
+			}
+		default:
+			c.AddReplyError("syntax error")
+		}
+	case sub == "unblock" && (len(c.args) == 3 || len(c.args) == 4):
+		// CLIENT UNBLOCK <id> [TIMEOUT|ERROR]
+		id, err := strconv.ParseInt(c.args[2].StrVal(), 10, 64)
+		if err != nil {
+			c.AddReplyError("value is not an integer or out of range")
+			return
+		}
+		unblockError := false
+		if len(c.args) == 4 {
+			switch strings.ToLower(c.args[3].StrVal()) {
+			case "timeout":
+			case "error":
+				unblockError = true
+			default:
+				c.AddReplyError("CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+				return
+			}
+		}
+		// 被 CLIENT PAUSE 推迟的客户端只能等待解除暂停
+		target := lookupClientByID(id)
+		if target == nil || target.flags&CLIENT_BLOCKED == 0 || target.btype == BLOCKED_POSTPONE {
+			c.AddReplyInt(0)
+			return
+		}
+		if unblockError {
+			target.AddReplyError("-UNBLOCKED client unblocked via CLIENT UNBLOCK")
+		} else {
+			replyToBlockedClientTimedOut(target)
+		}
+		unblockClient(target)
+		c.AddReplyInt(1)
+	case sub == "help" && len(c.args) == 2:
+		addReplyHelp(c, []string{
+			"GETNAME",
+			"    Return the name of the current connection.",
+			"ID",
+			"    Return the ID of the current connection.",
+			"INFO",
+			"    Return information about the current client connection.",
+			"KILL <ip:port>",
+			"    Kill connection made from <ip:port>.",
+			"KILL <option> <value> [<option> <value> [...]]",
+			"    Kill connections. Options are:",
+			"    * ADDR (<ip:port>|<unixsocket>:0)",
+			"      Kill connections made from the specified address",
+			"    * LADDR (<ip:port>|<unixsocket>:0)",
+			"      Kill connections made to specified local address",
+			"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+			"      Kill connections by type.",
+			"    * USER <username>",
+			"      Kill connections authenticated by <username>.",
+			"    * SKIPME (YES|NO)",
+			"      Skip killing current connection (default: yes).",
+			"    * ID <client-id>",
+			"      Kill connections by client id.",
+			"    * MAXAGE <maxage>",
+			"      Kill connections older than the specified age.",
+			"LIST [options ...]",
+			"    Return information about client connections. Options:",
+			"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+			"      Return clients of specified type.",
+			"    * ID <client-id> [<client-id> ...]",
+			"      Return clients of specified IDs only.",
+			"PAUSE <timeout> [WRITE|ALL]",
+			"    Suspend all, or just write, clients for <timeout> milliseconds.",
+			"UNPAUSE",
+			"    Stop the current client pause, resuming traffic.",
+			"SETNAME <name>",
+			"    Assign the name <name> to the current connection.",
+			"UNBLOCK <clientid> [TIMEOUT|ERROR]",
+			"    Unblock the specified blocked client.",
+			"NO-EVICT (ON|OFF)",
+			"    Protect current client connection from eviction.",
+			"REPLY (ON|OFF|SKIP)",
+			"    Control the replies sent to the current connection.",
+		})
+	default:
+		addReplySubcommandSyntaxError(c)
+	}
+}
+
+// 客户端类别，用于输出缓冲区限制
+const (
+	CLIENT_TYPE_NORMAL = iota
+	CLIENT_TYPE_SLAVE
+	CLIENT_TYPE_PUBSUB
+	CLIENT_TYPE_MASTER // 主节点没有输出缓冲区限制，只用于 CLIENT LIST 与 CLIENT KILL
+)
+
+const CLIENT_TYPE_OBUF_COUNT = CLIENT_TYPE_MASTER
+
+// clientBufferLimitsConfig 输出缓冲区限制，超过硬限制，或者持续超过软限制 softLimitSeconds 秒时断开连接，0 表示不限制
+type clientBufferLimitsConfig struct {
+	hardLimitBytes   int64
+	softLimitBytes   int64
+	softLimitSeconds int64
+}
+
+// getClientType 客户端所属的类别，主节点视为普通客户端
+func getClientType(c *RedisClient) int {
+	if c.flags&CLIENT_SLAVE != 0 {
+		return CLIENT_TYPE_SLAVE
+	}
+	if c.flags&CLIENT_PUBSUB != 0 {
+		return CLIENT_TYPE_PUBSUB
+	}
+	return CLIENT_TYPE_NORMAL
+}
+
+func getClientTypeByName(name string) int {
+	switch strings.ToLower(name) {
+	case "normal":
+		return CLIENT_TYPE_NORMAL
+	case "replica", "slave":
+		return CLIENT_TYPE_SLAVE
+	case "pubsub":
+		return CLIENT_TYPE_PUBSUB
+	}
+	return -1
+}
+
+// parseClientOutputBufferLimit 解析 "<class> <hard> <soft> <seconds> ..."，未指定的类别保持 limits 中的值
+func parseClientOutputBufferLimit(s string, limits []clientBufferLimitsConfig) error {
+	fields := strings.Fields(s)
+	if len(fields)%4 != 0 {
+		return errors.New("Wrong number of arguments in buffer limit configuration.")
+	}
+	for j := 0; j < len(fields); j += 4 {
+		class := getClientTypeByName(fields[j])
+		if class == -1 {
+			return errors.New("Invalid client class specified in buffer limit configuration.")
+		}
+		hard, err := memtoll(fields[j+1])
+		if err != nil {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		soft, err := memtoll(fields[j+2])
+		if err != nil {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		seconds, err := strconv.ParseInt(fields[j+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		limits[class] = clientBufferLimitsConfig{hardLimitBytes: hard, softLimitBytes: soft, softLimitSeconds: seconds}
+	}
+	return nil
+}
+
+// checkClientOutputBufferLimits 输出缓冲区是否超过限制，同时维护软限制的开始时间
+func checkClientOutputBufferLimits(c *RedisClient) bool {
+	limits := server.clientObufLimits[getClientType(c)]
+	used := c.replyBytes
+	hard := limits.hardLimitBytes > 0 && used >= limits.hardLimitBytes
+	soft := limits.softLimitBytes > 0 && used >= limits.softLimitBytes
+	if soft {
+		now := time.Now().Unix()
+		if c.obufSoftLimitReachedTime == 0 {
+			// 刚超过软限制，开始计时
+			c.obufSoftLimitReachedTime = now
+			soft = false
+		} else if now-c.obufSoftLimitReachedTime <= limits.softLimitSeconds {
+			soft = false
+		}
+	} else {
+		c.obufSoftLimitReachedTime = 0
+	}
+	return soft || hard
+}
+
+// closeClientOnOutputBufferLimitReached 超过输出缓冲区限制时异步关闭客户端，返回是否关闭
+func closeClientOnOutputBufferLimitReached(c *RedisClient) bool {
+	// 内部使用的假客户端没有连接，不受限制
+	if c.fd < 0 || c.flags&CLIENT_CLOSE_ASAP != 0 {
+		return false
+	}
+	if !checkClientOutputBufferLimits(c) {
+		return false
+	}
+	log.Printf("Client id=%d fd=%d scheduled to be closed ASAP for overcoming of output buffer limits.\n", c.id, c.fd)
+	server.statClientOutbufLimitDisconnections++
+	freeClientAsync(c)
+	return true
+}
+
+// freeClientAsync 命令执行中不能直接释放客户端，在 beforeSleep 中释放
+func freeClientAsync(c *RedisClient) {
+	if c.flags&(CLIENT_CLOSE_ASAP|CLIENT_CLOSED) != 0 {
+		return
+	}
+	c.flags |= CLIENT_CLOSE_ASAP
+	server.clientsToClose = append(server.clientsToClose, c)
+}
+
+func freeClientsInAsyncFreeQueue() {
+	clients := server.clientsToClose
+	server.clientsToClose = nil
+	for _, c := range clients {
+		freeClient(c)
+	}
+}
+
+// clientsCronHandleTimeout 关闭空闲超过 timeout 秒的客户端，返回是否关闭。
+// 主从之间的连接由复制的超时处理，阻塞的客户端由阻塞超时处理，订阅中的客户端本来就可能长时间没有请求
+func clientsCronHandleTimeout(c *RedisClient, now int64) bool {
+	if server.maxidletime == 0 || c.flags&(CLIENT_SLAVE|CLIENT_MASTER|CLIENT_BLOCKED|CLIENT_PUBSUB|CLIENT_PENDING_READ) != 0 {
+		return false
+	}
+	if now-c.lastinteraction <= server.maxidletime {
+		return false
+	}
+	log.Printf("Closing idle client id=%d fd=%d\n", c.id, c.fd)
+	freeClient(c)
+	return true
+}
+
+// clientsCron 在 ServerCron 中检查所有客户端
+func clientsCron() {
+	now := time.Now().Unix()
+	for _, c := range server.clients {
+		clientsCronHandleTimeout(c, now)
+	}
+}
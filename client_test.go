@@ -0,0 +1,235 @@
+package main
+
+import (
+	"go-redis/ae"
+	"go-redis/conf"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// createSocketClient 创建使用 socketpair 的客户端，返回对端 fd
+func createSocketClient(t *testing.T) (*RedisClient, int) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	c := CreateClient(fds[0])
+	linkClient(c)
+	return c, fds[1]
+}
+
+func TestParseClientOutputBufferLimit(t *testing.T) {
+	limits := make([]clientBufferLimitsConfig, CLIENT_TYPE_OBUF_COUNT)
+	assert.Nil(t, parseClientOutputBufferLimit("replica 1gb 64kb 60 PUBSUB 32m 8mb 0", limits))
+	assert.Equal(t, clientBufferLimitsConfig{1024 * 1024 * 1024, 64 * 1024, 60}, limits[CLIENT_TYPE_SLAVE])
+	assert.Equal(t, clientBufferLimitsConfig{32 * 1000 * 1000, 8 * 1024 * 1024, 0}, limits[CLIENT_TYPE_PUBSUB])
+	assert.Equal(t, clientBufferLimitsConfig{}, limits[CLIENT_TYPE_NORMAL])
+	assert.NotNil(t, parseClientOutputBufferLimit("master 0 0 0", limits))
+	assert.NotNil(t, parseClientOutputBufferLimit("normal 0 0", limits))
+	assert.NotNil(t, parseClientOutputBufferLimit("normal 1xb 0 0", limits))
+}
+
+func TestClientOutputBufferLimit(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.ClientOutputBufferLimit = "pubsub 1kb 100 10"
+	initServer(cfg)
+	sub, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	ReadQuery(sub, "subscribe ch\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+
+	// 超过软限制开始计时，持续超过 10 秒才断开
+	p := CreateClient(-1)
+	ReadQuery(p, "publish ch "+strings.Repeat("x", 100)+"\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.NotEqual(t, int64(0), sub.obufSoftLimitReachedTime)
+	assert.Equal(t, 0, sub.flags&CLIENT_CLOSE_ASAP)
+	sub.obufSoftLimitReachedTime = time.Now().Unix() - 11
+	ReadQuery(p, "publish ch x\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.NotEqual(t, 0, sub.flags&CLIENT_CLOSE_ASAP)
+	assert.Equal(t, int64(1), server.statClientOutbufLimitDisconnections)
+
+	// 即将关闭的客户端不再接收回复，在 beforeSleep 中释放
+	n := sub.reply.Length
+	ReadQuery(p, "publish ch x\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, n, sub.reply.Length)
+	beforeSleep(server.aeLoop)
+	assert.NotEqual(t, 0, sub.flags&CLIENT_CLOSED)
+	assert.Equal(t, 0, len(server.pubsubChannels["ch"]))
+
+	// 超过硬限制立即断开
+	sub, peer = createSocketClient(t)
+	defer unix.Close(peer)
+	ReadQuery(sub, "subscribe ch\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+	ReadQuery(p, "publish ch "+strings.Repeat("x", 1024)+"\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.NotEqual(t, 0, sub.flags&CLIENT_CLOSE_ASAP)
+	assert.Equal(t, int64(2), server.statClientOutbufLimitDisconnections)
+}
+
+func TestClientQueryBufferLimit(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	server.clientMaxQuerybufLen = 16
+	c, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	_, err := unix.Write(peer, []byte("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$100\r\n"))
+	assert.Nil(t, err)
+	ReadQueryFromClient(server.aeLoop, c.fd, c)
+	assert.NotEqual(t, 0, c.flags&CLIENT_CLOSED)
+	assert.Contains(t, genRedisInfoString([]string{"stats"}), "client_query_buffer_limit_disconnections:1\r\n")
+}
+
+func TestAcceptMaxclientsAndProtectedMode(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.Maxclients = 1
+	initServer(cfg)
+	accept := func(ip string) (*RedisClient, int) {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+		assert.Nil(t, err)
+		return acceptCommonHandler(fds[0], ip), fds[1]
+	}
+	readReply := func(fd int) string {
+		buf := make([]byte, 4096)
+		n, _ := unix.Read(fd, buf)
+		return string(buf[:n])
+	}
+
+	// 没有密码时只接受本机连接
+	c, peer := accept("10.0.0.1")
+	defer unix.Close(peer)
+	assert.Nil(t, c)
+	assert.True(t, strings.HasPrefix(readReply(peer), "-DENIED Redis is running in protected mode"))
+	c, peer = accept("127.0.0.1")
+	defer unix.Close(peer)
+	assert.NotNil(t, c)
+
+	c, peer = accept("127.0.0.1")
+	defer unix.Close(peer)
+	assert.Nil(t, c)
+	assert.Equal(t, "-ERR max number of clients reached\r\n", readReply(peer))
+	assert.Equal(t, int64(1), server.statRejectedConn)
+
+	// 设置密码后接受外部连接
+	cfg.Maxclients = 10
+	cfg.Requirepass = "secret"
+	initServer(cfg)
+	c, peer = accept("10.0.0.1")
+	defer unix.Close(peer)
+	assert.NotNil(t, c)
+}
+
+func TestClientTimeout(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.Timeout = 10
+	initServer(cfg)
+	idle, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	sub, peer2 := createSocketClient(t)
+	defer unix.Close(peer2)
+	ReadQuery(sub, "subscribe ch\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+	active, peer3 := createSocketClient(t)
+	defer unix.Close(peer3)
+
+	// 订阅中的客户端不会因为空闲被关闭
+	idle.lastinteraction = time.Now().Unix() - 11
+	sub.lastinteraction = time.Now().Unix() - 11
+	active.lastinteraction = time.Now().Unix() - 5
+	ServerCron(server.aeLoop, 0, nil)
+	assert.NotEqual(t, 0, idle.flags&CLIENT_CLOSED)
+	assert.Equal(t, 0, sub.flags&CLIENT_CLOSED)
+	assert.Equal(t, 0, active.flags&CLIENT_CLOSED)
+}
+
+func TestClientListAndKill(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	other, peer2 := createSocketClient(t)
+	defer unix.Close(peer2)
+	ReadQuery(c, "client setname \"my conn\"\r\nclient setname myconn\r\nclient getname\r\nclient id\r\nclient info\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	replies := allReplies(c)
+	prefix := "-ERR Client names cannot contain spaces, newlines or special characters.\r\n+OK\r\n$6\r\nmyconn\r\n:" +
+		strconv.FormatInt(c.id, 10) + "\r\n"
+	assert.True(t, strings.HasPrefix(replies, prefix), replies)
+	info := replies[strings.Index(replies[len(prefix):], "\r\n")+len(prefix)+2:]
+	assert.Contains(t, info, "id="+strconv.FormatInt(c.id, 10)+" ")
+	assert.Contains(t, info, " name=myconn ")
+	assert.Contains(t, info, " flags=N ")
+	assert.Contains(t, info, " cmd=client|info user=default ")
+	assert.Contains(t, info, " tot-cmds=4 ")
+
+	// 按类型与 ID 过滤
+	ReadQuery(other, "subscribe ch\r\n")
+	assert.Nil(t, ProcessQueryBuf(other))
+	ReadQuery(c, "client list type pubsub\r\nclient list id "+strconv.FormatInt(c.id, 10)+" 999\r\nclient list type foo\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	replies = allReplies(c)
+	lines := strings.Split(replies, "\r\n")
+	assert.True(t, strings.HasPrefix(lines[1], "id="+strconv.FormatInt(other.id, 10)+" "), lines[1])
+	assert.Contains(t, lines[1], " flags=P ")
+	assert.True(t, strings.HasPrefix(lines[3], "id="+strconv.FormatInt(c.id, 10)+" "), lines[3])
+	assert.Equal(t, "-ERR Unknown client type 'foo'", lines[4])
+
+	// 默认跳过自己
+	ReadQuery(c, "client kill 1.2.3.4:5\r\nclient kill user default\r\nclient kill id "+strconv.FormatInt(other.id, 10)+"\r\n"+
+		"client kill user nosuch\r\nclient kill id 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR No such client\r\n:1\r\n:0\r\n-ERR No such user 'nosuch'\r\n-ERR client-id should be greater than 0\r\n", allReplies(c))
+	assert.NotEqual(t, 0, other.flags&CLIENT_CLOSE_ASAP)
+	ReadQuery(c, "client kill id "+strconv.FormatInt(c.id, 10)+" skipme no\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":1\r\n", allReplies(c))
+	assert.NotEqual(t, 0, c.flags&CLIENT_CLOSE_AFTER_REPLY)
+}
+
+func TestClientPause(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	admin := CreateClient(-1)
+	c := CreateClient(-1)
+	ReadQuery(admin, "client pause 100000 write\r\n")
+	assert.Nil(t, ProcessQueryBuf(admin))
+	assert.Equal(t, "+OK\r\n", allReplies(admin))
+
+	// 读命令照常执行，写命令以及之后的命令推迟到解除暂停
+	ReadQuery(c, "get k\r\nset k v\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$-1\r\n", allReplies(c))
+	assert.NotEqual(t, 0, c.flags&CLIENT_BLOCKED)
+	ReadQuery(admin, "client unblock "+strconv.FormatInt(c.id, 10)+"\r\nclient unpause\r\n")
+	assert.Nil(t, ProcessQueryBuf(admin))
+	assert.Equal(t, ":0\r\n+OK\r\n", allReplies(admin))
+	processUnblockedClients()
+	assert.Equal(t, "+OK\r\n$1\r\nv\r\n", allReplies(c))
+
+	// 只会加强不会减弱，暂停到期后自动解除
+	ReadQuery(admin, "client pause 100000 all\r\n")
+	assert.Nil(t, ProcessQueryBuf(admin))
+	pauseClients(ae.GetMsTime()+10, CLIENT_PAUSE_WRITE)
+	assert.Equal(t, "+OK\r\n", allReplies(admin))
+	assert.Equal(t, CLIENT_PAUSE_ALL, server.clientPauseType)
+	ReadQuery(c, "get k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "", allReplies(c))
+	server.clientPauseEndTime = ae.GetMsTime() - 1
+	ServerCron(server.aeLoop, 0, nil)
+	processUnblockedClients()
+	assert.Equal(t, "$1\r\nv\r\n", allReplies(c))
+}
+
+func TestClientReply(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "client reply skip\r\nset k v\r\nget k\r\nclient reply off\r\nget k\r\nclient reply on\r\nclient no-evict on\r\nclient info\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	replies := allReplies(c)
+	assert.True(t, strings.HasPrefix(replies, "$1\r\nv\r\n+OK\r\n+OK\r\n"), replies)
+	assert.Contains(t, replies, " flags=e ")
+}
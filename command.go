@@ -0,0 +1,923 @@
+package main
+
+import (
+	"fmt"
+	"go-redis/obj"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type CommandProc func(c *RedisClient)
+
+// 命令标识
+const (
+	CMD_WRITE        = 1 << 0
+	CMD_DENYOOM      = 1 << 1 // 可能增加内存，超出 maxmemory 时拒绝
+	CMD_READONLY     = 1 << 2
+	CMD_ADMIN        = 1 << 3
+	CMD_PUBSUB       = 1 << 4
+	CMD_NOSCRIPT     = 1 << 5
+	CMD_BLOCKING     = 1 << 6
+	CMD_LOADING      = 1 << 7  // 加载数据时允许执行
+	CMD_STALE        = 1 << 8  // 从节点与主节点断开时允许执行
+	CMD_FAST         = 1 << 9  // 时间复杂度 O(1) 或 O(log(N))
+	CMD_NO_AUTH      = 1 << 10 // 认证之前允许执行
+	CMD_SKIP_SLOWLOG = 1 << 11 // 不记录到慢日志，例如 EXEC 中的命令会单独记录
+)
+
+// COMMAND INFO 中的标识名称，顺序与 redis 一致
+var commandFlagNames = []struct {
+	flag int
+	name string
+}{
+	{CMD_WRITE, "write"},
+	{CMD_READONLY, "readonly"},
+	{CMD_DENYOOM, "denyoom"},
+	{CMD_ADMIN, "admin"},
+	{CMD_PUBSUB, "pubsub"},
+	{CMD_NOSCRIPT, "noscript"},
+	{CMD_BLOCKING, "blocking"},
+	{CMD_LOADING, "loading"},
+	{CMD_STALE, "stale"},
+	{CMD_FAST, "fast"},
+	{CMD_SKIP_SLOWLOG, "skip_slowlog"},
+	{CMD_NO_AUTH, "no_auth"},
+}
+
+// 键描述的标识，说明命令如何使用键，ACL 据此判断需要读还是写权限
+const (
+	CMD_KEY_RO     = 1 << 0 // 只读
+	CMD_KEY_RW     = 1 << 1 // 读写
+	CMD_KEY_OW     = 1 << 2 // 覆盖，不读取原值
+	CMD_KEY_RM     = 1 << 3 // 删除
+	CMD_KEY_ACCESS = 1 << 4 // 返回或者使用键的内容
+	CMD_KEY_UPDATE = 1 << 5
+	CMD_KEY_INSERT = 1 << 6
+	CMD_KEY_DELETE = 1 << 7
+)
+
+var keySpecFlagNames = []struct {
+	flag int
+	name string
+}{
+	{CMD_KEY_RO, "RO"},
+	{CMD_KEY_RW, "RW"},
+	{CMD_KEY_OW, "OW"},
+	{CMD_KEY_RM, "RM"},
+	{CMD_KEY_ACCESS, "access"},
+	{CMD_KEY_UPDATE, "update"},
+	{CMD_KEY_INSERT, "insert"},
+	{CMD_KEY_DELETE, "delete"},
+}
+
+// ACL 类别
+const (
+	ACL_CATEGORY_KEYSPACE = 1 << iota
+	ACL_CATEGORY_READ
+	ACL_CATEGORY_WRITE
+	ACL_CATEGORY_SET
+	ACL_CATEGORY_SORTEDSET
+	ACL_CATEGORY_LIST
+	ACL_CATEGORY_HASH
+	ACL_CATEGORY_STRING
+	ACL_CATEGORY_BITMAP
+	ACL_CATEGORY_HYPERLOGLOG
+	ACL_CATEGORY_GEO
+	ACL_CATEGORY_STREAM
+	ACL_CATEGORY_PUBSUB
+	ACL_CATEGORY_ADMIN
+	ACL_CATEGORY_FAST
+	ACL_CATEGORY_SLOW
+	ACL_CATEGORY_BLOCKING
+	ACL_CATEGORY_DANGEROUS
+	ACL_CATEGORY_CONNECTION
+	ACL_CATEGORY_TRANSACTION
+	ACL_CATEGORY_SCRIPTING
+)
+
+var aclCategoryNames = []struct {
+	flag int
+	name string
+}{
+	{ACL_CATEGORY_KEYSPACE, "keyspace"},
+	{ACL_CATEGORY_READ, "read"},
+	{ACL_CATEGORY_WRITE, "write"},
+	{ACL_CATEGORY_SET, "set"},
+	{ACL_CATEGORY_SORTEDSET, "sortedset"},
+	{ACL_CATEGORY_LIST, "list"},
+	{ACL_CATEGORY_HASH, "hash"},
+	{ACL_CATEGORY_STRING, "string"},
+	{ACL_CATEGORY_BITMAP, "bitmap"},
+	{ACL_CATEGORY_HYPERLOGLOG, "hyperloglog"},
+	{ACL_CATEGORY_GEO, "geo"},
+	{ACL_CATEGORY_STREAM, "stream"},
+	{ACL_CATEGORY_PUBSUB, "pubsub"},
+	{ACL_CATEGORY_ADMIN, "admin"},
+	{ACL_CATEGORY_FAST, "fast"},
+	{ACL_CATEGORY_SLOW, "slow"},
+	{ACL_CATEGORY_BLOCKING, "blocking"},
+	{ACL_CATEGORY_DANGEROUS, "dangerous"},
+	{ACL_CATEGORY_CONNECTION, "connection"},
+	{ACL_CATEGORY_TRANSACTION, "transaction"},
+	{ACL_CATEGORY_SCRIPTING, "scripting"},
+}
+
+// aclGetCategoryByName 类别名称对应的标识，不存在时返回 0
+func aclGetCategoryByName(name string) int {
+	for _, c := range aclCategoryNames {
+		if strings.EqualFold(c.name, name) {
+			return c.flag
+		}
+	}
+	return 0
+}
+
+type RedisCommand struct {
+	name          string
+	proc          CommandProc
+	arity         int // 负数表示至少 -arity 个参数
+	flags         int
+	aclCategories int // 只需要填写数据类型等类别，读写、快慢等由 flags 推导
+	firstKey      int // 第一个键的位置，0 表示没有键
+	lastKey       int // 最后一个键的位置，负数表示从末尾倒数
+	keyStep       int
+	keySpecFlags  int                              // 为 0 时由 flags 推导，见 commandKeySpecFlags
+	getkeysProc   func(args []*obj.RedisObj) []int // 键的位置不固定时，返回键在参数中的位置
+	summary       string
+	since         string
+	group         string
+	subcommands   []RedisCommand
+
+	// 以下在 populateCommand 中生成
+	id              int    // 从 0 开始的编号，子命令也有编号，ACL 以此记录允许的命令
+	fullname        string // 子命令为 parent|sub
+	parent          *RedisCommand
+	subcommandsDict map[string]*RedisCommand
+
+	// 统计，INFO commandstats 与 latencystats 输出
+	calls            int64
+	microseconds     int64
+	rejectedCalls    int64 // 执行前被拒绝的次数，例如参数个数错误、没有权限
+	failedCalls      int64 // 执行中回复了错误的次数
+	latencyHistogram *latencyHistogram
+}
+
+var cmdTable []RedisCommand
+
+// commandTable 命令名称的小写形式到命令的映射
+var commandTable map[string]*RedisCommand
+
+// commandCount 包括子命令在内的命令数量
+var commandCount int
+
+// 命令处理函数间接引用了 cmdTable，需要在 init 中初始化
+func init() {
+	cmdTable = []RedisCommand{
+		{name: "get", proc: getCommand, arity: 2, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_STRING, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the string value of a key.", since: "1.0.0", group: "string"},
+		{name: "set", proc: setCommand, arity: 3, flags: CMD_WRITE | CMD_DENYOOM, aclCategories: ACL_CATEGORY_STRING, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_OW | CMD_KEY_UPDATE,
+			summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", since: "1.0.0", group: "string"},
+		{name: "del", proc: delCommand, arity: -2, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 1, lastKey: -1, keyStep: 1, keySpecFlags: CMD_KEY_RM | CMD_KEY_DELETE,
+			summary: "Deletes one or more keys.", since: "1.0.0", group: "generic"},
+		{name: "unlink", proc: unlinkCommand, arity: -2, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 1, lastKey: -1, keyStep: 1, keySpecFlags: CMD_KEY_RM | CMD_KEY_DELETE,
+			summary: "Asynchronously deletes one or more keys.", since: "4.0.0", group: "generic"},
+		{name: "expire", proc: expireCommand, arity: 3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_UPDATE,
+			summary: "Sets the expiration time of a key in seconds.", since: "1.0.0", group: "generic"},
+		{name: "pexpireat", proc: pexpireatCommand, arity: 3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_UPDATE,
+			summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", since: "2.6.0", group: "generic"},
+		{name: "auth", proc: authCommand, arity: -2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_NO_AUTH, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Authenticates the connection.", since: "1.0.0", group: "connection"},
+		{name: "hello", proc: helloCommand, arity: -1, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_NO_AUTH, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Handshakes with the Redis server.", since: "6.0.0", group: "connection"},
+		{name: "ping", proc: pingCommand, arity: -1, flags: CMD_FAST, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Returns the server's liveliness response.", since: "1.0.0", group: "connection"},
+		{name: "sync", proc: syncCommand, arity: 1, flags: CMD_ADMIN | CMD_NOSCRIPT,
+			summary: "An internal command used in replication.", since: "1.0.0", group: "server"},
+		{name: "psync", proc: syncCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT,
+			summary: "An internal command used in replication.", since: "2.8.0", group: "server"},
+		{name: "replconf", proc: replconfCommand, arity: -1, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+			summary: "An internal command for configuring the replication stream.", since: "3.0.0", group: "server"},
+		{name: "replicaof", proc: replicaofCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_STALE,
+			summary: "Configures a server as replica of another, or promotes it to a master.", since: "5.0.0", group: "server"},
+		{name: "slaveof", proc: replicaofCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_STALE,
+			summary: "Sets a Redis server as a replica of another, or promotes it to being a master.", since: "1.0.0", group: "server"},
+		{name: "wait", proc: waitCommand, arity: 3, flags: CMD_NOSCRIPT | CMD_BLOCKING, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.", since: "3.0.0", group: "generic"},
+		{name: "waitaof", proc: waitaofCommand, arity: 4, flags: CMD_NOSCRIPT | CMD_BLOCKING, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Blocks until all of the preceding write commands sent by the connection are written to the append-only file of the master and/or replicas.", since: "7.2.0", group: "generic"},
+		{name: "subscribe", proc: subscribeCommand, arity: -2, flags: CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+			summary: "Listens for messages published to channels.", since: "2.0.0", group: "pubsub"},
+		{name: "unsubscribe", proc: unsubscribeCommand, arity: -1, flags: CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+			summary: "Stops listening to messages posted to channels.", since: "2.0.0", group: "pubsub"},
+		{name: "psubscribe", proc: psubscribeCommand, arity: -2, flags: CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+			summary: "Listens for messages published to channels that match one or more patterns.", since: "2.0.0", group: "pubsub"},
+		{name: "punsubscribe", proc: punsubscribeCommand, arity: -1, flags: CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+			summary: "Stops listening to messages published to channels that match one or more patterns.", since: "2.0.0", group: "pubsub"},
+		{name: "publish", proc: publishCommand, arity: 3, flags: CMD_PUBSUB | CMD_LOADING | CMD_STALE | CMD_FAST,
+			summary: "Posts a message to a channel.", since: "2.0.0", group: "pubsub"},
+		{name: "pubsub", proc: pubsubCommand, arity: -2,
+			summary: "A container for Pub/Sub commands.", since: "2.8.0", group: "pubsub",
+			subcommands: []RedisCommand{
+				{name: "channels", proc: pubsubCommand, arity: -2, flags: CMD_PUBSUB | CMD_LOADING | CMD_STALE,
+					summary: "Returns the active channels.", since: "2.8.0", group: "pubsub"},
+				{name: "numpat", proc: pubsubCommand, arity: 2, flags: CMD_PUBSUB | CMD_LOADING | CMD_STALE,
+					summary: "Returns a count of unique pattern subscriptions.", since: "2.8.0", group: "pubsub"},
+				{name: "numsub", proc: pubsubCommand, arity: -2, flags: CMD_PUBSUB | CMD_LOADING | CMD_STALE,
+					summary: "Returns a count of subscribers to channels.", since: "2.8.0", group: "pubsub"},
+			}},
+		{name: "multi", proc: multiCommand, arity: 1, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION,
+			summary: "Starts a transaction.", since: "1.2.0", group: "transactions"},
+		{name: "exec", proc: execCommand, arity: 1, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_SKIP_SLOWLOG, aclCategories: ACL_CATEGORY_TRANSACTION,
+			summary: "Executes all commands in a transaction.", since: "1.2.0", group: "transactions"},
+		{name: "discard", proc: discardCommand, arity: 1, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION,
+			summary: "Discards a transaction.", since: "2.0.0", group: "transactions"},
+		{name: "watch", proc: watchCommand, arity: -2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION, firstKey: 1, lastKey: -1, keyStep: 1,
+			summary: "Monitors changes to keys to determine the execution of a transaction.", since: "2.2.0", group: "transactions"},
+		{name: "unwatch", proc: unwatchCommand, arity: 1, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION,
+			summary: "Forgets about watched keys of a transaction.", since: "2.2.0", group: "transactions"},
+		{name: "lpush", proc: lpushCommand, arity: -3, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_INSERT,
+			summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.", since: "1.0.0", group: "list"},
+		{name: "rpush", proc: rpushCommand, arity: -3, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_INSERT,
+			summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.", since: "1.0.0", group: "list"},
+		{name: "lpop", proc: lpopCommand, arity: -2, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", since: "1.0.0", group: "list"},
+		{name: "rpop", proc: rpopCommand, arity: -2, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.", since: "1.0.0", group: "list"},
+		{name: "llen", proc: llenCommand, arity: 2, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the length of a list.", since: "1.0.0", group: "list"},
+		{name: "lrange", proc: lrangeCommand, arity: 4, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns a range of elements from a list.", since: "1.0.0", group: "list"},
+		{name: "lmove", proc: lmoveCommand, arity: 5, flags: CMD_WRITE | CMD_DENYOOM, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 2, keyStep: 1,
+			summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", since: "6.2.0", group: "list"},
+		{name: "lmpop", proc: lmpopCommand, arity: -4, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_LIST, getkeysProc: lmpopGetKeys,
+			summary: "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.", since: "7.0.0", group: "list"},
+		{name: "blpop", proc: blpopCommand, arity: -3, flags: CMD_WRITE | CMD_BLOCKING, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: -2, keyStep: 1,
+			summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", since: "2.0.0", group: "list"},
+		{name: "brpop", proc: brpopCommand, arity: -3, flags: CMD_WRITE | CMD_BLOCKING, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: -2, keyStep: 1,
+			summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", since: "2.0.0", group: "list"},
+		{name: "blmove", proc: blmoveCommand, arity: 6, flags: CMD_WRITE | CMD_DENYOOM | CMD_BLOCKING, aclCategories: ACL_CATEGORY_LIST, firstKey: 1, lastKey: 2, keyStep: 1,
+			summary: "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.", since: "6.2.0", group: "list"},
+		{name: "blmpop", proc: blmpopCommand, arity: -5, flags: CMD_WRITE | CMD_BLOCKING, aclCategories: ACL_CATEGORY_LIST, getkeysProc: blmpopGetKeys,
+			summary: "Pops the first element from one of multiple lists. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", since: "7.0.0", group: "list"},
+		{name: "zadd", proc: zaddCommand, arity: -4, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_UPDATE,
+			summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", since: "1.2.0", group: "sorted-set"},
+		{name: "zrem", proc: zremCommand, arity: -3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_DELETE,
+			summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", since: "1.2.0", group: "sorted-set"},
+		{name: "zcard", proc: zcardCommand, arity: 2, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the number of members in a sorted set.", since: "1.2.0", group: "sorted-set"},
+		{name: "zscore", proc: zscoreCommand, arity: 3, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the score of a member in a sorted set.", since: "1.2.0", group: "sorted-set"},
+		{name: "zrange", proc: zrangeCommand, arity: -4, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns members in a sorted set within a range of indexes.", since: "1.2.0", group: "sorted-set"},
+		{name: "zpopmin", proc: zpopminCommand, arity: -2, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", since: "5.0.0", group: "sorted-set"},
+		{name: "zpopmax", proc: zpopmaxCommand, arity: -2, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", since: "5.0.0", group: "sorted-set"},
+		{name: "bzpopmin", proc: bzpopminCommand, arity: -3, flags: CMD_WRITE | CMD_FAST | CMD_BLOCKING, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: -2, keyStep: 1,
+			summary: "Removes and returns the member with the lowest score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.", since: "5.0.0", group: "sorted-set"},
+		{name: "bzpopmax", proc: bzpopmaxCommand, arity: -3, flags: CMD_WRITE | CMD_FAST | CMD_BLOCKING, aclCategories: ACL_CATEGORY_SORTEDSET, firstKey: 1, lastKey: -2, keyStep: 1,
+			summary: "Removes and returns the member with the highest score from one or more sorted sets. Blocks until a member available otherwise. Deletes the sorted set if the last element was popped.", since: "5.0.0", group: "sorted-set"},
+		{name: "client", proc: clientCommand, arity: -2,
+			summary: "A container for client connection commands.", since: "2.4.0", group: "connection",
+			subcommands: []RedisCommand{
+				{name: "getname", proc: clientCommand, arity: 2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns the name of the connection.", since: "2.6.9", group: "connection"},
+				{name: "id", proc: clientCommand, arity: 2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns the unique client ID of the connection.", since: "5.0.0", group: "connection"},
+				{name: "info", proc: clientCommand, arity: 2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns information about the connection.", since: "6.2.0", group: "connection"},
+				{name: "kill", proc: clientCommand, arity: -3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Terminates open connections.", since: "2.4.0", group: "connection"},
+				{name: "list", proc: clientCommand, arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Lists open connections.", since: "2.4.0", group: "connection"},
+				{name: "no-evict", proc: clientCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Sets the client eviction mode of the connection.", since: "7.0.0", group: "connection"},
+				{name: "pause", proc: clientCommand, arity: -3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Suspends commands processing.", since: "3.0.0", group: "connection"},
+				{name: "reply", proc: clientCommand, arity: 3, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Instructs the server whether to reply to commands.", since: "3.2.0", group: "connection"},
+				{name: "setname", proc: clientCommand, arity: 3, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Sets the connection name.", since: "2.6.9", group: "connection"},
+				{name: "unpause", proc: clientCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Resumes processing commands from paused clients.", since: "6.2.0", group: "connection"},
+				{name: "help", proc: clientCommand, arity: 2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns helpful text about the different subcommands.", since: "5.0.0", group: "connection"},
+				{name: "unblock", proc: clientCommand, arity: -3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Unblocks a client blocked by a blocking command from a different connection.", since: "5.0.0", group: "connection"},
+			}},
+		{name: "xadd", proc: xaddCommand, arity: -5, flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_UPDATE,
+			summary: "Appends a new message to a stream. Creates the key if it doesn't exist.", since: "5.0.0", group: "stream"},
+		{name: "xrange", proc: xrangeCommand, arity: -4, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the messages from a stream within a range of IDs.", since: "5.0.0", group: "stream"},
+		{name: "xrevrange", proc: xrevrangeCommand, arity: -4, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the messages from a stream within a range of IDs in reverse order.", since: "5.0.0", group: "stream"},
+		{name: "xlen", proc: xlenCommand, arity: 2, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Return the number of messages in a stream.", since: "5.0.0", group: "stream"},
+		{name: "xdel", proc: xdelCommand, arity: -3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_DELETE,
+			summary: "Returns the number of messages after removing them from a stream.", since: "5.0.0", group: "stream"},
+		{name: "xtrim", proc: xtrimCommand, arity: -4, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_DELETE,
+			summary: "Deletes messages from the beginning of a stream.", since: "5.0.0", group: "stream"},
+		{name: "xread", proc: xreadCommand, arity: -4, flags: CMD_READONLY | CMD_BLOCKING, aclCategories: ACL_CATEGORY_STREAM, getkeysProc: xreadGetKeys,
+			summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.", since: "5.0.0", group: "stream"},
+		{name: "xgroup", proc: xgroupCommand, arity: -2,
+			summary: "A container for consumer groups commands.", since: "5.0.0", group: "stream",
+			subcommands: []RedisCommand{
+				{name: "create", proc: xgroupCommand, arity: -5, flags: CMD_WRITE | CMD_DENYOOM, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_INSERT,
+					summary: "Creates a consumer group.", since: "5.0.0", group: "stream"},
+				{name: "setid", proc: xgroupCommand, arity: -5, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_UPDATE,
+					summary: "Sets the last-delivered ID of a consumer group.", since: "5.0.0", group: "stream"},
+				{name: "destroy", proc: xgroupCommand, arity: 4, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_DELETE,
+					summary: "Destroys a consumer group.", since: "5.0.0", group: "stream"},
+				{name: "createconsumer", proc: xgroupCommand, arity: 5, flags: CMD_WRITE | CMD_DENYOOM, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_INSERT,
+					summary: "Creates a consumer in a consumer group.", since: "6.2.0", group: "stream"},
+				{name: "delconsumer", proc: xgroupCommand, arity: 5, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_DELETE,
+					summary: "Deletes a consumer from a consumer group.", since: "5.0.0", group: "stream"},
+				{name: "help", proc: xgroupCommand, arity: 2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_STREAM,
+					summary: "Returns helpful text about the different subcommands.", since: "5.0.0", group: "stream"},
+			}},
+		{name: "xreadgroup", proc: xreadCommand, arity: -7, flags: CMD_WRITE | CMD_BLOCKING, aclCategories: ACL_CATEGORY_STREAM, getkeysProc: xreadGetKeys,
+			summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.", since: "5.0.0", group: "stream"},
+		{name: "xack", proc: xackCommand, arity: -4, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1, keySpecFlags: CMD_KEY_RW | CMD_KEY_UPDATE,
+			summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.", since: "5.0.0", group: "stream"},
+		{name: "xpending", proc: xpendingCommand, arity: -3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Returns the information and entries from a stream consumer group's pending entries list.", since: "5.0.0", group: "stream"},
+		{name: "xclaim", proc: xclaimCommand, arity: -6, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.", since: "5.0.0", group: "stream"},
+		{name: "xautoclaim", proc: xautoclaimCommand, arity: -6, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_STREAM, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.", since: "6.2.0", group: "stream"},
+		{name: "xinfo", proc: xinfoCommand, arity: -2,
+			summary: "A container for stream introspection commands.", since: "5.0.0", group: "stream",
+			subcommands: []RedisCommand{
+				{name: "consumers", proc: xinfoCommand, arity: 4, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns a list of the consumers in a consumer group.", since: "5.0.0", group: "stream"},
+				{name: "groups", proc: xinfoCommand, arity: 3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns a list of the consumer groups of a stream.", since: "5.0.0", group: "stream"},
+				{name: "stream", proc: xinfoCommand, arity: -3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_STREAM, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns information about a stream.", since: "5.0.0", group: "stream"},
+				{name: "help", proc: xinfoCommand, arity: 2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_STREAM,
+					summary: "Returns helpful text about the different subcommands.", since: "5.0.0", group: "stream"},
+			}},
+		{name: "select", proc: selectCommand, arity: 2, flags: CMD_LOADING | CMD_STALE | CMD_FAST, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Changes the selected database.", since: "1.0.0", group: "connection"},
+		{name: "move", proc: moveCommand, arity: 3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 1, lastKey: 1, keyStep: 1,
+			summary: "Moves a key to another database.", since: "1.0.0", group: "generic"},
+		{name: "swapdb", proc: swapdbCommand, arity: 3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS,
+			summary: "Swaps two Redis databases.", since: "4.0.0", group: "server"},
+		{name: "flushdb", proc: flushdbCommand, arity: -1, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS,
+			summary: "Remove all keys from the current database.", since: "1.0.0", group: "server"},
+		{name: "flushall", proc: flushallCommand, arity: -1, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS,
+			summary: "Removes all keys from all databases.", since: "1.0.0", group: "server"},
+		{name: "dbsize", proc: dbsizeCommand, arity: 1, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE,
+			summary: "Returns the number of keys in the database.", since: "1.0.0", group: "server"},
+		{name: "info", proc: infoCommand, arity: -1, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_DANGEROUS,
+			summary: "Returns information and statistics about the server.", since: "1.0.0", group: "server"},
+		{name: "memory", proc: memoryCommand, arity: -2,
+			summary: "A container for memory diagnostics commands.", since: "4.0.0", group: "server",
+			subcommands: []RedisCommand{
+				{name: "usage", proc: memoryCommand, arity: -3, flags: CMD_READONLY, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Estimates the memory usage of a key.", since: "4.0.0", group: "server"},
+				{name: "help", proc: memoryCommand, arity: 2, flags: CMD_LOADING | CMD_STALE,
+					summary: "Returns helpful text about the different subcommands.", since: "4.0.0", group: "server"},
+			}},
+		{name: "object", proc: objectCommand, arity: -2,
+			summary: "A container for object introspection commands.", since: "2.2.3", group: "generic",
+			subcommands: []RedisCommand{
+				{name: "encoding", proc: objectCommand, arity: 3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the internal encoding of a Redis object.", since: "2.2.3", group: "generic"},
+				{name: "freq", proc: objectCommand, arity: 3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the logarithmic access frequency counter of a Redis object.", since: "4.0.0", group: "generic"},
+				{name: "idletime", proc: objectCommand, arity: 3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the time since the last access to a Redis object.", since: "2.2.3", group: "generic"},
+				{name: "refcount", proc: objectCommand, arity: 3, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_KEYSPACE, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the reference count of a value of a key.", since: "2.2.3", group: "generic"},
+				{name: "help", proc: objectCommand, arity: 2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_KEYSPACE,
+					summary: "Returns helpful text about the different subcommands.", since: "6.2.0", group: "generic"},
+			}},
+		{name: "latency", proc: latencyCommand, arity: -2,
+			summary: "A container for latency diagnostics commands.", since: "2.8.13", group: "server",
+			subcommands: []RedisCommand{
+				{name: "doctor", proc: latencyCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Returns a human-readable latency analysis report.", since: "2.8.13", group: "server"},
+				{name: "graph", proc: latencyCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Returns a latency graph for an event.", since: "2.8.13", group: "server"},
+				{name: "help", proc: latencyCommand, arity: 2, flags: CMD_LOADING | CMD_STALE,
+					summary: "Returns helpful text about the different subcommands.", since: "2.8.13", group: "server"},
+				{name: "histogram", proc: latencyCommand, arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Returns the cumulative distribution of latencies of a subset or all commands.", since: "7.0.0", group: "server"},
+				{name: "history", proc: latencyCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Returns timestamp-latency samples for an event.", since: "2.8.13", group: "server"},
+				{name: "latest", proc: latencyCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Returns the latest latency samples for all events.", since: "2.8.13", group: "server"},
+				{name: "reset", proc: latencyCommand, arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Resets the latency data for one or more events.", since: "2.8.13", group: "server"},
+			}},
+		{name: "monitor", proc: monitorCommand, arity: 1, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+			summary: "Listens for all requests received by the server in real time.", since: "1.0.0", group: "server"},
+		{name: "slowlog", proc: slowlogCommand, arity: -2,
+			summary: "A container for slow log commands.", since: "2.2.12", group: "server",
+			subcommands: []RedisCommand{
+				{name: "get", proc: slowlogCommand, arity: -2, flags: CMD_ADMIN | CMD_LOADING | CMD_STALE,
+					summary: "Returns the slow log's entries.", since: "2.2.12", group: "server"},
+				{name: "help", proc: slowlogCommand, arity: 2, flags: CMD_LOADING | CMD_STALE,
+					summary: "Show helpful text about the different subcommands.", since: "6.2.0", group: "server"},
+				{name: "len", proc: slowlogCommand, arity: 2, flags: CMD_ADMIN | CMD_LOADING | CMD_STALE,
+					summary: "Returns the number of entries in the slow log.", since: "2.2.12", group: "server"},
+				{name: "reset", proc: slowlogCommand, arity: 2, flags: CMD_ADMIN | CMD_LOADING | CMD_STALE,
+					summary: "Clears all entries from the slow log.", since: "2.2.12", group: "server"},
+			}},
+		{name: "acl", proc: aclCommand, arity: -2,
+			summary: "A container for Access List Control commands.", since: "6.0.0", group: "server",
+			subcommands: []RedisCommand{
+				{name: "cat", proc: aclCommand, arity: -2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Lists the ACL categories, or the commands inside a category.", since: "6.0.0", group: "server"},
+				{name: "deluser", proc: aclCommand, arity: -3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Deletes ACL users, and terminates their connections.", since: "6.0.0", group: "server"},
+				{name: "dryrun", proc: aclCommand, arity: -4, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Simulates the execution of a command by a user, without executing the command.", since: "7.0.0", group: "server"},
+				{name: "genpass", proc: aclCommand, arity: -2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Generates a pseudorandom, secure password that can be used to identify ACL users.", since: "6.0.0", group: "server"},
+				{name: "getuser", proc: aclCommand, arity: 3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Lists the ACL rules of a user.", since: "6.0.0", group: "server"},
+				{name: "list", proc: aclCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Dumps the effective rules in ACL file format.", since: "6.0.0", group: "server"},
+				{name: "load", proc: aclCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Reloads the rules from the configured ACL file.", since: "6.0.0", group: "server"},
+				{name: "log", proc: aclCommand, arity: -2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Lists recent security events generated due to ACL rules.", since: "6.0.0", group: "server"},
+				{name: "save", proc: aclCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Saves the effective ACL rules in the configured ACL file.", since: "6.0.0", group: "server"},
+				{name: "setuser", proc: aclCommand, arity: -3, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Creates and modifies an ACL user and its rules.", since: "6.0.0", group: "server"},
+				{name: "users", proc: aclCommand, arity: 2, flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Lists all ACL users.", since: "6.0.0", group: "server"},
+				{name: "whoami", proc: aclCommand, arity: 2, flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+					summary: "Returns the authenticated username of the current connection.", since: "6.0.0", group: "server"},
+				{name: "help", proc: aclCommand, arity: 2, flags: CMD_LOADING | CMD_STALE,
+					summary: "Returns helpful text about the different subcommands.", since: "6.0.0", group: "server"},
+			}},
+		{name: "command", proc: commandCommand, arity: -1, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+			summary: "Returns detailed information about all commands.", since: "2.8.13", group: "server",
+			subcommands: []RedisCommand{
+				{name: "count", proc: commandCommand, arity: 2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns a count of commands.", since: "2.8.13", group: "server"},
+				{name: "docs", proc: commandCommand, arity: -2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns documentary information about one, multiple or all commands.", since: "7.0.0", group: "server"},
+				{name: "getkeys", proc: commandCommand, arity: -3, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Extracts the key names from an arbitrary command.", since: "2.8.13", group: "server"},
+				{name: "info", proc: commandCommand, arity: -2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns information about one, multiple or all commands.", since: "2.8.13", group: "server"},
+				{name: "list", proc: commandCommand, arity: -2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns a list of command names.", since: "7.0.0", group: "server"},
+				{name: "help", proc: commandCommand, arity: 2, flags: CMD_LOADING | CMD_STALE, aclCategories: ACL_CATEGORY_CONNECTION,
+					summary: "Returns helpful text about the different subcommands.", since: "5.0.0", group: "server"},
+			}},
+	}
+	commandTable = make(map[string]*RedisCommand, len(cmdTable))
+	commandCount = 0
+	for i := range cmdTable {
+		populateCommand(&cmdTable[i], nil)
+		commandTable[cmdTable[i].name] = &cmdTable[i]
+	}
+}
+
+// populateCommand 生成全名、子命令表，并根据 flags 补充 ACL 类别
+func populateCommand(cmd *RedisCommand, parent *RedisCommand) {
+	cmd.id = commandCount
+	commandCount++
+	cmd.parent = parent
+	cmd.fullname = cmd.name
+	if parent != nil {
+		cmd.fullname = parent.name + "|" + cmd.name
+	}
+	setImplicitACLCategories(cmd)
+	if len(cmd.subcommands) == 0 {
+		return
+	}
+	cmd.subcommandsDict = make(map[string]*RedisCommand, len(cmd.subcommands))
+	for i := range cmd.subcommands {
+		sub := &cmd.subcommands[i]
+		populateCommand(sub, cmd)
+		cmd.subcommandsDict[sub.name] = sub
+	}
+}
+
+func setImplicitACLCategories(cmd *RedisCommand) {
+	if cmd.flags&CMD_WRITE != 0 {
+		cmd.aclCategories |= ACL_CATEGORY_WRITE
+	}
+	if cmd.flags&CMD_READONLY != 0 {
+		cmd.aclCategories |= ACL_CATEGORY_READ
+	}
+	if cmd.flags&CMD_ADMIN != 0 {
+		cmd.aclCategories |= ACL_CATEGORY_ADMIN | ACL_CATEGORY_DANGEROUS
+	}
+	if cmd.flags&CMD_PUBSUB != 0 {
+		cmd.aclCategories |= ACL_CATEGORY_PUBSUB
+	}
+	if cmd.flags&CMD_FAST != 0 {
+		cmd.aclCategories |= ACL_CATEGORY_FAST
+	}
+	if cmd.flags&CMD_BLOCKING != 0 {
+		cmd.aclCategories |= ACL_CATEGORY_BLOCKING
+	}
+	if cmd.aclCategories&ACL_CATEGORY_FAST == 0 {
+		cmd.aclCategories |= ACL_CATEGORY_SLOW
+	}
+}
+
+// forEachCommand 遍历所有命令，包括子命令
+func forEachCommand(fn func(cmd *RedisCommand)) {
+	for i := range cmdTable {
+		fn(&cmdTable[i])
+		for j := range cmdTable[i].subcommands {
+			fn(&cmdTable[i].subcommands[j])
+		}
+	}
+}
+
+// lookupCommand 按名称查找命令，不区分大小写
+func lookupCommand(name string) *RedisCommand {
+	return commandTable[strings.ToLower(name)]
+}
+
+// lookupSubcommand 查找容器命令的子命令
+func lookupSubcommand(container *RedisCommand, name string) *RedisCommand {
+	return container.subcommandsDict[strings.ToLower(name)]
+}
+
+// lookupCommandByArgs 根据参数查找命令，容器命令的子命令存在时返回子命令，否则返回容器命令
+func lookupCommandByArgs(args []*obj.RedisObj) *RedisCommand {
+	cmd := lookupCommand(args[0].StrVal())
+	if cmd == nil || cmd.subcommandsDict == nil || len(args) < 2 {
+		return cmd
+	}
+	if sub := lookupSubcommand(cmd, args[1].StrVal()); sub != nil {
+		return sub
+	}
+	return cmd
+}
+
+// lookupCommandByFullname 按全名查找命令，例如 object|encoding
+func lookupCommandByFullname(fullname string) *RedisCommand {
+	parts := strings.Split(fullname, "|")
+	if len(parts) > 2 {
+		return nil
+	}
+	cmd := lookupCommand(parts[0])
+	if cmd == nil || len(parts) == 1 {
+		return cmd
+	}
+	return lookupSubcommand(cmd, parts[1])
+}
+
+// commandCheckArity 检查参数个数是否满足命令要求
+func commandCheckArity(cmd *RedisCommand, argc int) bool {
+	return (cmd.arity > 0 && cmd.arity == argc) || (cmd.arity < 0 && argc >= -cmd.arity)
+}
+
+// getKeysFromCommand 返回命令中键的位置
+func getKeysFromCommand(cmd *RedisCommand, args []*obj.RedisObj) []int {
+	if cmd.getkeysProc != nil {
+		return cmd.getkeysProc(args)
+	}
+	if cmd.firstKey == 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	var keys []int
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.keyStep {
+		keys = append(keys, i)
+	}
+	return keys
+}
+
+// numkeysGetKeys 键的个数在 numkeysIdx 位置，紧接着是键
+func numkeysGetKeys(args []*obj.RedisObj, numkeysIdx int) []int {
+	if numkeysIdx >= len(args) {
+		return nil
+	}
+	n, err := strconv.Atoi(args[numkeysIdx].StrVal())
+	if err != nil || n <= 0 || numkeysIdx+n >= len(args) {
+		return nil
+	}
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = numkeysIdx + 1 + i
+	}
+	return keys
+}
+
+// lmpopGetKeys LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func lmpopGetKeys(args []*obj.RedisObj) []int {
+	return numkeysGetKeys(args, 1)
+}
+
+// blmpopGetKeys BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func blmpopGetKeys(args []*obj.RedisObj) []int {
+	return numkeysGetKeys(args, 2)
+}
+
+// xreadGetKeys STREAMS 之后的前一半参数是键，后一半是 ID
+func xreadGetKeys(args []*obj.RedisObj) []int {
+	for i := 1; i < len(args); i++ {
+		if !strings.EqualFold(args[i].StrVal(), "streams") {
+			continue
+		}
+		num := len(args) - i - 1
+		if num == 0 || num%2 != 0 {
+			return nil
+		}
+		keys := make([]int, num/2)
+		for j := range keys {
+			keys[j] = i + 1 + j
+		}
+		return keys
+	}
+	return nil
+}
+
+func addReplyCommandFlags(c *RedisClient, cmd *RedisCommand) {
+	var names []string
+	for _, f := range commandFlagNames {
+		if cmd.flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	if cmd.getkeysProc != nil {
+		names = append(names, "movablekeys")
+	}
+	c.AddReplyArrayLen(len(names))
+	for _, name := range names {
+		c.AddReplyStr("+" + name + "\r\n")
+	}
+}
+
+func addReplyCommandCategories(c *RedisClient, cmd *RedisCommand) {
+	var names []string
+	for _, cat := range aclCategoryNames {
+		if cmd.aclCategories&cat.flag != 0 {
+			names = append(names, cat.name)
+		}
+	}
+	c.AddReplyArrayLen(len(names))
+	for _, name := range names {
+		c.AddReplyStr("+@" + name + "\r\n")
+	}
+}
+
+// commandKeySpecFlags 没有填写时，写命令按读写处理，其余命令按只读处理
+func commandKeySpecFlags(cmd *RedisCommand) int {
+	if cmd.keySpecFlags != 0 {
+		return cmd.keySpecFlags
+	}
+	if cmd.flags&CMD_WRITE != 0 {
+		return CMD_KEY_RW | CMD_KEY_ACCESS | CMD_KEY_UPDATE
+	}
+	return CMD_KEY_RO | CMD_KEY_ACCESS
+}
+
+// addReplyCommandKeySpecs 由 firstKey/lastKey/keyStep 生成键描述，位置不固定的键描述为 unknown
+func addReplyCommandKeySpecs(c *RedisClient, cmd *RedisCommand) {
+	if cmd.firstKey == 0 && cmd.getkeysProc == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	c.AddReplyArrayLen(1)
+	c.AddReplyArrayLen(6)
+	c.AddReplyBulk("flags")
+	var names []string
+	for _, f := range keySpecFlagNames {
+		if commandKeySpecFlags(cmd)&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	c.AddReplyArrayLen(len(names))
+	for _, name := range names {
+		c.AddReplyStr("+" + name + "\r\n")
+	}
+	if cmd.getkeysProc != nil {
+		c.AddReplyBulk("begin_search")
+		c.AddReplyArrayLen(4)
+		c.AddReplyBulk("type")
+		c.AddReplyBulk("unknown")
+		c.AddReplyBulk("spec")
+		c.AddReplyArrayLen(0)
+		c.AddReplyBulk("find_keys")
+		c.AddReplyArrayLen(4)
+		c.AddReplyBulk("type")
+		c.AddReplyBulk("unknown")
+		c.AddReplyBulk("spec")
+		c.AddReplyArrayLen(0)
+		return
+	}
+	c.AddReplyBulk("begin_search")
+	c.AddReplyArrayLen(4)
+	c.AddReplyBulk("type")
+	c.AddReplyBulk("index")
+	c.AddReplyBulk("spec")
+	c.AddReplyArrayLen(2)
+	c.AddReplyBulk("index")
+	c.AddReplyInt(int64(cmd.firstKey))
+	// lastkey 相对于第一个键，负数表示从末尾倒数
+	lastkey := cmd.lastKey
+	if lastkey >= 0 {
+		lastkey -= cmd.firstKey
+	}
+	c.AddReplyBulk("find_keys")
+	c.AddReplyArrayLen(4)
+	c.AddReplyBulk("type")
+	c.AddReplyBulk("range")
+	c.AddReplyBulk("spec")
+	c.AddReplyArrayLen(6)
+	c.AddReplyBulk("lastkey")
+	c.AddReplyInt(int64(lastkey))
+	c.AddReplyBulk("keystep")
+	c.AddReplyInt(int64(cmd.keyStep))
+	c.AddReplyBulk("limit")
+	c.AddReplyInt(0)
+}
+
+// addReplyCommandInfo 命令的 10 项信息：名称、参数个数、标识、键的位置、ACL 类别、提示、键描述与子命令
+func addReplyCommandInfo(c *RedisClient, cmd *RedisCommand) {
+	if cmd == nil {
+		c.AddReplyStr("*-1\r\n")
+		return
+	}
+	c.AddReplyArrayLen(10)
+	c.AddReplyBulk(cmd.fullname)
+	c.AddReplyInt(int64(cmd.arity))
+	addReplyCommandFlags(c, cmd)
+	c.AddReplyInt(int64(cmd.firstKey))
+	c.AddReplyInt(int64(cmd.lastKey))
+	c.AddReplyInt(int64(cmd.keyStep))
+	addReplyCommandCategories(c, cmd)
+	c.AddReplyArrayLen(0)
+	addReplyCommandKeySpecs(c, cmd)
+	c.AddReplyArrayLen(len(cmd.subcommands))
+	for i := range cmd.subcommands {
+		addReplyCommandInfo(c, &cmd.subcommands[i])
+	}
+}
+
+// addReplyCommandDocs 以键值对的形式回复命令的文档
+func addReplyCommandDocs(c *RedisClient, cmd *RedisCommand) {
+	n := 3
+	if len(cmd.subcommands) > 0 {
+		n++
+	}
+	c.AddReplyArrayLen(n * 2)
+	c.AddReplyBulk("summary")
+	c.AddReplyBulk(cmd.summary)
+	c.AddReplyBulk("since")
+	c.AddReplyBulk(cmd.since)
+	c.AddReplyBulk("group")
+	c.AddReplyBulk(cmd.group)
+	if len(cmd.subcommands) > 0 {
+		c.AddReplyBulk("subcommands")
+		c.AddReplyArrayLen(len(cmd.subcommands) * 2)
+		for i := range cmd.subcommands {
+			c.AddReplyBulk(cmd.subcommands[i].fullname)
+			addReplyCommandDocs(c, &cmd.subcommands[i])
+		}
+	}
+}
+
+// sortedCommands 按名称排序的顶层命令，保证回复的顺序固定
+func sortedCommands() []*RedisCommand {
+	cmds := make([]*RedisCommand, 0, len(commandTable))
+	for _, cmd := range commandTable {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].name < cmds[j].name })
+	return cmds
+}
+
+// commandListFilter COMMAND LIST 的过滤条件，匹配时返回 true
+func commandListFilter(cmd *RedisCommand, filter, arg string) bool {
+	switch filter {
+	case "aclcat":
+		cat := aclGetCategoryByName(arg)
+		return cat != 0 && cmd.aclCategories&cat != 0
+	case "pattern":
+		return stringMatch(arg, cmd.fullname, true)
+	}
+	// 没有模块，按模块过滤的结果总是为空
+	return false
+}
+
+// commandCommand COMMAND [COUNT | INFO [command ...] | DOCS [command ...] | GETKEYS command [arg ...] | LIST [FILTERBY ...]]
+func commandCommand(c *RedisClient) {
+	if len(c.args) == 1 {
+		cmds := sortedCommands()
+		c.AddReplyArrayLen(len(cmds))
+		for _, cmd := range cmds {
+			addReplyCommandInfo(c, cmd)
+		}
+		return
+	}
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "help" && len(c.args) == 2:
+		addReplyHelp(c, []string{
+			"(no subcommand)",
+			"    Return details about all Redis commands.",
+			"COUNT",
+			"    Return the total number of commands in this Redis server.",
+			"LIST",
+			"    Return a list of all commands in this Redis server.",
+			"INFO [<command-name> ...]",
+			"    Return details about multiple Redis commands.",
+			"    If no command names are given, documentation details for all",
+			"    commands are returned.",
+			"DOCS [<command-name> ...]",
+			"    Return documentation details about multiple Redis commands.",
+			"    If no command names are given, documentation details for all",
+			"    commands are returned.",
+			"GETKEYS <full-command>",
+			"    Return the keys from a full Redis command.",
+		})
+	case sub == "count" && len(c.args) == 2:
+		c.AddReplyInt(int64(len(commandTable)))
+	case sub == "info":
+		if len(c.args) == 2 {
+			cmds := sortedCommands()
+			c.AddReplyArrayLen(len(cmds))
+			for _, cmd := range cmds {
+				addReplyCommandInfo(c, cmd)
+			}
+			return
+		}
+		c.AddReplyArrayLen(len(c.args) - 2)
+		for _, arg := range c.args[2:] {
+			addReplyCommandInfo(c, lookupCommandByFullname(arg.StrVal()))
+		}
+	case sub == "docs":
+		var cmds []*RedisCommand
+		if len(c.args) == 2 {
+			cmds = sortedCommands()
+		} else {
+			// 不存在的命令不出现在回复中
+			for _, arg := range c.args[2:] {
+				if cmd := lookupCommandByFullname(arg.StrVal()); cmd != nil {
+					cmds = append(cmds, cmd)
+				}
+			}
+		}
+		c.AddReplyArrayLen(len(cmds) * 2)
+		for _, cmd := range cmds {
+			c.AddReplyBulk(cmd.fullname)
+			addReplyCommandDocs(c, cmd)
+		}
+	case sub == "getkeys" && len(c.args) >= 3:
+		args := c.args[2:]
+		cmd := lookupCommandByArgs(args)
+		if cmd == nil {
+			c.AddReplyError("Invalid command specified")
+			return
+		} else if !commandCheckArity(cmd, len(args)) {
+			c.AddReplyError("Invalid number of arguments specified for command")
+			return
+		}
+		keys := getKeysFromCommand(cmd, args)
+		if len(keys) == 0 {
+			c.AddReplyError("The command has no key arguments")
+			return
+		}
+		c.AddReplyArrayLen(len(keys))
+		for _, i := range keys {
+			c.AddReplyBulk(args[i].StrVal())
+		}
+	case sub == "list" && (len(c.args) == 2 || len(c.args) == 5):
+		filter, arg := "", ""
+		if len(c.args) == 5 {
+			filter = strings.ToLower(c.args[3].StrVal())
+			arg = c.args[4].StrVal()
+			if !strings.EqualFold(c.args[2].StrVal(), "filterby") ||
+				(filter != "module" && filter != "aclcat" && filter != "pattern") {
+				c.AddReplyError("syntax error")
+				return
+			}
+		}
+		var names []string
+		for _, cmd := range sortedCommands() {
+			if filter == "" || commandListFilter(cmd, filter, arg) {
+				names = append(names, cmd.fullname)
+			}
+			for i := range cmd.subcommands {
+				if filter == "" || commandListFilter(&cmd.subcommands[i], filter, arg) {
+					names = append(names, cmd.subcommands[i].fullname)
+				}
+			}
+		}
+		c.AddReplyArrayLen(len(names))
+		for _, name := range names {
+			c.AddReplyBulk(name)
+		}
+	default:
+		c.AddReplyError(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try COMMAND HELP.", c.args[1].StrVal()))
+	}
+}
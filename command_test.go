@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go-redis/conf"
+	"go-redis/obj"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCommand(t *testing.T) {
+	assert.Equal(t, "get", lookupCommand("GeT").name)
+	assert.Nil(t, lookupCommand("nosuchcmd"))
+	args := []*obj.RedisObj{obj.CreateObject(obj.STR, "OBJECT"), obj.CreateObject(obj.STR, "Encoding")}
+	assert.Equal(t, "object|encoding", lookupCommandByArgs(args).fullname)
+	args[1] = obj.CreateObject(obj.STR, "foo")
+	assert.Equal(t, "object", lookupCommandByArgs(args).fullname)
+	assert.Equal(t, "xgroup|create", lookupCommandByFullname("XGROUP|CREATE").fullname)
+	assert.Nil(t, lookupCommandByFullname("get|foo"))
+
+	// 读写、快慢等类别由标识推导
+	get := lookupCommand("get")
+	assert.Equal(t, ACL_CATEGORY_READ|ACL_CATEGORY_STRING|ACL_CATEGORY_FAST, get.aclCategories)
+	assert.NotZero(t, lookupCommand("blpop").aclCategories&ACL_CATEGORY_BLOCKING)
+	assert.NotZero(t, lookupCommand("sync").aclCategories&ACL_CATEGORY_DANGEROUS)
+}
+
+func TestProcessCommandCaseAndSubcommandArity(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "SeT k v\r\nGET k\r\nobject encoding\r\nobject encoding k extra\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n$1\r\nv\r\n"+
+		"-ERR wrong number of arguments for 'object|encoding' command\r\n"+
+		"-ERR wrong number of arguments for 'object|encoding' command\r\n", allReplies(c))
+
+	// 未知的子命令由容器命令回复
+	ReadQuery(c, "xgroup foo\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR unknown subcommand or wrong number of arguments for 'foo'. Try XGROUP HELP.\r\n", allReplies(c))
+}
+
+func TestCommandInfo(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "command info get nosuchcmd\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n"+
+		"*3\r\n+@read\r\n+@string\r\n+@fast\r\n*0\r\n"+
+		"*1\r\n*6\r\n$5\r\nflags\r\n*2\r\n+RO\r\n+access\r\n"+
+		"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n"+
+		"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n"+
+		"*0\r\n*-1\r\n", allReplies(c))
+
+	// 子命令与位置不固定的键
+	ReadQuery(c, "command info object|freq lmpop\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	reply := allReplies(c)
+	assert.True(t, strings.HasPrefix(reply, "*2\r\n*10\r\n$11\r\nobject|freq\r\n:3\r\n*1\r\n+readonly\r\n:2\r\n:2\r\n:1\r\n"))
+	assert.Contains(t, reply, "$5\r\nlmpop\r\n:-4\r\n*2\r\n+write\r\n+movablekeys\r\n:0\r\n:0\r\n:0\r\n")
+
+	ReadQuery(c, "command count\r\ncommand\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	reply = allReplies(c)
+	assert.True(t, strings.HasPrefix(reply, ":"+strconv.Itoa(len(commandTable))+"\r\n*"+strconv.Itoa(len(commandTable))+"\r\n"))
+}
+
+func TestCommandDocs(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "command docs get nosuchcmd\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*2\r\n$3\r\nget\r\n*6\r\n$7\r\nsummary\r\n$34\r\nReturns the string value of a key.\r\n"+
+		"$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$6\r\nstring\r\n", allReplies(c))
+
+	ReadQuery(c, "command docs memory\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	reply := allReplies(c)
+	assert.True(t, strings.HasPrefix(reply, "*2\r\n$6\r\nmemory\r\n*8\r\n"))
+	assert.Contains(t, reply, "$11\r\nsubcommands\r\n*4\r\n$12\r\nmemory|usage\r\n")
+}
+
+func TestCommandGetkeys(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "command getkeys del a b\r\ncommand getkeys blpop a b 0\r\ncommand getkeys lmpop 2 a b left\r\n"+
+		"command getkeys xread count 1 streams s1 s2 0 0\r\ncommand getkeys xgroup create s g $\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n"+
+		"*2\r\n$2\r\ns1\r\n$2\r\ns2\r\n*1\r\n$1\r\ns\r\n", allReplies(c))
+
+	ReadQuery(c, "command getkeys nosuchcmd a\r\ncommand getkeys get\r\ncommand getkeys ping x\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR Invalid command specified\r\n-ERR Invalid number of arguments specified for command\r\n"+
+		"-ERR The command has no key arguments\r\n", allReplies(c))
+}
+
+func TestCommandList(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "command list filterby pattern x*group*\r\ncommand list filterby aclcat transaction\r\n"+
+		"command list filterby module foo\r\ncommand list filterby foo bar\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*9\r\n$6\r\nxgroup\r\n$13\r\nxgroup|create\r\n$12\r\nxgroup|setid\r\n$14\r\nxgroup|destroy\r\n"+
+		"$21\r\nxgroup|createconsumer\r\n$18\r\nxgroup|delconsumer\r\n$11\r\nxgroup|help\r\n"+
+		"$12\r\nxinfo|groups\r\n$10\r\nxreadgroup\r\n"+
+		"*5\r\n$7\r\ndiscard\r\n$4\r\nexec\r\n$5\r\nmulti\r\n$7\r\nunwatch\r\n$5\r\nwatch\r\n*0\r\n-ERR syntax error\r\n", allReplies(c))
+}
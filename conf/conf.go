@@ -9,10 +9,119 @@ import (
 type Config struct {
 	Port     int
 	HttpAddr string
+
+	// 保护模式：default 用户没有密码时只接受本机连接
+	ProtectedMode bool `toml:"protected-mode"`
+	// 同时连接的客户端数量上限
+	Maxclients int `toml:"maxclients"`
+	// 客户端空闲超过 timeout 秒后关闭连接，0 表示不关闭
+	Timeout int64 `toml:"timeout"`
+
+	// 持久化
+	Dbfilename string `toml:"dbfilename"`
+
+	// 读取、解析请求与发送回复的线程数，包括主线程，1 表示不使用 I/O 线程
+	IoThreads int `toml:"io-threads"`
+
+	// 客户端缓冲区限制，输出缓冲区按类别配置 "<class> <hard> <soft> <seconds>"，class 为 normal、replica 或 pubsub
+	ClientOutputBufferLimit string `toml:"client-output-buffer-limit"`
+	ClientQueryBufferLimit  string `toml:"client-query-buffer-limit"`
+
+	// 协议限制：单个参数的最大长度，以及一条命令的最大参数个数
+	ProtoMaxBulkLen      string `toml:"proto-max-bulk-len"`
+	ProtoMaxMultibulkLen int64  `toml:"proto-max-multibulk-len"`
+
+	// 认证：客户端需要先 AUTH requirepass，从节点使用 masterauth 向主节点认证
+	Requirepass string `toml:"requirepass"`
+	Masterauth  string `toml:"masterauth"`
+
+	// ACL：aclfile 中每行为 "user <username> <rules...>"，acllog-max-len 为 ACL LOG 保留的记录数
+	Aclfile      string `toml:"aclfile"`
+	AcllogMaxLen int    `toml:"acllog-max-len"`
+
+	// 数据库数量
+	Databases int `toml:"databases"`
+
+	// 主从复制
+	ReplicaOf             string `toml:"replicaof"` // "host port"
+	ReplicaReadOnly       bool   `toml:"replica-read-only"`
+	ReplBacklogSize       int64  `toml:"repl-backlog-size"`
+	ReplTimeout           int64  `toml:"repl-timeout"`
+	ReplPingReplicaPeriod int64  `toml:"repl-ping-replica-period"`
+	ReplDisklessSync      bool   `toml:"repl-diskless-sync"`
+	ReplDisklessSyncDelay int64  `toml:"repl-diskless-sync-delay"`
+	ReplDisklessLoad      string `toml:"repl-diskless-load"` // disabled, on-empty-db, swapdb
+
+	// 键空间通知
+	NotifyKeyspaceEvents string `toml:"notify-keyspace-events"`
+
+	// 内存限制，单位字节，0 表示不限制
+	Maxmemory        int64  `toml:"maxmemory"`
+	MaxmemoryPolicy  string `toml:"maxmemory-policy"`
+	MaxmemorySamples int    `toml:"maxmemory-samples"`
+	LfuLogFactor     int    `toml:"lfu-log-factor"`
+	LfuDecayTime     int    `toml:"lfu-decay-time"` // 分钟
+
+	// 惰性释放：淘汰、过期以及服务器隐式删除（例如 SET 覆盖）时在后台释放较大的值
+	LazyfreeLazyEviction  bool `toml:"lazyfree-lazy-eviction"`
+	LazyfreeLazyExpire    bool `toml:"lazyfree-lazy-expire"`
+	LazyfreeLazyServerDel bool `toml:"lazyfree-lazy-server-del"`
+
+	// stream 节点大小
+	StreamNodeMaxBytes   int64 `toml:"stream-node-max-bytes"`
+	StreamNodeMaxEntries int64 `toml:"stream-node-max-entries"`
+
+	// 慢日志：执行时间超过 slowlog-log-slower-than 微秒的命令，负数表示不记录
+	SlowlogLogSlowerThan int64 `toml:"slowlog-log-slower-than"`
+	SlowlogMaxLen        int   `toml:"slowlog-max-len"`
+
+	// 延迟监控：记录耗时超过该毫秒数的事件，0 表示关闭
+	LatencyMonitorThreshold int64 `toml:"latency-monitor-threshold"`
+
+	// 每个命令的耗时直方图，INFO latencystats 输出其中的分位数
+	LatencyTracking                bool   `toml:"latency-tracking"`
+	LatencyTrackingInfoPercentiles string `toml:"latency-tracking-info-percentiles"`
+}
+
+// ConfigFile 配置文件的路径
+const ConfigFile = "./conf/config.toml"
+
+// DefaultConfig 默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		ProtectedMode:                  true,
+		Maxclients:                     10000,
+		Dbfilename:                     "dump.rdb",
+		Databases:                      16,
+		IoThreads:                      1,
+		ClientOutputBufferLimit:        "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60",
+		ClientQueryBufferLimit:         "1gb",
+		ProtoMaxBulkLen:                "512mb",
+		ProtoMaxMultibulkLen:           1024 * 1024,
+		AcllogMaxLen:                   128,
+		ReplicaReadOnly:                true,
+		ReplBacklogSize:                1024 * 1024,
+		ReplTimeout:                    60,
+		ReplPingReplicaPeriod:          10,
+		ReplDisklessSync:               true,
+		ReplDisklessSyncDelay:          5,
+		ReplDisklessLoad:               "disabled",
+		MaxmemoryPolicy:                "noeviction",
+		MaxmemorySamples:               5,
+		LfuLogFactor:                   10,
+		LfuDecayTime:                   1,
+		StreamNodeMaxBytes:             4096,
+		StreamNodeMaxEntries:           100,
+		SlowlogLogSlowerThan:           10000,
+		SlowlogMaxLen:                  128,
+		LatencyTracking:                true,
+		LatencyTrackingInfoPercentiles: "50 99 99.9",
+	}
 }
 
 func LoadConfig() (config *Config, err error) {
-	_, err = toml.DecodeFile("./conf/config.toml", &config)
+	config = DefaultConfig()
+	_, err = toml.DecodeFile(ConfigFile, config)
 	if err != nil {
 		log.Fatalln("Error decoding TOML:", err)
 		return
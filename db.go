@@ -0,0 +1,302 @@
+package main
+
+import (
+	"go-redis/obj"
+	"strconv"
+	"strings"
+)
+
+// 估算集合类型的内存占用时采样的元素个数
+const OBJ_COMPUTE_SIZE_DEF_SAMPLES = 5
+
+// 键在数据库中的额外开销：哈希表项
+const DICT_ENTRY_OVERHEAD = 24
+
+// lookupKeyWrite 写命令查找键，过期键会先被删除
+func lookupKeyWrite(db *redisDB, key *obj.RedisObj) *obj.RedisObj {
+	expireIfNeeded(db, key)
+	val := db.data.Get(key)
+	if val != nil {
+		updateObjectAccess(val)
+	}
+	return val
+}
+
+// lookupKeyReadNoTouch 查找键但不更新访问信息，也不触发 keymiss 通知，用于 OBJECT 等内省命令
+func lookupKeyReadNoTouch(db *redisDB, key *obj.RedisObj) *obj.RedisObj {
+	if expireIfNeeded(db, key) {
+		return nil
+	}
+	return db.data.Get(key)
+}
+
+// keyComputeSize 估算键值对的内存占用
+func keyComputeSize(key, val *obj.RedisObj) int64 {
+	return DICT_ENTRY_OVERHEAD + obj.ObjectComputeSize(key, 0) + obj.ObjectComputeSize(val, OBJ_COMPUTE_SIZE_DEF_SAMPLES)
+}
+
+// updateKeyMemUsage 键的值被修改后重新估算内存占用
+func updateKeyMemUsage(db *redisDB, key *obj.RedisObj) {
+	val := db.data.Get(key)
+	if val == nil {
+		return
+	}
+	db.mem -= val.Mem
+	val.Mem = keyComputeSize(key, val)
+	db.mem += val.Mem
+}
+
+// dbAdd 添加新键，等待该键的阻塞客户端可能可以继续执行
+func dbAdd(db *redisDB, key, val *obj.RedisObj) {
+	db.data.Set(key, val)
+	// 新创建的对象初始化访问信息，MOVE 等移动的对象保留原有的信息
+	if val.Lru == 0 {
+		initObjectAccess(val)
+	}
+	val.Mem = keyComputeSize(key, val)
+	db.mem += val.Mem
+	signalKeyAsReady(db, key, val.Type)
+}
+
+// dbOverwrite 覆盖已存在的键，过期时间由调用者处理
+func dbOverwrite(db *redisDB, key, val *obj.RedisObj) {
+	old := db.data.Get(key)
+	db.mem -= old.Mem
+	// LFU 的计数器属于键，覆盖值时保留
+	if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		val.Lru = old.Lru
+	} else {
+		initObjectAccess(val)
+	}
+	db.data.Set(key, val)
+	val.Mem = keyComputeSize(key, val)
+	db.mem += val.Mem
+	if server.lazyfreeLazyServerDel {
+		freeObjAsync(old)
+	}
+}
+
+// setKey 设置键的值并清除过期时间
+func setKey(db *redisDB, key, val *obj.RedisObj) {
+	if db.data.Get(key) == nil {
+		dbAdd(db, key, val)
+	} else {
+		dbOverwrite(db, key, val)
+	}
+	db.expire.Delete(key)
+}
+
+// dbGenericDelete 删除键及其过期时间，async 时较大的值在后台释放，键不存在时返回 false
+func dbGenericDelete(db *redisDB, key *obj.RedisObj, async bool) bool {
+	// 键不存在时也删除过期时间，避免残留的过期项被反复处理
+	db.expire.Delete(key)
+	val := db.data.Get(key)
+	if val == nil {
+		return false
+	}
+	db.data.Delete(key)
+	db.mem -= val.Mem
+	// 等待该键的 XREADGROUP 需要以错误解除阻塞
+	signalKeyAsReady(db, key, val.Type)
+	if async {
+		freeObjAsync(val)
+	}
+	return true
+}
+
+// dbDelete 同步删除键
+func dbDelete(db *redisDB, key *obj.RedisObj) bool {
+	return dbGenericDelete(db, key, false)
+}
+
+// dbAsyncDelete 删除键，较大的值在后台释放
+func dbAsyncDelete(db *redisDB, key *obj.RedisObj) bool {
+	return dbGenericDelete(db, key, true)
+}
+
+// dbTotalServerKeyCount 所有数据库的键总数
+func dbTotalServerKeyCount() int64 {
+	var total int64
+	for _, db := range server.db {
+		total += db.data.Len()
+	}
+	return total
+}
+
+// emptyDB 清空数据库，返回删除的键数，async 时原有的数据在后台释放
+func emptyDB(db *redisDB, async bool) int64 {
+	removed := db.data.Len()
+	scanDatabaseForDeletedKeys(db, nil)
+	data := db.data
+	replaceDBData(db, createRedisDB(db.id))
+	db.avgTTL = 0
+	if async {
+		emptyDbAsync(data)
+	}
+	return removed
+}
+
+// scanDatabaseForReadyKeys 数据库被替换后，有阻塞客户端等待且存在的键视为就绪
+func scanDatabaseForReadyKeys(db *redisDB) {
+	for key := range db.blockingKeys {
+		k := obj.CreateObject(obj.STR, key)
+		if o := db.data.Get(k); o != nil {
+			signalKeyAsReady(db, k, o.Type)
+		}
+	}
+}
+
+// scanDatabaseForDeletedKeys 清空或替换数据库时，有阻塞客户端等待的键被删除或类型改变，
+// 需要以错误解除阻塞的客户端（例如 XREADGROUP）由此得到通知
+func scanDatabaseForDeletedKeys(emptied, replaced *redisDB) {
+	for key := range emptied.blockingKeys {
+		k := obj.CreateObject(obj.STR, key)
+		old := emptied.data.Get(k)
+		if old == nil {
+			continue
+		}
+		if replaced != nil {
+			if o := replaced.data.Get(k); o != nil && o.Type == old.Type {
+				continue
+			}
+		}
+		signalKeyAsReady(emptied, k, old.Type)
+	}
+}
+
+// selectDb 切换客户端的数据库，id 超出范围时返回 false
+func selectDb(c *RedisClient, id int64) bool {
+	if id < 0 || id >= int64(server.dbnum) {
+		return false
+	}
+	c.db = server.db[id]
+	return true
+}
+
+// selectCommand SELECT index
+func selectCommand(c *RedisClient) {
+	id, ok := getLongFromObjectOrReply(c, c.args[1], "")
+	if !ok {
+		return
+	}
+	if !selectDb(c, id) {
+		c.AddReplyError("DB index is out of range")
+		return
+	}
+	c.AddReplyStr("+OK\r\n")
+}
+
+// moveCommand MOVE key db，目标数据库中已存在该键时不移动
+func moveCommand(c *RedisClient) {
+	key := c.args[1]
+	dbid, ok := getLongFromObjectOrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	if dbid < 0 || dbid >= int64(server.dbnum) {
+		c.AddReplyError("DB index is out of range")
+		return
+	}
+	src, dst := c.db, server.db[dbid]
+	if src == dst {
+		c.AddReplyError("source and destination objects are the same")
+		return
+	}
+	o := lookupKeyWrite(src, key)
+	if o == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	var expire *obj.RedisObj
+	if e := src.expire.Find(key); e != nil {
+		expire = e.Val
+	}
+	if lookupKeyWrite(dst, key) != nil {
+		c.AddReplyInt(0)
+		return
+	}
+	dbAdd(dst, key, o)
+	if expire != nil {
+		dst.expire.Set(key, expire)
+	}
+	dbDelete(src, key)
+	signalModifiedKey(src, key)
+	signalModifiedKey(dst, key)
+	notifyKeyspaceEvent(NOTIFY_GENERIC, "move_from", key, src.id)
+	notifyKeyspaceEvent(NOTIFY_GENERIC, "move_to", key, dst.id)
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+// swapdbCommand SWAPDB index1 index2，连接到两个数据库的客户端随之看到交换后的数据
+func swapdbCommand(c *RedisClient) {
+	id1, err := strconv.ParseInt(c.args[1].StrVal(), 10, 64)
+	if err != nil {
+		c.AddReplyError("invalid first DB index")
+		return
+	}
+	id2, err := strconv.ParseInt(c.args[2].StrVal(), 10, 64)
+	if err != nil {
+		c.AddReplyError("invalid second DB index")
+		return
+	}
+	if id1 < 0 || id1 >= int64(server.dbnum) || id2 < 0 || id2 >= int64(server.dbnum) {
+		c.AddReplyError("DB index is out of range")
+		return
+	}
+	if id1 != id2 {
+		db1, db2 := server.db[id1], server.db[id2]
+		scanDatabaseForDeletedKeys(db1, db2)
+		scanDatabaseForDeletedKeys(db2, db1)
+		touchAllWatchedKeysInDb(db1, db2)
+		touchAllWatchedKeysInDb(db2, db1)
+		// 只交换数据，WATCH 与阻塞状态仍属于原来的数据库
+		db1.data, db2.data = db2.data, db1.data
+		db1.expire, db2.expire = db2.expire, db1.expire
+		db1.avgTTL, db2.avgTTL = db2.avgTTL, db1.avgTTL
+		db1.mem, db2.mem = db2.mem, db1.mem
+		scanDatabaseForReadyKeys(db1)
+		scanDatabaseForReadyKeys(db2)
+	}
+	server.dirty++
+	c.AddReplyStr("+OK\r\n")
+}
+
+// getFlushCommandFlags 解析 FLUSHDB/FLUSHALL 的 [ASYNC|SYNC] 参数，返回是否在后台释放
+func getFlushCommandFlags(c *RedisClient) (async bool, ok bool) {
+	if len(c.args) > 2 || (len(c.args) == 2 &&
+		!strings.EqualFold(c.args[1].StrVal(), "sync") && !strings.EqualFold(c.args[1].StrVal(), "async")) {
+		c.AddReplyError("syntax error")
+		return false, false
+	}
+	return len(c.args) == 2 && strings.EqualFold(c.args[1].StrVal(), "async"), true
+}
+
+// flushdbCommand FLUSHDB [ASYNC|SYNC]
+func flushdbCommand(c *RedisClient) {
+	async, ok := getFlushCommandFlags(c)
+	if !ok {
+		return
+	}
+	server.dirty += emptyDB(c.db, async)
+	// 数据库原本为空时也需要传播
+	c.flags |= CLIENT_FORCE_REPL
+	c.AddReplyStr("+OK\r\n")
+}
+
+// flushallCommand FLUSHALL [ASYNC|SYNC]
+func flushallCommand(c *RedisClient) {
+	async, ok := getFlushCommandFlags(c)
+	if !ok {
+		return
+	}
+	for _, db := range server.db {
+		server.dirty += emptyDB(db, async)
+	}
+	c.flags |= CLIENT_FORCE_REPL
+	c.AddReplyStr("+OK\r\n")
+}
+
+func dbsizeCommand(c *RedisClient) {
+	c.AddReplyInt(c.db.data.Len())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"go-redis/conf"
+	"go-redis/obj"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectMoveSwapdb(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v0\r\nselect 1\r\nset k v1\r\nget k\r\nselect 16\r\nselect x\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n+OK\r\n+OK\r\n$2\r\nv1\r\n-ERR DB index is out of range\r\n"+
+		"-ERR value is not an integer or out of range\r\n", allReplies(c))
+	assert.Equal(t, 1, c.db.id)
+
+	// 目标数据库中已存在时不移动
+	ReadQuery(c, "move k 0\r\nmove k 1\r\nset m v\r\nexpire m 100\r\nmove m 2\r\ndbsize\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":0\r\n-ERR source and destination objects are the same\r\n+OK\r\n:1\r\n:1\r\n:1\r\n", allReplies(c))
+	m := obj.CreateObject(obj.STR, "m")
+	assert.NotNil(t, server.db[2].data.Get(m))
+	assert.NotNil(t, server.db[2].expire.Get(m))
+	assert.Nil(t, server.db[1].expire.Get(m))
+
+	// 交换后连接到数据库 1 的客户端看到原来数据库 0 的数据
+	other := CreateClient(-2)
+	ReadQuery(other, "watch k\r\n")
+	assert.Nil(t, ProcessQueryBuf(other))
+	ReadQuery(c, "swapdb 0 1\r\nget k\r\nswapdb 0 x\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n$2\r\nv0\r\n-ERR invalid second DB index\r\n", allReplies(c))
+	assert.NotEqual(t, 0, other.flags&CLIENT_DIRTY_CAS)
+
+	ReadQuery(c, "info keyspace\r\nflushdb\r\ndbsize\r\nselect 0\r\ndbsize\r\nflushall\r\ndbsize\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	info := "# Keyspace\r\ndb0:keys=1,expires=0,avg_ttl=0\r\ndb1:keys=1,expires=0,avg_ttl=0\r\n" +
+		"db2:keys=1,expires=1,avg_ttl=0\r\n"
+	assert.Equal(t, "$108\r\n"+info+"\r\n+OK\r\n:0\r\n+OK\r\n:1\r\n+OK\r\n:0\r\n", allReplies(c))
+	assert.Equal(t, int64(0), dbTotalServerKeyCount())
+}
+
+func TestBlockedClientOnSwapdb(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "blpop l 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	p := CreateClient(-2)
+	ReadQuery(p, "select 1\r\nrpush l a\r\nswapdb 0 1\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, 0, c.flags&CLIENT_BLOCKED)
+	assert.Equal(t, "*2\r\n$1\r\nl\r\n$1\r\na\r\n", allReplies(c))
+}
+
+func TestReplicationSelect(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	server.masterhost = ""
+	createReplicationBacklog()
+	server.slaveseldb = -1
+	start := server.masterReplOffset + 1
+	c := CreateClient(-1)
+	ReadQuery(c, "set a 1\r\nset b 2\r\nselect 3\r\nset c 3\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"+
+		"*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n"+
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n3\r\n*3\r\n$3\r\nset\r\n$1\r\nc\r\n$1\r\n3\r\n", string(server.backlog.copyFrom(start)))
+}
+
+func TestRdbMultipleDbs(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v0\r\nselect 5\r\nset k v5\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+	emptyData()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db))
+	k := obj.CreateObject(obj.STR, "k")
+	assert.Equal(t, "v0", server.db[0].data.Get(k).StrVal())
+	assert.Equal(t, "v5", server.db[5].data.Get(k).StrVal())
+
+	// 数据库数量不足时拒绝加载
+	assert.NotNil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db[:2]))
+}
+
+func TestExpireMissingKey(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "expire nokey 100\r\npexpireat nokey 100\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":0\r\n:0\r\n", allReplies(c))
+	k := obj.CreateObject(obj.STR, "nokey")
+	assert.Nil(t, c.db.expire.Get(k))
+
+	ReadQuery(c, "set k v\r\nexpire k abc\r\nget k\r\nexpire k 100\r\npexpireat k 99999999999999\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n-ERR value is not an integer or out of range\r\n$1\r\nv\r\n:1\r\n:1\r\n", allReplies(c))
+
+	// 残留的过期项被删除，但不计入过期统计
+	c.db.expire.Set(k, obj.CreateFromInt(0))
+	activeExpireCycle()
+	assert.Nil(t, c.db.expire.Get(k))
+	assert.Equal(t, int64(0), server.statExpiredkeys)
+}
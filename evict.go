@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/ae"
+	"go-redis/obj"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// LRU 时钟
+const (
+	LRU_BITS             = 24
+	LRU_CLOCK_MAX        = 1<<LRU_BITS - 1
+	LRU_CLOCK_RESOLUTION = 1000 // 时钟精度，毫秒
+)
+
+// 新键的 LFU 初始计数，避免刚写入就被淘汰
+const LFU_INIT_VAL = 5
+
+// 淘汰策略标识
+const (
+	MAXMEMORY_FLAG_LRU     = 1 << 0
+	MAXMEMORY_FLAG_LFU     = 1 << 1
+	MAXMEMORY_FLAG_ALLKEYS = 1 << 2
+
+	MAXMEMORY_VOLATILE_LRU    = 0<<8 | MAXMEMORY_FLAG_LRU
+	MAXMEMORY_VOLATILE_LFU    = 1<<8 | MAXMEMORY_FLAG_LFU
+	MAXMEMORY_VOLATILE_TTL    = 2 << 8
+	MAXMEMORY_VOLATILE_RANDOM = 3 << 8
+	MAXMEMORY_ALLKEYS_LRU     = 4<<8 | MAXMEMORY_FLAG_LRU | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_ALLKEYS_LFU     = 5<<8 | MAXMEMORY_FLAG_LFU | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_ALLKEYS_RANDOM  = 6<<8 | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_NO_EVICTION     = 7 << 8
+)
+
+var maxmemoryPolicies = []struct {
+	name   string
+	policy int
+}{
+	{"volatile-lru", MAXMEMORY_VOLATILE_LRU},
+	{"volatile-lfu", MAXMEMORY_VOLATILE_LFU},
+	{"volatile-random", MAXMEMORY_VOLATILE_RANDOM},
+	{"volatile-ttl", MAXMEMORY_VOLATILE_TTL},
+	{"allkeys-lru", MAXMEMORY_ALLKEYS_LRU},
+	{"allkeys-lfu", MAXMEMORY_ALLKEYS_LFU},
+	{"allkeys-random", MAXMEMORY_ALLKEYS_RANDOM},
+	{"noeviction", MAXMEMORY_NO_EVICTION},
+}
+
+// maxmemoryPolicyFromName 解析淘汰策略名
+func maxmemoryPolicyFromName(name string) (int, error) {
+	for _, p := range maxmemoryPolicies {
+		if strings.EqualFold(p.name, name) {
+			return p.policy, nil
+		}
+	}
+	return 0, errors.New("invalid maxmemory-policy " + name)
+}
+
+func maxmemoryPolicyName(policy int) string {
+	for _, p := range maxmemoryPolicies {
+		if p.policy == policy {
+			return p.name
+		}
+	}
+	return "unknown"
+}
+
+// performEvictions 的结果
+const (
+	EVICT_OK   = 0
+	EVICT_FAIL = 1 // 内存超出限制且无法淘汰足够的键
+)
+
+// 淘汰池
+const EVPOOL_SIZE = 16
+
+// evictionPoolEntry 淘汰候选键，idle 越大越应该被淘汰
+type evictionPoolEntry struct {
+	idle uint64
+	key  string
+	dbid int
+}
+
+// getLRUClock 根据当前时间计算 LRU 时钟
+func getLRUClock() uint32 {
+	return uint32(ae.GetMsTime()/LRU_CLOCK_RESOLUTION) & LRU_CLOCK_MAX
+}
+
+// lruClock 当前的 LRU 时钟，cron 的精度足够时使用缓存的值，避免每次访问都取系统时间
+func lruClock() uint32 {
+	if SERVER_CRON_PERIOD <= LRU_CLOCK_RESOLUTION {
+		return server.lruclock
+	}
+	return getLRUClock()
+}
+
+// estimateObjectIdleTime 根据 LRU 时钟估算对象的空闲时间，毫秒
+func estimateObjectIdleTime(o *obj.RedisObj) uint64 {
+	clock := lruClock()
+	if clock >= o.Lru {
+		return uint64(clock-o.Lru) * LRU_CLOCK_RESOLUTION
+	}
+	// 时钟回绕
+	return uint64(clock+(LRU_CLOCK_MAX-o.Lru)) * LRU_CLOCK_RESOLUTION
+}
+
+// lfuGetTimeInMinutes 以分钟为单位的时间，取低 16 位
+func lfuGetTimeInMinutes() uint32 {
+	return uint32(ae.GetMsTime()/1000/60) & 65535
+}
+
+// lfuTimeElapsed 距离上次递减经过的分钟数，处理回绕
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuGetTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 65535 - ldt + now
+}
+
+// lfuLogIncr 以对数概率增加计数器，计数越大越难增加
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return 255
+	}
+	baseval := float64(counter) - LFU_INIT_VAL
+	if baseval < 0 {
+		baseval = 0
+	}
+	p := 1.0 / (baseval*float64(server.lfuLogFactor) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn 按经过的时间衰减计数器，返回衰减后的值，不修改对象
+func lfuDecrAndReturn(o *obj.RedisObj) uint32 {
+	ldt := o.Lru >> 8
+	counter := o.Lru & 255
+	// 衰减时间为 0 时不衰减
+	var periods uint32
+	if server.lfuDecayTime > 0 {
+		periods = lfuTimeElapsed(ldt) / uint32(server.lfuDecayTime)
+	}
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// updateLFU 访问时更新 LFU 计数器
+func updateLFU(o *obj.RedisObj) {
+	counter := lfuLogIncr(lfuDecrAndReturn(o))
+	o.Lru = lfuGetTimeInMinutes()<<8 | counter
+}
+
+// initObjectAccess 初始化新对象的访问信息
+func initObjectAccess(o *obj.RedisObj) {
+	if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		o.Lru = lfuGetTimeInMinutes()<<8 | LFU_INIT_VAL
+	} else {
+		o.Lru = lruClock()
+	}
+}
+
+// updateObjectAccess 键被访问时更新 LRU 时钟或 LFU 计数器
+func updateObjectAccess(o *obj.RedisObj) {
+	if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		updateLFU(o)
+	} else {
+		o.Lru = lruClock()
+	}
+}
+
+// objectSetLRUOrLFU 加载 rdb 时恢复访问信息，lfuFreq 或 lruIdle（秒）为 -1 表示没有保存
+func objectSetLRUOrLFU(o *obj.RedisObj, lfuFreq, lruIdle int64) {
+	if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		if lfuFreq >= 0 {
+			o.Lru = lfuGetTimeInMinutes()<<8 | uint32(lfuFreq)
+		}
+	} else if lruIdle >= 0 {
+		// 换算为时钟，处理回绕
+		idle := uint32(lruIdle*1000/LRU_CLOCK_RESOLUTION) & LRU_CLOCK_MAX
+		clock := lruClock()
+		if idle <= clock {
+			o.Lru = clock - idle
+		} else {
+			o.Lru = LRU_CLOCK_MAX - (idle - clock)
+		}
+	}
+}
+
+// usedMemory 数据占用的内存估算
+func usedMemory() int64 {
+	var mem int64
+	for _, db := range server.db {
+		mem += db.mem + db.expire.Len()*DICT_ENTRY_OVERHEAD
+	}
+	return mem
+}
+
+// evictionPoolPopulate 从 sampledict 中采样若干键，比池中已有候选更适合淘汰的键插入淘汰池
+func evictionPoolPopulate(db *redisDB, sampledict *obj.Dict) {
+	pool := server.evictionPool
+	for i := 0; i < server.maxmemorySamples; i++ {
+		entry := sampledict.RandomGet()
+		if entry == nil {
+			break
+		}
+		key := entry.Key.StrVal()
+		var idle uint64
+		if server.maxmemoryPolicy == MAXMEMORY_VOLATILE_TTL {
+			// 越早过期越应该淘汰
+			idle = math.MaxUint64 - uint64(entry.Val.IntVal())
+		} else {
+			o := entry.Val
+			// 采样的是过期字典时需要从数据字典取值
+			if sampledict != db.data {
+				o = db.data.Get(entry.Key)
+			}
+			if server.maxmemoryPolicy&MAXMEMORY_FLAG_LRU != 0 {
+				idle = estimateObjectIdleTime(o)
+			} else {
+				idle = 255 - uint64(lfuDecrAndReturn(o))
+			}
+		}
+
+		// 同一个键可能被重复采样
+		dup := false
+		for _, e := range pool {
+			if e.key == key && e.dbid == db.id {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+
+		// 找到第一个空位或 idle 更大的位置，池按 idle 升序排列
+		k := 0
+		for k < EVPOOL_SIZE && pool[k].key != "" && pool[k].idle < idle {
+			k++
+		}
+		if k == 0 && pool[EVPOOL_SIZE-1].key != "" {
+			// 比池中所有候选都不适合淘汰，且池已满
+			continue
+		} else if k < EVPOOL_SIZE && pool[k].key == "" {
+			// 插入空位
+		} else if pool[EVPOOL_SIZE-1].key == "" {
+			// 右侧还有空位，后移腾出位置
+			copy(pool[k+1:], pool[k:EVPOOL_SIZE-1])
+		} else {
+			// 池已满，丢弃最不适合淘汰的第一个候选
+			k--
+			copy(pool[:k], pool[1:k+1])
+		}
+		pool[k] = evictionPoolEntry{idle: idle, key: key, dbid: db.id}
+	}
+}
+
+// performEvictions 内存超出 maxmemory 时按策略淘汰键，直到回到限制以内
+func performEvictions() int {
+	// 从节点的数据由主节点控制，不主动淘汰
+	if server.masterhost != "" {
+		return EVICT_OK
+	}
+	// 暂停期间数据集不能改变，暂停通常很短，暂不淘汰
+	if checkClientPauseTimeoutAndReturnIfPaused() {
+		return EVICT_OK
+	}
+	mem := usedMemory()
+	if server.maxmemory == 0 || mem <= server.maxmemory {
+		return EVICT_OK
+	}
+	if server.maxmemoryPolicy == MAXMEMORY_NO_EVICTION {
+		return EVICT_FAIL
+	}
+	tofree := mem - server.maxmemory
+	var freed int64
+	start := latencyStartMonitor()
+	for freed < tofree {
+		var bestdb *redisDB
+		var bestkey *obj.RedisObj
+
+		if server.maxmemoryPolicy&(MAXMEMORY_FLAG_LRU|MAXMEMORY_FLAG_LFU) != 0 ||
+			server.maxmemoryPolicy == MAXMEMORY_VOLATILE_TTL {
+			for bestkey == nil {
+				var total int64
+				for _, db := range server.db {
+					dict := db.expire
+					if server.maxmemoryPolicy&MAXMEMORY_FLAG_ALLKEYS != 0 {
+						dict = db.data
+					}
+					if dict.Len() > 0 {
+						total += dict.Len()
+						evictionPoolPopulate(db, dict)
+					}
+				}
+				if total == 0 {
+					break
+				}
+				// 从最适合淘汰的候选开始，跳过已经不存在的键
+				for k := EVPOOL_SIZE - 1; k >= 0; k-- {
+					entry := server.evictionPool[k]
+					if entry.key == "" {
+						continue
+					}
+					server.evictionPool[k] = evictionPoolEntry{}
+					db := server.db[entry.dbid]
+					key := obj.CreateObject(obj.STR, entry.key)
+					var found bool
+					if server.maxmemoryPolicy&MAXMEMORY_FLAG_ALLKEYS != 0 {
+						found = db.data.Get(key) != nil
+					} else {
+						found = db.expire.Get(key) != nil
+					}
+					if found {
+						bestdb, bestkey = db, key
+						break
+					}
+				}
+			}
+		} else {
+			// 随机淘汰，轮流从各个数据库中选择
+			for i := 0; i < server.dbnum; i++ {
+				db := server.db[server.evictNextDb]
+				server.evictNextDb = (server.evictNextDb + 1) % server.dbnum
+				dict := db.expire
+				if server.maxmemoryPolicy == MAXMEMORY_ALLKEYS_RANDOM {
+					dict = db.data
+				}
+				if entry := dict.RandomGet(); entry != nil {
+					bestdb, bestkey = db, entry.Key
+					break
+				}
+			}
+		}
+
+		if bestkey == nil {
+			break
+		}
+		before := usedMemory()
+		delStart := latencyStartMonitor()
+		dbGenericDelete(bestdb, bestkey, server.lazyfreeLazyEviction)
+		latencyAddSampleIfNeeded("eviction-del", latencyEndMonitor(delStart))
+		freed += before - usedMemory()
+		server.statEvictedkeys++
+		signalModifiedKey(bestdb, bestkey)
+		notifyKeyspaceEvent(NOTIFY_EVICTED, "evicted", bestkey, bestdb.id)
+		propagateDeletion(bestdb, bestkey)
+	}
+	latencyAddSampleIfNeeded("eviction-cycle", latencyEndMonitor(start))
+	if freed < tofree {
+		return EVICT_FAIL
+	}
+	return EVICT_OK
+}
+
+// bytesToHuman 以可读的单位显示字节数
+func bytesToHuman(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.2fK", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.2fM", float64(n)/(1024*1024))
+	default:
+		return fmt.Sprintf("%.2fG", float64(n)/(1024*1024*1024))
+	}
+}
+
+// memoryCommand MEMORY USAGE <key> [SAMPLES <count>] | MEMORY HELP
+func memoryCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	if sub == "help" && len(c.args) == 2 {
+		addReplyHelp(c, []string{
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		})
+	} else if sub == "usage" && len(c.args) >= 3 {
+		samples := OBJ_COMPUTE_SIZE_DEF_SAMPLES
+		for j := 3; j < len(c.args); j++ {
+			if strings.EqualFold(c.args[j].StrVal(), "samples") && j+1 < len(c.args) {
+				n, err := strconv.Atoi(c.args[j+1].StrVal())
+				if err != nil || n < 0 {
+					c.AddReplyError("value is out of range, must be positive")
+					return
+				}
+				// 0 表示采样全部元素
+				samples = n
+				j++
+			} else {
+				c.AddReplyError("syntax error")
+				return
+			}
+		}
+		val := lookupKeyReadNoTouch(c.db, c.args[2])
+		if val == nil {
+			c.AddReplyStr("$-1\r\n")
+			return
+		}
+		c.AddReplyInt(DICT_ENTRY_OVERHEAD + obj.ObjectComputeSize(c.args[2], 0) + obj.ObjectComputeSize(val, samples))
+	} else {
+		addReplySubcommandSyntaxError(c)
+	}
+}
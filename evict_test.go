@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go-redis/conf"
+	"go-redis/obj"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictAllkeysLRU(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.MaxmemoryPolicy = "allkeys-lru"
+	// 采样足够多次，保证采样到所有键
+	cfg.MaxmemorySamples = 64
+	initServer(cfg)
+	c := CreateClient(-1)
+	ReadQuery(c, "set k1 v\r\nset k2 v\r\nset k3 v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	server.maxmemory = usedMemory()
+
+	// k2 最久没有访问，超出限制后的下一个写命令执行前被淘汰
+	server.db[0].data.Get(obj.CreateObject(obj.STR, "k2")).Lru = lruClock() - 100
+	ReadQuery(c, "set k4 v\r\nset k1 v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n+OK\r\n", allReplies(c))
+	assert.Nil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "k2")))
+	assert.Equal(t, int64(1), server.statEvictedkeys)
+	assert.Equal(t, int64(3), server.db[0].data.Len())
+}
+
+func TestEvictNoeviction(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set k1 v\r\nrpush l a b c\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	server.maxmemory = usedMemory() / 2
+
+	// 不增加内存的写命令仍然可以执行，事务中排队时就拒绝
+	ReadQuery(c, "set k2 v\r\nget k1\r\nlpop l\r\nmulti\r\nset k2 v\r\nexec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-OOM command not allowed when used memory > 'maxmemory'.\r\n$1\r\nv\r\n$1\r\na\r\n"+
+		"+OK\r\n-OOM command not allowed when used memory > 'maxmemory'.\r\n"+
+		"-EXECABORT Transaction discarded because of previous errors.\r\n", allReplies(c))
+	assert.Equal(t, int64(0), server.statEvictedkeys)
+}
+
+func TestEvictVolatileTTL(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.MaxmemoryPolicy = "volatile-ttl"
+	cfg.MaxmemorySamples = 64
+	cfg.NotifyKeyspaceEvents = "Ee"
+	initServer(cfg)
+	server.masterhost = ""
+	createReplicationBacklog()
+	sub := CreateClient(-2)
+	ReadQuery(sub, "subscribe __keyevent@0__:evicted\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+	freeReplyList(sub)
+
+	c := CreateClient(-1)
+	ReadQuery(c, "set p v\r\nset a v\r\nexpire a 100\r\nset b v\r\nexpire b 50\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	server.maxmemory = usedMemory()
+	start := server.masterReplOffset + 1
+
+	// 写命令执行前检查内存，最先过期的 b 先被淘汰，之后只剩没有过期时间的键
+	ReadQuery(c, "set x v\r\nset y v\r\nset z v\r\nset w v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n+OK\r\n+OK\r\n-OOM command not allowed when used memory > 'maxmemory'.\r\n", allReplies(c))
+	assert.Nil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "a")))
+	assert.NotNil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "p")))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:evicted\r\n$1\r\nb\r\n"+
+		"*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:evicted\r\n$1\r\na\r\n", allReplies(sub))
+	assert.True(t, strings.Contains(string(server.backlog.copyFrom(start)),
+		"*2\r\n$3\r\nDEL\r\n$1\r\nb\r\n*3\r\n$3\r\nset\r\n$1\r\ny\r\n"))
+}
+
+func TestMemoryUsage(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v\r\nrpush l a b c d e f g\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	k := obj.CreateObject(obj.STR, "k")
+	l := obj.CreateObject(obj.STR, "l")
+	assert.Equal(t, server.db[0].data.Get(k).Mem+server.db[0].data.Get(l).Mem, usedMemory())
+
+	ReadQuery(c, "memory usage nokey\r\nmemory usage l samples x\r\nmemory usage k foo\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$-1\r\n-ERR value is out of range, must be positive\r\n-ERR syntax error\r\n", allReplies(c))
+	ReadQuery(c, "memory usage l samples 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":"+strconv.FormatInt(server.db[0].data.Get(l).Mem, 10)+"\r\n", allReplies(c))
+
+	// 修改值后重新估算，删除后归零
+	before := usedMemory()
+	ReadQuery(c, "rpush l h i j\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Less(t, before, usedMemory())
+	ReadQuery(c, "del k l\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":10\r\n:2\r\n", allReplies(c))
+	assert.Equal(t, int64(0), usedMemory())
+}
@@ -2,6 +2,8 @@ package http
 
 import (
 	"bufio"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -9,14 +11,20 @@ import (
 )
 
 const (
-	responseOKFormat       = "HTTP/1.1 200 OK\r\n\r\n%s"
-	responseNOTFOUNDFormat = "HTTP/1.1 404 Not Found\r\n\r\n%s"
-	responseErrorFormat    = "HTTP/1.1 500 Internal Server Error\r\n\r\n%s"
+	responseOKFormat        = "HTTP/1.1 200 OK\r\n\r\n%s"
+	responseNOTFOUNDFormat  = "HTTP/1.1 404 Not Found\r\n\r\n%s"
+	responseErrorFormat     = "HTTP/1.1 500 Internal Server Error\r\n\r\n%s"
+	responseUnauthorized    = "HTTP/1.1 401 Unauthorized\r\nWWW-Authenticate: Basic realm=\"go-redis\"\r\n\r\nNOAUTH Authentication required."
+	responseForbiddenFormat = "HTTP/1.1 403 Forbidden\r\n\r\n%s"
 )
 
+// ErrUnauthorized AuthFunc 返回该错误时回复 401，其余错误回复 403
+var ErrUnauthorized = errors.New("unauthorized")
+
 type request struct {
-	requestURI  string
-	requestBody []byte
+	requestURI    string
+	requestBody   []byte
+	authorization string
 }
 
 type response struct {
@@ -25,8 +33,12 @@ type response struct {
 
 type router struct {
 	routes map[string]func(*request, *response)
+	auth   AuthFunc
 }
 
+// AuthFunc 校验 Basic 认证的用户名与密码，以及用户能否以 args 访问 path，没有 Authorization 头时用户名与密码为空
+type AuthFunc func(username, password, path string, args []string) error
+
 func newRouter() *router {
 	return &router{
 		routes: make(map[string]func(*request, *response)),
@@ -37,13 +49,53 @@ type Handler func(...string) string
 
 func (r *router) AddRoute(path string, handler Handler) *router {
 	r.routes[path] = func(req *request, rsp *response) {
-		args := strings.Split(string(req.requestBody), "|")
-		okHandler(req, rsp, handler(args...))
+		okHandler(req, rsp, handler(req.args()...))
 	}
 	return r
 }
 
+// SetAuth 设置后所有请求都需要通过 Basic 认证
+func (r *router) SetAuth(auth AuthFunc) *router {
+	r.auth = auth
+	return r
+}
+
+func (req *request) args() []string {
+	return strings.Split(string(req.requestBody), "|")
+}
+
+// authenticate 解析 Authorization: Basic base64(username:password)
+func (r *router) authenticate(req *request) error {
+	if r.auth == nil {
+		return nil
+	}
+	var username, password string
+	if req.authorization != "" {
+		const prefix = "Basic "
+		if !strings.HasPrefix(req.authorization, prefix) {
+			return ErrUnauthorized
+		}
+		decoded, err := base64.StdEncoding.DecodeString(req.authorization[len(prefix):])
+		if err != nil {
+			return ErrUnauthorized
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return ErrUnauthorized
+		}
+		username, password = parts[0], parts[1]
+	}
+	return r.auth(username, password, req.requestURI, req.args())
+}
+
 func (r *router) serveHTTP(req *request, rsp *response) {
+	if err := r.authenticate(req); err == ErrUnauthorized {
+		unauthorizedHandler(req, rsp)
+		return
+	} else if err != nil {
+		forbiddenHandler(req, rsp, err.Error())
+		return
+	}
 	handler, ok := r.routes[req.requestURI]
 	if ok {
 		handler(req, rsp)
@@ -130,6 +182,7 @@ func handleRequest(conn net.Conn, router *router) {
 		}
 	}
 	req.requestURI = requestURI
+	req.authorization = headers["Authorization"]
 	router.serveHTTP(req, rsp)
 }
 
@@ -145,6 +198,17 @@ func errHandler(req *request, rsp *response, message string) {
 	rsp.c.Close()
 }
 
+func unauthorizedHandler(req *request, rsp *response) {
+	rsp.c.Write([]byte(responseUnauthorized))
+	rsp.c.Close()
+}
+
+func forbiddenHandler(req *request, rsp *response, message string) {
+	response := fmt.Sprintf(responseForbiddenFormat, message)
+	rsp.c.Write([]byte(response))
+	rsp.c.Close()
+}
+
 func notFoundHandler(req *request, rsp *response) {
 	response := fmt.Sprintf(responseNOTFOUNDFormat, "Page not found")
 	rsp.c.Write([]byte(response))
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"go-redis/conf"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 错误码的种类超过该数量后不再记录新的错误码，避免错误信息中的随机内容耗尽内存
+const ERROR_STATS_NUMBER = 128
+
+// 瞬时指标，每次 ServerCron 采样一次，取最近 STATS_METRIC_SAMPLES 次的平均值
+const (
+	STATS_METRIC_COMMAND = iota
+	STATS_METRIC_NET_INPUT
+	STATS_METRIC_NET_OUTPUT
+	STATS_METRIC_COUNT
+)
+
+const STATS_METRIC_SAMPLES = 16
+
+type instMetric struct {
+	lastSampleTime  int64 // ms
+	lastSampleCount int64
+	samples         [STATS_METRIC_SAMPLES]int64 // 每秒的增量
+	idx             int
+}
+
+// INFO 默认输出的部分，commandstats 与 latencystats 需要显式指定或者使用 all
+var defaultInfoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication",
+	"cpu", "modules", "errorstats", "cluster", "keyspace"}
+
+// trackInstantaneousMetric 记录 current 相对上次采样的每秒增量
+func trackInstantaneousMetric(metric int, current int64) {
+	m := &server.instMetric[metric]
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if m.lastSampleTime > 0 {
+		if t := now - m.lastSampleTime; t > 0 {
+			m.samples[m.idx] = (current - m.lastSampleCount) * 1000 / t
+			m.idx = (m.idx + 1) % STATS_METRIC_SAMPLES
+		}
+	}
+	m.lastSampleTime = now
+	m.lastSampleCount = current
+}
+
+func getInstantaneousMetric(metric int) int64 {
+	var sum int64
+	for _, v := range server.instMetric[metric].samples {
+		sum += v
+	}
+	return sum / STATS_METRIC_SAMPLES
+}
+
+// afterErrorReply 统计错误回复，按错误码（第一个单词）分类
+func afterErrorReply(msg string) {
+	server.statTotalErrorReplies++
+	code := strings.TrimPrefix(msg, "-")
+	if i := strings.IndexAny(code, " \r\n"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := server.errors[code]; ok || len(server.errors) < ERROR_STATS_NUMBER {
+		server.errors[code]++
+	}
+}
+
+// resetServerStats 清空统计，initServer 中调用
+func resetServerStats() {
+	server.statNumcommands = 0
+	server.statNumconnections = 0
+	server.statExpiredkeys = 0
+	server.statEvictedkeys = 0
+	server.statKeyspaceHits = 0
+	server.statKeyspaceMisses = 0
+	server.statRejectedConn = 0
+	server.statTotalErrorReplies = 0
+	server.statClientQbufLimitDisconnections = 0
+	server.statClientOutbufLimitDisconnections = 0
+	atomic.StoreInt64(&server.statNetInputBytes, 0)
+	atomic.StoreInt64(&server.statNetOutputBytes, 0)
+	server.statPeakMemory = 0
+	server.errors = make(map[string]int64)
+	server.instMetric = [STATS_METRIC_COUNT]instMetric{}
+	forEachCommand(func(cmd *RedisCommand) {
+		cmd.calls = 0
+		cmd.microseconds = 0
+		cmd.rejectedCalls = 0
+		cmd.failedCalls = 0
+		cmd.latencyHistogram = nil
+	})
+}
+
+// parseLatencyTrackingPercentiles 解析 "50 99 99.9"
+func parseLatencyTrackingPercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+	for _, f := range strings.Fields(s) {
+		p, err := strconv.ParseFloat(f, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("latency-tracking-info-percentiles: invalid percentile '%s'", f)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+func updatePeakMemory(mem int64) {
+	if mem > server.statPeakMemory {
+		server.statPeakMemory = mem
+	}
+}
+
+func genInfoServer(sb *strings.Builder) {
+	now := time.Now()
+	uptime := now.Unix() - server.statStarttime
+	var uts unix.Utsname
+	unix.Uname(&uts)
+	executable, _ := os.Executable()
+	configFile, _ := filepath.Abs(conf.ConfigFile)
+	ioThreadsActive := 0
+	if server.ioThreadsNum > 1 {
+		ioThreadsActive = 1
+	}
+	sb.WriteString("# Server\r\n")
+	fmt.Fprintf(sb, "redis_version:%s\r\nredis_git_sha1:00000000\r\nredis_git_dirty:0\r\nredis_mode:standalone\r\n", REDIS_VERSION)
+	fmt.Fprintf(sb, "os:%s %s %s\r\narch_bits:%d\r\nmultiplexing_api:epoll\r\ngo_version:%s\r\n",
+		unix.ByteSliceToString(uts.Sysname[:]), unix.ByteSliceToString(uts.Release[:]), unix.ByteSliceToString(uts.Machine[:]),
+		strconv.IntSize, runtime.Version())
+	fmt.Fprintf(sb, "process_id:%d\r\nrun_id:%s\r\ntcp_port:%d\r\n", os.Getpid(), server.runid, server.port)
+	fmt.Fprintf(sb, "server_time_usec:%d\r\nuptime_in_seconds:%d\r\nuptime_in_days:%d\r\n",
+		now.UnixNano()/int64(time.Microsecond), uptime, uptime/86400)
+	fmt.Fprintf(sb, "hz:%d\r\nlru_clock:%d\r\nexecutable:%s\r\nconfig_file:%s\r\nio_threads_active:%d\r\n",
+		1000/SERVER_CRON_PERIOD, server.lruclock, executable, configFile, ioThreadsActive)
+}
+
+func genInfoClients(sb *strings.Builder) {
+	var maxIn, maxOut int64
+	var pubsubClients, watchingClients, timeoutClients int
+	for _, c := range server.clients {
+		if n := int64(len(c.queryBuf)); n > maxIn {
+			maxIn = n
+		}
+		if c.replyBytes > maxOut {
+			maxOut = c.replyBytes
+		}
+		if c.flags&CLIENT_PUBSUB != 0 {
+			pubsubClients++
+		}
+		if len(c.watchedKeys) > 0 {
+			watchingClients++
+		}
+		if c.flags&CLIENT_BLOCKED != 0 && c.bpop.timeout != 0 {
+			timeoutClients++
+		}
+	}
+	var watchedKeys, blockingKeys int
+	for _, db := range server.db {
+		watchedKeys += len(db.watchedKeys)
+		blockingKeys += len(db.blockingKeys)
+	}
+	sb.WriteString("# Clients\r\n")
+	fmt.Fprintf(sb, "connected_clients:%d\r\ncluster_connections:0\r\nmaxclients:%d\r\n",
+		len(server.clients)-len(server.slaves), server.maxclients)
+	fmt.Fprintf(sb, "client_recent_max_input_buffer:%d\r\nclient_recent_max_output_buffer:%d\r\n", maxIn, maxOut)
+	fmt.Fprintf(sb, "blocked_clients:%d\r\ntracking_clients:0\r\npubsub_clients:%d\r\nwatching_clients:%d\r\n",
+		server.blockedClients, pubsubClients, watchingClients)
+	fmt.Fprintf(sb, "clients_in_timeout_table:%d\r\ntotal_watched_keys:%d\r\ntotal_blocking_keys:%d\r\n",
+		timeoutClients, watchedKeys, blockingKeys)
+}
+
+func genInfoMemory(sb *strings.Builder) {
+	mem := usedMemory()
+	updatePeakMemory(mem)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rss := int64(ms.Sys)
+	var si unix.Sysinfo_t
+	var totalSystemMemory int64
+	if unix.Sysinfo(&si) == nil {
+		totalSystemMemory = int64(si.Totalram) * int64(si.Unit)
+	}
+	peakPerc, fragRatio := 0.0, 0.0
+	if server.statPeakMemory > 0 {
+		peakPerc = float64(mem) * 100 / float64(server.statPeakMemory)
+	}
+	if mem > 0 {
+		fragRatio = float64(rss) / float64(mem)
+	}
+	sb.WriteString("# Memory\r\n")
+	fmt.Fprintf(sb, "used_memory:%d\r\nused_memory_human:%s\r\n", mem, bytesToHuman(mem))
+	fmt.Fprintf(sb, "used_memory_rss:%d\r\nused_memory_rss_human:%s\r\n", rss, bytesToHuman(rss))
+	fmt.Fprintf(sb, "used_memory_peak:%d\r\nused_memory_peak_human:%s\r\nused_memory_peak_perc:%.2f%%\r\n",
+		server.statPeakMemory, bytesToHuman(server.statPeakMemory), peakPerc)
+	fmt.Fprintf(sb, "total_system_memory:%d\r\ntotal_system_memory_human:%s\r\n", totalSystemMemory, bytesToHuman(totalSystemMemory))
+	fmt.Fprintf(sb, "maxmemory:%d\r\nmaxmemory_human:%s\r\nmaxmemory_policy:%s\r\n",
+		server.maxmemory, bytesToHuman(server.maxmemory), maxmemoryPolicyName(server.maxmemoryPolicy))
+	fmt.Fprintf(sb, "mem_fragmentation_ratio:%.2f\r\n", fragRatio)
+	fmt.Fprintf(sb, "lazyfree_pending_objects:%d\r\nlazyfreed_objects:%d\r\n",
+		atomic.LoadInt64(&lazyfreeObjects), atomic.LoadInt64(&lazyfreedObjects))
+}
+
+func genInfoPersistence(sb *strings.Builder) {
+	sb.WriteString("# Persistence\r\n")
+	fmt.Fprintf(sb, "loading:0\r\nasync_loading:0\r\nrdb_changes_since_last_save:%d\r\nrdb_bgsave_in_progress:0\r\n",
+		server.dirty-server.dirtyAtLastSave)
+	fmt.Fprintf(sb, "rdb_last_save_time:%d\r\nrdb_last_bgsave_status:ok\r\nrdb_saves:%d\r\naof_enabled:0\r\n",
+		server.lastsave, server.statRdbSaves)
+}
+
+func genInfoStats(sb *strings.Builder) {
+	sb.WriteString("# Stats\r\n")
+	fmt.Fprintf(sb, "total_connections_received:%d\r\ntotal_commands_processed:%d\r\ninstantaneous_ops_per_sec:%d\r\n",
+		server.statNumconnections, server.statNumcommands, getInstantaneousMetric(STATS_METRIC_COMMAND))
+	fmt.Fprintf(sb, "total_net_input_bytes:%d\r\ntotal_net_output_bytes:%d\r\n",
+		atomic.LoadInt64(&server.statNetInputBytes), atomic.LoadInt64(&server.statNetOutputBytes))
+	fmt.Fprintf(sb, "instantaneous_input_kbps:%.2f\r\ninstantaneous_output_kbps:%.2f\r\n",
+		float64(getInstantaneousMetric(STATS_METRIC_NET_INPUT))/1024, float64(getInstantaneousMetric(STATS_METRIC_NET_OUTPUT))/1024)
+	fmt.Fprintf(sb, "rejected_connections:%d\r\nexpired_keys:%d\r\nevicted_keys:%d\r\n",
+		server.statRejectedConn, server.statExpiredkeys, server.statEvictedkeys)
+	fmt.Fprintf(sb, "keyspace_hits:%d\r\nkeyspace_misses:%d\r\npubsub_channels:%d\r\npubsub_patterns:%d\r\n",
+		server.statKeyspaceHits, server.statKeyspaceMisses, len(server.pubsubChannels), len(server.pubsubPatterns))
+	fmt.Fprintf(sb, "total_error_replies:%d\r\n", server.statTotalErrorReplies)
+	fmt.Fprintf(sb, "client_query_buffer_limit_disconnections:%d\r\n", atomic.LoadInt64(&server.statClientQbufLimitDisconnections))
+	fmt.Fprintf(sb, "client_output_buffer_limit_disconnections:%d\r\n", server.statClientOutbufLimitDisconnections)
+}
+
+func slaveStateName(state ReplState) string {
+	switch state {
+	case SLAVE_STATE_WAIT_BGSAVE_START:
+		return "wait_bgsave"
+	case SLAVE_STATE_SEND_BULK:
+		return "send_bulk"
+	case SLAVE_STATE_ONLINE:
+		return "online"
+	}
+	return ""
+}
+
+func genInfoReplication(sb *strings.Builder) {
+	now := time.Now().Unix()
+	sb.WriteString("# Replication\r\n")
+	if server.masterhost == "" {
+		sb.WriteString("role:master\r\n")
+	} else {
+		linkStatus, lastIO, readOffset, offset := "down", int64(-1), int64(0), int64(0)
+		if server.replState == REPL_STATE_CONNECTED {
+			linkStatus = "up"
+		}
+		if server.master != nil {
+			lastIO = now - server.master.lastinteraction
+			readOffset, offset = server.master.readReploff, server.master.reploff
+		}
+		syncInProgress := 0
+		if server.replState == REPL_STATE_TRANSFER {
+			syncInProgress = 1
+		}
+		fmt.Fprintf(sb, "role:slave\r\nmaster_host:%s\r\nmaster_port:%d\r\nmaster_link_status:%s\r\n",
+			server.masterhost, server.masterport, linkStatus)
+		fmt.Fprintf(sb, "master_last_io_seconds_ago:%d\r\nmaster_sync_in_progress:%d\r\n", lastIO, syncInProgress)
+		fmt.Fprintf(sb, "slave_read_repl_offset:%d\r\nslave_repl_offset:%d\r\n", readOffset, offset)
+		if syncInProgress == 1 {
+			fmt.Fprintf(sb, "master_sync_total_bytes:%d\r\nmaster_sync_read_bytes:%d\r\nmaster_sync_left_bytes:%d\r\n",
+				server.replTransferSize, server.replTransferRead, server.replTransferSize-server.replTransferRead)
+			fmt.Fprintf(sb, "master_sync_last_io_seconds_ago:%d\r\n", now-server.replTransferLastIO)
+		}
+		readOnly := 0
+		if server.replicaReadOnly {
+			readOnly = 1
+		}
+		fmt.Fprintf(sb, "slave_read_only:%d\r\n", readOnly)
+	}
+	slaves := make([]*RedisClient, 0, len(server.slaves))
+	for _, slave := range server.slaves {
+		slaves = append(slaves, slave)
+	}
+	sort.Slice(slaves, func(i, j int) bool { return slaves[i].id < slaves[j].id })
+	fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(slaves))
+	for i, slave := range slaves {
+		ip := getClientPeerId(slave)
+		if j := strings.LastIndexByte(ip, ':'); j >= 0 {
+			ip = ip[:j]
+		}
+		fmt.Fprintf(sb, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, ip, slave.slaveListeningPort, slaveStateName(slave.replState), slave.replAckOff, now-slave.replAckTime)
+	}
+	fmt.Fprintf(sb, "master_failover_state:no-failover\r\nmaster_replid:%s\r\nmaster_replid2:%s\r\n", server.replid, server.replid2)
+	fmt.Fprintf(sb, "master_repl_offset:%d\r\nsecond_repl_offset:%d\r\n", server.masterReplOffset, server.secondReplidOffset)
+	if server.backlog != nil {
+		fmt.Fprintf(sb, "repl_backlog_active:1\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d\r\n",
+			server.replBacklogSize, server.backlog.offset, server.backlog.histlen)
+	} else {
+		fmt.Fprintf(sb, "repl_backlog_active:0\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:0\r\nrepl_backlog_histlen:0\r\n",
+			server.replBacklogSize)
+	}
+}
+
+func genInfoCPU(sb *strings.Builder) {
+	var self, children unix.Rusage
+	unix.Getrusage(unix.RUSAGE_SELF, &self)
+	unix.Getrusage(unix.RUSAGE_CHILDREN, &children)
+	seconds := func(tv unix.Timeval) float64 {
+		return float64(tv.Sec) + float64(tv.Usec)/1e6
+	}
+	sb.WriteString("# CPU\r\n")
+	fmt.Fprintf(sb, "used_cpu_sys:%.6f\r\nused_cpu_user:%.6f\r\n", seconds(self.Stime), seconds(self.Utime))
+	fmt.Fprintf(sb, "used_cpu_sys_children:%.6f\r\nused_cpu_user_children:%.6f\r\n", seconds(children.Stime), seconds(children.Utime))
+}
+
+// sortedCommandsWithSubcommands 按全名排序的所有命令，包括子命令
+func sortedCommandsWithSubcommands() []*RedisCommand {
+	var cmds []*RedisCommand
+	forEachCommand(func(cmd *RedisCommand) {
+		cmds = append(cmds, cmd)
+	})
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].fullname < cmds[j].fullname })
+	return cmds
+}
+
+func genInfoCommandStats(sb *strings.Builder) {
+	sb.WriteString("# Commandstats\r\n")
+	for _, cmd := range sortedCommandsWithSubcommands() {
+		if cmd.calls == 0 && cmd.failedCalls == 0 && cmd.rejectedCalls == 0 {
+			continue
+		}
+		perCall := 0.0
+		if cmd.calls > 0 {
+			perCall = float64(cmd.microseconds) / float64(cmd.calls)
+		}
+		fmt.Fprintf(sb, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			cmd.fullname, cmd.calls, cmd.microseconds, perCall, cmd.rejectedCalls, cmd.failedCalls)
+	}
+}
+
+func genInfoErrorStats(sb *strings.Builder) {
+	codes := make([]string, 0, len(server.errors))
+	for code := range server.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	sb.WriteString("# Errorstats\r\n")
+	for _, code := range codes {
+		fmt.Fprintf(sb, "errorstat_%s:count=%d\r\n", code, server.errors[code])
+	}
+}
+
+// genInfoLatencyStats 每个命令的耗时分位数，单位微秒
+func genInfoLatencyStats(sb *strings.Builder) {
+	sb.WriteString("# Latencystats\r\n")
+	if !server.latencyTrackingEnabled {
+		return
+	}
+	for _, cmd := range sortedCommandsWithSubcommands() {
+		if cmd.latencyHistogram == nil || cmd.latencyHistogram.totalCount == 0 {
+			continue
+		}
+		parts := make([]string, len(server.latencyTrackingInfoPercentiles))
+		for i, p := range server.latencyTrackingInfoPercentiles {
+			parts[i] = fmt.Sprintf("p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64),
+				float64(cmd.latencyHistogram.valueAtPercentile(p))/1000)
+		}
+		fmt.Fprintf(sb, "latency_percentiles_usec_%s:%s\r\n", cmd.fullname, strings.Join(parts, ","))
+	}
+}
+
+func genInfoKeyspace(sb *strings.Builder) {
+	sb.WriteString("# Keyspace\r\n")
+	for _, db := range server.db {
+		keys, expires := db.data.Len(), db.expire.Len()
+		if keys == 0 {
+			continue
+		}
+		fmt.Fprintf(sb, "db%d:keys=%d,expires=%d,avg_ttl=%d\r\n", db.id, keys, expires, db.avgTTL)
+	}
+}
+
+// genRedisInfoString 生成 INFO 的内容，sections 为空时输出默认部分。
+// all 输出所有部分，everything 额外包括模块生成的部分，default 为默认部分
+func genRedisInfoString(sections []string) string {
+	want := make(map[string]bool)
+	if len(sections) == 0 {
+		sections = []string{"default"}
+	}
+	for _, s := range sections {
+		switch s = strings.ToLower(s); s {
+		case "all", "everything":
+			for _, d := range defaultInfoSections {
+				want[d] = true
+			}
+			want["commandstats"] = true
+			want["latencystats"] = true
+		case "default":
+			for _, d := range defaultInfoSections {
+				want[d] = true
+			}
+		default:
+			want[s] = true
+		}
+	}
+	generators := []struct {
+		name string
+		gen  func(sb *strings.Builder)
+	}{
+		{"server", genInfoServer},
+		{"clients", genInfoClients},
+		{"memory", genInfoMemory},
+		{"persistence", genInfoPersistence},
+		{"stats", genInfoStats},
+		{"replication", genInfoReplication},
+		{"cpu", genInfoCPU},
+		{"modules", func(sb *strings.Builder) { sb.WriteString("# Modules\r\n") }},
+		{"commandstats", genInfoCommandStats},
+		{"errorstats", genInfoErrorStats},
+		{"latencystats", genInfoLatencyStats},
+		{"cluster", func(sb *strings.Builder) { sb.WriteString("# Cluster\r\ncluster_enabled:0\r\n") }},
+		{"keyspace", genInfoKeyspace},
+	}
+	var sb strings.Builder
+	for _, g := range generators {
+		if !want[g.name] {
+			continue
+		}
+		// 各部分之间以空行分隔
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		g.gen(&sb)
+	}
+	return sb.String()
+}
+
+// infoCommand INFO [section [section ...]]
+func infoCommand(c *RedisClient) {
+	sections := make([]string, 0, len(c.args)-1)
+	for _, arg := range c.args[1:] {
+		sections = append(sections, arg.StrVal())
+	}
+	c.AddReplyBulk(genRedisInfoString(sections))
+}
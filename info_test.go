@@ -0,0 +1,59 @@
+package main
+
+import (
+	"go-redis/conf"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfoSections(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "info\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	info := allReplies(c)
+	for _, section := range []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "CPU", "Errorstats", "Keyspace"} {
+		assert.Contains(t, info, "# "+section+"\r\n")
+	}
+	assert.NotContains(t, info, "# Commandstats\r\n")
+	assert.NotContains(t, info, "# Latencystats\r\n")
+	assert.Contains(t, info, "\r\nrun_id:"+server.runid+"\r\n")
+	assert.Contains(t, info, "\r\nrole:master\r\n")
+
+	// 多个部分按固定顺序输出，以空行分隔
+	assert.Equal(t, "# CPU", strings.Split(genRedisInfoString([]string{"cpu"}), "\r\n")[0])
+	info = genRedisInfoString([]string{"keyspace", "CLUSTER"})
+	assert.Equal(t, "# Cluster\r\ncluster_enabled:0\r\n\r\n# Keyspace\r\n", info)
+	info = genRedisInfoString([]string{"everything"})
+	assert.Contains(t, info, "# Commandstats\r\n")
+	assert.Contains(t, info, "# Latencystats\r\n")
+	assert.Equal(t, "", genRedisInfoString([]string{"nosuchsection"}))
+}
+
+func TestInfoStats(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v\r\nget k\r\nget nokey\r\nlpush k v\r\nget\r\nnosuchcmd\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	allReplies(c)
+
+	info := genRedisInfoString([]string{"stats", "errorstats"})
+	assert.Contains(t, info, "\r\ntotal_commands_processed:4\r\n")
+	assert.Contains(t, info, "\r\nkeyspace_hits:1\r\nkeyspace_misses:1\r\n")
+	assert.Contains(t, info, "\r\ntotal_error_replies:3\r\n")
+	assert.Contains(t, info, "# Errorstats\r\nerrorstat_ERR:count=2\r\nerrorstat_WRONGTYPE:count=1\r\n")
+
+	info = genRedisInfoString([]string{"commandstats"})
+	assert.Contains(t, info, "\r\ncmdstat_get:calls=2,")
+	assert.Contains(t, info, ",rejected_calls=1,failed_calls=0\r\n")
+	assert.Regexp(t, `cmdstat_lpush:calls=1,usec=\d+,usec_per_call=[\d.]+,rejected_calls=0,failed_calls=1\r\n`, info)
+	assert.NotContains(t, info, "cmdstat_nosuchcmd")
+
+	info = genRedisInfoString([]string{"latencystats"})
+	assert.Regexp(t, `latency_percentiles_usec_set:p50=[\d.]+,p99=[\d.]+,p99\.9=[\d.]+\r\n`, info)
+
+	_, err := parseLatencyTrackingPercentiles("50 101")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"go-redis/ae"
+	"log"
+	"sync"
+)
+
+const IO_THREADS_MAX_NUM = 128
+
+// I/O 线程的操作
+const (
+	IO_THREADS_OP_READ = iota
+	IO_THREADS_OP_WRITE
+)
+
+// ioThreadJob 一批由同一个 I/O 线程处理的客户端
+type ioThreadJob struct {
+	op      int
+	clients []*RedisClient
+}
+
+// I/O 线程只处理客户端自身的读写与解析，命令始终在主线程执行。
+// 主线程分发任务后等待所有线程完成，期间不会访问这些客户端。
+var ioThreads struct {
+	jobs []chan ioThreadJob // 下标 0 对应主线程，不使用
+	wg   sync.WaitGroup
+}
+
+// initThreadedIO 启动 io-threads - 1 个 I/O 线程，已经启动的线程会被复用
+func initThreadedIO() {
+	if len(ioThreads.jobs) == 0 {
+		ioThreads.jobs = append(ioThreads.jobs, nil)
+	}
+	for len(ioThreads.jobs) < server.ioThreadsNum {
+		ch := make(chan ioThreadJob)
+		ioThreads.jobs = append(ioThreads.jobs, ch)
+		go ioThreadMain(ch)
+	}
+}
+
+func ioThreadMain(jobs chan ioThreadJob) {
+	for job := range jobs {
+		for _, c := range job.clients {
+			ioThreadHandleClient(job.op, c)
+		}
+		ioThreads.wg.Done()
+	}
+}
+
+// ioThreadHandleClient 读取并解析第一条命令，或者发送回复；读写出错的客户端由主线程释放。
+// socket 是非阻塞的，写满时直接返回，剩余的回复由主线程注册写事件发送
+func ioThreadHandleClient(op int, c *RedisClient) {
+	if op == IO_THREADS_OP_WRITE {
+		if err := writeToClient(c); err != nil {
+			log.Printf("send reply err: %v\n", err)
+			c.flags |= CLIENT_CLOSE_ASAP
+		}
+		return
+	}
+	if err := readFromClient(c); err != nil {
+		log.Printf("client %v read err: %v\n", c.fd, err)
+		c.flags |= CLIENT_CLOSE_ASAP
+		return
+	}
+	if c.queryLen == 0 {
+		return
+	}
+	// 协议错误需要回复，留给主线程重新解析，出错时 queryBuf 没有被消费
+	if ok, err := parseQueryBuf(c); err == nil && ok {
+		c.flags |= CLIENT_PENDING_COMMAND
+	}
+}
+
+// runIOThreads 将客户端轮流分配给各个线程，主线程处理第一份，等待全部完成后返回。
+// 客户端较少时并行的收益不足以抵消调度开销，直接在主线程处理
+func runIOThreads(op int, clients []*RedisClient) {
+	n := server.ioThreadsNum
+	if n == 1 || len(clients) < n*2 {
+		for _, c := range clients {
+			ioThreadHandleClient(op, c)
+		}
+		return
+	}
+	lists := make([][]*RedisClient, n)
+	for i, c := range clients {
+		lists[i%n] = append(lists[i%n], c)
+	}
+	for i := 1; i < n; i++ {
+		if len(lists[i]) > 0 {
+			ioThreads.wg.Add(1)
+			ioThreads.jobs[i] <- ioThreadJob{op: op, clients: lists[i]}
+		}
+	}
+	for _, c := range lists[0] {
+		ioThreadHandleClient(op, c)
+	}
+	ioThreads.wg.Wait()
+}
+
+// postponeClientRead 开启 I/O 线程时推迟普通客户端的读取，返回 true 表示已推迟
+func postponeClientRead(c *RedisClient) bool {
+	if server.ioThreadsNum > 1 && c.flags&(CLIENT_MASTER|CLIENT_SLAVE|CLIENT_BLOCKED|CLIENT_PENDING_READ) == 0 {
+		c.flags |= CLIENT_PENDING_READ
+		server.clientsPendingRead = append(server.clientsPendingRead, c)
+		return true
+	}
+	return false
+}
+
+// handleClientsWithPendingReadsUsingThreads 并行读取并解析，然后在主线程按顺序执行命令
+func handleClientsWithPendingReadsUsingThreads() {
+	clients := server.clientsPendingRead
+	server.clientsPendingRead = nil
+	// 已经释放的客户端的 fd 可能被新连接复用，不能再读取
+	live := clients[:0]
+	for _, c := range clients {
+		c.flags &^= CLIENT_PENDING_READ
+		if c.flags&CLIENT_CLOSED == 0 {
+			live = append(live, c)
+		}
+	}
+	runIOThreads(IO_THREADS_OP_READ, live)
+	for _, c := range live {
+		// 可能已被前面的客户端执行的命令释放
+		if c.flags&CLIENT_CLOSED != 0 {
+			continue
+		}
+		if c.flags&CLIENT_CLOSE_ASAP != 0 {
+			freeClient(c)
+			continue
+		}
+		if c.flags&CLIENT_PENDING_COMMAND != 0 {
+			c.flags &^= CLIENT_PENDING_COMMAND
+			processParsedCommand(c)
+			if c.flags&CLIENT_CLOSED != 0 {
+				continue
+			}
+		}
+		// 剩余的命令在主线程解析执行
+		if err := ProcessQueryBuf(c); err != nil {
+			log.Printf("process query buf err: %v\n", err)
+			freeClient(c)
+		}
+	}
+}
+
+// handleClientsWithPendingWritesUsingThreads 并行发送回复，没有发送完的注册写事件
+func handleClientsWithPendingWritesUsingThreads() {
+	clients := server.clientsPendingWrite
+	server.clientsPendingWrite = nil
+	live := clients[:0]
+	for _, c := range clients {
+		c.flags &^= CLIENT_PENDING_WRITE
+		if c.flags&CLIENT_CLOSED == 0 && c.reply.Length > 0 {
+			live = append(live, c)
+		}
+	}
+	runIOThreads(IO_THREADS_OP_WRITE, live)
+	for _, c := range live {
+		if c.flags&CLIENT_CLOSE_ASAP != 0 || (c.reply.Length == 0 && c.flags&CLIENT_CLOSE_AFTER_REPLY != 0) {
+			freeClient(c)
+		} else if c.reply.Length > 0 {
+			server.aeLoop.AddFileEvent(c.fd, ae.FE_WRITABLE, SendReplyToClient, c)
+		}
+	}
+}
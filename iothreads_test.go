@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"go-redis/conf"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestThreadedIO(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.IoThreads = 2
+	initServer(cfg)
+	var clients []*RedisClient
+	var peers []int
+	for i := 0; i < 5; i++ {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+		assert.Nil(t, err)
+		c := CreateClient(fds[0])
+		linkClient(c)
+		clients = append(clients, c)
+		peers = append(peers, fds[1])
+		defer unix.Close(fds[1])
+		if i < 4 {
+			_, err = unix.Write(fds[1], []byte(fmt.Sprintf("set k%d v%d\r\nget k%d\r\n", i, i, i)))
+			assert.Nil(t, err)
+		}
+	}
+	// 最后一个客户端断开连接，由 I/O 线程发现后交给主线程释放
+	unix.Shutdown(peers[4], unix.SHUT_WR)
+
+	// 读取推迟到 beforeSleep 中
+	for _, c := range clients {
+		ReadQueryFromClient(server.aeLoop, c.fd, c)
+		assert.NotEqual(t, 0, c.flags&CLIENT_PENDING_READ)
+		assert.Equal(t, 0, c.reply.Length)
+	}
+	beforeSleep(server.aeLoop)
+	buf := make([]byte, 64)
+	for i, c := range clients[:4] {
+		assert.Equal(t, 0, c.flags&(CLIENT_PENDING_READ|CLIENT_PENDING_WRITE|CLIENT_PENDING_COMMAND))
+		n, err := unix.Read(peers[i], buf)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("+OK\r\n$2\r\nv%d\r\n", i), string(buf[:n]))
+	}
+	assert.Equal(t, int64(4), server.db[0].data.Len())
+	assert.NotEqual(t, 0, clients[4].flags&CLIENT_CLOSED)
+}
+
+func TestThreadedIOClientNotReading(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.IoThreads = 2
+	initServer(cfg)
+	var clients []*RedisClient
+	for i := 0; i < 4; i++ {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+		assert.Nil(t, err)
+		defer unix.Close(fds[1])
+		c := acceptCommonHandler(fds[0], "127.0.0.1")
+		clients = append(clients, c)
+		// 没有数据可读时不解析、不断开
+		ReadQueryFromClient(server.aeLoop, c.fd, c)
+	}
+	beforeSleep(server.aeLoop)
+	for _, c := range clients {
+		assert.Equal(t, 0, c.flags&(CLIENT_CLOSED|CLIENT_PENDING_COMMAND))
+	}
+
+	// 对端不读取，I/O 线程写满 socket 后返回，剩余的回复注册写事件
+	for _, c := range clients {
+		for i := 0; i < 64; i++ {
+			c.AddReplyBulk(strings.Repeat("x", 64*1024))
+		}
+	}
+	beforeSleep(server.aeLoop)
+	for _, c := range clients {
+		assert.Equal(t, 0, c.flags&(CLIENT_CLOSED|CLIENT_PENDING_WRITE))
+		assert.True(t, c.reply.Length > 0)
+		assert.NotNil(t, server.aeLoop.FileEvents[-c.fd])
+		freeClient(c)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 命令耗时的直方图，与 HDR histogram 一样按 2 的幂分段，每段再线性分为若干子桶，
+// 相对误差固定，取值范围为 1ns 到 1s，超出范围的值按边界记录
+const (
+	LATENCY_HISTOGRAM_SUB_BUCKET_BITS  = 7 // 每段 128 个子桶，相对误差小于 1%
+	LATENCY_HISTOGRAM_SUB_BUCKET_COUNT = 1 << LATENCY_HISTOGRAM_SUB_BUCKET_BITS
+	LATENCY_HISTOGRAM_MIN_VALUE        = 1          // ns
+	LATENCY_HISTOGRAM_MAX_VALUE        = 1000000000 // ns
+)
+
+type latencyHistogram struct {
+	counts     []int64 // 第一次记录时分配
+	totalCount int64
+	max        int64
+}
+
+// latencyHistogramIndex 小于子桶数量的值一一对应，其余的值按最高位分段
+func latencyHistogramIndex(v int64) int {
+	if v < LATENCY_HISTOGRAM_SUB_BUCKET_COUNT {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 1 - LATENCY_HISTOGRAM_SUB_BUCKET_BITS
+	sub := int(v >> uint(shift))
+	return (shift+1)*LATENCY_HISTOGRAM_SUB_BUCKET_COUNT + sub - LATENCY_HISTOGRAM_SUB_BUCKET_COUNT
+}
+
+// latencyHistogramUpperBound 下标对应的子桶中最大的值
+func latencyHistogramUpperBound(idx int) int64 {
+	if idx < LATENCY_HISTOGRAM_SUB_BUCKET_COUNT {
+		return int64(idx)
+	}
+	shift := idx/LATENCY_HISTOGRAM_SUB_BUCKET_COUNT - 1
+	sub := int64(idx%LATENCY_HISTOGRAM_SUB_BUCKET_COUNT + LATENCY_HISTOGRAM_SUB_BUCKET_COUNT)
+	return (sub+1)<<uint(shift) - 1
+}
+
+func (h *latencyHistogram) record(ns int64) {
+	if ns < LATENCY_HISTOGRAM_MIN_VALUE {
+		ns = LATENCY_HISTOGRAM_MIN_VALUE
+	} else if ns > LATENCY_HISTOGRAM_MAX_VALUE {
+		ns = LATENCY_HISTOGRAM_MAX_VALUE
+	}
+	if h.counts == nil {
+		h.counts = make([]int64, latencyHistogramIndex(LATENCY_HISTOGRAM_MAX_VALUE)+1)
+	}
+	h.counts[latencyHistogramIndex(ns)]++
+	h.totalCount++
+	if ns > h.max {
+		h.max = ns
+	}
+}
+
+// valueAtPercentile 不小于 percentile% 的记录所在子桶的上界，没有记录时返回 0
+func (h *latencyHistogram) valueAtPercentile(percentile float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	target := int64(float64(h.totalCount)*percentile/100 + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var count int64
+	for idx, n := range h.counts {
+		count += n
+		if count >= target {
+			if v := latencyHistogramUpperBound(idx); v < h.max {
+				return v
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// 延迟监控：记录各类事件超过 latency-monitor-threshold 毫秒的耗时，
+// 每个事件保留最近 LATENCY_TS_LEN 个样本，同一秒内的多个样本只保留最大值
+const (
+	LATENCY_TS_LEN     = 160
+	LATENCY_GRAPH_COLS = 80
+)
+
+type latencySample struct {
+	time    int64 // 秒
+	latency int64 // 毫秒
+}
+
+type latencyTimeSeries struct {
+	idx     int // 下一个样本的位置
+	max     int64
+	samples [LATENCY_TS_LEN]latencySample
+}
+
+// latencyStats 事件的统计，用于 LATENCY DOCTOR
+type latencyStats struct {
+	samples int
+	avg     int64
+	min     int64
+	max     int64
+	mad     int64 // 平均绝对偏差
+	period  int64 // 最早的样本距今的秒数
+}
+
+func latencyMonitorInit() {
+	server.latencyEvents = make(map[string]*latencyTimeSeries)
+}
+
+// latencyStartMonitor 开始计时，监控关闭时返回零值，避免不必要的系统调用
+func latencyStartMonitor() time.Time {
+	if server.latencyMonitorThreshold == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// latencyEndMonitor 从 start 到现在经过的毫秒数
+func latencyEndMonitor(start time.Time) int64 {
+	if start.IsZero() {
+		return 0
+	}
+	return int64(time.Since(start) / time.Millisecond)
+}
+
+// latencyAddSampleIfNeeded 耗时达到阈值时记录样本
+func latencyAddSampleIfNeeded(event string, latency int64) {
+	if server.latencyMonitorThreshold > 0 && latency >= server.latencyMonitorThreshold {
+		latencyAddSample(event, latency)
+	}
+}
+
+func latencyAddSample(event string, latency int64) {
+	ts := server.latencyEvents[event]
+	if ts == nil {
+		ts = &latencyTimeSeries{}
+		server.latencyEvents[event] = ts
+	}
+	if latency > ts.max {
+		ts.max = latency
+	}
+	now := time.Now().Unix()
+	prev := &ts.samples[(ts.idx+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+	if prev.time == now {
+		if latency > prev.latency {
+			prev.latency = latency
+		}
+		return
+	}
+	ts.samples[ts.idx] = latencySample{now, latency}
+	ts.idx = (ts.idx + 1) % LATENCY_TS_LEN
+}
+
+// latencyResetEvent 删除事件的样本，事件存在时返回 1
+func latencyResetEvent(event string) int {
+	if _, ok := server.latencyEvents[event]; !ok {
+		return 0
+	}
+	delete(server.latencyEvents, event)
+	return 1
+}
+
+// samplesInOrder 从旧到新的样本
+func (ts *latencyTimeSeries) samplesInOrder() []latencySample {
+	var samples []latencySample
+	for j := 0; j < LATENCY_TS_LEN; j++ {
+		s := ts.samples[(ts.idx+j)%LATENCY_TS_LEN]
+		if s.time != 0 {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+func sortedLatencyEvents() []string {
+	events := make([]string, 0, len(server.latencyEvents))
+	for event := range server.latencyEvents {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+func analyzeLatencyForEvent(ts *latencyTimeSeries) latencyStats {
+	var ls latencyStats
+	samples := ts.samplesInOrder()
+	if len(samples) == 0 {
+		return ls
+	}
+	ls.samples = len(samples)
+	ls.min, ls.max = samples[0].latency, samples[0].latency
+	var sum int64
+	for _, s := range samples {
+		if s.latency < ls.min {
+			ls.min = s.latency
+		}
+		if s.latency > ls.max {
+			ls.max = s.latency
+		}
+		sum += s.latency
+	}
+	ls.avg = sum / int64(ls.samples)
+	ls.period = time.Now().Unix() - samples[0].time
+	if ls.period == 0 {
+		ls.period = 1
+	}
+	var dev int64
+	for _, s := range samples {
+		d := s.latency - ls.avg
+		if d < 0 {
+			d = -d
+		}
+		dev += d
+	}
+	ls.mad = dev / int64(ls.samples)
+	return ls
+}
+
+// createLatencyReport LATENCY DOCTOR 的报告，按事件分析并给出建议
+func createLatencyReport() string {
+	if len(server.latencyEvents) == 0 {
+		if server.latencyMonitorThreshold == 0 {
+			return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+				"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+		}
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. " +
+			"I honestly think you ought to sleep tonight.\n"
+	}
+	var b strings.Builder
+	b.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+	advices := make(map[string]bool)
+	for i, event := range sortedLatencyEvents() {
+		ts := server.latencyEvents[event]
+		ls := analyzeLatencyForEvent(ts)
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.\n",
+			i+1, event, ls.samples, ls.avg, ls.mad, float64(ls.period)/float64(ls.samples), ts.max)
+		switch {
+		case event == "command":
+			advices["slowlog"] = true
+			advices["slowcommands"] = true
+		case event == "fast-command":
+			advices["fastcommands"] = true
+		case strings.HasPrefix(event, "expire-"):
+			advices["expire"] = true
+		case strings.HasPrefix(event, "eviction-"):
+			advices["eviction"] = true
+		case event == "eventloop":
+			advices["eventloop"] = true
+		}
+	}
+	b.WriteString("\n")
+	if len(advices) == 0 {
+		b.WriteString("While there are latency events logged, I'm not able to suggest any easy fix. " +
+			"Please use the Redis community to get some help, providing this report in your help request.\n")
+		return b.String()
+	}
+	b.WriteString("I have a few advices for you:\n\n")
+	if advices["slowlog"] && (server.slowlogLogSlowerThan < 0 || server.slowlogLogSlowerThan/1000 > server.latencyMonitorThreshold) {
+		fmt.Fprintf(&b, "- The slowlog is not logging the commands reported here. Consider setting slowlog-log-slower-than "+
+			"to %d microseconds (the latency monitor threshold) or less.\n", server.latencyMonitorThreshold*1000)
+	}
+	if advices["slowcommands"] {
+		b.WriteString("- Check your Slow Log to understand what are the commands you are running which are too slow to execute. " +
+			"Use SLOWLOG GET to see the entries.\n")
+		b.WriteString("- The top offenders are also reported by LATENCY HISTOGRAM and INFO commandstats.\n")
+	}
+	if advices["fastcommands"] {
+		b.WriteString("- The system is slow to execute code paths not containing slow commands. " +
+			"This usually means the process is not getting enough CPU time, check the load of the system and the Go GC.\n")
+	}
+	if advices["expire"] {
+		b.WriteString("- Deleting or expiring many keys at the same time is slow. Avoid setting the same expire time on " +
+			"a large number of keys, or consider enabling lazyfree-lazy-expire.\n")
+	}
+	if advices["eviction"] {
+		b.WriteString("- Eviction is slow, consider enabling lazyfree-lazy-eviction, or raising maxmemory " +
+			"so that fewer keys need to be evicted at once.\n")
+	}
+	if advices["eventloop"] {
+		b.WriteString("- Single event loop iterations are slow. Besides slow commands, check clients with large pending " +
+			"replies, replicas in full sync and the io-threads setting.\n")
+	}
+	return b.String()
+}
+
+// latencyCommandGenSparkline LATENCY GRAPH 的 ASCII 图，横轴标签为样本距今的时间
+func latencyCommandGenSparkline(event string, ts *latencyTimeSeries) string {
+	samples := ts.samplesInOrder()
+	now := time.Now().Unix()
+	values := make([]int64, len(samples))
+	labels := make([]string, len(samples))
+	var min, max int64
+	for i, s := range samples {
+		if i == 0 || s.latency < min {
+			min = s.latency
+		}
+		if i == 0 || s.latency > max {
+			max = s.latency
+		}
+		values[i] = s.latency
+		switch elapsed := now - s.time; {
+		case elapsed < 60:
+			labels[i] = fmt.Sprintf("%ds", elapsed)
+		case elapsed < 3600:
+			labels[i] = fmt.Sprintf("%dm", elapsed/60)
+		case elapsed < 3600*24:
+			labels[i] = fmt.Sprintf("%dh", elapsed/3600)
+		default:
+			labels[i] = fmt.Sprintf("%dd", elapsed/(3600*24))
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - high %d ms, low %d ms (all time high %d ms)\n", event, max, min, ts.max)
+	b.WriteString(strings.Repeat("-", LATENCY_GRAPH_COLS))
+	b.WriteString("\n")
+	for offset := 0; offset < len(values); offset += LATENCY_GRAPH_COLS {
+		end := offset + LATENCY_GRAPH_COLS
+		if end > len(values) {
+			end = len(values)
+		}
+		sparklineRenderRange(&b, values[offset:end], labels[offset:end], min, max, 4)
+	}
+	return b.String()
+}
+
+// sparklineRenderRange 与 redis 的 sparkline 一致：每行 3 级字符，高于当前行的部分以 | 填充，
+// 图下方空一行后竖排标签
+func sparklineRenderRange(b *strings.Builder, values []int64, labels []string, min, max int64, rows int) {
+	const charset = "_o#"
+	relmax := float64(max - min)
+	if relmax == 0 {
+		relmax = 1
+	}
+	steps := len(charset) * rows
+	chars := make([]byte, len(values))
+	for row := 0; ; row++ {
+		loop := false
+		for j := range chars {
+			chars[j] = ' '
+		}
+		for j, v := range values {
+			step := int(float64(v-min) * float64(steps) / relmax)
+			if step >= steps {
+				step = steps - 1
+			}
+			if row < rows {
+				loop = true
+				charidx := step - (rows-row-1)*len(charset)
+				if charidx >= 0 && charidx < len(charset) {
+					chars[j] = charset[charidx]
+				} else if charidx >= len(charset) {
+					chars[j] = '|'
+				}
+			} else if row == rows {
+				// 图与标签之间的空行
+				loop = true
+				break
+			} else if labelChar := row - rows - 1; labelChar < len(labels[j]) {
+				loop = true
+				chars[j] = labels[j][labelChar]
+			}
+		}
+		if !loop {
+			return
+		}
+		b.Write(chars)
+		b.WriteString("\n")
+	}
+}
+
+// latencyHistogramCDF 以 2 的幂微秒分桶的累计次数，只输出次数有变化的桶
+func latencyHistogramCDF(h *latencyHistogram) (buckets, counts []int64) {
+	var cumulative int64
+	idx := 0
+	for bound := int64(1024); cumulative < h.totalCount; bound *= 2 {
+		prev := cumulative
+		for ; idx < len(h.counts) && latencyHistogramUpperBound(idx) < bound; idx++ {
+			cumulative += h.counts[idx]
+		}
+		if cumulative > prev {
+			buckets = append(buckets, bound/1000)
+			counts = append(counts, cumulative)
+		}
+	}
+	return buckets, counts
+}
+
+func addReplyCommandHistogram(c *RedisClient, cmd *RedisCommand) {
+	buckets, counts := latencyHistogramCDF(cmd.latencyHistogram)
+	c.AddReplyBulk(cmd.fullname)
+	c.AddReplyArrayLen(4)
+	c.AddReplyBulk("calls")
+	c.AddReplyInt(cmd.latencyHistogram.totalCount)
+	c.AddReplyBulk("histogram_usec")
+	c.AddReplyArrayLen(len(buckets) * 2)
+	for i := range buckets {
+		c.AddReplyInt(buckets[i])
+		c.AddReplyInt(counts[i])
+	}
+}
+
+// latencyHistogramCommand LATENCY HISTOGRAM [command ...]，没有参数时输出所有有记录的命令，
+// 容器命令同时输出其子命令
+func latencyHistogramCommand(c *RedisClient) {
+	var cmds []*RedisCommand
+	hasData := func(cmd *RedisCommand) bool {
+		return cmd.latencyHistogram != nil && cmd.latencyHistogram.totalCount > 0
+	}
+	if len(c.args) == 2 {
+		for _, cmd := range sortedCommandsWithSubcommands() {
+			if hasData(cmd) {
+				cmds = append(cmds, cmd)
+			}
+		}
+	} else {
+		for _, arg := range c.args[2:] {
+			cmd := lookupCommandByFullname(arg.StrVal())
+			if cmd == nil {
+				continue
+			}
+			if hasData(cmd) {
+				cmds = append(cmds, cmd)
+			}
+			for i := range cmd.subcommands {
+				if sub := &cmd.subcommands[i]; hasData(sub) {
+					cmds = append(cmds, sub)
+				}
+			}
+		}
+	}
+	c.AddReplyArrayLen(len(cmds) * 2)
+	for _, cmd := range cmds {
+		addReplyCommandHistogram(c, cmd)
+	}
+}
+
+// latencyCommand LATENCY LATEST | HISTORY | RESET | GRAPH | DOCTOR | HISTOGRAM | HELP
+func latencyCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "history" && len(c.args) == 3:
+		// LATENCY HISTORY <event>
+		var samples []latencySample
+		if ts := server.latencyEvents[c.args[2].StrVal()]; ts != nil {
+			samples = ts.samplesInOrder()
+		}
+		c.AddReplyArrayLen(len(samples))
+		for _, s := range samples {
+			c.AddReplyArrayLen(2)
+			c.AddReplyInt(s.time)
+			c.AddReplyInt(s.latency)
+		}
+	case sub == "graph" && len(c.args) == 3:
+		// LATENCY GRAPH <event>
+		event := c.args[2].StrVal()
+		ts := server.latencyEvents[event]
+		if ts == nil {
+			c.AddReplyError(fmt.Sprintf("No samples available for event '%s'", event))
+			return
+		}
+		c.AddReplyBulk(latencyCommandGenSparkline(event, ts))
+	case sub == "latest" && len(c.args) == 2:
+		events := sortedLatencyEvents()
+		c.AddReplyArrayLen(len(events))
+		for _, event := range events {
+			ts := server.latencyEvents[event]
+			last := ts.samples[(ts.idx+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+			c.AddReplyArrayLen(4)
+			c.AddReplyBulk(event)
+			c.AddReplyInt(last.time)
+			c.AddReplyInt(last.latency)
+			c.AddReplyInt(ts.max)
+		}
+	case sub == "doctor" && len(c.args) == 2:
+		c.AddReplyBulk(createLatencyReport())
+	case sub == "reset" && len(c.args) >= 2:
+		// LATENCY RESET [event ...]，返回重置的事件数
+		if len(c.args) == 2 {
+			n := len(server.latencyEvents)
+			latencyMonitorInit()
+			c.AddReplyInt(int64(n))
+			return
+		}
+		resets := 0
+		for _, arg := range c.args[2:] {
+			resets += latencyResetEvent(arg.StrVal())
+		}
+		c.AddReplyInt(int64(resets))
+	case sub == "histogram" && len(c.args) >= 2:
+		latencyHistogramCommand(c)
+	case sub == "help" && len(c.args) == 2:
+		addReplyHelp(c, []string{
+			"DOCTOR",
+			"    Return a human readable latency analysis report.",
+			"GRAPH <event>",
+			"    Return an ASCII latency graph for the <event> class.",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event> class.",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+			"HISTOGRAM [COMMAND ...]",
+			"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+			"    If no commands are specified then all histograms are replied.",
+		})
+	default:
+		addReplySubcommandSyntaxError(c)
+	}
+}
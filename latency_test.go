@@ -0,0 +1,90 @@
+package main
+
+import (
+	"go-redis/conf"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := &latencyHistogram{}
+	assert.Equal(t, int64(0), h.valueAtPercentile(50))
+	for i := int64(1); i <= 1000; i++ {
+		h.record(i * 1000)
+	}
+	// 相对误差小于 1%
+	assert.InEpsilon(t, 500000, h.valueAtPercentile(50), 0.01)
+	assert.InEpsilon(t, 990000, h.valueAtPercentile(99), 0.01)
+	assert.Equal(t, int64(1000000), h.valueAtPercentile(100))
+	h.record(10 * LATENCY_HISTOGRAM_MAX_VALUE)
+	assert.Equal(t, int64(LATENCY_HISTOGRAM_MAX_VALUE), h.valueAtPercentile(100))
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.LatencyMonitorThreshold = 100
+	initServer(cfg)
+	latencyAddSampleIfNeeded("command", 99)
+	assert.Equal(t, 0, len(server.latencyEvents))
+	latencyAddSampleIfNeeded("command", 150)
+	// 同一秒内只保留最大值
+	latencyAddSampleIfNeeded("command", 300)
+	latencyAddSampleIfNeeded("command", 200)
+	latencyAddSampleIfNeeded("expire-cycle", 120)
+	ts := server.latencyEvents["command"]
+	assert.Equal(t, []latencySample{{time.Now().Unix(), 300}}, ts.samplesInOrder())
+	// 模拟更早的样本
+	ts.samples[0].time -= 120
+	latencyAddSampleIfNeeded("command", 100)
+	now := time.Now().Unix()
+
+	c := CreateClient(-1)
+	ReadQuery(c, "latency latest\r\nlatency history command\r\nlatency history nosuchevent\r\nlatency graph nosuchevent\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	nowStr := strconv.FormatInt(now, 10)
+	assert.Equal(t, "*2\r\n*4\r\n$7\r\ncommand\r\n:"+nowStr+"\r\n:100\r\n:300\r\n"+
+		"*4\r\n$12\r\nexpire-cycle\r\n:"+nowStr+"\r\n:120\r\n:120\r\n"+
+		"*2\r\n*2\r\n:"+strconv.FormatInt(now-120, 10)+"\r\n:300\r\n*2\r\n:"+nowStr+"\r\n:100\r\n"+
+		"*0\r\n-ERR No samples available for event 'nosuchevent'\r\n", allReplies(c))
+
+	graph := latencyCommandGenSparkline("command", ts)
+	assert.Equal(t, "command - high 300 ms, low 100 ms (all time high 300 ms)\n"+strings.Repeat("-", 80)+"\n"+
+		"# \n| \n| \n|_\n  \n20\nms\n", graph)
+
+	report := createLatencyReport()
+	assert.Contains(t, report, "1. command: 2 latency spikes (average 200ms, mean deviation 100ms, period 60.00 sec). Worst all time event 300ms.\n")
+	assert.Contains(t, report, "2. expire-cycle: 1 latency spikes")
+	assert.Contains(t, report, "- Check your Slow Log")
+
+	ReadQuery(c, "latency reset command nosuchevent\r\nlatency reset\r\nlatency doctor\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":1\r\n:1\r\n$151\r\nDave, no latency spike was observed during the lifetime of this Redis instance, "+
+		"not in the slightest bit. I honestly think you ought to sleep tonight.\n\r\n", allReplies(c))
+}
+
+func TestLatencyHistogramCommand(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	set := lookupCommand("set")
+	set.latencyHistogram = &latencyHistogram{}
+	for _, ns := range []int64{500, 900, 1500, 3000, 3500, 1000000} {
+		set.latencyHistogram.record(ns)
+	}
+	buckets, counts := latencyHistogramCDF(set.latencyHistogram)
+	assert.Equal(t, []int64{1, 2, 4, 1048}, buckets)
+	assert.Equal(t, []int64{2, 3, 5, 6}, counts)
+
+	c := CreateClient(-1)
+	ReadQuery(c, "latency histogram set nosuchcmd\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*2\r\n$3\r\nset\r\n*4\r\n$5\r\ncalls\r\n:6\r\n$14\r\nhistogram_usec\r\n"+
+		"*8\r\n:1\r\n:2\r\n:2\r\n:3\r\n:4\r\n:5\r\n:1048\r\n:6\r\n", allReplies(c))
+
+	// 容器命令输出其子命令
+	ReadQuery(c, "client id\r\nlatency histogram client\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Contains(t, allReplies(c), "*2\r\n$9\r\nclient|id\r\n*4\r\n$5\r\ncalls\r\n:1\r\n")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"go-redis/obj"
+	"sync/atomic"
+)
+
+// 释放代价超过该值的对象交给后台 goroutine 释放
+const LAZYFREE_THRESHOLD = 64
+
+// 由后台 goroutine 更新，需要原子访问
+var (
+	lazyfreeObjects  int64 // 等待释放的对象数
+	lazyfreedObjects int64 // 已经释放的对象数
+)
+
+// lazyfreeGetFreeEffort 释放对象的代价，大致为需要断开的内部节点数
+func lazyfreeGetFreeEffort(o *obj.RedisObj) int64 {
+	switch o.Type {
+	case obj.LIST:
+		return int64(o.Val.(*obj.List).Length)
+	case obj.ZSET:
+		return int64(o.Val.(*obj.ZSet).Len())
+	case obj.STREAM:
+		s := o.Val.(*obj.Stream)
+		effort := int64(s.Rax.NumNodes())
+		// 每个消费组按 PEL 的大小估算
+		if s.CGroups != nil {
+			it := s.CGroups.Iterator()
+			it.Seek("^", nil)
+			for it.Next() {
+				effort += 1 + int64(it.Val.(*obj.StreamCG).PEL.Len())
+			}
+		}
+		return effort
+	}
+	return 1
+}
+
+// freeObjAsync 已经从数据库中摘除的对象，代价较大时在后台释放，否则交给 GC
+func freeObjAsync(o *obj.RedisObj) {
+	if lazyfreeGetFreeEffort(o) <= LAZYFREE_THRESHOLD {
+		return
+	}
+	atomic.AddInt64(&lazyfreeObjects, 1)
+	bioCreateLazyFreeJob(func() {
+		obj.FreeObject(o)
+		atomic.AddInt64(&lazyfreeObjects, -1)
+		atomic.AddInt64(&lazyfreedObjects, 1)
+	})
+}
+
+// emptyDbAsync 在后台释放已经被替换下来的数据字典
+func emptyDbAsync(data *obj.Dict) {
+	n := data.Len()
+	atomic.AddInt64(&lazyfreeObjects, n)
+	bioCreateLazyFreeJob(func() {
+		data.Range(func(e *obj.Entry) bool {
+			obj.FreeObject(e.Val)
+			return true
+		})
+		atomic.AddInt64(&lazyfreeObjects, -n)
+		atomic.AddInt64(&lazyfreedObjects, n)
+	})
+}
+
+// unlinkCommand UNLINK key [key ...]，与 DEL 相同但在后台释放值
+func unlinkCommand(c *RedisClient) {
+	delGenericCommand(c, true)
+}
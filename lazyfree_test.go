@@ -0,0 +1,76 @@
+package main
+
+import (
+	"go-redis/conf"
+	"go-redis/obj"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bigListQuery 创建释放代价超过阈值的列表
+func bigListQuery(key string) string {
+	return "rpush " + key + strings.Repeat(" x", LAZYFREE_THRESHOLD+1) + "\r\n"
+}
+
+func TestUnlink(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, bigListQuery("big")+"set small v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	big := server.db[0].data.Get(obj.CreateObject(obj.STR, "big"))
+	freed := atomic.LoadInt64(&lazyfreedObjects)
+
+	// 小对象直接交给 GC
+	ReadQuery(c, "unlink big small nokey\r\nget small\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":2\r\n$-1\r\n", allReplies(c))
+	assert.Equal(t, int64(0), usedMemory())
+	bioDrainWorker()
+	assert.Equal(t, freed+1, atomic.LoadInt64(&lazyfreedObjects))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&lazyfreeObjects))
+	assert.Equal(t, 0, big.Val.(*obj.List).Length)
+}
+
+func TestFlushAsync(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, bigListQuery("big")+"set a 1\r\nselect 1\r\nset b 2\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	big := server.db[0].data.Get(obj.CreateObject(obj.STR, "big"))
+	freed := atomic.LoadInt64(&lazyfreedObjects)
+
+	ReadQuery(c, "flushall async\r\ndbsize\r\nflushdb lazy\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n:0\r\n-ERR syntax error\r\n", allReplies(c))
+	assert.Equal(t, int64(0), dbTotalServerKeyCount())
+	bioDrainWorker()
+	assert.Equal(t, freed+3, atomic.LoadInt64(&lazyfreedObjects))
+	assert.Equal(t, 0, big.Val.(*obj.List).Length)
+}
+
+func TestLazyfreeServerDelAndExpire(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.LazyfreeLazyServerDel = true
+	cfg.LazyfreeLazyExpire = true
+	initServer(cfg)
+	c := CreateClient(-1)
+	ReadQuery(c, bigListQuery("k")+bigListQuery("e")+"expire e 100\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	k := server.db[0].data.Get(obj.CreateObject(obj.STR, "k"))
+	e := server.db[0].data.Get(obj.CreateObject(obj.STR, "e"))
+	server.db[0].expire.Set(obj.CreateObject(obj.STR, "e"), obj.CreateFromInt(1))
+
+	// SET 覆盖与过期删除都在后台释放旧值
+	ReadQuery(c, "set k v\r\nllen e\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n:0\r\n", allReplies(c))
+	bioDrainWorker()
+	assert.Equal(t, 0, k.Val.(*obj.List).Length)
+	assert.Equal(t, 0, e.Val.(*obj.List).Length)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"go-redis/ae"
+	"go-redis/obj"
+)
+
+// 事务中排队的命令
+type multiCmd struct {
+	cmd  *RedisCommand
+	args []*obj.RedisObj
+}
+
+type multiState struct {
+	commands []multiCmd
+	cmdFlags int // 排队命令标识的并集
+}
+
+// WATCH 的键
+type watchedKey struct {
+	key string
+	db  *redisDB
+	// WATCH 时键已经过期，之后的过期不算作修改
+	expired bool
+}
+
+func queueMultiCommand(c *RedisClient, cmd *RedisCommand) {
+	// 已经出错的事务不再排队
+	if c.flags&(CLIENT_DIRTY_CAS|CLIENT_DIRTY_EXEC) != 0 {
+		return
+	}
+	c.mstate.commands = append(c.mstate.commands, multiCmd{cmd: cmd, args: c.args})
+	c.mstate.cmdFlags |= cmd.flags
+}
+
+func discardTransaction(c *RedisClient) {
+	c.mstate = multiState{}
+	c.flags &^= CLIENT_MULTI | CLIENT_DIRTY_CAS | CLIENT_DIRTY_EXEC
+	unwatchAllKeys(c)
+}
+
+// flagTransaction 排队时发现错误，EXEC 将返回 EXECABORT
+func flagTransaction(c *RedisClient) {
+	if c.flags&CLIENT_MULTI != 0 {
+		c.flags |= CLIENT_DIRTY_EXEC
+	}
+}
+
+func multiCommand(c *RedisClient) {
+	if c.flags&CLIENT_MULTI != 0 {
+		c.AddReplyError("MULTI calls can not be nested")
+		return
+	}
+	c.flags |= CLIENT_MULTI
+	c.AddReplyStr("+OK\r\n")
+}
+
+func discardCommand(c *RedisClient) {
+	if c.flags&CLIENT_MULTI == 0 {
+		c.AddReplyError("DISCARD without MULTI")
+		return
+	}
+	discardTransaction(c)
+	c.AddReplyStr("+OK\r\n")
+}
+
+func execCommand(c *RedisClient) {
+	if c.flags&CLIENT_MULTI == 0 {
+		c.AddReplyError("EXEC without MULTI")
+		return
+	}
+	if isWatchedKeyExpired(c) {
+		c.flags |= CLIENT_DIRTY_CAS
+	}
+	if c.flags&CLIENT_DIRTY_EXEC != 0 {
+		c.AddReplyError("-EXECABORT Transaction discarded because of previous errors.")
+		discardTransaction(c)
+		return
+	}
+	if c.flags&CLIENT_DIRTY_CAS != 0 {
+		c.AddReplyStr("*-1\r\n")
+		discardTransaction(c)
+		return
+	}
+	// 执行前取消 WATCH，事务中的修改不应让自己失败
+	unwatchAllKeys(c)
+	c.AddReplyArrayLen(len(c.mstate.commands))
+	origArgs := c.args
+	propagated := false
+	c.flags |= CLIENT_DENY_BLOCKING
+	for _, mc := range c.mstate.commands {
+		// 第一个写命令前向从节点传播 MULTI，保证从节点上也是原子执行
+		if !propagated && mc.cmd.flags&CMD_WRITE != 0 {
+			replicationFeedSlaves(c.db.id, createStrArgs("MULTI"))
+			propagated = true
+		}
+		c.args = mc.args
+		call(c, mc.cmd)
+	}
+	c.flags &^= CLIENT_DENY_BLOCKING
+	c.args = origArgs
+	discardTransaction(c)
+	// 由 call 传播 EXEC
+	if propagated {
+		c.flags |= CLIENT_FORCE_REPL
+	}
+}
+
+// watchForKey 键被修改时 c 会被标记为 CLIENT_DIRTY_CAS
+func watchForKey(c *RedisClient, key *obj.RedisObj) {
+	for _, wk := range c.watchedKeys {
+		if wk.db == c.db && wk.key == key.StrVal() {
+			return
+		}
+	}
+	clients := c.db.watchedKeys[key.StrVal()]
+	c.db.watchedKeys[key.StrVal()] = append(clients, c)
+	c.watchedKeys = append(c.watchedKeys, watchedKey{
+		key:     key.StrVal(),
+		db:      c.db,
+		expired: keyIsExpired(c.db, key),
+	})
+}
+
+func unwatchAllKeys(c *RedisClient) {
+	for _, wk := range c.watchedKeys {
+		clients := removeClient(wk.db.watchedKeys[wk.key], c)
+		if len(clients) == 0 {
+			delete(wk.db.watchedKeys, wk.key)
+		} else {
+			wk.db.watchedKeys[wk.key] = clients
+		}
+	}
+	c.watchedKeys = nil
+}
+
+// isWatchedKeyExpired WATCH 之后过期但还没有被删除的键
+func isWatchedKeyExpired(c *RedisClient) bool {
+	for _, wk := range c.watchedKeys {
+		if !wk.expired && keyIsExpired(wk.db, obj.CreateObject(obj.STR, wk.key)) {
+			return true
+		}
+	}
+	return false
+}
+
+func keyIsExpired(db *redisDB, key *obj.RedisObj) bool {
+	entry := db.expire.Find(key)
+	return entry != nil && entry.Val.IntVal() <= ae.GetMsTime()
+}
+
+// touchWatchedKey 键被修改，WATCH 该键的客户端事务失败
+func touchWatchedKey(db *redisDB, key *obj.RedisObj) {
+	for _, c := range db.watchedKeys[key.StrVal()] {
+		c.flags |= CLIENT_DIRTY_CAS
+	}
+}
+
+// touchAllWatchedKeysInDb 清空或替换数据库时，存在于 emptied 或 replaced 中的被 WATCH 的键视为被修改
+func touchAllWatchedKeysInDb(emptied, replaced *redisDB) {
+	for key, clients := range emptied.watchedKeys {
+		k := obj.CreateObject(obj.STR, key)
+		if emptied.data.Find(k) == nil && (replaced == nil || replaced.data.Find(k) == nil) {
+			continue
+		}
+		for _, c := range clients {
+			c.flags |= CLIENT_DIRTY_CAS
+		}
+	}
+}
+
+func watchCommand(c *RedisClient) {
+	if c.flags&CLIENT_MULTI != 0 {
+		c.AddReplyError("WATCH inside MULTI is not allowed")
+		return
+	}
+	// 已经失败的事务没必要继续 WATCH
+	if c.flags&CLIENT_DIRTY_CAS == 0 {
+		for _, key := range c.args[1:] {
+			watchForKey(c, key)
+		}
+	}
+	c.AddReplyStr("+OK\r\n")
+}
+
+func unwatchCommand(c *RedisClient) {
+	unwatchAllKeys(c)
+	c.flags &^= CLIENT_DIRTY_CAS
+	c.AddReplyStr("+OK\r\n")
+}
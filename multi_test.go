@@ -0,0 +1,64 @@
+package main
+
+import (
+	"go-redis/ae"
+	"go-redis/conf"
+	"go-redis/obj"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lastReply(c *RedisClient) string {
+	return c.reply.Tail.Val.StrVal()
+}
+
+func TestMultiExec(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "multi\r\nset k v\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+QUEUED\r\n", lastReply(c))
+	assert.Nil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "k")))
+
+	ReadQuery(c, "exec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$1\r\nv\r\n", lastReply(c))
+	assert.Equal(t, 0, c.flags&CLIENT_MULTI)
+
+	// 排队时出错
+	ReadQuery(c, "multi\r\nset k\r\nset k v2\r\nexec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-EXECABORT Transaction discarded because of previous errors.\r\n", lastReply(c))
+	assert.Equal(t, "v", server.db[0].data.Get(obj.CreateObject(obj.STR, "k")).StrVal())
+}
+
+func TestWatch(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	other := CreateClient(-2)
+	ReadQuery(c, "watch k\r\nmulti\r\nset k v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	ReadQuery(other, "set k other\r\n")
+	assert.Nil(t, ProcessQueryBuf(other))
+	ReadQuery(c, "exec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*-1\r\n", lastReply(c))
+	assert.Equal(t, "other", server.db[0].data.Get(obj.CreateObject(obj.STR, "k")).StrVal())
+	assert.Equal(t, 0, len(server.db[0].watchedKeys))
+
+	// WATCH 之后过期
+	key := obj.CreateObject(obj.STR, "k")
+	server.db[0].expire.Set(key, obj.CreateFromInt(ae.GetMsTime()+100000))
+	ReadQuery(c, "watch k\r\nmulti\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	server.db[0].expire.Set(key, obj.CreateFromInt(ae.GetMsTime()-1))
+	ReadQuery(c, "exec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*-1\r\n", lastReply(c))
+
+	// 事务自身的修改不影响 EXEC
+	ReadQuery(c, "watch k\r\nmulti\r\nset k mine\r\nexec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n", lastReply(c))
+}
@@ -1,16 +1,69 @@
 package net
 
 import (
+	"errors"
 	"log"
+	gonet "net"
+	"strconv"
 
 	"golang.org/x/sys/unix"
 )
 
 const BACKLOG int = 64
 
-func Accept(fd int) (int, error) {
-	nfd, _, err := unix.Accept(fd)
-	return nfd, err
+// Accept 返回新连接的 fd 与对端的 ip
+func Accept(fd int) (int, string, error) {
+	nfd, sa, err := unix.Accept(fd)
+	if err != nil {
+		return -1, "", err
+	}
+	ip, _ := sockaddrToIPPort(sa)
+	return nfd, ip, nil
+}
+
+func sockaddrToIPPort(sa unix.Sockaddr) (string, int) {
+	switch addr := sa.(type) {
+	case *unix.SockaddrInet4:
+		return gonet.IP(addr.Addr[:]).String(), addr.Port
+	case *unix.SockaddrInet6:
+		return gonet.IP(addr.Addr[:]).String(), addr.Port
+	}
+	return "", 0
+}
+
+// IsLoopback ip 为 127.0.0.0/8 或者 ::1 时返回 true
+func IsLoopback(ip string) bool {
+	addr := gonet.ParseIP(ip)
+	return addr != nil && addr.IsLoopback()
+}
+
+// PeerName 对端地址 ip:port，获取失败时返回空字符串
+func PeerName(fd int) string {
+	sa, err := unix.Getpeername(fd)
+	if err != nil {
+		return ""
+	}
+	return formatAddr(sa)
+}
+
+// SockName 本端地址 ip:port，获取失败时返回空字符串
+func SockName(fd int) string {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return ""
+	}
+	return formatAddr(sa)
+}
+
+func formatAddr(sa unix.Sockaddr) string {
+	if addr, ok := sa.(*unix.SockaddrUnix); ok {
+		return addr.Name + ":0"
+	}
+	ip, port := sockaddrToIPPort(sa)
+	if ip == "" {
+		return ""
+	}
+	return gonet.JoinHostPort(ip, strconv.Itoa(port))
 }
 
 func Connect(host [4]byte, port int) (int, error) {
@@ -25,11 +78,56 @@ func Connect(host [4]byte, port int) (int, error) {
 	err = unix.Connect(s, &addr)
 	if err != nil {
 		log.Printf("connect err: %v\n", err)
+		unix.Close(s)
 		return -1, err
 	}
 	return s, nil
 }
 
+// ResolveIPv4 解析主机名为 ipv4 地址
+func ResolveIPv4(host string) ([4]byte, error) {
+	var ip [4]byte
+	addr, err := gonet.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return ip, err
+	}
+	v4 := addr.IP.To4()
+	if v4 == nil {
+		return ip, errors.New("not an ipv4 address")
+	}
+	copy(ip[:], v4)
+	return ip, nil
+}
+
+// SetTimeout 设置阻塞读写超时，ms 为 0 表示不超时
+func SetTimeout(fd int, ms int64) error {
+	tv := unix.NsecToTimeval(ms * 1e6)
+	err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return err
+	}
+	return unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv)
+}
+
+// SetNonBlock 设置为非阻塞模式，暂时无法读写时返回 EAGAIN
+func SetNonBlock(fd int) error {
+	return unix.SetNonblock(fd, true)
+}
+
+// IsAgain 非阻塞读写暂时无法完成，等待下一次可读或可写事件
+func IsAgain(err error) bool {
+	return err == unix.EAGAIN || err == unix.EWOULDBLOCK
+}
+
+// Pipe 创建非阻塞的管道，返回读端与写端
+func Pipe() (int, int, error) {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		return -1, -1, err
+	}
+	return fds[0], fds[1], nil
+}
+
 func Read(fd int, buf []byte) (int, error) {
 	return unix.Read(fd, buf)
 }
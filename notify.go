@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"go-redis/obj"
+)
+
+// 键空间通知的事件类型
+const (
+	NOTIFY_KEYSPACE = 1 << 0  // K
+	NOTIFY_KEYEVENT = 1 << 1  // E
+	NOTIFY_GENERIC  = 1 << 2  // g
+	NOTIFY_STRING   = 1 << 3  // $
+	NOTIFY_LIST     = 1 << 4  // l
+	NOTIFY_SET      = 1 << 5  // s
+	NOTIFY_HASH     = 1 << 6  // h
+	NOTIFY_ZSET     = 1 << 7  // z
+	NOTIFY_EXPIRED  = 1 << 8  // x
+	NOTIFY_EVICTED  = 1 << 9  // e
+	NOTIFY_STREAM   = 1 << 10 // t
+	NOTIFY_KEY_MISS = 1 << 11 // m，不包含在 A 中
+	NOTIFY_NEW      = 1 << 12 // n，不包含在 A 中
+	NOTIFY_ALL      = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET |
+		NOTIFY_HASH | NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM // A
+)
+
+var notifyFlagChars = []struct {
+	c    byte
+	flag int
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'l', NOTIFY_LIST},
+	{'s', NOTIFY_SET},
+	{'h', NOTIFY_HASH},
+	{'z', NOTIFY_ZSET},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'t', NOTIFY_STREAM},
+	{'m', NOTIFY_KEY_MISS},
+	{'n', NOTIFY_NEW},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+}
+
+// keyspaceEventsStringToFlags 解析 notify-keyspace-events，包含未知字符时返回错误
+func keyspaceEventsStringToFlags(classes string) (int, error) {
+	flags := 0
+	for i := 0; i < len(classes); i++ {
+		if classes[i] == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+		found := false
+		for _, f := range notifyFlagChars {
+			if f.c == classes[i] {
+				flags |= f.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid notify-keyspace-events class '%c'", classes[i])
+		}
+	}
+	return flags, nil
+}
+
+func keyspaceEventsFlagsToString(flags int) string {
+	var res []byte
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		res = append(res, 'A')
+	}
+	for _, f := range notifyFlagChars {
+		if f.flag&NOTIFY_ALL != 0 && flags&NOTIFY_ALL == NOTIFY_ALL {
+			continue
+		}
+		if flags&f.flag != 0 {
+			res = append(res, f.c)
+		}
+	}
+	return string(res)
+}
+
+// notifyKeyspaceEvent 通过发布订阅发送 __keyspace@<db>__:<key> 与 __keyevent@<db>__:<event>
+func notifyKeyspaceEvent(typ int, event string, key *obj.RedisObj, dbid int) {
+	flags := server.notifyKeyspaceEvents
+	if flags&typ == 0 {
+		return
+	}
+	if flags&NOTIFY_KEYSPACE != 0 {
+		pubsubPublishMessage(fmt.Sprintf("__keyspace@%d__:%s", dbid, key.StrVal()), event)
+	}
+	if flags&NOTIFY_KEYEVENT != 0 {
+		pubsubPublishMessage(fmt.Sprintf("__keyevent@%d__:%s", dbid, event), key.StrVal())
+	}
+}
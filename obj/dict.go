@@ -207,6 +207,7 @@ func (dict *Dict) Delete(key *RedisObj) error {
 				} else {
 					prev.next = e.next
 				}
+				dict.hts[i].used -= 1
 				return nil
 			}
 			prev = e
@@ -243,6 +244,35 @@ func (dict *Dict) Find(key *RedisObj) *Entry {
 	return nil
 }
 
+// Len 键值对数量
+func (dict *Dict) Len() int64 {
+	var n int64
+	for _, ht := range dict.hts {
+		if ht != nil {
+			n += ht.used
+		}
+	}
+	return n
+}
+
+// Range 遍历所有键值对，fn 返回 false 时停止；遍历期间不能修改 dict
+func (dict *Dict) Range(fn func(e *Entry) bool) {
+	for _, ht := range dict.hts {
+		if ht == nil {
+			continue
+		}
+		for _, e := range ht.table {
+			for e != nil {
+				next := e.next
+				if !fn(e) {
+					return
+				}
+				e = next
+			}
+		}
+	}
+}
+
 func (dict *Dict) Get(key *RedisObj) *RedisObj {
 	entry := dict.Find(key)
 	if entry == nil {
@@ -6,6 +6,14 @@ type Node struct {
 	prev *Node
 }
 
+func (n *Node) Next() *Node {
+	return n.next
+}
+
+func (n *Node) Prev() *Node {
+	return n.prev
+}
+
 type ListType struct {
 	EqualFunc func(a, b *RedisObj) bool
 }
@@ -62,6 +70,26 @@ func (list *List) LPush(val *RedisObj) {
 	list.Length += 1
 }
 
+// Index 下标从 0 开始，负数从尾部开始计数
+func (list *List) Index(index int) *Node {
+	var n *Node
+	if index < 0 {
+		index = -index - 1
+		n = list.Tail
+		for n != nil && index > 0 {
+			n = n.prev
+			index--
+		}
+	} else {
+		n = list.Head
+		for n != nil && index > 0 {
+			n = n.next
+			index--
+		}
+	}
+	return n
+}
+
 func (list *List) DelNode(n *Node) {
 	if n == nil {
 		return
@@ -94,3 +122,13 @@ func (list *List) DelNode(n *Node) {
 func (list *List) Delete(val *RedisObj) {
 	list.DelNode(list.Find(val))
 }
+
+// Release 断开所有节点，供后台释放使用
+func (list *List) Release() {
+	for n := list.Head; n != nil; {
+		next := n.next
+		n.Val, n.prev, n.next = nil, nil, nil
+		n = next
+	}
+	list.Head, list.Tail, list.Length = nil, nil, 0
+}
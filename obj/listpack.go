@@ -0,0 +1,284 @@
+package obj
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// listpack 与 Redis 一致的紧凑编码：
+// <total-bytes uint32><num-elements uint16><element ...><0xFF>
+// 每个元素为 <encoding><data><backlen>，backlen 用于从后往前遍历
+const (
+	LP_HDR_SIZE = 6
+	LP_EOF      = 0xFF
+
+	LP_ENCODING_7BIT_UINT  = 0x00
+	LP_ENCODING_6BIT_STR   = 0x80
+	LP_ENCODING_13BIT_INT  = 0xC0
+	LP_ENCODING_12BIT_STR  = 0xE0
+	LP_ENCODING_16BIT_INT  = 0xF1
+	LP_ENCODING_24BIT_INT  = 0xF2
+	LP_ENCODING_32BIT_INT  = 0xF3
+	LP_ENCODING_64BIT_INT  = 0xF4
+	LP_ENCODING_32BIT_STR  = 0xF0
+	LP_NUMELE_UNKNOWN      = 65535
+	lpMaxStringIntegerSize = 20
+)
+
+var ErrListpackFormat = errors.New("invalid listpack")
+
+func LpNew() []byte {
+	lp := make([]byte, LP_HDR_SIZE+1)
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	lp[LP_HDR_SIZE] = LP_EOF
+	return lp
+}
+
+func lpEncodeBacklen(l int) []byte {
+	switch {
+	case l <= 127:
+		return []byte{byte(l)}
+	case l < 16383:
+		return []byte{byte(l >> 7), byte(l&127) | 128}
+	case l < 2097151:
+		return []byte{byte(l >> 14), byte((l>>7)&127) | 128, byte(l&127) | 128}
+	case l < 268435455:
+		return []byte{byte(l >> 21), byte((l>>14)&127) | 128, byte((l>>7)&127) | 128, byte(l&127) | 128}
+	default:
+		return []byte{byte(l >> 28), byte((l>>21)&127) | 128, byte((l>>14)&127) | 128,
+			byte((l>>7)&127) | 128, byte(l&127) | 128}
+	}
+}
+
+func lpEncodeInt(v int64) []byte {
+	switch {
+	case v >= 0 && v <= 127:
+		return []byte{byte(v)}
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		return []byte{byte(u>>8) | LP_ENCODING_13BIT_INT, byte(u)}
+	case v >= -32768 && v <= 32767:
+		buf := []byte{LP_ENCODING_16BIT_INT, 0, 0}
+		binary.LittleEndian.PutUint16(buf[1:], uint16(v))
+		return buf
+	case v >= -8388608 && v <= 8388607:
+		u := uint32(v)
+		return []byte{LP_ENCODING_24BIT_INT, byte(u), byte(u >> 8), byte(u >> 16)}
+	case v >= -2147483648 && v <= 2147483647:
+		buf := []byte{LP_ENCODING_32BIT_INT, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(buf[1:], uint32(v))
+		return buf
+	default:
+		buf := make([]byte, 9)
+		buf[0] = LP_ENCODING_64BIT_INT
+		binary.LittleEndian.PutUint64(buf[1:], uint64(v))
+		return buf
+	}
+}
+
+func lpEncodeString(s string) []byte {
+	l := len(s)
+	var buf []byte
+	switch {
+	case l < 64:
+		buf = append([]byte{LP_ENCODING_6BIT_STR | byte(l)}, s...)
+	case l < 4096:
+		buf = append([]byte{LP_ENCODING_12BIT_STR | byte(l>>8), byte(l)}, s...)
+	default:
+		buf = make([]byte, 5, 5+l)
+		buf[0] = LP_ENCODING_32BIT_STR
+		binary.LittleEndian.PutUint32(buf[1:], uint32(l))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+// lpStringToInt64 与 Redis 一致，只有规范形式的整数字符串才编码为整数
+func lpStringToInt64(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > lpMaxStringIntegerSize {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+func lpAppendRaw(lp []byte, enc []byte) []byte {
+	lp = lp[:len(lp)-1]
+	lp = append(lp, enc...)
+	lp = append(lp, lpEncodeBacklen(len(enc))...)
+	lp = append(lp, LP_EOF)
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	num := binary.LittleEndian.Uint16(lp[4:])
+	if num != LP_NUMELE_UNKNOWN {
+		num++
+		binary.LittleEndian.PutUint16(lp[4:], num)
+	}
+	return lp
+}
+
+// LpAppend 追加字符串元素，可以表示为整数时按整数编码
+func LpAppend(lp []byte, s string) []byte {
+	if v, ok := lpStringToInt64(s); ok {
+		return lpAppendRaw(lp, lpEncodeInt(v))
+	}
+	return lpAppendRaw(lp, lpEncodeString(s))
+}
+
+func LpAppendInteger(lp []byte, v int64) []byte {
+	return lpAppendRaw(lp, lpEncodeInt(v))
+}
+
+// LpBytes listpack 的总字节数
+func LpBytes(lp []byte) int {
+	return int(binary.LittleEndian.Uint32(lp))
+}
+
+func signExtend(u uint64, bits uint) int64 {
+	shift := 64 - bits
+	return int64(u<<shift) >> shift
+}
+
+// lpDecode 解析 p 处的元素，返回字符串值与元素总长度（包含 backlen）
+func lpDecode(p []byte) (string, int, error) {
+	if len(p) == 0 {
+		return "", 0, ErrListpackFormat
+	}
+	var s string
+	var enclen int
+	b := p[0]
+	need := func(n int) bool { return len(p) >= n }
+	switch {
+	case b&0x80 == LP_ENCODING_7BIT_UINT:
+		s, enclen = strconv.FormatInt(int64(b&0x7f), 10), 1
+	case b&0xC0 == LP_ENCODING_6BIT_STR:
+		l := int(b & 0x3f)
+		if !need(1 + l) {
+			return "", 0, ErrListpackFormat
+		}
+		s, enclen = string(p[1:1+l]), 1+l
+	case b&0xE0 == LP_ENCODING_13BIT_INT:
+		if !need(2) {
+			return "", 0, ErrListpackFormat
+		}
+		u := uint64(b&0x1f)<<8 | uint64(p[1])
+		s, enclen = strconv.FormatInt(signExtend(u, 13), 10), 2
+	case b&0xF0 == LP_ENCODING_12BIT_STR:
+		if !need(2) {
+			return "", 0, ErrListpackFormat
+		}
+		l := int(b&0x0f)<<8 | int(p[1])
+		if !need(2 + l) {
+			return "", 0, ErrListpackFormat
+		}
+		s, enclen = string(p[2:2+l]), 2+l
+	case b == LP_ENCODING_16BIT_INT:
+		if !need(3) {
+			return "", 0, ErrListpackFormat
+		}
+		s, enclen = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(p[1:]))), 10), 3
+	case b == LP_ENCODING_24BIT_INT:
+		if !need(4) {
+			return "", 0, ErrListpackFormat
+		}
+		u := uint64(p[1]) | uint64(p[2])<<8 | uint64(p[3])<<16
+		s, enclen = strconv.FormatInt(signExtend(u, 24), 10), 4
+	case b == LP_ENCODING_32BIT_INT:
+		if !need(5) {
+			return "", 0, ErrListpackFormat
+		}
+		s, enclen = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(p[1:]))), 10), 5
+	case b == LP_ENCODING_64BIT_INT:
+		if !need(9) {
+			return "", 0, ErrListpackFormat
+		}
+		s, enclen = strconv.FormatInt(int64(binary.LittleEndian.Uint64(p[1:])), 10), 9
+	case b == LP_ENCODING_32BIT_STR:
+		if !need(5) {
+			return "", 0, ErrListpackFormat
+		}
+		l := int(binary.LittleEndian.Uint32(p[1:]))
+		if l < 0 || !need(5+l) {
+			return "", 0, ErrListpackFormat
+		}
+		s, enclen = string(p[5:5+l]), 5+l
+	default:
+		return "", 0, ErrListpackFormat
+	}
+	total := enclen + len(lpEncodeBacklen(enclen))
+	if !need(total) {
+		return "", 0, ErrListpackFormat
+	}
+	return s, total, nil
+}
+
+// LpElements 按顺序返回所有元素
+func LpElements(lp []byte) ([]string, error) {
+	if len(lp) < LP_HDR_SIZE+1 || LpBytes(lp) != len(lp) || lp[len(lp)-1] != LP_EOF {
+		return nil, ErrListpackFormat
+	}
+	var elems []string
+	p := lp[LP_HDR_SIZE : len(lp)-1]
+	for len(p) > 0 {
+		s, n, err := lpDecode(p)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, s)
+		p = p[n:]
+	}
+	return elems, nil
+}
+
+// LpFirst 第一个元素的偏移，listpack 为空时返回 -1
+func LpFirst(lp []byte) int {
+	if lp[LP_HDR_SIZE] == LP_EOF {
+		return -1
+	}
+	return LP_HDR_SIZE
+}
+
+// LpNext p 之后元素的偏移，没有更多元素时返回 -1
+func LpNext(lp []byte, p int) int {
+	_, n, err := lpDecode(lp[p:])
+	if err != nil {
+		panic(err)
+	}
+	p += n
+	if lp[p] == LP_EOF {
+		return -1
+	}
+	return p
+}
+
+func LpGet(lp []byte, p int) string {
+	s, _, err := lpDecode(lp[p:])
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func LpGetInteger(lp []byte, p int) int64 {
+	v, _ := strconv.ParseInt(LpGet(lp, p), 10, 64)
+	return v
+}
+
+// LpReplaceInteger 替换 p 处的元素，返回新的 listpack，p 处仍是被替换的元素
+func LpReplaceInteger(lp []byte, p int, v int64) []byte {
+	_, n, err := lpDecode(lp[p:])
+	if err != nil {
+		panic(err)
+	}
+	enc := lpEncodeInt(v)
+	enc = append(enc, lpEncodeBacklen(len(enc))...)
+	out := make([]byte, 0, len(lp)-n+len(enc))
+	out = append(out, lp[:p]...)
+	out = append(out, enc...)
+	out = append(out, lp[p+n:]...)
+	binary.LittleEndian.PutUint32(out, uint32(len(out)))
+	return out
+}
@@ -5,9 +5,11 @@ import "strconv"
 type RedisType uint8
 
 const (
-	STR  RedisType = 0x00
-	LIST RedisType = 0x01
-	DICT RedisType = 0x02
+	STR    RedisType = 0x00
+	LIST   RedisType = 0x01
+	DICT   RedisType = 0x02
+	ZSET   RedisType = 0x03
+	STREAM RedisType = 0x06
 )
 
 type RedisVal interface{}
@@ -15,6 +17,10 @@ type RedisVal interface{}
 type RedisObj struct {
 	Type RedisType
 	Val  RedisVal
+	// LRU 时间（秒级时钟的低 24 位），或者 LFU 数据（高 16 位为分钟级时间，低 8 位为对数计数器）
+	Lru uint32
+	// 作为值保存在数据库中时，所在键的内存占用估算，由数据库维护
+	Mem int64
 }
 
 func (o *RedisObj) IntVal() int64 {
@@ -45,3 +51,92 @@ func CreateObject(typ RedisType, ptr interface{}) *RedisObj {
 		Val:  ptr,
 	}
 }
+
+// FreeObject 拆除集合类型的内部结构，对象之后不能再使用
+func FreeObject(o *RedisObj) {
+	switch o.Type {
+	case LIST:
+		o.Val.(*List).Release()
+	case ZSET:
+		o.Val.(*ZSet).Release()
+	case STREAM:
+		o.Val.(*Stream).Release()
+	}
+}
+
+// 内存估算使用的结构大小，以 64 位平台为准
+const (
+	objOverhead      = 40 // RedisObj
+	strOverhead      = 16 // string 头
+	listNodeOverhead = 24
+	zsetNodeOverhead = 80 // 跳表节点与 map 中的项
+	raxNodeOverhead  = 48
+	nackOverhead     = 32
+	consumerOverhead = 64
+)
+
+// ObjectComputeSize 估算对象的内存占用，集合类型只采样 samples 个元素，samples 为 0 时计算所有元素
+func ObjectComputeSize(o *RedisObj, samples int) int64 {
+	size := int64(objOverhead)
+	switch o.Type {
+	case STR:
+		size += strOverhead + int64(len(o.Val.(string)))
+	case LIST:
+		l := o.Val.(*List)
+		var elesize int64
+		n := 0
+		for node := l.Head; node != nil && (samples == 0 || n < samples); node = node.Next() {
+			elesize += listNodeOverhead + ObjectComputeSize(node.Val, 0)
+			n++
+		}
+		if n > 0 {
+			size += elesize / int64(n) * int64(l.Length)
+		}
+	case ZSET:
+		zs := o.Val.(*ZSet)
+		var elesize int64
+		n := 0
+		for node := zs.First(); node != nil && (samples == 0 || n < samples); node = node.Next() {
+			elesize += zsetNodeOverhead + strOverhead + int64(len(node.Member)) + int64(len(node.level))*16
+			n++
+		}
+		if n > 0 {
+			size += elesize / int64(n) * int64(zs.Len())
+		}
+	case STREAM:
+		size += streamComputeSize(o.Val.(*Stream), samples)
+	}
+	return size
+}
+
+func streamComputeSize(s *Stream, samples int) int64 {
+	size := int64(s.Rax.NumNodes()) * raxNodeOverhead
+	var lpsize int64
+	n := 0
+	it := s.Rax.Iterator()
+	it.Seek("^", nil)
+	for (samples == 0 || n < samples) && it.Next() {
+		lpsize += int64(len(it.Val.([]byte)))
+		n++
+	}
+	if n > 0 {
+		size += lpsize / int64(n) * int64(s.Rax.Len())
+	}
+	if s.CGroups == nil {
+		return size
+	}
+	size += int64(s.CGroups.NumNodes()) * raxNodeOverhead
+	cgit := s.CGroups.Iterator()
+	cgit.Seek("^", nil)
+	for n = 0; (samples == 0 || n < samples) && cgit.Next(); n++ {
+		cg := cgit.Val.(*StreamCG)
+		size += int64(cg.PEL.NumNodes())*raxNodeOverhead + int64(cg.PEL.Len())*nackOverhead
+		cit := cg.Consumers.Iterator()
+		cit.Seek("^", nil)
+		for cit.Next() {
+			consumer := cit.Val.(*StreamConsumer)
+			size += consumerOverhead + int64(len(consumer.Name)) + int64(consumer.PEL.NumNodes())*raxNodeOverhead
+		}
+	}
+	return size
+}
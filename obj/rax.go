@@ -0,0 +1,341 @@
+package obj
+
+import "bytes"
+
+// Rax 压缩前缀树，键按字节序有序，用于保存 stream 的节点与 PEL
+type Rax struct {
+	head     *raxNode
+	numele   int
+	numnodes int
+}
+
+type raxNode struct {
+	isKey    bool
+	val      interface{}
+	children []raxEdge // 按 label 首字节排序
+}
+
+type raxEdge struct {
+	label []byte // 压缩的路径
+	child *raxNode
+}
+
+func RaxNew() *Rax {
+	return &Rax{head: &raxNode{}, numnodes: 1}
+}
+
+func (r *Rax) Len() int {
+	return r.numele
+}
+
+func (r *Rax) NumNodes() int {
+	return r.numnodes
+}
+
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// findEdge 返回首字节为 c 的边的位置，不存在时返回应插入的位置
+func (n *raxNode) findEdge(c byte) (int, bool) {
+	lo, hi := 0, len(n.children)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.children[mid].label[0] < c {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(n.children) && n.children[lo].label[0] == c
+}
+
+// Insert 插入或覆盖，新插入时返回 true
+func (r *Rax) Insert(key []byte, val interface{}) bool {
+	n := r.head
+	for len(key) > 0 {
+		i, ok := n.findEdge(key[0])
+		if !ok {
+			child := &raxNode{isKey: true, val: val}
+			n.children = append(n.children, raxEdge{})
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = raxEdge{label: append([]byte(nil), key...), child: child}
+			r.numnodes++
+			r.numele++
+			return true
+		}
+		e := &n.children[i]
+		cp := commonPrefixLen(e.label, key)
+		if cp < len(e.label) {
+			// 拆分压缩的边
+			split := &raxNode{children: []raxEdge{{label: e.label[cp:], child: e.child}}}
+			e.label = e.label[:cp:cp]
+			e.child = split
+			r.numnodes++
+		}
+		n = e.child
+		key = key[cp:]
+	}
+	if n.isKey {
+		n.val = val
+		return false
+	}
+	n.isKey = true
+	n.val = val
+	r.numele++
+	return true
+}
+
+func (r *Rax) Find(key []byte) (interface{}, bool) {
+	n := r.head
+	for len(key) > 0 {
+		i, ok := n.findEdge(key[0])
+		if !ok {
+			return nil, false
+		}
+		e := n.children[i]
+		if !bytes.HasPrefix(key, e.label) {
+			return nil, false
+		}
+		n = e.child
+		key = key[len(e.label):]
+	}
+	if !n.isKey {
+		return nil, false
+	}
+	return n.val, true
+}
+
+// Remove 删除键，并合并只剩一个子节点的中间节点
+func (r *Rax) Remove(key []byte) (interface{}, bool) {
+	type step struct {
+		node *raxNode
+		idx  int
+	}
+	var path []step
+	n := r.head
+	for len(key) > 0 {
+		i, ok := n.findEdge(key[0])
+		if !ok {
+			return nil, false
+		}
+		e := n.children[i]
+		if !bytes.HasPrefix(key, e.label) {
+			return nil, false
+		}
+		path = append(path, step{n, i})
+		n = e.child
+		key = key[len(e.label):]
+	}
+	if !n.isKey {
+		return nil, false
+	}
+	val := n.val
+	n.isKey = false
+	n.val = nil
+	r.numele--
+
+	if len(path) == 0 {
+		return val, true
+	}
+	parent := path[len(path)-1]
+	switch len(n.children) {
+	case 0:
+		// 删除叶子节点
+		p := parent.node
+		p.children = append(p.children[:parent.idx], p.children[parent.idx+1:]...)
+		r.numnodes--
+		// 父节点可能只剩一个子节点，可以与它合并
+		if !p.isKey && len(p.children) == 1 && len(path) > 1 {
+			r.compress(path[len(path)-2].node, path[len(path)-2].idx)
+		}
+	case 1:
+		r.compress(parent.node, parent.idx)
+	}
+	return val, true
+}
+
+// compress 将 p 的第 idx 条边指向的节点与其唯一的子节点合并
+func (r *Rax) compress(p *raxNode, idx int) {
+	e := &p.children[idx]
+	child := e.child
+	if child.isKey || len(child.children) != 1 {
+		return
+	}
+	next := child.children[0]
+	label := make([]byte, 0, len(e.label)+len(next.label))
+	label = append(label, e.label...)
+	label = append(label, next.label...)
+	e.label = label
+	e.child = next.child
+	r.numnodes--
+}
+
+func appendPath(path, label []byte) []byte {
+	p := make([]byte, 0, len(path)+len(label))
+	p = append(p, path...)
+	return append(p, label...)
+}
+
+func raxFirst(n *raxNode, path []byte) ([]byte, interface{}, bool) {
+	for {
+		if n.isKey {
+			return path, n.val, true
+		}
+		if len(n.children) == 0 {
+			return nil, nil, false
+		}
+		path = appendPath(path, n.children[0].label)
+		n = n.children[0].child
+	}
+}
+
+func raxLast(n *raxNode, path []byte) ([]byte, interface{}, bool) {
+	for len(n.children) > 0 {
+		e := n.children[len(n.children)-1]
+		path = appendPath(path, e.label)
+		n = e.child
+	}
+	if n.isKey {
+		return path, n.val, true
+	}
+	return nil, nil, false
+}
+
+// compareEdge 比较边与 key 剩余部分，key 在边中间结束时边更大
+func compareEdge(label, rest []byte) int {
+	l := len(label)
+	if len(rest) < l {
+		l = len(rest)
+	}
+	if cmp := bytes.Compare(label[:l], rest[:l]); cmp != 0 {
+		return cmp
+	}
+	if len(rest) < len(label) {
+		return 1
+	}
+	return 0
+}
+
+// seekGE 子树中大于等于 key 的最小键，strict 时为大于；path 是 key 的前缀
+func raxSeekGE(n *raxNode, path, key []byte, strict bool) ([]byte, interface{}, bool) {
+	rest := key[len(path):]
+	if n.isKey && len(rest) == 0 && !strict {
+		return path, n.val, true
+	}
+	for _, e := range n.children {
+		if len(rest) == 0 {
+			return raxFirst(e.child, appendPath(path, e.label))
+		}
+		switch compareEdge(e.label, rest) {
+		case 1:
+			return raxFirst(e.child, appendPath(path, e.label))
+		case 0:
+			if k, v, ok := raxSeekGE(e.child, appendPath(path, e.label), key, strict); ok {
+				return k, v, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// seekLE 子树中小于等于 key 的最大键，strict 时为小于
+func raxSeekLE(n *raxNode, path, key []byte, strict bool) ([]byte, interface{}, bool) {
+	rest := key[len(path):]
+	if len(rest) > 0 {
+		for i := len(n.children) - 1; i >= 0; i-- {
+			e := n.children[i]
+			switch compareEdge(e.label, rest) {
+			case -1:
+				return raxLast(e.child, appendPath(path, e.label))
+			case 0:
+				if k, v, ok := raxSeekLE(e.child, appendPath(path, e.label), key, strict); ok {
+					return k, v, true
+				}
+			}
+		}
+	}
+	if n.isKey && (len(rest) > 0 || !strict) {
+		return path, n.val, true
+	}
+	return nil, nil, false
+}
+
+// Seek 查找满足条件的键，op 为 ">=" ">" "<=" "<" "^"（第一个） "$"（最后一个）
+func (r *Rax) Seek(op string, key []byte) ([]byte, interface{}, bool) {
+	switch op {
+	case "^":
+		return raxFirst(r.head, nil)
+	case "$":
+		return raxLast(r.head, nil)
+	case ">=":
+		return raxSeekGE(r.head, nil, key, false)
+	case ">":
+		return raxSeekGE(r.head, nil, key, true)
+	case "<=":
+		return raxSeekLE(r.head, nil, key, false)
+	case "<":
+		return raxSeekLE(r.head, nil, key, true)
+	}
+	return nil, nil, false
+}
+
+// RaxIterator 基于键重新查找实现，迭代过程中可以修改 Rax
+type RaxIterator struct {
+	rax    *Rax
+	Key    []byte
+	Val    interface{}
+	seeked bool // Seek 后的第一次 Next/Prev 返回 Seek 到的元素
+	valid  bool
+}
+
+func (r *Rax) Iterator() *RaxIterator {
+	return &RaxIterator{rax: r}
+}
+
+func (it *RaxIterator) Seek(op string, key []byte) bool {
+	it.Key, it.Val, it.valid = it.rax.Seek(op, key)
+	it.seeked = true
+	return it.valid
+}
+
+func (it *RaxIterator) Next() bool {
+	return it.step(">")
+}
+
+func (it *RaxIterator) Prev() bool {
+	return it.step("<")
+}
+
+func (it *RaxIterator) step(op string) bool {
+	if it.seeked {
+		it.seeked = false
+		return it.valid
+	}
+	if !it.valid {
+		return false
+	}
+	it.Key, it.Val, it.valid = it.rax.Seek(op, it.Key)
+	return it.valid
+}
+
+// Free 断开所有节点，freeval 不为 nil 时对每个值调用，供后台释放使用
+func (r *Rax) Free(freeval func(val interface{})) {
+	stack := []*raxNode{r.head}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.isKey && freeval != nil {
+			freeval(n.val)
+		}
+		for _, e := range n.children {
+			stack = append(stack, e.child)
+		}
+		n.val, n.children = nil, nil
+	}
+	r.head, r.numele, r.numnodes = &raxNode{}, 0, 1
+}
@@ -0,0 +1,655 @@
+package obj
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// StreamID 消息 ID，<ms>-<seq>
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var StreamIDMax = StreamID{math.MaxUint64, math.MaxUint64}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) Compare(o StreamID) int {
+	switch {
+	case id.Ms > o.Ms:
+		return 1
+	case id.Ms < o.Ms:
+		return -1
+	case id.Seq > o.Seq:
+		return 1
+	case id.Seq < o.Seq:
+		return -1
+	}
+	return 0
+}
+
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Incr 下一个 ID，溢出时返回 false
+func (id StreamID) Incr() (StreamID, bool) {
+	if id.Seq == math.MaxUint64 {
+		if id.Ms == math.MaxUint64 {
+			return id, false
+		}
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return StreamID{id.Ms, id.Seq + 1}, true
+}
+
+// Decr 上一个 ID，溢出时返回 false
+func (id StreamID) Decr() (StreamID, bool) {
+	if id.Seq == 0 {
+		if id.Ms == 0 {
+			return id, false
+		}
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return StreamID{id.Ms, id.Seq - 1}, true
+}
+
+// Encode 大端编码，保证 Rax 中的字节序与 ID 顺序一致
+func (id StreamID) Encode() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+func DecodeStreamID(buf []byte) StreamID {
+	return StreamID{binary.BigEndian.Uint64(buf), binary.BigEndian.Uint64(buf[8:])}
+}
+
+// 消息标识
+const (
+	STREAM_ITEM_FLAG_NONE       = 0
+	STREAM_ITEM_FLAG_DELETED    = 1 << 0 // 已删除
+	STREAM_ITEM_FLAG_SAMEFIELDS = 1 << 1 // 与主条目的字段相同，只保存值
+)
+
+const SCG_INVALID_ENTRIES_READ int64 = -1
+
+// Stream 以 Rax 保存 listpack 节点，键为节点中第一条消息的 ID（主 ID）。
+// 节点布局与 Redis 一致：
+// 主条目 count deleted num-fields field_1 ... field_N 0
+// 消息   flags ms-diff seq-diff [num-fields field value ... | value ...] lp-count
+type Stream struct {
+	Rax               *Rax
+	Length            uint64
+	LastId            StreamID
+	FirstId           StreamID
+	MaxDeletedEntryId StreamID
+	EntriesAdded      uint64 // 曾经添加过的消息数
+	CGroups           *Rax   // 消费组名 -> *StreamCG，没有消费组时为 nil
+}
+
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string // field value 交替排列
+}
+
+// StreamCG 消费组
+type StreamCG struct {
+	LastId      StreamID
+	EntriesRead int64 // 已读取的消息数，未知时为 SCG_INVALID_ENTRIES_READ
+	PEL         *Rax  // 已投递未确认的消息 ID -> *StreamNACK
+	Consumers   *Rax  // 消费者名 -> *StreamConsumer
+}
+
+type StreamNACK struct {
+	DeliveryTime  int64
+	DeliveryCount uint64
+	Consumer      *StreamConsumer
+}
+
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64 // 最近一次尝试交互的时间
+	ActiveTime int64 // 最近一次成功交互的时间，-1 表示没有
+	PEL        *Rax  // 与消费组 PEL 共享 *StreamNACK
+}
+
+func StreamCreate() *Stream {
+	return &Stream{Rax: RaxNew()}
+}
+
+// 解码后的节点
+type streamNode struct {
+	master       StreamID
+	count        int64
+	deleted      int64
+	masterFields []string
+	entries      []streamNodeEntry
+}
+
+type streamNodeEntry struct {
+	flags  int64
+	flagsp int // flags 在 listpack 中的偏移
+	id     StreamID
+	fields []string
+}
+
+var ErrStreamNodeFormat = errors.New("invalid stream node")
+
+func decodeStreamNode(master StreamID, lp []byte) (*streamNode, error) {
+	elems, err := LpElements(lp)
+	if err != nil {
+		return nil, err
+	}
+	// 记录每个元素的偏移，用于原地修改 flags
+	offsets := make([]int, 0, len(elems))
+	for p := LpFirst(lp); p != -1; p = LpNext(lp, p) {
+		offsets = append(offsets, p)
+	}
+	ints := func(i int) (int64, bool) {
+		v, ok := lpStringToInt64(elems[i])
+		return v, ok
+	}
+	node := &streamNode{master: master}
+	if len(elems) < 4 {
+		return nil, ErrStreamNodeFormat
+	}
+	var ok1, ok2, ok3 bool
+	var numfields int64
+	node.count, ok1 = ints(0)
+	node.deleted, ok2 = ints(1)
+	numfields, ok3 = ints(2)
+	if !ok1 || !ok2 || !ok3 || numfields < 0 || int64(len(elems)) < 4+numfields {
+		return nil, ErrStreamNodeFormat
+	}
+	node.masterFields = elems[3 : 3+numfields]
+	i := int(3 + numfields)
+	if elems[i] != "0" {
+		return nil, ErrStreamNodeFormat
+	}
+	i++
+	for i < len(elems) {
+		if i+3 > len(elems) {
+			return nil, ErrStreamNodeFormat
+		}
+		flags, ok1 := ints(i)
+		msDiff, ok2 := ints(i + 1)
+		seqDiff, ok3 := ints(i + 2)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, ErrStreamNodeFormat
+		}
+		e := streamNodeEntry{
+			flags:  flags,
+			flagsp: offsets[i],
+			id:     StreamID{master.Ms + uint64(msDiff), master.Seq + uint64(seqDiff)},
+		}
+		i += 3
+		if flags&STREAM_ITEM_FLAG_SAMEFIELDS != 0 {
+			if i+len(node.masterFields) > len(elems) {
+				return nil, ErrStreamNodeFormat
+			}
+			e.fields = make([]string, 0, len(node.masterFields)*2)
+			for j, f := range node.masterFields {
+				e.fields = append(e.fields, f, elems[i+j])
+			}
+			i += len(node.masterFields)
+		} else {
+			if i >= len(elems) {
+				return nil, ErrStreamNodeFormat
+			}
+			n, ok := ints(i)
+			if !ok || n < 0 || int64(len(elems)-i-1) < n*2 {
+				return nil, ErrStreamNodeFormat
+			}
+			e.fields = elems[i+1 : i+1+int(n*2)]
+			i += 1 + int(n*2)
+		}
+		// 跳过 lp-count
+		if i >= len(elems) {
+			return nil, ErrStreamNodeFormat
+		}
+		i++
+		node.entries = append(node.entries, e)
+	}
+	if len(node.entries) == 0 || int64(len(node.entries)) != node.count+node.deleted {
+		return nil, ErrStreamNodeFormat
+	}
+	return node, nil
+}
+
+func mustDecodeStreamNode(key []byte, val interface{}) *streamNode {
+	node, err := decodeStreamNode(DecodeStreamID(key), val.([]byte))
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// ValidateStreamNode 检查从 rdb 加载的节点
+func ValidateStreamNode(key, lp []byte) error {
+	if len(key) != 16 {
+		return ErrStreamNodeFormat
+	}
+	_, err := decodeStreamNode(DecodeStreamID(key), lp)
+	return err
+}
+
+// Append 追加消息，id 必须大于 LastId，节点超过 maxBytes 或 maxEntries 时创建新节点
+func (s *Stream) Append(id StreamID, fields []string, maxBytes, maxEntries int64) {
+	var lp []byte
+	var master StreamID
+	totelelen := 0
+	for _, f := range fields {
+		totelelen += len(f)
+	}
+	if key, val, ok := s.Rax.Seek("$", nil); ok {
+		lp = val.([]byte)
+		master = DecodeStreamID(key)
+		if maxBytes > 0 && int64(LpBytes(lp)+totelelen) >= maxBytes {
+			lp = nil
+		} else if maxEntries > 0 {
+			p := LpFirst(lp)
+			count := LpGetInteger(lp, p)
+			deleted := LpGetInteger(lp, LpNext(lp, p))
+			if count+deleted >= maxEntries {
+				lp = nil
+			}
+		}
+	}
+
+	numfields := len(fields) / 2
+	flags := int64(STREAM_ITEM_FLAG_NONE)
+	if lp == nil {
+		master = id
+		lp = LpNew()
+		lp = LpAppendInteger(lp, 1)
+		lp = LpAppendInteger(lp, 0)
+		lp = LpAppendInteger(lp, int64(numfields))
+		for i := 0; i < numfields; i++ {
+			lp = LpAppend(lp, fields[i*2])
+		}
+		lp = LpAppendInteger(lp, 0)
+		flags |= STREAM_ITEM_FLAG_SAMEFIELDS
+	} else {
+		p := LpFirst(lp)
+		lp = LpReplaceInteger(lp, p, LpGetInteger(lp, p)+1)
+		p = LpNext(lp, LpNext(lp, p))
+		if LpGetInteger(lp, p) == int64(numfields) {
+			same := true
+			for i := 0; i < numfields; i++ {
+				p = LpNext(lp, p)
+				if LpGet(lp, p) != fields[i*2] {
+					same = false
+					break
+				}
+			}
+			if same {
+				flags |= STREAM_ITEM_FLAG_SAMEFIELDS
+			}
+		}
+	}
+
+	lp = LpAppendInteger(lp, flags)
+	lp = LpAppendInteger(lp, int64(id.Ms-master.Ms))
+	lp = LpAppendInteger(lp, int64(id.Seq-master.Seq))
+	if flags&STREAM_ITEM_FLAG_SAMEFIELDS != 0 {
+		for i := 0; i < numfields; i++ {
+			lp = LpAppend(lp, fields[i*2+1])
+		}
+		lp = LpAppendInteger(lp, int64(numfields+3))
+	} else {
+		lp = LpAppendInteger(lp, int64(numfields))
+		for _, f := range fields {
+			lp = LpAppend(lp, f)
+		}
+		lp = LpAppendInteger(lp, int64(numfields*2+4))
+	}
+	s.Rax.Insert(master.Encode(), lp)
+
+	s.Length++
+	s.EntriesAdded++
+	s.LastId = id
+	if s.Length == 1 {
+		s.FirstId = id
+	}
+}
+
+// Range 遍历 [start, end] 内未删除的消息，rev 时逆序，fn 返回 false 时停止
+func (s *Stream) Range(start, end StreamID, rev bool, fn func(e *StreamEntry) bool) {
+	if s.Length == 0 || start.Compare(end) > 0 {
+		return
+	}
+	it := s.Rax.Iterator()
+	if !rev {
+		// 从包含 start 的节点开始
+		if !it.Seek("<=", start.Encode()) {
+			it.Seek("^", nil)
+		}
+		for it.Next() {
+			node := mustDecodeStreamNode(it.Key, it.Val)
+			if node.master.Compare(end) > 0 {
+				return
+			}
+			for _, e := range node.entries {
+				if e.flags&STREAM_ITEM_FLAG_DELETED != 0 || e.id.Compare(start) < 0 {
+					continue
+				}
+				if e.id.Compare(end) > 0 {
+					return
+				}
+				if !fn(&StreamEntry{ID: e.id, Fields: e.fields}) {
+					return
+				}
+			}
+		}
+		return
+	}
+	if !it.Seek("<=", end.Encode()) {
+		return
+	}
+	for it.Prev() {
+		node := mustDecodeStreamNode(it.Key, it.Val)
+		for i := len(node.entries) - 1; i >= 0; i-- {
+			e := node.entries[i]
+			if e.flags&STREAM_ITEM_FLAG_DELETED != 0 || e.id.Compare(end) > 0 {
+				continue
+			}
+			if e.id.Compare(start) < 0 {
+				return
+			}
+			if !fn(&StreamEntry{ID: e.id, Fields: e.fields}) {
+				return
+			}
+		}
+		if node.master.Compare(start) <= 0 {
+			return
+		}
+	}
+}
+
+// Lookup 查找未删除的消息
+func (s *Stream) Lookup(id StreamID) *StreamEntry {
+	var found *StreamEntry
+	s.Range(id, id, false, func(e *StreamEntry) bool {
+		found = e
+		return false
+	})
+	return found
+}
+
+func (s *Stream) EntryExists(id StreamID) bool {
+	return s.Lookup(id) != nil
+}
+
+// FirstEntryID 第一条未删除消息的 ID
+func (s *Stream) FirstEntryID() (StreamID, bool) {
+	e := s.edgeEntry(false)
+	if e == nil {
+		return StreamID{}, false
+	}
+	return e.ID, true
+}
+
+// LastEntryID 最后一条未删除消息的 ID，可能小于 LastId
+func (s *Stream) LastEntryID() (StreamID, bool) {
+	e := s.edgeEntry(true)
+	if e == nil {
+		return StreamID{}, false
+	}
+	return e.ID, true
+}
+
+func (s *Stream) edgeEntry(last bool) *StreamEntry {
+	var found *StreamEntry
+	s.Range(StreamID{}, StreamIDMax, last, func(e *StreamEntry) bool {
+		found = e
+		return false
+	})
+	return found
+}
+
+// updateFirstId 删除消息后更新 FirstId
+func (s *Stream) updateFirstId() {
+	if s.Length == 0 {
+		s.FirstId = StreamID{}
+		return
+	}
+	s.FirstId, _ = s.FirstEntryID()
+}
+
+// Delete 删除消息，节点中的消息全部删除后移除节点
+func (s *Stream) Delete(id StreamID) bool {
+	key, val, ok := s.Rax.Seek("<=", id.Encode())
+	if !ok {
+		return false
+	}
+	lp := val.([]byte)
+	node := mustDecodeStreamNode(key, lp)
+	for _, e := range node.entries {
+		if e.id != id || e.flags&STREAM_ITEM_FLAG_DELETED != 0 {
+			continue
+		}
+		lp = LpReplaceInteger(lp, e.flagsp, e.flags|STREAM_ITEM_FLAG_DELETED)
+		if node.count == 1 {
+			s.Rax.Remove(key)
+		} else {
+			p := LpFirst(lp)
+			lp = LpReplaceInteger(lp, p, node.count-1)
+			lp = LpReplaceInteger(lp, LpNext(lp, p), node.deleted+1)
+			s.Rax.Insert(key, lp)
+		}
+		s.Length--
+		if id.Compare(s.MaxDeletedEntryId) > 0 {
+			s.MaxDeletedEntryId = id
+		}
+		if id == s.FirstId {
+			s.updateFirstId()
+		}
+		return true
+	}
+	return false
+}
+
+// 裁剪策略
+const (
+	TRIM_STRATEGY_NONE = iota
+	TRIM_STRATEGY_MAXLEN
+	TRIM_STRATEGY_MINID
+)
+
+type StreamTrimArgs struct {
+	Strategy int
+	Approx   bool  // 只删除整个节点
+	MaxLen   int64 // MAXLEN
+	MinID    StreamID
+	Limit    int64 // 最多删除的消息数，0 表示不限制
+}
+
+// Trim 按 MAXLEN 或 MINID 裁剪，返回删除的消息数
+func (s *Stream) Trim(args *StreamTrimArgs) int64 {
+	if args.Strategy == TRIM_STRATEGY_NONE {
+		return 0
+	}
+	var deleted int64
+	it := s.Rax.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		if args.Strategy == TRIM_STRATEGY_MAXLEN && int64(s.Length) <= args.MaxLen {
+			break
+		}
+		lp := it.Val.([]byte)
+		node := mustDecodeStreamNode(it.Key, lp)
+		entries := node.count
+		if args.Limit > 0 && deleted+entries > args.Limit {
+			break
+		}
+
+		var removeNode bool
+		if args.Strategy == TRIM_STRATEGY_MAXLEN {
+			removeNode = int64(s.Length)-entries >= args.MaxLen
+		} else {
+			lastId := node.entries[len(node.entries)-1].id
+			removeNode = lastId.Compare(args.MinID) < 0
+		}
+		if removeNode {
+			key := it.Key
+			s.Rax.Remove(key)
+			it.Seek(">=", key)
+			s.Length -= uint64(entries)
+			deleted += entries
+			continue
+		}
+		// 近似裁剪不删除节点内的消息
+		if args.Approx {
+			break
+		}
+
+		var deletedFromLp int64
+		for _, e := range node.entries {
+			var stop bool
+			if args.Strategy == TRIM_STRATEGY_MAXLEN {
+				stop = int64(s.Length) <= args.MaxLen
+			} else {
+				stop = e.id.Compare(args.MinID) >= 0
+			}
+			if stop {
+				break
+			}
+			if e.flags&STREAM_ITEM_FLAG_DELETED == 0 {
+				// flags 的编码长度不变，后续偏移仍然有效
+				lp = LpReplaceInteger(lp, e.flagsp, e.flags|STREAM_ITEM_FLAG_DELETED)
+				deletedFromLp++
+				s.Length--
+			}
+		}
+		deleted += deletedFromLp
+		p := LpFirst(lp)
+		lp = LpReplaceInteger(lp, p, entries-deletedFromLp)
+		lp = LpReplaceInteger(lp, LpNext(lp, p), node.deleted+deletedFromLp)
+		s.Rax.Insert(it.Key, lp)
+		break
+	}
+	if deleted > 0 {
+		s.updateFirstId()
+	}
+	return deleted
+}
+
+// EstimateDistanceFromFirstEverEntry 估算 id 之前（含）添加过的消息数，无法确定时返回 SCG_INVALID_ENTRIES_READ
+func (s *Stream) EstimateDistanceFromFirstEverEntry(id StreamID) int64 {
+	if s.EntriesAdded == 0 {
+		return 0
+	}
+	if s.Length == 0 && id.Compare(s.LastId) < 1 {
+		return int64(s.EntriesAdded)
+	}
+	cmpLast := id.Compare(s.LastId)
+	if cmpLast == 0 {
+		return int64(s.EntriesAdded)
+	} else if cmpLast > 0 {
+		return SCG_INVALID_ENTRIES_READ
+	}
+	cmpIdFirst := id.Compare(s.FirstId)
+	if s.MaxDeletedEntryId.IsZero() || s.MaxDeletedEntryId.Compare(s.FirstId) < 0 {
+		// 没有被删除的空洞
+		if cmpIdFirst < 0 {
+			return int64(s.EntriesAdded - s.Length)
+		} else if cmpIdFirst == 0 {
+			return int64(s.EntriesAdded - s.Length + 1)
+		}
+	}
+	return SCG_INVALID_ENTRIES_READ
+}
+
+// RangeHasTombstones [start, end] 内是否可能有被删除的消息
+func (s *Stream) RangeHasTombstones(start, end StreamID) bool {
+	if s.Length == 0 || s.MaxDeletedEntryId.IsZero() {
+		return false
+	}
+	return start.Compare(s.MaxDeletedEntryId) <= 0 && end.Compare(s.MaxDeletedEntryId) >= 0
+}
+
+// CreateCG 创建消费组，已存在时返回 nil
+func (s *Stream) CreateCG(name string, id StreamID, entriesRead int64) *StreamCG {
+	if s.CGroups == nil {
+		s.CGroups = RaxNew()
+	}
+	if _, ok := s.CGroups.Find([]byte(name)); ok {
+		return nil
+	}
+	cg := &StreamCG{
+		LastId:      id,
+		EntriesRead: entriesRead,
+		PEL:         RaxNew(),
+		Consumers:   RaxNew(),
+	}
+	s.CGroups.Insert([]byte(name), cg)
+	return cg
+}
+
+func (s *Stream) LookupCG(name string) *StreamCG {
+	if s.CGroups == nil {
+		return nil
+	}
+	if cg, ok := s.CGroups.Find([]byte(name)); ok {
+		return cg.(*StreamCG)
+	}
+	return nil
+}
+
+func (s *Stream) DestroyCG(name string) bool {
+	if s.CGroups == nil {
+		return false
+	}
+	_, ok := s.CGroups.Remove([]byte(name))
+	return ok
+}
+
+func (cg *StreamCG) LookupConsumer(name string) *StreamConsumer {
+	if c, ok := cg.Consumers.Find([]byte(name)); ok {
+		return c.(*StreamConsumer)
+	}
+	return nil
+}
+
+// CreateConsumer 创建消费者，已存在时返回 nil
+func (cg *StreamCG) CreateConsumer(name string, now int64) *StreamConsumer {
+	if cg.LookupConsumer(name) != nil {
+		return nil
+	}
+	consumer := &StreamConsumer{Name: name, SeenTime: now, ActiveTime: -1, PEL: RaxNew()}
+	cg.Consumers.Insert([]byte(name), consumer)
+	return consumer
+}
+
+// DelConsumer 删除消费者，其未确认的消息也从消费组 PEL 中删除
+func (cg *StreamCG) DelConsumer(consumer *StreamConsumer) {
+	it := consumer.PEL.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		cg.PEL.Remove(it.Key)
+	}
+	cg.Consumers.Remove([]byte(consumer.Name))
+}
+
+// Release 释放消息节点与所有消费组，供后台释放使用
+func (s *Stream) Release() {
+	s.Rax.Free(nil)
+	if s.CGroups != nil {
+		s.CGroups.Free(func(val interface{}) {
+			cg := val.(*StreamCG)
+			cg.PEL.Free(nil)
+			cg.Consumers.Free(func(val interface{}) {
+				val.(*StreamConsumer).PEL.Free(nil)
+			})
+		})
+		s.CGroups = nil
+	}
+	s.Length = 0
+}
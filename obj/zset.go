@@ -0,0 +1,262 @@
+package obj
+
+import "math/rand"
+
+const (
+	ZSKIPLIST_MAXLEVEL = 32
+	ZSKIPLIST_P        = 0.25
+)
+
+type zskiplistLevel struct {
+	forward *ZSkiplistNode
+	span    int // 到 forward 跨越的节点数，用于计算排名
+}
+
+type ZSkiplistNode struct {
+	Member   string
+	Score    float64
+	backward *ZSkiplistNode
+	level    []zskiplistLevel
+}
+
+func (n *ZSkiplistNode) Next() *ZSkiplistNode {
+	return n.level[0].forward
+}
+
+func (n *ZSkiplistNode) Prev() *ZSkiplistNode {
+	return n.backward
+}
+
+type zskiplist struct {
+	header *ZSkiplistNode
+	tail   *ZSkiplistNode
+	length int
+	level  int
+}
+
+// ZSet 有序集合，dict 用于按成员查找分值，跳表按分值排序
+type ZSet struct {
+	dict map[string]float64
+	zsl  *zskiplist
+}
+
+func zslCreateNode(level int, score float64, member string) *ZSkiplistNode {
+	return &ZSkiplistNode{
+		Member: member,
+		Score:  score,
+		level:  make([]zskiplistLevel, level),
+	}
+}
+
+func zslCreate() *zskiplist {
+	return &zskiplist{
+		header: zslCreateNode(ZSKIPLIST_MAXLEVEL, 0, ""),
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < ZSKIPLIST_MAXLEVEL && rand.Float64() < ZSKIPLIST_P {
+		level++
+	}
+	return level
+}
+
+// zslLess 先比较分值，分值相同时按成员字典序
+func zslLess(n *ZSkiplistNode, score float64, member string) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+func (zsl *zskiplist) insert(score float64, member string) *ZSkiplistNode {
+	var update [ZSKIPLIST_MAXLEVEL]*ZSkiplistNode
+	var rank [ZSKIPLIST_MAXLEVEL]int
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(x.level[i].forward, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = zslCreateNode(level, score, member)
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *zskiplist) deleteNode(x *ZSkiplistNode, update []*ZSkiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := make([]*ZSkiplistNode, ZSKIPLIST_MAXLEVEL)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(x.level[i].forward, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.Score == score && x.Member == member {
+		zsl.deleteNode(x, update)
+		return true
+	}
+	return false
+}
+
+// getRank 从 1 开始的排名，不存在时返回 0
+func (zsl *zskiplist) getRank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (zslLess(x.level[i].forward, score, member) ||
+			(x.level[i].forward.Score == score && x.level[i].forward.Member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getElementByRank rank 从 1 开始
+func (zsl *zskiplist) getElementByRank(rank int) *ZSkiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+func ZSetCreate() *ZSet {
+	return &ZSet{
+		dict: make(map[string]float64),
+		zsl:  zslCreate(),
+	}
+}
+
+func (zs *ZSet) Len() int {
+	return zs.zsl.length
+}
+
+func (zs *ZSet) Score(member string) (float64, bool) {
+	score, ok := zs.dict[member]
+	return score, ok
+}
+
+// Add 添加成员或更新分值，新增时返回 true
+func (zs *ZSet) Add(member string, score float64) bool {
+	old, ok := zs.dict[member]
+	if ok {
+		if old != score {
+			zs.zsl.delete(old, member)
+			zs.zsl.insert(score, member)
+			zs.dict[member] = score
+		}
+		return false
+	}
+	zs.zsl.insert(score, member)
+	zs.dict[member] = score
+	return true
+}
+
+func (zs *ZSet) Remove(member string) bool {
+	score, ok := zs.dict[member]
+	if !ok {
+		return false
+	}
+	zs.zsl.delete(score, member)
+	delete(zs.dict, member)
+	return true
+}
+
+// Rank 从 0 开始的排名
+func (zs *ZSet) Rank(member string) (int, bool) {
+	score, ok := zs.dict[member]
+	if !ok {
+		return 0, false
+	}
+	return zs.zsl.getRank(score, member) - 1, true
+}
+
+// ByRank rank 从 0 开始
+func (zs *ZSet) ByRank(rank int) *ZSkiplistNode {
+	if rank < 0 || rank >= zs.zsl.length {
+		return nil
+	}
+	return zs.zsl.getElementByRank(rank + 1)
+}
+
+func (zs *ZSet) First() *ZSkiplistNode {
+	return zs.zsl.header.level[0].forward
+}
+
+func (zs *ZSet) Last() *ZSkiplistNode {
+	return zs.zsl.tail
+}
+
+// Release 断开跳表的所有节点并清空 dict，供后台释放使用
+func (zs *ZSet) Release() {
+	for x := zs.zsl.header; x != nil; {
+		next := x.level[0].forward
+		x.backward, x.level = nil, nil
+		x = next
+	}
+	zs.zsl = zslCreate()
+	zs.dict = make(map[string]float64)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"go-redis/obj"
+	"strconv"
+	"strings"
+)
+
+// 与 redis 一致，能放进一次内存分配的短字符串
+const OBJ_ENCODING_EMBSTR_SIZE_LIMIT = 44
+
+// objectEncoding 对象的编码名称，对应 OBJECT ENCODING 的回复
+func objectEncoding(o *obj.RedisObj) string {
+	switch o.Type {
+	case obj.STR:
+		s := o.StrVal()
+		if len(s) <= 20 {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+				return "int"
+			}
+		}
+		if len(s) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+			return "embstr"
+		}
+		return "raw"
+	case obj.LIST:
+		return "linkedlist"
+	case obj.ZSET:
+		return "skiplist"
+	case obj.STREAM:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// objectCommand OBJECT <subcommand> <key>
+func objectCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	if sub == "help" && len(c.args) == 2 {
+		addReplyHelp(c, []string{
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		})
+		return
+	}
+	if len(c.args) != 3 || (sub != "encoding" && sub != "freq" && sub != "idletime" && sub != "refcount") {
+		addReplySubcommandSyntaxError(c)
+		return
+	}
+	// 内省不算访问，不更新 LRU/LFU
+	o := lookupKeyReadNoTouch(c.db, c.args[2])
+	if o == nil {
+		c.AddReplyStr("$-1\r\n")
+		return
+	}
+	switch sub {
+	case "encoding":
+		c.AddReplyBulk(objectEncoding(o))
+	case "refcount":
+		// 值不在键之间共享，引用计数总是 1
+		c.AddReplyInt(1)
+	case "idletime":
+		if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+			c.AddReplyError("An LFU maxmemory policy is selected, idle time not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		c.AddReplyInt(int64(estimateObjectIdleTime(o) / 1000))
+	case "freq":
+		if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU == 0 {
+			c.AddReplyError("An LFU maxmemory policy is not selected, access frequency not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		c.AddReplyInt(int64(lfuDecrAndReturn(o)))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"go-redis/conf"
+	"go-redis/obj"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set i 12345\r\nset e abc\r\nset r "+strings.Repeat("x", 45)+"\r\nrpush l a\r\nzadd z 1 a\r\nxadd s * f v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	ReadQuery(c, "object encoding i\r\nobject encoding e\r\nobject encoding r\r\nobject encoding l\r\n"+
+		"object encoding z\r\nobject encoding s\r\nobject encoding nokey\r\nobject refcount e\r\nobject foo e\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$3\r\nint\r\n$6\r\nembstr\r\n$3\r\nraw\r\n$10\r\nlinkedlist\r\n$8\r\nskiplist\r\n$6\r\nstream\r\n"+
+		"$-1\r\n:1\r\n-ERR unknown subcommand or wrong number of arguments for 'foo'. Try OBJECT HELP.\r\n", allReplies(c))
+}
+
+func TestObjectIdletime(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	server.db[0].data.Get(obj.CreateObject(obj.STR, "k")).Lru = lruClock() - 100
+
+	// OBJECT 本身不算访问，GET 之后空闲时间归零
+	ReadQuery(c, "object idletime k\r\nobject idletime k\r\nget k\r\nobject idletime k\r\nobject freq k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":100\r\n:100\r\n$1\r\nv\r\n:0\r\n-ERR An LFU maxmemory policy is not selected, access frequency not tracked. "+
+		"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n", allReplies(c))
+
+	// 空闲时间在 rdb 中保存，仅在 LRU 策略下
+	server.maxmemoryPolicy = MAXMEMORY_ALLKEYS_LRU
+	server.db[0].data.Get(obj.CreateObject(obj.STR, "k")).Lru = lruClock() - 50
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+	emptyData()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db))
+	ReadQuery(c, "object idletime k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":50\r\n", allReplies(c))
+}
+
+func TestObjectFreq(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.MaxmemoryPolicy = "allkeys-lfu"
+	cfg.LfuLogFactor = 0
+	initServer(cfg)
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v\r\nobject freq k\r\nget k\r\nget k\r\nobject freq k\r\nobject idletime k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	// 因子为 0 时每次访问都计数
+	assert.Equal(t, "+OK\r\n:5\r\n$1\r\nv\r\n$1\r\nv\r\n:7\r\n-ERR An LFU maxmemory policy is selected, idle time not tracked. "+
+		"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n", allReplies(c))
+
+	// 覆盖值保留计数，rdb 保存计数
+	ReadQuery(c, "set k v2\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+	emptyData()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db))
+	ReadQuery(c, "object freq k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, ":7\r\n", allReplies(c))
+}
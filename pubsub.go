@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pubsubSubscriptionCount 客户端订阅的频道与模式总数
+func pubsubSubscriptionCount(c *RedisClient) int {
+	return len(c.pubsubChannels) + len(c.pubsubPatterns)
+}
+
+func addReplyPubsubSubscribed(c *RedisClient, kind, channel string) {
+	c.AddReplyStr(fmt.Sprintf("*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n",
+		len(kind), kind, len(channel), channel, pubsubSubscriptionCount(c)))
+}
+
+// addReplyPubsubUnsubscribed 没有任何订阅时 channel 为 nil
+func addReplyPubsubUnsubscribed(c *RedisClient, kind string, channel *string) {
+	ch := "$-1\r\n"
+	if channel != nil {
+		ch = fmt.Sprintf("$%d\r\n%s\r\n", len(*channel), *channel)
+	}
+	c.AddReplyStr(fmt.Sprintf("*3\r\n$%d\r\n%s\r\n%s:%d\r\n",
+		len(kind), kind, ch, pubsubSubscriptionCount(c)))
+}
+
+func addReplyPubsubMessage(c *RedisClient, channel, msg string) {
+	c.AddReplyStr(fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+		len(channel), channel, len(msg), msg))
+}
+
+func addReplyPubsubPatMessage(c *RedisClient, pattern, channel, msg string) {
+	c.AddReplyStr(fmt.Sprintf("*4\r\n$8\r\npmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+		len(pattern), pattern, len(channel), channel, len(msg), msg))
+}
+
+// updatePubsubFlag 有订阅时客户端进入订阅模式
+func updatePubsubFlag(c *RedisClient) {
+	if pubsubSubscriptionCount(c) > 0 {
+		c.flags |= CLIENT_PUBSUB
+	} else {
+		c.flags &^= CLIENT_PUBSUB
+	}
+}
+
+func removeClient(clients []*RedisClient, c *RedisClient) []*RedisClient {
+	for i, v := range clients {
+		if v == c {
+			return append(clients[:i], clients[i+1:]...)
+		}
+	}
+	return clients
+}
+
+// pubsubSubscribeChannel 已订阅时返回 false
+func pubsubSubscribeChannel(c *RedisClient, channel string) bool {
+	_, ok := c.pubsubChannels[channel]
+	if !ok {
+		c.pubsubChannels[channel] = struct{}{}
+		server.pubsubChannels[channel] = append(server.pubsubChannels[channel], c)
+	}
+	updatePubsubFlag(c)
+	addReplyPubsubSubscribed(c, "subscribe", channel)
+	return !ok
+}
+
+func pubsubUnsubscribeChannel(c *RedisClient, channel string, notify bool) bool {
+	_, ok := c.pubsubChannels[channel]
+	if ok {
+		delete(c.pubsubChannels, channel)
+		clients := removeClient(server.pubsubChannels[channel], c)
+		if len(clients) == 0 {
+			delete(server.pubsubChannels, channel)
+		} else {
+			server.pubsubChannels[channel] = clients
+		}
+	}
+	updatePubsubFlag(c)
+	if notify {
+		addReplyPubsubUnsubscribed(c, "unsubscribe", &channel)
+	}
+	return ok
+}
+
+func pubsubSubscribePattern(c *RedisClient, pattern string) bool {
+	_, ok := c.pubsubPatterns[pattern]
+	if !ok {
+		c.pubsubPatterns[pattern] = struct{}{}
+		server.pubsubPatterns[pattern] = append(server.pubsubPatterns[pattern], c)
+	}
+	updatePubsubFlag(c)
+	addReplyPubsubSubscribed(c, "psubscribe", pattern)
+	return !ok
+}
+
+func pubsubUnsubscribePattern(c *RedisClient, pattern string, notify bool) bool {
+	_, ok := c.pubsubPatterns[pattern]
+	if ok {
+		delete(c.pubsubPatterns, pattern)
+		clients := removeClient(server.pubsubPatterns[pattern], c)
+		if len(clients) == 0 {
+			delete(server.pubsubPatterns, pattern)
+		} else {
+			server.pubsubPatterns[pattern] = clients
+		}
+	}
+	updatePubsubFlag(c)
+	if notify {
+		addReplyPubsubUnsubscribed(c, "punsubscribe", &pattern)
+	}
+	return ok
+}
+
+// pubsubUnsubscribeAllChannels 返回取消订阅的数量
+func pubsubUnsubscribeAllChannels(c *RedisClient, notify bool) int {
+	count := 0
+	for _, channel := range sortedKeys(c.pubsubChannels) {
+		if pubsubUnsubscribeChannel(c, channel, notify) {
+			count++
+		}
+	}
+	if notify && count == 0 {
+		addReplyPubsubUnsubscribed(c, "unsubscribe", nil)
+	}
+	return count
+}
+
+func pubsubUnsubscribeAllPatterns(c *RedisClient, notify bool) int {
+	count := 0
+	for _, pattern := range sortedKeys(c.pubsubPatterns) {
+		if pubsubUnsubscribePattern(c, pattern, notify) {
+			count++
+		}
+	}
+	if notify && count == 0 {
+		addReplyPubsubUnsubscribed(c, "punsubscribe", nil)
+	}
+	return count
+}
+
+// pubsubPublishMessage 返回收到消息的客户端数量
+func pubsubPublishMessage(channel, msg string) int {
+	receivers := 0
+	for _, c := range server.pubsubChannels[channel] {
+		addReplyPubsubMessage(c, channel, msg)
+		receivers++
+	}
+	for pattern, clients := range server.pubsubPatterns {
+		if !stringMatch(pattern, channel, false) {
+			continue
+		}
+		for _, c := range clients {
+			addReplyPubsubPatMessage(c, pattern, channel, msg)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func subscribeCommand(c *RedisClient) {
+	for _, arg := range c.args[1:] {
+		pubsubSubscribeChannel(c, arg.StrVal())
+	}
+}
+
+func unsubscribeCommand(c *RedisClient) {
+	if len(c.args) == 1 {
+		pubsubUnsubscribeAllChannels(c, true)
+		return
+	}
+	for _, arg := range c.args[1:] {
+		pubsubUnsubscribeChannel(c, arg.StrVal(), true)
+	}
+}
+
+func psubscribeCommand(c *RedisClient) {
+	for _, arg := range c.args[1:] {
+		pubsubSubscribePattern(c, arg.StrVal())
+	}
+}
+
+func punsubscribeCommand(c *RedisClient) {
+	if len(c.args) == 1 {
+		pubsubUnsubscribeAllPatterns(c, true)
+		return
+	}
+	for _, arg := range c.args[1:] {
+		pubsubUnsubscribePattern(c, arg.StrVal(), true)
+	}
+}
+
+// publishCommand PUBLISH 不修改数据，但需要传播给从节点
+func publishCommand(c *RedisClient) {
+	receivers := pubsubPublishMessage(c.args[1].StrVal(), c.args[2].StrVal())
+	c.flags |= CLIENT_FORCE_REPL
+	c.AddReplyInt(int64(receivers))
+}
+
+// pubsubCommand PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func pubsubCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "channels" && (len(c.args) == 2 || len(c.args) == 3):
+		channels := make([]string, 0, len(server.pubsubChannels))
+		for channel := range server.pubsubChannels {
+			if len(c.args) == 2 || stringMatch(c.args[2].StrVal(), channel, false) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		c.AddReplyArrayLen(len(channels))
+		for _, channel := range channels {
+			c.AddReplyBulk(channel)
+		}
+	case sub == "numsub":
+		c.AddReplyArrayLen((len(c.args) - 2) * 2)
+		for _, arg := range c.args[2:] {
+			c.AddReplyBulk(arg.StrVal())
+			c.AddReplyInt(int64(len(server.pubsubChannels[arg.StrVal()])))
+		}
+	case sub == "numpat" && len(c.args) == 2:
+		c.AddReplyInt(int64(len(server.pubsubPatterns)))
+	default:
+		c.AddReplyError(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.", c.args[1].StrVal()))
+	}
+}
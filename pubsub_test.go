@@ -0,0 +1,74 @@
+package main
+
+import (
+	"go-redis/conf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringMatch(t *testing.T) {
+	assert.True(t, stringMatch("*", "", false))
+	assert.True(t, stringMatch("news.*", "news.tech", false))
+	assert.False(t, stringMatch("news.*", "new.tech", false))
+	assert.True(t, stringMatch("h?llo", "hello", false))
+	assert.True(t, stringMatch("h[ae]llo", "hallo", false))
+	assert.False(t, stringMatch("h[^e]llo", "hello", false))
+	assert.True(t, stringMatch("h[a-f]llo", "hdllo", false))
+	assert.True(t, stringMatch("h\\*llo", "h*llo", false))
+	assert.False(t, stringMatch("h\\*llo", "hello", false))
+	assert.True(t, stringMatch("HE*", "hello", true))
+}
+
+func TestPubsub(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	sub := CreateClient(-1)
+	ReadQuery(sub, "subscribe ch1 ch2\r\npsubscribe ch*\r\nget k\r\nping\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+	assert.NotEqual(t, 0, sub.flags&CLIENT_PUBSUB)
+	assert.Equal(t, 7, sub.reply.Length)
+	sub.reply.DelNode(sub.reply.Head)
+	sub.reply.DelNode(sub.reply.Head)
+	assert.Equal(t, "*3\r\n$10\r\npsubscribe\r\n$3\r\nch*\r\n:3\r\n", sub.reply.Head.Val.StrVal())
+	freeReplyList(sub)
+
+	pub := CreateClient(-2)
+	ReadQuery(pub, "publish ch1 hi\r\npubsub numsub ch1 ch3\r\npubsub numpat\r\n")
+	assert.Nil(t, ProcessQueryBuf(pub))
+	assert.Equal(t, ":2\r\n", pub.reply.Head.Val.StrVal())
+	assert.Equal(t, ":1\r\n", pub.reply.Tail.Val.StrVal())
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$3\r\nch1\r\n$2\r\nhi\r\n", sub.reply.Head.Val.StrVal())
+	assert.Equal(t, "*4\r\n$8\r\npmessage\r\n$3\r\nch*\r\n$3\r\nch1\r\n$2\r\nhi\r\n", sub.reply.Tail.Val.StrVal())
+	freeReplyList(sub)
+
+	ReadQuery(sub, "unsubscribe\r\npunsubscribe\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+	assert.Equal(t, 0, sub.flags&CLIENT_PUBSUB)
+	assert.Equal(t, 0, len(server.pubsubChannels))
+	assert.Equal(t, 0, len(server.pubsubPatterns))
+	assert.Equal(t, "*3\r\n$12\r\npunsubscribe\r\n$3\r\nch*\r\n:0\r\n", sub.reply.Tail.Val.StrVal())
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	flags, err := keyspaceEventsStringToFlags("KEA")
+	assert.Nil(t, err)
+	assert.Equal(t, "AKE", keyspaceEventsFlagsToString(flags))
+	_, err = keyspaceEventsStringToFlags("Kq")
+	assert.NotNil(t, err)
+
+	config := conf.DefaultConfig()
+	config.NotifyKeyspaceEvents = "Kgx"
+	initServer(config)
+	sub := CreateClient(-1)
+	ReadQuery(sub, "psubscribe __key*__:*\r\n")
+	assert.Nil(t, ProcessQueryBuf(sub))
+	freeReplyList(sub)
+
+	c := CreateClient(-2)
+	// set 不属于订阅的事件类型
+	ReadQuery(c, "set k v\r\ndel k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, 1, sub.reply.Length)
+	assert.Equal(t, "*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$16\r\n__keyspace@0__:k\r\n$3\r\ndel\r\n",
+		sub.reply.Head.Val.StrVal())
+}
@@ -0,0 +1,843 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-redis/ae"
+	"go-redis/obj"
+	"hash/crc64"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+const RDB_VERSION int = 11
+
+const (
+	RDB_TYPE_STRING byte = 0
+	RDB_TYPE_LIST   byte = 1
+	RDB_TYPE_ZSET_2 byte = 5 // 分值以二进制 double 保存
+
+	RDB_TYPE_STREAM_LISTPACKS   byte = 15
+	RDB_TYPE_STREAM_LISTPACKS_2 byte = 19 // 增加 first_id、max_deleted_entry_id、entries_added 与 entries_read
+	RDB_TYPE_STREAM_LISTPACKS_3 byte = 21 // 增加消费者的 active_time
+
+	RDB_OPCODE_IDLE          byte = 248
+	RDB_OPCODE_FREQ          byte = 249
+	RDB_OPCODE_AUX           byte = 250
+	RDB_OPCODE_RESIZEDB      byte = 251
+	RDB_OPCODE_EXPIRETIME_MS byte = 252
+	RDB_OPCODE_SELECTDB      byte = 254
+	RDB_OPCODE_EOF           byte = 255
+)
+
+const (
+	RDB_6BITLEN  byte = 0
+	RDB_14BITLEN byte = 1
+	RDB_32BITLEN byte = 0x80
+	RDB_64BITLEN byte = 0x81
+	RDB_ENCVAL   byte = 3
+
+	RDB_ENC_INT8  byte = 0
+	RDB_ENC_INT16 byte = 1
+	RDB_ENC_INT32 byte = 2
+)
+
+var ErrRdbFormat = errors.New("wrong rdb format")
+
+// redis 使用的 crc64 (Jones 多项式，初值 0，无结果异或)
+var crc64Table = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+type rdbWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func (r *rdbWriter) Write(p []byte) (int, error) {
+	r.crc = crc64Update(r.crc, p)
+	return r.w.Write(p)
+}
+
+func (r *rdbWriter) saveType(t byte) error {
+	_, err := r.Write([]byte{t})
+	return err
+}
+
+func (r *rdbWriter) saveLen(l uint64) error {
+	var buf []byte
+	if l < 1<<6 {
+		buf = []byte{byte(l) | RDB_6BITLEN<<6}
+	} else if l < 1<<14 {
+		buf = []byte{byte(l>>8) | RDB_14BITLEN<<6, byte(l)}
+	} else if l <= 0xffffffff {
+		buf = make([]byte, 5)
+		buf[0] = RDB_32BITLEN
+		binary.BigEndian.PutUint32(buf[1:], uint32(l))
+	} else {
+		buf = make([]byte, 9)
+		buf[0] = RDB_64BITLEN
+		binary.BigEndian.PutUint64(buf[1:], l)
+	}
+	_, err := r.Write(buf)
+	return err
+}
+
+func (r *rdbWriter) saveString(s string) error {
+	if err := r.saveLen(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(r, s)
+	return err
+}
+
+func (r *rdbWriter) saveMillisecondTime(ms int64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(ms))
+	_, err := r.Write(buf)
+	return err
+}
+
+func (r *rdbWriter) saveBinaryDouble(f float64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+	_, err := r.Write(buf)
+	return err
+}
+
+func (r *rdbWriter) saveAuxField(key, val string) error {
+	if err := r.saveType(RDB_OPCODE_AUX); err != nil {
+		return err
+	}
+	if err := r.saveString(key); err != nil {
+		return err
+	}
+	return r.saveString(val)
+}
+
+func (r *rdbWriter) saveObjectType(o *obj.RedisObj) error {
+	switch o.Type {
+	case obj.STR:
+		return r.saveType(RDB_TYPE_STRING)
+	case obj.LIST:
+		return r.saveType(RDB_TYPE_LIST)
+	case obj.ZSET:
+		return r.saveType(RDB_TYPE_ZSET_2)
+	case obj.STREAM:
+		return r.saveType(RDB_TYPE_STREAM_LISTPACKS_3)
+	default:
+		return fmt.Errorf("unsupported object type %v", o.Type)
+	}
+}
+
+func (r *rdbWriter) saveObject(o *obj.RedisObj) error {
+	switch o.Type {
+	case obj.STR:
+		return r.saveString(o.StrVal())
+	case obj.LIST:
+		l := o.Val.(*obj.List)
+		if err := r.saveLen(uint64(l.Length)); err != nil {
+			return err
+		}
+		for n := l.Head; n != nil; n = n.Next() {
+			if err := r.saveString(n.Val.StrVal()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case obj.ZSET:
+		zs := o.Val.(*obj.ZSet)
+		if err := r.saveLen(uint64(zs.Len())); err != nil {
+			return err
+		}
+		// 从尾部开始保存，与 Redis 一致
+		for ln := zs.Last(); ln != nil; ln = ln.Prev() {
+			if err := r.saveString(ln.Member); err != nil {
+				return err
+			}
+			if err := r.saveBinaryDouble(ln.Score); err != nil {
+				return err
+			}
+		}
+		return nil
+	case obj.STREAM:
+		return r.saveStream(o.Val.(*obj.Stream))
+	default:
+		return fmt.Errorf("unsupported object type %v", o.Type)
+	}
+}
+
+func (r *rdbWriter) saveStreamID(id obj.StreamID) error {
+	if err := r.saveLen(id.Ms); err != nil {
+		return err
+	}
+	return r.saveLen(id.Seq)
+}
+
+// saveStreamPEL 保存 PEL 中的消息 ID，nacks 时同时保存投递时间与次数
+func (r *rdbWriter) saveStreamPEL(pel *obj.Rax, nacks bool) error {
+	if err := r.saveLen(uint64(pel.Len())); err != nil {
+		return err
+	}
+	it := pel.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		if _, err := r.Write(it.Key); err != nil {
+			return err
+		}
+		if !nacks {
+			continue
+		}
+		nack := it.Val.(*obj.StreamNACK)
+		if err := r.saveMillisecondTime(nack.DeliveryTime); err != nil {
+			return err
+		}
+		if err := r.saveLen(nack.DeliveryCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rdbWriter) saveStreamConsumers(cg *obj.StreamCG) error {
+	if err := r.saveLen(uint64(cg.Consumers.Len())); err != nil {
+		return err
+	}
+	it := cg.Consumers.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		consumer := it.Val.(*obj.StreamConsumer)
+		if err := r.saveString(consumer.Name); err != nil {
+			return err
+		}
+		if err := r.saveMillisecondTime(consumer.SeenTime); err != nil {
+			return err
+		}
+		if err := r.saveMillisecondTime(consumer.ActiveTime); err != nil {
+			return err
+		}
+		if err := r.saveStreamPEL(consumer.PEL, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveStream 按 RDB_TYPE_STREAM_LISTPACKS_3 的格式保存 stream
+func (r *rdbWriter) saveStream(s *obj.Stream) error {
+	if err := r.saveLen(uint64(s.Rax.Len())); err != nil {
+		return err
+	}
+	it := s.Rax.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		if err := r.saveString(string(it.Key)); err != nil {
+			return err
+		}
+		if err := r.saveString(string(it.Val.([]byte))); err != nil {
+			return err
+		}
+	}
+	if err := r.saveLen(s.Length); err != nil {
+		return err
+	}
+	for _, id := range []obj.StreamID{s.LastId, s.FirstId, s.MaxDeletedEntryId} {
+		if err := r.saveStreamID(id); err != nil {
+			return err
+		}
+	}
+	if err := r.saveLen(s.EntriesAdded); err != nil {
+		return err
+	}
+	if s.CGroups == nil {
+		return r.saveLen(0)
+	}
+	if err := r.saveLen(uint64(s.CGroups.Len())); err != nil {
+		return err
+	}
+	cgit := s.CGroups.Iterator()
+	cgit.Seek("^", nil)
+	for cgit.Next() {
+		cg := cgit.Val.(*obj.StreamCG)
+		if err := r.saveString(string(cgit.Key)); err != nil {
+			return err
+		}
+		if err := r.saveStreamID(cg.LastId); err != nil {
+			return err
+		}
+		if err := r.saveLen(uint64(cg.EntriesRead)); err != nil {
+			return err
+		}
+		if err := r.saveStreamPEL(cg.PEL, true); err != nil {
+			return err
+		}
+		if err := r.saveStreamConsumers(cg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rdbWriter) saveKeyValuePair(key, val *obj.RedisObj, expire int64) error {
+	if expire != -1 {
+		if err := r.saveType(RDB_OPCODE_EXPIRETIME_MS); err != nil {
+			return err
+		}
+		if err := r.saveMillisecondTime(expire); err != nil {
+			return err
+		}
+	}
+	// 淘汰策略需要时保存访问信息，重启后冷热数据不变
+	if server.maxmemoryPolicy&MAXMEMORY_FLAG_LRU != 0 {
+		if err := r.saveType(RDB_OPCODE_IDLE); err != nil {
+			return err
+		}
+		if err := r.saveLen(estimateObjectIdleTime(val) / 1000); err != nil {
+			return err
+		}
+	} else if server.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		if err := r.saveType(RDB_OPCODE_FREQ); err != nil {
+			return err
+		}
+		if err := r.saveType(byte(lfuDecrAndReturn(val))); err != nil {
+			return err
+		}
+	}
+	if err := r.saveObjectType(val); err != nil {
+		return err
+	}
+	if err := r.saveString(key.StrVal()); err != nil {
+		return err
+	}
+	return r.saveObject(val)
+}
+
+func (r *rdbWriter) saveDB(dbid int, db *redisDB) error {
+	if err := r.saveType(RDB_OPCODE_SELECTDB); err != nil {
+		return err
+	}
+	if err := r.saveLen(uint64(dbid)); err != nil {
+		return err
+	}
+	if err := r.saveType(RDB_OPCODE_RESIZEDB); err != nil {
+		return err
+	}
+	if err := r.saveLen(uint64(db.data.Len())); err != nil {
+		return err
+	}
+	if err := r.saveLen(uint64(db.expire.Len())); err != nil {
+		return err
+	}
+	var err error
+	db.data.Range(func(e *obj.Entry) bool {
+		expire := int64(-1)
+		if when := db.expire.Get(e.Key); when != nil {
+			expire = when.IntVal()
+		}
+		err = r.saveKeyValuePair(e.Key, e.Val, expire)
+		return err == nil
+	})
+	return err
+}
+
+// rdbSaveRio 将数据集以 rdb 格式写入 w
+func rdbSaveRio(w io.Writer) error {
+	r := &rdbWriter{w: w}
+	if _, err := fmt.Fprintf(r, "REDIS%04d", RDB_VERSION); err != nil {
+		return err
+	}
+	if err := r.saveAuxField("redis-ver", REDIS_VERSION); err != nil {
+		return err
+	}
+	if err := r.saveAuxField("redis-bits", "64"); err != nil {
+		return err
+	}
+	if err := r.saveAuxField("ctime", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		return err
+	}
+	for _, db := range server.db {
+		if db.data.Len() == 0 {
+			continue
+		}
+		if err := r.saveDB(db.id, db); err != nil {
+			return err
+		}
+	}
+	if err := r.saveType(RDB_OPCODE_EOF); err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, r.crc)
+	_, err := r.w.Write(buf)
+	return err
+}
+
+// rdbSave 同步保存 rdb 文件，先写临时文件再原子替换
+func rdbSave(filename string) error {
+	tmpfile := fmt.Sprintf("temp-%d.rdb", os.Getpid())
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = rdbSaveRio(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	if err = os.Rename(tmpfile, filename); err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	log.Printf("DB saved on disk: %v\n", filename)
+	server.dirtyAtLastSave = server.dirty
+	server.lastsave = time.Now().Unix()
+	server.statRdbSaves++
+	return nil
+}
+
+type rdbReader struct {
+	r   io.Reader
+	crc uint64
+}
+
+func (r *rdbReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc = crc64Update(r.crc, p[:n])
+	return n, err
+}
+
+func (r *rdbReader) loadType() (byte, error) {
+	var buf [1]byte
+	_, err := io.ReadFull(r, buf[:])
+	return buf[0], err
+}
+
+// loadLenByRef 读取长度，isEncoded 表示随后是特殊编码的整数
+func (r *rdbReader) loadLenByRef() (l uint64, isEncoded bool, err error) {
+	var buf [8]byte
+	if _, err = io.ReadFull(r, buf[:1]); err != nil {
+		return
+	}
+	typ := (buf[0] & 0xC0) >> 6
+	switch {
+	case typ == RDB_ENCVAL:
+		return uint64(buf[0] & 0x3F), true, nil
+	case typ == RDB_6BITLEN:
+		return uint64(buf[0] & 0x3F), false, nil
+	case typ == RDB_14BITLEN:
+		hi := buf[0] & 0x3F
+		if _, err = io.ReadFull(r, buf[:1]); err != nil {
+			return
+		}
+		return uint64(hi)<<8 | uint64(buf[0]), false, nil
+	case buf[0] == RDB_32BITLEN:
+		if _, err = io.ReadFull(r, buf[:4]); err != nil {
+			return
+		}
+		return uint64(binary.BigEndian.Uint32(buf[:4])), false, nil
+	case buf[0] == RDB_64BITLEN:
+		if _, err = io.ReadFull(r, buf[:8]); err != nil {
+			return
+		}
+		return binary.BigEndian.Uint64(buf[:8]), false, nil
+	}
+	return 0, false, ErrRdbFormat
+}
+
+func (r *rdbReader) loadLen() (uint64, error) {
+	l, isEncoded, err := r.loadLenByRef()
+	if err == nil && isEncoded {
+		err = ErrRdbFormat
+	}
+	return l, err
+}
+
+func (r *rdbReader) loadString() (string, error) {
+	l, isEncoded, err := r.loadLenByRef()
+	if err != nil {
+		return "", err
+	}
+	if isEncoded {
+		var buf [4]byte
+		switch byte(l) {
+		case RDB_ENC_INT8:
+			_, err = io.ReadFull(r, buf[:1])
+			return strconv.FormatInt(int64(int8(buf[0])), 10), err
+		case RDB_ENC_INT16:
+			_, err = io.ReadFull(r, buf[:2])
+			return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf[:2]))), 10), err
+		case RDB_ENC_INT32:
+			_, err = io.ReadFull(r, buf[:4])
+			return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf[:4]))), 10), err
+		default:
+			return "", fmt.Errorf("unsupported string encoding %v", l)
+		}
+	}
+	buf := make([]byte, l)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func (r *rdbReader) loadObject(typ byte) (*obj.RedisObj, error) {
+	switch typ {
+	case RDB_TYPE_STRING:
+		s, err := r.loadString()
+		if err != nil {
+			return nil, err
+		}
+		return obj.CreateObject(obj.STR, s), nil
+	case RDB_TYPE_LIST:
+		n, err := r.loadLen()
+		if err != nil {
+			return nil, err
+		}
+		o := createListObject()
+		l := o.Val.(*obj.List)
+		for ; n > 0; n-- {
+			s, err := r.loadString()
+			if err != nil {
+				return nil, err
+			}
+			l.Append(obj.CreateObject(obj.STR, s))
+		}
+		return o, nil
+	case RDB_TYPE_ZSET_2:
+		n, err := r.loadLen()
+		if err != nil {
+			return nil, err
+		}
+		o := createZsetObject()
+		zs := o.Val.(*obj.ZSet)
+		buf := make([]byte, 8)
+		for ; n > 0; n-- {
+			member, err := r.loadString()
+			if err != nil {
+				return nil, err
+			}
+			if _, err = io.ReadFull(r, buf); err != nil {
+				return nil, err
+			}
+			zs.Add(member, math.Float64frombits(binary.LittleEndian.Uint64(buf)))
+		}
+		return o, nil
+	case RDB_TYPE_STREAM_LISTPACKS, RDB_TYPE_STREAM_LISTPACKS_2, RDB_TYPE_STREAM_LISTPACKS_3:
+		s, err := r.loadStream(typ)
+		if err != nil {
+			return nil, err
+		}
+		return obj.CreateObject(obj.STREAM, s), nil
+	default:
+		return nil, fmt.Errorf("unsupported rdb object type %v", typ)
+	}
+}
+
+func (r *rdbReader) loadMillisecondTime() (int64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+func (r *rdbReader) loadStreamID() (obj.StreamID, error) {
+	var id obj.StreamID
+	var err error
+	if id.Ms, err = r.loadLen(); err != nil {
+		return id, err
+	}
+	id.Seq, err = r.loadLen()
+	return id, err
+}
+
+func (r *rdbReader) loadRawStreamID() ([]byte, error) {
+	buf := make([]byte, 16)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+// loadStream 加载 stream，兼容 RDB_TYPE_STREAM_LISTPACKS 与 RDB_TYPE_STREAM_LISTPACKS_2
+func (r *rdbReader) loadStream(typ byte) (*obj.Stream, error) {
+	s := obj.StreamCreate()
+	nodes, err := r.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	for ; nodes > 0; nodes-- {
+		key, err := r.loadString()
+		if err != nil {
+			return nil, err
+		}
+		lp, err := r.loadString()
+		if err != nil {
+			return nil, err
+		}
+		if err = obj.ValidateStreamNode([]byte(key), []byte(lp)); err != nil {
+			return nil, err
+		}
+		if !s.Rax.Insert([]byte(key), []byte(lp)) {
+			return nil, ErrRdbFormat
+		}
+	}
+	if s.Length, err = r.loadLen(); err != nil {
+		return nil, err
+	}
+	if s.LastId, err = r.loadStreamID(); err != nil {
+		return nil, err
+	}
+	if typ >= RDB_TYPE_STREAM_LISTPACKS_2 {
+		if s.FirstId, err = r.loadStreamID(); err != nil {
+			return nil, err
+		}
+		if s.MaxDeletedEntryId, err = r.loadStreamID(); err != nil {
+			return nil, err
+		}
+		if s.EntriesAdded, err = r.loadLen(); err != nil {
+			return nil, err
+		}
+	} else {
+		// 旧格式没有这些信息，按没有删除过消息处理
+		s.FirstId, _ = s.FirstEntryID()
+		s.EntriesAdded = s.Length
+	}
+
+	groups, err := r.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	for ; groups > 0; groups-- {
+		name, err := r.loadString()
+		if err != nil {
+			return nil, err
+		}
+		lastId, err := r.loadStreamID()
+		if err != nil {
+			return nil, err
+		}
+		entriesRead := obj.SCG_INVALID_ENTRIES_READ
+		if typ >= RDB_TYPE_STREAM_LISTPACKS_2 {
+			n, err := r.loadLen()
+			if err != nil {
+				return nil, err
+			}
+			entriesRead = int64(n)
+		} else {
+			entriesRead = s.EstimateDistanceFromFirstEverEntry(lastId)
+		}
+		cg := s.CreateCG(name, lastId, entriesRead)
+		if cg == nil {
+			return nil, ErrRdbFormat
+		}
+
+		pending, err := r.loadLen()
+		if err != nil {
+			return nil, err
+		}
+		for ; pending > 0; pending-- {
+			key, err := r.loadRawStreamID()
+			if err != nil {
+				return nil, err
+			}
+			nack := &obj.StreamNACK{}
+			if nack.DeliveryTime, err = r.loadMillisecondTime(); err != nil {
+				return nil, err
+			}
+			if nack.DeliveryCount, err = r.loadLen(); err != nil {
+				return nil, err
+			}
+			if !cg.PEL.Insert(key, nack) {
+				return nil, ErrRdbFormat
+			}
+		}
+
+		consumers, err := r.loadLen()
+		if err != nil {
+			return nil, err
+		}
+		for ; consumers > 0; consumers-- {
+			cname, err := r.loadString()
+			if err != nil {
+				return nil, err
+			}
+			seen, err := r.loadMillisecondTime()
+			if err != nil {
+				return nil, err
+			}
+			active := seen
+			if typ >= RDB_TYPE_STREAM_LISTPACKS_3 {
+				if active, err = r.loadMillisecondTime(); err != nil {
+					return nil, err
+				}
+			}
+			consumer := cg.CreateConsumer(cname, seen)
+			if consumer == nil {
+				return nil, ErrRdbFormat
+			}
+			consumer.ActiveTime = active
+			// 消费者的 PEL 与消费组共享 NACK
+			pending, err := r.loadLen()
+			if err != nil {
+				return nil, err
+			}
+			for ; pending > 0; pending-- {
+				key, err := r.loadRawStreamID()
+				if err != nil {
+					return nil, err
+				}
+				v, ok := cg.PEL.Find(key)
+				if !ok {
+					return nil, ErrRdbFormat
+				}
+				nack := v.(*obj.StreamNACK)
+				if nack.Consumer != nil {
+					return nil, ErrRdbFormat
+				}
+				nack.Consumer = consumer
+				consumer.PEL.Insert(key, nack)
+			}
+		}
+		// 每个 NACK 都必须属于某个消费者
+		it := cg.PEL.Iterator()
+		it.Seek("^", nil)
+		for it.Next() {
+			if it.Val.(*obj.StreamNACK).Consumer == nil {
+				return nil, ErrRdbFormat
+			}
+		}
+	}
+	return s, nil
+}
+
+// rdbLoadRio 从 r 中读取 rdb 数据并加载到 dbs
+func rdbLoadRio(rd io.Reader, dbs []*redisDB) error {
+	r := &rdbReader{r: rd}
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return ErrRdbFormat
+	}
+	ver, err := strconv.Atoi(string(header[5:]))
+	if err != nil || ver < 1 || ver > RDB_VERSION {
+		return fmt.Errorf("can't handle RDB format version %s", header[5:])
+	}
+	now := ae.GetMsTime()
+	expire := int64(-1)
+	lfuFreq, lruIdle := int64(-1), int64(-1)
+	db := dbs[0]
+	for {
+		typ, err := r.loadType()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case RDB_OPCODE_EXPIRETIME_MS:
+			buf := make([]byte, 8)
+			if _, err = io.ReadFull(r, buf); err != nil {
+				return err
+			}
+			expire = int64(binary.LittleEndian.Uint64(buf))
+			continue
+		case RDB_OPCODE_IDLE:
+			idle, err := r.loadLen()
+			if err != nil {
+				return err
+			}
+			lruIdle = int64(idle)
+			continue
+		case RDB_OPCODE_FREQ:
+			freq, err := r.loadType()
+			if err != nil {
+				return err
+			}
+			lfuFreq = int64(freq)
+			continue
+		case RDB_OPCODE_SELECTDB:
+			dbid, err := r.loadLen()
+			if err != nil {
+				return err
+			}
+			if dbid >= uint64(len(dbs)) {
+				return fmt.Errorf("FATAL: Data file was created with a Redis server configured to handle more than %d databases", len(dbs))
+			}
+			db = dbs[dbid]
+			continue
+		case RDB_OPCODE_RESIZEDB:
+			if _, err = r.loadLen(); err != nil {
+				return err
+			}
+			if _, err = r.loadLen(); err != nil {
+				return err
+			}
+			continue
+		case RDB_OPCODE_AUX:
+			if _, err = r.loadString(); err != nil {
+				return err
+			}
+			if _, err = r.loadString(); err != nil {
+				return err
+			}
+			continue
+		case RDB_OPCODE_EOF:
+			expected := r.crc
+			buf := make([]byte, 8)
+			if _, err = io.ReadFull(r.r, buf); err != nil {
+				return err
+			}
+			if crc := binary.LittleEndian.Uint64(buf); crc != 0 && crc != expected {
+				return errors.New("wrong RDB checksum")
+			}
+			return nil
+		}
+		key, err := r.loadString()
+		if err != nil {
+			return err
+		}
+		val, err := r.loadObject(typ)
+		if err != nil {
+			return err
+		}
+		// 主节点加载时直接丢弃已过期的键，从节点等待主节点的 DEL
+		if expire != -1 && expire < now && server.masterhost == "" {
+			expire = -1
+			lfuFreq, lruIdle = -1, -1
+			continue
+		}
+		keyObj := obj.CreateObject(obj.STR, key)
+		dbAdd(db, keyObj, val)
+		objectSetLRUOrLFU(val, lfuFreq, lruIdle)
+		if expire != -1 {
+			db.expire.Set(keyObj, obj.CreateFromInt(expire))
+		}
+		expire = -1
+		lfuFreq, lruIdle = -1, -1
+	}
+}
+
+func rdbLoad(filename string, dbs []*redisDB) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rdbLoadRio(bufio.NewReader(f), dbs)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"go-redis/ae"
@@ -10,8 +13,10 @@ import (
 	"go-redis/obj"
 	"hash/fnv"
 	"log"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,42 +29,307 @@ const (
 )
 
 const (
-	IO_BUF     int = 1024 * 16
-	MAX_BULK   int = 1024 * 4
-	MAX_INLINE int = 1024 * 4
+	IO_BUF              int = 1024 * 16
+	MAX_INLINE          int = 1024 * 64 // 内联命令以及多条批量请求中长度行的最大长度
+	PROTO_MBULK_BIG_ARG int = 1024 * 32 // 超过该长度的参数直接读入按长度预分配的缓冲区
+)
+
+const REDIS_VERSION string = "7.2.0"
+
+const WRONGTYPE_ERR string = "-WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// 客户端标识
+const (
+	CLIENT_SLAVE                = 1 << 0  // 从节点连接
+	CLIENT_MASTER               = 1 << 1  // 主节点连接
+	CLIENT_MASTER_FORCE_REPLY   = 1 << 2  // 允许向主节点回复，用于 REPLCONF ACK
+	CLIENT_PRE_PSYNC            = 1 << 3  // 使用旧版 SYNC 的从节点
+	CLIENT_CLOSED               = 1 << 4  // 连接已释放
+	CLIENT_BLOCKED              = 1 << 5  // 阻塞中，例如 WAIT
+	CLIENT_PUBSUB               = 1 << 6  // 订阅模式
+	CLIENT_FORCE_REPL           = 1 << 7  // 即使没有修改数据也传播命令，例如 PUBLISH
+	CLIENT_MULTI                = 1 << 8  // 事务中
+	CLIENT_DIRTY_CAS            = 1 << 9  // WATCH 的键被修改，EXEC 将失败
+	CLIENT_DIRTY_EXEC           = 1 << 10 // 排队时出错，EXEC 将返回 EXECABORT
+	CLIENT_DENY_BLOCKING        = 1 << 11 // 不允许阻塞，例如 EXEC 中的命令
+	CLIENT_PREVENT_PROP         = 1 << 12 // 命令本身不传播，例如 XREADGROUP 以 XCLAIM 的形式传播
+	CLIENT_PENDING_READ         = 1 << 13 // 等待 I/O 线程读取
+	CLIENT_PENDING_WRITE        = 1 << 14 // 等待在 beforeSleep 中发送回复
+	CLIENT_PENDING_COMMAND      = 1 << 15 // I/O 线程已解析出一条命令，等待主线程执行
+	CLIENT_CLOSE_ASAP           = 1 << 16 // 等待主线程释放，例如 I/O 线程中出错或超过输出缓冲区限制
+	CLIENT_CLOSE_AFTER_REPLY    = 1 << 17 // 回复发送完后关闭连接，例如协议错误
+	CLIENT_NO_EVICT             = 1 << 18 // CLIENT NO-EVICT ON
+	CLIENT_REPLY_OFF            = 1 << 19 // CLIENT REPLY OFF，不发送回复
+	CLIENT_REPLY_SKIP_NEXT      = 1 << 20 // CLIENT REPLY SKIP，跳过下一条命令的回复
+	CLIENT_REPLY_SKIP           = 1 << 21 // 跳过当前命令的回复
+	CLIENT_MONITOR              = 1 << 22 // MONITOR 客户端
+	CLIENT_REPROCESSING_COMMAND = 1 << 23 // 解除阻塞后重新执行命令
 )
 
 var server RedisServer
 
 type RedisServer struct {
-	fd      int
-	port    int
-	db      *redisDB
-	clients map[int]*RedisClient
-	aeLoop  *ae.AeLoop
+	fd              int
+	port            int
+	db              []*redisDB
+	dbnum           int
+	clients         map[int]*RedisClient
+	clientsIndex    map[int64]*RedisClient // 客户端 ID -> 客户端
+	maxclients      int
+	maxidletime     int64 // 秒，0 表示不关闭空闲客户端
+	protectedMode   bool
+	aeLoop          *ae.AeLoop
+	currentClient   *RedisClient
+	nextClientId    int64
+	dirty           int64 // 修改次数
+	dirtyAtLastSave int64 // 上次保存时的 dirty
+	lastsave        int64 // 上次成功保存的时间，秒
+	statRdbSaves    int64
+	cronloops       int64
+	dbfilename      string
+
+	// 命令执行期间需要额外传播的命令
+	alsoPropagate [][]*obj.RedisObj
+
+	streamNodeMaxBytes   int64
+	streamNodeMaxEntries int64
+
+	// 内存限制与淘汰
+	maxmemory        int64
+	maxmemoryPolicy  int
+	maxmemorySamples int
+	evictionPool     []evictionPoolEntry
+	evictNextDb      int // 随机淘汰策略下一次选择的数据库
+	statEvictedkeys  int64
+	lruclock         uint32 // 缓存的 LRU 时钟，由 cron 更新
+	lfuLogFactor     int
+	lfuDecayTime     int
+
+	// 惰性释放
+	lazyfreeLazyEviction  bool
+	lazyfreeLazyExpire    bool
+	lazyfreeLazyServerDel bool
+
+	// 认证与 ACL
+	masterauth       string // 从节点连接主节点时使用的密码
+	users            map[string]*aclUser
+	defaultUser      *aclUser // 新连接使用的用户
+	aclFilename      string
+	aclLog           []*aclLogEntry // 最新的记录在前
+	aclLogEntryCount int64
+	acllogMaxLen     int
+
+	// 协议限制
+	protoMaxBulkLen      int64
+	protoMaxMultibulkLen int64
+
+	// 客户端缓冲区限制
+	clientObufLimits                    []clientBufferLimitsConfig
+	clientMaxQuerybufLen                int64
+	clientsToClose                      []*RedisClient
+	statClientQbufLimitDisconnections   int64 // I/O 线程中更新，需要原子访问
+	statClientOutbufLimitDisconnections int64
+	statRejectedConn                    int64 // 超过 maxclients 被拒绝的连接数
+
+	// 统计
+	runid                  string // 每次启动随机生成
+	statStarttime          int64  // 秒
+	statNumcommands        int64
+	statNumconnections     int64
+	statExpiredkeys        int64
+	statKeyspaceHits       int64
+	statKeyspaceMisses     int64
+	statNetInputBytes      int64 // I/O 线程中更新，需要原子访问
+	statNetOutputBytes     int64 // 同上
+	statTotalErrorReplies  int64
+	statPeakMemory         int64
+	errors                 map[string]int64 // 错误码 -> 次数
+	instMetric             [STATS_METRIC_COUNT]instMetric
+	latencyTrackingEnabled bool
+
+	// 延迟监控
+	latencyMonitorThreshold int64 // 毫秒，0 表示关闭
+	latencyEvents           map[string]*latencyTimeSeries
+	elStart                 time.Time // 本轮事件循环开始处理事件的时间
+
+	// 慢日志
+	slowlog                        []*slowlogEntry // 最新的记录在前
+	slowlogEntryId                 int64
+	slowlogLogSlowerThan           int64 // 微秒，负数表示不记录
+	slowlogMaxLen                  int
+	latencyTrackingInfoPercentiles []float64
+
+	// CLIENT PAUSE
+	clientPauseType    int
+	clientPauseEndTime int64          // ms
+	postponedClients   []*RedisClient // 暂停期间推迟执行命令的客户端
+
+	// I/O 线程
+	ioThreadsNum        int
+	clientsPendingRead  []*RedisClient
+	clientsPendingWrite []*RedisClient
+
+	// 阻塞客户端
+	blockedClients     int
+	unblockedClients   []*RedisClient // 刚解除阻塞、待处理积压命令的客户端
+	clientsWaitingAcks []*RedisClient // WAIT/WAITAOF
+	getAckFromSlaves   bool
+	readyKeys          []readyKey // 有阻塞客户端等待且有了新数据的键
+
+	monitors []*RedisClient
+
+	// HTTP 接口，请求交给事件循环执行
+	httpJobs          chan func()
+	httpWakeFd        int      // 管道写端，唤醒事件循环
+	postponedHttpJobs []func() // CLIENT PAUSE 期间推迟的写请求
+
+	// 发布订阅
+	pubsubChannels       map[string][]*RedisClient
+	pubsubPatterns       map[string][]*RedisClient
+	notifyKeyspaceEvents int
+
+	// 主节点
+	replid                string
+	replid2               string
+	secondReplidOffset    int64
+	masterReplOffset      int64
+	backlog               *replBacklog
+	slaveseldb            int // 复制流当前选择的数据库，-1 表示下一条命令前必须发送 SELECT
+	replBacklogSize       int64
+	slaves                map[int]*RedisClient
+	replPingPeriod        int64
+	replCronLoops         int64
+	replDisklessSync      bool
+	replDisklessSyncDelay int64
+
+	// 从节点
+	masterhost            string
+	masterport            int
+	master                *RedisClient
+	cachedMaster          *RedisClient
+	replState             ReplState
+	replicaReadOnly       bool
+	replTimeout           int64
+	replTransferFd        int
+	replTransferSize      int64
+	replTransferRead      int64
+	replTransferLastIO    int64
+	replTransferTmpfile   *os.File
+	masterReplid          string
+	masterInitialOffset   int64
+	replDisklessLoad      string
+	replTransferEofmark   string // 无盘复制的 EOF 标记
+	replTransferLastbytes []byte
 }
 
 type redisDB struct {
-	data   *obj.Dict
-	expire *obj.Dict
+	id           int
+	data         *obj.Dict
+	expire       *obj.Dict
+	avgTTL       int64                     // 主动过期采样得到的平均 TTL
+	mem          int64                     // 键值对内存占用估算
+	watchedKeys  map[string][]*RedisClient // WATCH 的键 -> 客户端
+	blockingKeys map[string][]*RedisClient // 阻塞等待的键 -> 客户端
+	readyKeys    map[string]struct{}       // 已加入 server.readyKeys 的键
 }
 
 type RedisClient struct {
-	fd       int
-	db       *redisDB
-	args     []*obj.RedisObj
-	reply    *obj.List
-	sentLen  int
-	queryBuf []byte
-	queryLen int
-	cmdTy    CmdType
-	bulkNum  int
-	bulkLen  int
+	id              int64
+	fd              int
+	flags           int
+	db              *redisDB
+	args            []*obj.RedisObj
+	cmd             *RedisCommand // 当前执行的命令
+	reply           *obj.List
+	sentLen         int
+	queryBuf        []byte
+	queryLen        int
+	cmdTy           CmdType
+	bulkNum         int
+	bulkLen         int
+	ctime           int64 // 创建时间，秒
+	lastinteraction int64 // 最近一次读写的时间，秒
+	peerid          string
+	sockname        string
+	woff            int64 // 最近一次写命令后的复制偏移量
+	btype           BlockType
+	bpop            blockingState
+	pubsubChannels  map[string]struct{}
+	pubsubPatterns  map[string]struct{}
+	mstate          multiState
+	watchedKeys     []watchedKey
+
+	// 主节点视角下的从节点
+	replState          ReplState
+	replAckOff         int64
+	replAofOff         int64 // 从节点已 fsync 到 AOF 的偏移量
+	replAckTime        int64
+	slaveListeningPort int
+	slaveCapa          int
+	psyncInitialOffset int64
+	repldbfd           *os.File
+	repldboff          int64
+	repldbsize         int64
+	replPreamble       string
+	replPayload        []byte // 无盘复制的 rdb 数据
+	// 无盘复制完成后，收到第一个 ACK 才开始发送命令流
+	replStartCmdStreamOnAck bool
+
+	// 从节点视角下的主节点
+	replid      string
+	reploff     int64 // 已执行的复制偏移量
+	readReploff int64 // 已读取的复制偏移量
+
+	// 输出缓冲区限制
+	replyBytes               int64 // reply 中尚未发送的字节数
+	obufSoftLimitReachedTime int64 // 首次超过软限制的时间，0 表示没有超过
+
+	// 统计
+	commandsProcessed int64
+	netInputBytes     int64
+	netOutputBytes    int64
+
+	authenticated bool     // 已通过 AUTH 认证
+	user          *aclUser // 当前认证的用户，新连接为 default 用户
+	name          string   // HELLO SETNAME 设置的名称
+}
+
+// prepareClientToWrite 判断是否可以回复客户端，并注册写事件
+func prepareClientToWrite(c *RedisClient) bool {
+	if c.flags&CLIENT_MASTER != 0 && c.flags&CLIENT_MASTER_FORCE_REPLY == 0 {
+		return false
+	}
+	if c.flags&(CLIENT_CLOSED|CLIENT_CLOSE_ASAP) != 0 {
+		return false
+	}
+	if c.flags&(CLIENT_REPLY_OFF|CLIENT_REPLY_SKIP) != 0 {
+		return false
+	}
+	if c.fd < 0 {
+		return true
+	}
+	// 开启 I/O 线程时，普通客户端的回复在 beforeSleep 中并行发送
+	if c.flags&(CLIENT_SLAVE|CLIENT_MASTER) == 0 && server.ioThreadsNum > 1 {
+		if c.flags&CLIENT_PENDING_WRITE == 0 {
+			c.flags |= CLIENT_PENDING_WRITE
+			server.clientsPendingWrite = append(server.clientsPendingWrite, c)
+		}
+		return true
+	}
+	// 全量同步完成前，从节点的输出只缓存不发送
+	if c.flags&CLIENT_SLAVE == 0 || (c.replState == SLAVE_STATE_ONLINE && !c.replStartCmdStreamOnAck) {
+		server.aeLoop.AddFileEvent(c.fd, ae.FE_WRITABLE, SendReplyToClient, c)
+	}
+	return true
 }
 
 func (c *RedisClient) AddReply(o *obj.RedisObj) {
+	if !prepareClientToWrite(c) {
+		return
+	}
 	c.reply.Append(o)
-	server.aeLoop.AddFileEvent(c.fd, ae.FE_WRITABLE, SendReplyToClient, c)
+	c.replyBytes += int64(len(o.StrVal()))
+	closeClientOnOutputBufferLimitReached(c)
 }
 
 func (c *RedisClient) AddReplyStr(str string) {
@@ -67,37 +337,225 @@ func (c *RedisClient) AddReplyStr(str string) {
 	c.AddReply(o)
 }
 
+// AddReplyError 错误回复，msg 不以 '-' 开头时添加 ERR 前缀
+func (c *RedisClient) AddReplyError(msg string) {
+	if !strings.HasPrefix(msg, "-") {
+		msg = "-ERR " + msg
+	}
+	afterErrorReply(msg)
+	c.AddReplyStr(msg + "\r\n")
+}
+
+func (c *RedisClient) AddReplyBulk(str string) {
+	c.AddReplyStr(fmt.Sprintf("$%d\r\n%v\r\n", len(str), str))
+}
+
+func (c *RedisClient) AddReplyInt(n int64) {
+	c.AddReplyStr(fmt.Sprintf(":%d\r\n", n))
+}
+
+func (c *RedisClient) AddReplyArrayLen(n int) {
+	c.AddReplyStr(fmt.Sprintf("*%d\r\n", n))
+}
+
+// addReplyHelp 回复子命令的帮助信息，末尾追加 HELP 的说明
+func addReplyHelp(c *RedisClient, help []string) {
+	c.AddReplyArrayLen(len(help) + 3)
+	c.AddReplyStr(fmt.Sprintf("+%s <subcommand> [<arg> [value] [opt] ...]. Subcommands are:\r\n",
+		strings.ToUpper(c.args[0].StrVal())))
+	for _, line := range help {
+		c.AddReplyStr("+" + line + "\r\n")
+	}
+	c.AddReplyStr("+HELP\r\n")
+	c.AddReplyStr("+    Print this help.\r\n")
+}
+
 func ProcessCommand(c *RedisClient) {
 	cmdStr := c.args[0].StrVal()
 	log.Printf("process command: %v\n", cmdStr)
-	if cmdStr == "quit" {
+	if strings.EqualFold(cmdStr, "quit") {
 		freeClient(c)
 		return
 	}
-	cmd := lookupCommand(cmdStr)
+	// 未知的子命令交给容器命令处理，由其回复错误
+	cmd := lookupCommandByArgs(c.args)
+	c.cmd = cmd
 	if cmd == nil {
-		c.AddReplyStr("-ERR: unknow command")
+		rejectCommand(c, fmt.Sprintf("unknown command '%s'", cmdStr))
+		return
+	} else if !commandCheckArity(cmd, len(c.args)) {
+		rejectCommand(c, fmt.Sprintf("wrong number of arguments for '%s' command", cmd.fullname))
+		return
+	}
+	if authRequired(c) && cmd.flags&CMD_NO_AUTH == 0 {
+		rejectCommand(c, "-NOAUTH Authentication required.")
+		return
+	}
+	if reason, argpos := aclCheckAllPerm(c, cmd); reason != ACL_OK {
+		rejectCommandByACL(c, cmd, reason, argpos)
+		return
+	}
+	// 订阅模式下只能执行订阅相关命令
+	if c.flags&CLIENT_PUBSUB != 0 && cmd.name != "ping" && cmd.name != "subscribe" &&
+		cmd.name != "unsubscribe" && cmd.name != "psubscribe" && cmd.name != "punsubscribe" {
+		rejectCommand(c, fmt.Sprintf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", cmd.name))
+		return
+	}
+	if writeDeniedOnReplica(c, cmd) {
+		rejectCommand(c, READONLY_ERR)
+		return
+	}
+	// 写命令执行前淘汰键，内存仍然超出限制时拒绝会增加内存的命令
+	if server.maxmemory > 0 && c.flags&CLIENT_MASTER == 0 &&
+		(cmd.flags&CMD_WRITE != 0 || (cmd.name == "exec" && c.mstate.cmdFlags&CMD_WRITE != 0)) {
+		if performEvictions() == EVICT_FAIL && (cmd.flags&CMD_DENYOOM != 0 ||
+			(cmd.name == "exec" && c.mstate.cmdFlags&CMD_DENYOOM != 0)) {
+			rejectCommand(c, "-OOM command not allowed when used memory > 'maxmemory'.")
+			return
+		}
+	}
+	if commandPostponedByPause(c, cmd) {
+		blockPostponeClient(c)
 		resetClient(c)
 		return
-	} else if cmd.arity != len(c.args) {
-		c.AddReplyStr("-ERR: wrong number of args")
+	}
+	// 事务中的命令排队，等待 EXEC
+	if c.flags&CLIENT_MULTI != 0 && cmd.name != "exec" && cmd.name != "discard" &&
+		cmd.name != "multi" && cmd.name != "watch" {
+		queueMultiCommand(c, cmd)
+		c.AddReplyStr("+QUEUED\r\n")
 		resetClient(c)
 		return
 	}
-	cmd.proc(c)
+	call(c, cmd)
 	resetClient(c)
+	if len(server.readyKeys) > 0 {
+		handleClientsBlockedOnKeys()
+	}
+}
+
+const READONLY_ERR = "-READONLY You can't write against a read only replica."
+
+// writeDeniedOnReplica 只读从节点拒绝主节点以外的写命令
+func writeDeniedOnReplica(c *RedisClient, cmd *RedisCommand) bool {
+	return server.masterhost != "" && server.replicaReadOnly &&
+		c.flags&CLIENT_MASTER == 0 && cmd.flags&CMD_WRITE != 0
+}
+
+// commandPostponedByPause 暂停期间推迟执行，主从之间的连接不受影响
+func commandPostponedByPause(c *RedisClient, cmd *RedisCommand) bool {
+	return c.flags&(CLIENT_SLAVE|CLIENT_MASTER) == 0 && checkClientPauseTimeoutAndReturnIfPaused() &&
+		(server.clientPauseType == CLIENT_PAUSE_ALL || isMayReplicateCommand(c, cmd))
+}
+
+// rejectCommand 拒绝执行命令，事务中的错误会导致 EXEC 失败
+func rejectCommand(c *RedisClient, msg string) {
+	if c.cmd != nil {
+		c.cmd.rejectedCalls++
+	}
+	flagTransaction(c)
+	c.AddReplyError(msg)
+	resetClient(c)
+}
+
+// call 执行命令，数据有修改时传播给从节点
+func call(c *RedisClient, cmd *RedisCommand) {
+	dirty := server.dirty
+	replOffset := server.masterReplOffset
+	prev := server.currentClient
+	prevAlsoPropagate := server.alsoPropagate
+	server.alsoPropagate = nil
+	server.currentClient = c
+	c.cmd = cmd
+	errorReplies := server.statTotalErrorReplies
+	start := time.Now()
+	cmd.proc(c)
+	duration := time.Since(start)
+	c.commandsProcessed++
+	server.statNumcommands++
+	cmd.calls++
+	cmd.microseconds += int64(duration / time.Microsecond)
+	if server.statTotalErrorReplies > errorReplies {
+		cmd.failedCalls++
+	}
+	// 阻塞的命令还没有执行完成
+	if c.flags&CLIENT_BLOCKED == 0 {
+		if cmd.flags&CMD_FAST != 0 {
+			latencyAddSampleIfNeeded("fast-command", int64(duration/time.Millisecond))
+		} else {
+			latencyAddSampleIfNeeded("command", int64(duration/time.Millisecond))
+		}
+		if cmd.flags&CMD_SKIP_SLOWLOG == 0 {
+			slowlogPushEntryIfNeeded(c, int64(duration/time.Microsecond))
+		}
+	}
+	// 管理命令不发送给 MONITOR，解除阻塞后重新执行的命令已经发送过
+	if len(server.monitors) > 0 && cmd.flags&CMD_ADMIN == 0 && c.flags&CLIENT_REPROCESSING_COMMAND == 0 {
+		replicationFeedMonitors(c, server.monitors, c.db.id, c.args)
+	}
+	if server.latencyTrackingEnabled {
+		if cmd.latencyHistogram == nil {
+			cmd.latencyHistogram = &latencyHistogram{}
+		}
+		cmd.latencyHistogram.record(int64(duration))
+	}
+	server.currentClient = prev
+	if c.flags&CLIENT_MASTER == 0 {
+		ops := server.alsoPropagate
+		if (server.dirty > dirty || c.flags&CLIENT_FORCE_REPL != 0) && c.flags&CLIENT_PREVENT_PROP == 0 {
+			ops = append(ops, c.args)
+		}
+		propagatePendingCommands(c, ops)
+	}
+	server.alsoPropagate = prevAlsoPropagate
+	c.flags &^= CLIENT_FORCE_REPL | CLIENT_PREVENT_PROP
+	// WAIT 需要等待的偏移量
+	if server.masterReplOffset != replOffset {
+		c.woff = server.masterReplOffset
+	}
+}
+
+// alsoPropagate 当前命令执行完成后额外传播 args
+func alsoPropagate(args []*obj.RedisObj) {
+	server.alsoPropagate = append(server.alsoPropagate, args)
+}
+
+// preventCommandPropagation 当前命令本身不传播
+func preventCommandPropagation(c *RedisClient) {
+	c.flags |= CLIENT_PREVENT_PROP
+}
+
+// propagatePendingCommands 一条命令产生多个传播命令时以 MULTI/EXEC 包裹，
+// EXEC 中的命令已经由 EXEC 包裹
+func propagatePendingCommands(c *RedisClient, ops [][]*obj.RedisObj) {
+	wrap := len(ops) > 1 && c.flags&CLIENT_MULTI == 0
+	if wrap {
+		replicationFeedSlaves(c.db.id, createStrArgs("MULTI"))
+	}
+	for _, args := range ops {
+		replicationFeedSlaves(c.db.id, args)
+	}
+	if wrap {
+		replicationFeedSlaves(c.db.id, createStrArgs("EXEC"))
+	}
 }
 
 func resetClient(client *RedisClient) {
 	client.cmdTy = COMMAND_UNKNOWN
-	client.bulkLen = 0
+	client.bulkLen = -1
 	client.bulkNum = 0
+	// CLIENT REPLY SKIP 只跳过下一条命令的回复
+	client.flags &^= CLIENT_REPLY_SKIP
+	if client.flags&CLIENT_REPLY_SKIP_NEXT != 0 {
+		client.flags |= CLIENT_REPLY_SKIP
+		client.flags &^= CLIENT_REPLY_SKIP_NEXT
+	}
 }
 
 func (client *RedisClient) findLineInQuery() (int, error) {
-	index := strings.Index(string(client.queryBuf[:client.queryLen]), "\n")
+	index := bytes.IndexByte(client.queryBuf[:client.queryLen], '\n')
 	if index < 0 && client.queryLen > MAX_INLINE {
-		return index, errors.New("too big inline cmd")
+		return index, errors.New("Protocol error: too big inline request")
 	}
 	return index, nil
 }
@@ -115,7 +573,10 @@ func handleInlineBuf(client *RedisClient) (bool, error) {
 		return false, err
 	}
 
-	subs := strings.Split(string(client.queryBuf[:index]), " ")
+	subs, err := splitArgs(client.queryBuf[:index])
+	if err != nil {
+		return false, errors.New("Protocol error: unbalanced quotes in request")
+	}
 	client.queryBuf = client.queryBuf[index+1:]
 	client.queryLen -= index + 1
 	client.args = make([]*obj.RedisObj, len(subs))
@@ -133,35 +594,51 @@ func handleBulkBuf(client *RedisClient) (bool, error) {
 			return false, err
 		}
 
-		bnum, err := client.getNumInQuery(1, index)
-		if err != nil {
-			return false, err
+		// 长度行至少是 "*\r\n"
+		if index < 2 || client.queryBuf[index-1] != '\r' {
+			return false, errors.New("Protocol error: invalid multibulk length")
 		}
-		if bnum == 0 {
+		bnum, err := client.getNumInQuery(1, index-1)
+		if err != nil || int64(bnum) > server.protoMaxMultibulkLen {
+			return false, errors.New("Protocol error: invalid multibulk length")
+		}
+		if bnum <= 0 {
+			client.args = nil
 			return true, nil
 		}
 		client.bulkNum = bnum
-		client.args = make([]*obj.RedisObj, bnum)
+		// 参数个数来自客户端，预分配的空间需要有上限
+		prealloc := bnum
+		if prealloc > 1024 {
+			prealloc = 1024
+		}
+		client.args = make([]*obj.RedisObj, 0, prealloc)
 	}
 	for client.bulkNum > 0 {
-		if client.bulkLen == 0 {
+		if client.bulkLen == -1 {
 			index, err := client.findLineInQuery()
 			if index < 0 {
 				return false, err
 			}
 
 			if client.queryBuf[0] != '$' {
-				return false, errors.New("expect $ for bulk length")
+				return false, fmt.Errorf("Protocol error: expected '$', got '%c'", client.queryBuf[0])
 			}
 
-			blen, err := client.getNumInQuery(1, index)
-			if err != nil || blen == 0 {
-				return false, err
+			if index < 2 || client.queryBuf[index-1] != '\r' {
+				return false, errors.New("Protocol error: invalid bulk length")
 			}
-			if blen > MAX_BULK {
-				return false, errors.New("too big bulk")
+			blen, err := client.getNumInQuery(1, index-1)
+			if err != nil || blen < 0 || int64(blen) > server.protoMaxBulkLen {
+				return false, errors.New("Protocol error: invalid bulk length")
 			}
 			client.bulkLen = blen
+			// 大参数一次分配好需要的空间，避免读取过程中反复扩容 queryBuf
+			if blen >= PROTO_MBULK_BIG_ARG && len(client.queryBuf) < blen+2 {
+				buf := make([]byte, blen+2)
+				copy(buf, client.queryBuf[:client.queryLen])
+				client.queryBuf = buf
+			}
 		}
 		if client.queryLen < client.bulkLen+2 {
 			return false, nil
@@ -170,10 +647,10 @@ func handleBulkBuf(client *RedisClient) (bool, error) {
 		if client.queryBuf[index] != '\r' || client.queryBuf[index+1] != '\n' {
 			return false, errors.New("expect CRLF for bulk end")
 		}
-		client.args[len(client.args)-client.bulkNum] = obj.CreateObject(obj.STR, string(client.queryBuf[:index]))
+		client.args = append(client.args, obj.CreateObject(obj.STR, string(client.queryBuf[:index])))
 		client.queryBuf = client.queryBuf[index+2:]
 		client.queryLen -= index + 2
-		client.bulkLen = 0
+		client.bulkLen = -1
 		client.bulkNum -= 1
 	}
 	return true, nil
@@ -181,24 +658,16 @@ func handleBulkBuf(client *RedisClient) (bool, error) {
 
 func ReadQueryFromClient(loop *ae.AeLoop, fd int, extra interface{}) {
 	client := extra.(*RedisClient)
-	if len(client.queryBuf)-client.queryLen < MAX_BULK {
-		client.queryBuf = append(client.queryBuf, make([]byte, MAX_BULK)...)
-	}
-	n, err := net.Read(fd, client.queryBuf[client.queryLen:])
-	if err != nil {
-		log.Printf("client %v read err: %v\n", fd, err)
-		freeClient(client)
+	// 开启 I/O 线程时，读取与解析推迟到 beforeSleep 中并行执行
+	if postponeClientRead(client) {
 		return
 	}
-	if n == 0 {
-		log.Printf("process query buf empty")
+	if err := readFromClient(client); err != nil {
+		log.Printf("client %v read err: %v\n", fd, err)
 		freeClient(client)
 		return
 	}
-	client.queryLen += n
-	log.Printf("read %v bytes from client:%v\n", n, client.fd)
-	log.Printf("ReadQueryFromClient, queryBuf : %v\n", string(client.queryBuf))
-	err = ProcessQueryBuf(client)
+	err := ProcessQueryBuf(client)
 	if err != nil {
 		log.Printf("process query buf err: %v\n", err)
 		freeClient(client)
@@ -206,82 +675,184 @@ func ReadQueryFromClient(loop *ae.AeLoop, fd int, extra interface{}) {
 	}
 }
 
-func ProcessQueryBuf(client *RedisClient) error {
-	for client.queryLen > 0 {
-		if client.cmdTy == COMMAND_UNKNOWN {
-			if client.queryBuf[0] == '*' {
-				client.cmdTy = COMMAND_BULK
-			} else {
-				client.cmdTy = COMMAND_INLINE
-			}
+// setProtocolError 回复协议错误，丢弃剩余的请求，回复发送完后关闭连接
+func setProtocolError(client *RedisClient, err error) {
+	log.Printf("client id=%d protocol error: %v\n", client.id, err)
+	client.AddReplyError(err.Error())
+	client.flags |= CLIENT_CLOSE_AFTER_REPLY
+	// 不再读取后续的请求
+	if client.fd > 0 {
+		server.aeLoop.RemoveFileEvent(client.fd, ae.FE_READABLE)
+	}
+	client.queryBuf = make([]byte, IO_BUF)
+	client.queryLen = 0
+	resetClient(client)
+}
+
+// readFromClient 读取数据追加到 queryBuf，只访问客户端自身，可以在 I/O 线程中执行
+func readFromClient(client *RedisClient) error {
+	readlen := IO_BUF
+	// 读取大参数时只读剩余部分，数据正好填满预分配的缓冲区
+	if client.cmdTy == COMMAND_BULK && client.bulkLen >= PROTO_MBULK_BIG_ARG {
+		if remaining := client.bulkLen + 2 - client.queryLen; remaining > 0 && remaining < readlen {
+			readlen = remaining
 		}
-		var ok bool
-		var err error
-		if client.cmdTy == COMMAND_INLINE {
-			ok, err = handleInlineBuf(client)
-		} else if client.cmdTy == COMMAND_BULK {
-			ok, err = handleBulkBuf(client)
+	}
+	if len(client.queryBuf)-client.queryLen < readlen {
+		client.queryBuf = append(client.queryBuf[:client.queryLen], make([]byte, readlen)...)
+	}
+	n, err := net.Read(client.fd, client.queryBuf[client.queryLen:client.queryLen+readlen])
+	if net.IsAgain(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("client closed connection")
+	}
+	client.queryLen += n
+	client.netInputBytes += int64(n)
+	atomic.AddInt64(&server.statNetInputBytes, int64(n))
+	client.lastinteraction = time.Now().Unix()
+	if client.flags&CLIENT_MASTER != 0 {
+		client.readReploff += int64(n)
+	} else if int64(client.queryLen) > server.clientMaxQuerybufLen {
+		// 主节点的复制流不受限制
+		atomic.AddInt64(&server.statClientQbufLimitDisconnections, 1)
+		return fmt.Errorf("client id=%d reached max query buffer length (%d bytes)", client.id, client.queryLen)
+	}
+	return nil
+}
+
+// parseQueryBuf 从 queryBuf 中解析一条命令到 args，命令不完整时返回 false
+func parseQueryBuf(client *RedisClient) (bool, error) {
+	if client.cmdTy == COMMAND_UNKNOWN {
+		if client.queryBuf[0] == '*' {
+			client.cmdTy = COMMAND_BULK
 		} else {
-			return errors.New("unknow Redis Command Type")
+			client.cmdTy = COMMAND_INLINE
 		}
-		if err != nil {
-			return err
+	}
+	if client.cmdTy == COMMAND_INLINE {
+		return handleInlineBuf(client)
+	} else if client.cmdTy == COMMAND_BULK {
+		return handleBulkBuf(client)
+	}
+	return false, errors.New("unknow Redis Command Type")
+}
+
+// processParsedCommand 执行已解析的命令
+func processParsedCommand(client *RedisClient) {
+	if len(client.args) == 0 {
+		resetClient(client)
+	} else if client.flags&CLIENT_MASTER != 0 {
+		processMasterCommand(client)
+	} else {
+		ProcessCommand(client)
+	}
+}
+
+func ProcessQueryBuf(client *RedisClient) error {
+	for client.queryLen > 0 {
+		// 阻塞中的客户端，命令留在 queryBuf 中等待解除阻塞；即将关闭的客户端不再执行命令
+		if client.flags&(CLIENT_BLOCKED|CLIENT_CLOSE_ASAP|CLIENT_CLOSE_AFTER_REPLY) != 0 {
+			break
 		}
-		if ok {
-			if len(client.args) == 0 {
-				resetClient(client)
-			} else {
-				ProcessCommand(client)
+		ok, err := parseQueryBuf(client)
+		if err != nil {
+			// 主节点的复制流出错直接断开，其他客户端先收到错误回复
+			if client.flags&CLIENT_MASTER != 0 {
+				return err
 			}
-		} else {
+			setProtocolError(client, err)
+			break
+		}
+		if !ok {
+			break
+		}
+		processParsedCommand(client)
+		if client.flags&CLIENT_CLOSED != 0 {
 			break
 		}
 	}
 	return nil
 }
 
-type CommandProc func(c *RedisClient)
+// processMasterCommand 执行主节点的复制流，并原样转发给子从节点
+func processMasterCommand(c *RedisClient) {
+	raw := catCommandResp(c.args)
+	ProcessCommand(c)
+	if c.flags&CLIENT_CLOSED != 0 {
+		return
+	}
+	c.reploff = c.readReploff - int64(c.queryLen)
+	replicationFeedStreamFromMasterStream(raw)
+}
 
-type RedisCommand struct {
-	name  string
-	proc  CommandProc
-	arity int
+// signalModifiedKey 键被修改时调用
+func signalModifiedKey(db *redisDB, key *obj.RedisObj) {
+	touchWatchedKey(db, key)
+	updateKeyMemUsage(db, key)
 }
 
-var cmdTable []RedisCommand = []RedisCommand{
-	{"get", getCommand, 2},
-	{"set", setCommand, 3},
-	{"expire", expireCommand, 3},
+// propagateDeletion 过期或淘汰删除以 DEL 的形式传播给从节点
+func propagateDeletion(db *redisDB, key *obj.RedisObj) {
+	replicationFeedSlaves(db.id, []*obj.RedisObj{obj.CreateObject(obj.STR, "DEL"), key})
 }
 
-func expireIfNeeded(key *obj.RedisObj) {
-	entry := server.db.expire.Find(key)
+// expireIfNeeded 键已过期时返回 true
+func expireIfNeeded(db *redisDB, key *obj.RedisObj) bool {
+	entry := db.expire.Find(key)
 	if entry == nil {
-		return
+		return false
 	}
 	when := entry.Val.IntVal()
 	if when > ae.GetMsTime() {
-		return
+		return false
+	}
+	// 从节点不主动删除过期键，等待主节点的 DEL；主节点的命令流看到的键始终存在
+	if server.masterhost != "" {
+		return server.currentClient == nil || server.currentClient != server.master
+	}
+	// 暂停期间数据集不能改变，过期键视为不存在但不删除
+	if checkClientPauseTimeoutAndReturnIfPaused() {
+		return true
 	}
-	server.db.expire.Delete(key)
-	server.db.data.Delete(key)
+	if !dbGenericDelete(db, key, server.lazyfreeLazyExpire) {
+		return true
+	}
+	server.statExpiredkeys++
+	signalModifiedKey(db, key)
+	notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key, db.id)
+	propagateDeletion(db, key)
+	return true
 }
 
-func findKeyRead(key *obj.RedisObj) *obj.RedisObj {
-	expireIfNeeded(key)
-	return server.db.data.Get(key)
+func findKeyRead(db *redisDB, key *obj.RedisObj) *obj.RedisObj {
+	var val *obj.RedisObj
+	if !expireIfNeeded(db, key) {
+		val = db.data.Get(key)
+	}
+	if val == nil {
+		server.statKeyspaceMisses++
+		notifyKeyspaceEvent(NOTIFY_KEY_MISS, "keymiss", key, db.id)
+	} else {
+		server.statKeyspaceHits++
+		updateObjectAccess(val)
+	}
+	return val
 }
 
 func getCommand(c *RedisClient) {
 	key := c.args[1]
-	val := findKeyRead(key)
+	val := findKeyRead(c.db, key)
 	if val == nil {
 		c.AddReplyStr("$-1\r\n")
 	} else if val.Type != obj.STR {
-		c.AddReplyStr("-ERR: wrong type\r\n")
+		c.AddReplyError("-ERR: wrong type")
 	} else {
-		str := val.StrVal()
-		c.AddReplyStr(fmt.Sprintf("$%d%v\r\n", len(str), str))
+		c.AddReplyBulk(val.StrVal())
 	}
 }
 
@@ -289,32 +860,102 @@ func setCommand(c *RedisClient) {
 	key := c.args[1]
 	val := c.args[2]
 	if val.Type != obj.STR {
-		c.AddReplyStr("-ERR: wrong type\r\n")
+		c.AddReplyError("-ERR: wrong type")
+		return
+	}
+	if old := c.db.data.Get(key); old == nil {
+		notifyKeyspaceEvent(NOTIFY_NEW, "new", key, c.db.id)
+	} else if old.Type != obj.STR {
+		// 覆盖其他类型的值，等待该键的客户端可能需要解除阻塞
+		signalKeyAsReady(c.db, key, old.Type)
 	}
-	server.db.data.Set(key, val)
-	server.db.expire.Delete(key)
+	setKey(c.db, key, val)
+	signalModifiedKey(c.db, key)
+	server.dirty++
+	notifyKeyspaceEvent(NOTIFY_STRING, "set", key, c.db.id)
 	c.AddReplyStr("+OK\r\n")
 }
 
+// delGenericCommand DEL 与 UNLINK，lazy 时较大的值在后台释放
+func delGenericCommand(c *RedisClient, lazy bool) {
+	var deleted int64
+	for _, key := range c.args[1:] {
+		expireIfNeeded(c.db, key)
+		if dbGenericDelete(c.db, key, lazy) {
+			signalModifiedKey(c.db, key)
+			notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key, c.db.id)
+			deleted++
+		}
+	}
+	server.dirty += deleted
+	c.AddReplyInt(deleted)
+}
+
+func delCommand(c *RedisClient) {
+	delGenericCommand(c, false)
+}
+
 func expireCommand(c *RedisClient) {
 	key := c.args[1]
-	val := c.args[2]
-	if val.Type != obj.STR {
-		c.AddReplyStr("-ERR: wrong type\r\n")
+	seconds, err := strconv.ParseInt(c.args[2].StrVal(), 10, 64)
+	if err != nil {
+		c.AddReplyError("value is not an integer or out of range")
+		return
+	}
+	if lookupKeyWrite(c.db, key) == nil {
+		c.AddReplyInt(0)
+		return
 	}
-	expire := ae.GetMsTime() + (val.IntVal() * 1000)
+	expire := ae.GetMsTime() + seconds*1000
 	expObj := obj.CreateFromInt(expire)
-	server.db.expire.Set(key, expObj)
-	c.AddReplyStr("+OK\r\n")
+	c.db.expire.Set(key, expObj)
+	signalModifiedKey(c.db, key)
+	server.dirty++
+	notifyKeyspaceEvent(NOTIFY_GENERIC, "expire", key, c.db.id)
+	// 以绝对时间传播，避免从节点的过期时间漂移
+	c.args = createStrArgs("PEXPIREAT", key.StrVal(), expObj.StrVal())
+	c.AddReplyInt(1)
+}
+
+func pexpireatCommand(c *RedisClient) {
+	key := c.args[1]
+	when, err := strconv.ParseInt(c.args[2].StrVal(), 10, 64)
+	if err != nil {
+		c.AddReplyError("value is not an integer or out of range")
+		return
+	}
+	if lookupKeyWrite(c.db, key) == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	c.db.expire.Set(key, obj.CreateFromInt(when))
+	signalModifiedKey(c.db, key)
+	server.dirty++
+	notifyKeyspaceEvent(NOTIFY_GENERIC, "expire", key, c.db.id)
+	c.AddReplyInt(1)
 }
 
-func lookupCommand(cmdStr string) *RedisCommand {
-	for _, c := range cmdTable {
-		if c.name == cmdStr {
-			return &c
+func pingCommand(c *RedisClient) {
+	if len(c.args) > 2 {
+		c.AddReplyError("wrong number of arguments for 'ping' command")
+		return
+	}
+	// 订阅模式下以消息的格式回复
+	if c.flags&CLIENT_PUBSUB != 0 {
+		c.AddReplyArrayLen(2)
+		c.AddReplyBulk("pong")
+		if len(c.args) == 2 {
+			c.AddReplyBulk(c.args[1].StrVal())
+		} else {
+			c.AddReplyBulk("")
 		}
+		return
+	}
+	if len(c.args) == 2 {
+		c.AddReplyBulk(c.args[1].StrVal())
+	} else {
+		c.AddReplyStr("+PONG\r\n")
 	}
-	return nil
 }
 
 func freeReplyList(client *RedisClient) {
@@ -322,35 +963,84 @@ func freeReplyList(client *RedisClient) {
 		n := client.reply.Head
 		client.reply.DelNode(n)
 	}
+	client.replyBytes = 0
 }
 
 func freeClient(client *RedisClient) {
-	delete(server.clients, client.fd)
+	if client.flags&CLIENT_CLOSED != 0 {
+		return
+	}
+	if client.flags&CLIENT_BLOCKED != 0 {
+		unblockClient(client)
+	}
+	unwatchAllKeys(client)
+	pubsubUnsubscribeAllChannels(client, false)
+	pubsubUnsubscribeAllPatterns(client, false)
+	client.flags |= CLIENT_CLOSED
+	unlinkClient(client)
 	server.aeLoop.RemoveFileEvent(client.fd, ae.FE_READABLE)
 	server.aeLoop.RemoveFileEvent(client.fd, ae.FE_WRITABLE)
 	freeReplyList(client)
 	net.Close(client.fd)
+	if client.flags&CLIENT_MONITOR != 0 {
+		server.monitors = removeClient(server.monitors, client)
+	}
+	if client.flags&CLIENT_SLAVE != 0 {
+		if client.repldbfd != nil {
+			client.repldbfd.Close()
+			client.repldbfd = nil
+		}
+		delete(server.slaves, client.fd)
+		log.Printf("Connection with replica %v lost.\n", client.fd)
+	}
+	if client.flags&CLIENT_MASTER != 0 && client == server.master {
+		log.Printf("Connection with master lost.\n")
+		replicationCacheMaster(client)
+	}
 	log.Printf("close client fd:%d\n", client.fd)
 }
 
 func SendReplyToClient(loop *ae.AeLoop, fd int, extra interface{}) {
 	client := extra.(*RedisClient)
+	if err := writeToClient(client); err != nil {
+		log.Printf("send reply err: %v\n", err)
+		freeClient(client)
+		return
+	}
+	if client.reply.Length == 0 {
+		loop.RemoveFileEvent(fd, ae.FE_WRITABLE)
+		if client.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+			freeClient(client)
+		}
+	}
+}
+
+// writeToClient 发送回复列表，只访问客户端自身，可以在 I/O 线程中执行。
+// socket 缓冲区已满时停止发送，剩余部分等待下一次可写事件
+func writeToClient(client *RedisClient) error {
 	log.Printf("SendReplyToClient, reply len:%v\n", client.reply.Length)
 	for client.reply.Length > 0 {
 		rep := client.reply.Head
 		buf := []byte(rep.Val.StrVal())
 		bufLen := len(buf)
 		if client.sentLen < bufLen {
-			n, err := net.Write(fd, buf[client.sentLen:])
+			n, err := net.Write(client.fd, buf[client.sentLen:])
+			if net.IsAgain(err) {
+				break
+			}
 			if err != nil {
-				log.Printf("send reply err: %v\n", err)
-				freeClient(client)
-				return
+				return err
 			}
 			client.sentLen += n
+			client.netOutputBytes += int64(n)
+			atomic.AddInt64(&server.statNetOutputBytes, int64(n))
+			if client.flags&CLIENT_MASTER == 0 {
+				client.lastinteraction = time.Now().Unix()
+			}
 			log.Printf("send %v bytes to client:%v\n", n, client.fd)
 			if client.sentLen == bufLen {
 				client.reply.DelNode(rep)
+				client.replyBytes -= int64(bufLen)
 				client.sentLen = 0
 			} else {
 				break
@@ -359,8 +1049,8 @@ func SendReplyToClient(loop *ae.AeLoop, fd int, extra interface{}) {
 	}
 	if client.reply.Length == 0 {
 		client.sentLen = 0
-		loop.RemoveFileEvent(fd, ae.FE_WRITABLE)
 	}
+	return nil
 }
 
 func GStrEqual(a, b *obj.RedisObj) bool {
@@ -381,48 +1071,337 @@ func GStrHash(key *obj.RedisObj) int64 {
 
 func CreateClient(fd int) *RedisClient {
 	var client RedisClient
+	server.nextClientId++
+	client.id = server.nextClientId
 	client.fd = fd
-	client.db = server.db
+	client.db = server.db[0]
+	client.user = server.defaultUser
+	client.bulkLen = -1
+	client.ctime = time.Now().Unix()
+	client.lastinteraction = client.ctime
 	client.queryBuf = make([]byte, IO_BUF)
 	client.reply = obj.ListCreate(obj.ListType{EqualFunc: GStrEqual})
+	client.pubsubChannels = make(map[string]struct{})
+	client.pubsubPatterns = make(map[string]struct{})
 	return &client
 }
 
 func AcceptHandler(loop *ae.AeLoop, fd int, extra interface{}) {
-	cfd, err := net.Accept(fd)
+	cfd, ip, err := net.Accept(fd)
 	if err != nil {
 		log.Printf("accept err: %v\n", err)
 		return
 	}
+	acceptCommonHandler(cfd, ip)
+}
+
+const PROTECTED_MODE_ERR string = "-DENIED Redis is running in protected mode because protected mode is enabled and no password " +
+	"is set for the default user. In this mode connections are only accepted from the loopback interface. " +
+	"If you want to connect from external computers to Redis you may adopt one of the following solutions: " +
+	"1) Disable protected mode by setting the protected-mode option to false in the configuration file, " +
+	"and then restarting the server, however MAKE SURE Redis is not publicly accessible from internet if you do so. " +
+	"2) Set up an authentication password for the default user with requirepass or an ACL file. " +
+	"NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside.\r\n"
+
+// acceptCommonHandler 超过 maxclients，或者保护模式下收到非本机的连接时，回复错误后关闭连接
+func acceptCommonHandler(cfd int, ip string) *RedisClient {
+	if len(server.clients) >= server.maxclients {
+		net.Write(cfd, []byte("-ERR max number of clients reached\r\n"))
+		server.statRejectedConn++
+		net.Close(cfd)
+		return nil
+	}
+	if server.protectedMode && server.defaultUser.flags&USER_FLAG_NOPASS != 0 && !net.IsLoopback(ip) {
+		net.Write(cfd, []byte(PROTECTED_MODE_ERR))
+		net.Close(cfd)
+		return nil
+	}
+	// 读写不能阻塞事件循环，不读取回复的客户端（例如 MONITOR）只会积压在输出缓冲区中
+	if err := net.SetNonBlock(cfd); err != nil {
+		log.Printf("set nonblock err: %v\n", err)
+		net.Close(cfd)
+		return nil
+	}
 	client := CreateClient(cfd)
-	server.clients[cfd] = client
+	linkClient(client)
+	server.statNumconnections++
 	server.aeLoop.AddFileEvent(cfd, ae.FE_READABLE, ReadQueryFromClient, client)
 	log.Printf("accept client, fd: %v\n", cfd)
+	return client
 }
 
-const EXPIRE_CHECK_COUNT int = 100
+const (
+	EXPIRE_CHECK_COUNT int   = 100
+	SERVER_CRON_PERIOD int64 = 100 // ms
+)
 
-func ServerCron(loop *ae.AeLoop, id int, extra interface{}) {
-	for i := 0; i < EXPIRE_CHECK_COUNT; i++ {
-		entry := server.db.expire.RandomGet()
-		if entry == nil {
-			break
+func runWithPeriod(ms int64) bool {
+	return ms <= SERVER_CRON_PERIOD || server.cronloops%(ms/SERVER_CRON_PERIOD) == 0
+}
+
+func activeExpireCycle() {
+	start := latencyStartMonitor()
+	defer func() {
+		latencyAddSampleIfNeeded("expire-cycle", latencyEndMonitor(start))
+	}()
+	now := ae.GetMsTime()
+	for _, db := range server.db {
+		if db.expire.Len() == 0 {
+			db.avgTTL = 0
+			continue
 		}
-		if entry.Val.IntVal() < time.Now().Unix() {
-			server.db.data.Delete(entry.Key)
-			server.db.expire.Delete(entry.Key)
+		var ttlSum, ttlSamples int64
+		for i := 0; i < EXPIRE_CHECK_COUNT; i++ {
+			entry := db.expire.RandomGet()
+			if entry == nil {
+				break
+			}
+			if ttl := entry.Val.IntVal() - now; ttl >= 0 {
+				ttlSum += ttl
+				ttlSamples++
+				continue
+			}
+			key := entry.Key
+			if !dbGenericDelete(db, key, server.lazyfreeLazyExpire) {
+				continue
+			}
+			server.statExpiredkeys++
+			signalModifiedKey(db, key)
+			notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key, db.id)
+			propagateDeletion(db, key)
+		}
+		// 平滑更新平均 TTL，与 Redis 一致每次只占 2% 的权重
+		if ttlSamples > 0 {
+			avgTTL := ttlSum / ttlSamples
+			if db.avgTTL == 0 {
+				db.avgTTL = avgTTL
+			} else {
+				db.avgTTL = db.avgTTL/50*49 + avgTTL/50
+			}
 		}
 	}
 }
 
+func ServerCron(loop *ae.AeLoop, id int, extra interface{}) {
+	server.lruclock = getLRUClock()
+	if runWithPeriod(100) {
+		trackInstantaneousMetric(STATS_METRIC_COMMAND, server.statNumcommands)
+		trackInstantaneousMetric(STATS_METRIC_NET_INPUT, atomic.LoadInt64(&server.statNetInputBytes))
+		trackInstantaneousMetric(STATS_METRIC_NET_OUTPUT, atomic.LoadInt64(&server.statNetOutputBytes))
+	}
+	updatePeakMemory(usedMemory())
+	// 从节点的过期键由主节点删除，暂停期间不删除
+	if server.masterhost == "" && !checkClientPauseTimeoutAndReturnIfPaused() {
+		activeExpireCycle()
+	}
+	clientsCron()
+	if runWithPeriod(1000) {
+		replicationCron()
+	}
+	server.cronloops++
+}
+
+// afterSleep 等待事件返回后记录本轮开始处理的时间
+func afterSleep(loop *ae.AeLoop) {
+	server.elStart = latencyStartMonitor()
+}
+
+func beforeSleep(loop *ae.AeLoop) {
+	// 上一轮处理事件直到这里的耗时
+	defer func() {
+		latencyAddSampleIfNeeded("eventloop", latencyEndMonitor(server.elStart))
+		server.elStart = time.Time{}
+	}()
+	if len(server.clientsPendingRead) > 0 {
+		handleClientsWithPendingReadsUsingThreads()
+	}
+	// 有客户端在 WAIT 时，请求从节点立即汇报偏移量
+	if server.getAckFromSlaves {
+		replicationFeedSlaves(server.slaveseldb, createStrArgs("REPLCONF", "GETACK", "*"))
+		server.getAckFromSlaves = false
+	}
+	if len(server.clientsWaitingAcks) > 0 {
+		processClientsWaitingReplicas()
+	}
+	if len(server.readyKeys) > 0 {
+		handleClientsBlockedOnKeys()
+	}
+	processUnblockedClients()
+	if len(server.clientsToClose) > 0 {
+		freeClientsInAsyncFreeQueue()
+	}
+	// 最后发送回复，包含本轮所有命令产生的输出
+	if len(server.clientsPendingWrite) > 0 {
+		handleClientsWithPendingWritesUsingThreads()
+	}
+}
+
+func createRedisDB(id int) *redisDB {
+	return &redisDB{
+		id:           id,
+		data:         obj.DictCreate(obj.DictType{HashFunc: GStrHash, EqualFunc: GStrEqual}),
+		expire:       obj.DictCreate(obj.DictType{HashFunc: GStrHash, EqualFunc: GStrEqual}),
+		watchedKeys:  make(map[string][]*RedisClient),
+		blockingKeys: make(map[string][]*RedisClient),
+		readyKeys:    make(map[string]struct{}),
+	}
+}
+
+// replaceDBData 用 src 的数据替换 dst，dst 的 WATCH 状态保留
+func replaceDBData(dst, src *redisDB) {
+	touchAllWatchedKeysInDb(dst, src)
+	dst.data = src.data
+	dst.expire = src.expire
+	dst.mem = src.mem
+}
+
+// createDBArray 创建 server.dbnum 个空的数据库
+func createDBArray() []*redisDB {
+	dbs := make([]*redisDB, server.dbnum)
+	for i := range dbs {
+		dbs[i] = createRedisDB(i)
+	}
+	return dbs
+}
+
+func emptyData() {
+	for _, db := range server.db {
+		emptyDB(db, false)
+	}
+}
+
 func initServer(config *conf.Config) error {
 	server.port = config.Port
-	server.clients = make(map[int]*RedisClient)
-	server.db = &redisDB{
-		data:   obj.DictCreate(obj.DictType{HashFunc: GStrHash, EqualFunc: GStrEqual}),
-		expire: obj.DictCreate(obj.DictType{HashFunc: GStrHash, EqualFunc: GStrEqual}),
+	if config.Maxclients < 1 {
+		return errors.New("maxclients must be positive")
 	}
+	server.maxclients = config.Maxclients
+	if config.Timeout < 0 {
+		return errors.New("timeout can't be negative")
+	}
+	server.maxidletime = config.Timeout
+	server.protectedMode = config.ProtectedMode
+	server.dbfilename = config.Dbfilename
+	server.clients = make(map[int]*RedisClient)
+	server.clientsIndex = make(map[int64]*RedisClient)
+	server.clientPauseType = CLIENT_PAUSE_OFF
+	server.clientPauseEndTime = 0
+	server.postponedClients = nil
+	server.postponedHttpJobs = nil
+	server.slaves = make(map[int]*RedisClient)
+	server.monitors = nil
+	server.pubsubChannels = make(map[string][]*RedisClient)
+	server.pubsubPatterns = make(map[string][]*RedisClient)
+	server.replBacklogSize = config.ReplBacklogSize
+	server.replPingPeriod = config.ReplPingReplicaPeriod
+	server.replicaReadOnly = config.ReplicaReadOnly
+	server.replTimeout = config.ReplTimeout
+	server.replDisklessSync = config.ReplDisklessSync
+	server.replDisklessSyncDelay = config.ReplDisklessSyncDelay
+	server.replDisklessLoad = config.ReplDisklessLoad
+	server.replTransferFd = -1
+	server.streamNodeMaxBytes = config.StreamNodeMaxBytes
+	server.streamNodeMaxEntries = config.StreamNodeMaxEntries
 	var err error
+	server.maxmemory = config.Maxmemory
+	server.maxmemoryPolicy, err = maxmemoryPolicyFromName(config.MaxmemoryPolicy)
+	if err != nil {
+		return err
+	}
+	if config.MaxmemorySamples < 1 {
+		return errors.New("maxmemory-samples must be positive")
+	}
+	server.maxmemorySamples = config.MaxmemorySamples
+	server.evictionPool = make([]evictionPoolEntry, EVPOOL_SIZE)
+	server.evictNextDb = 0
+	if config.LfuLogFactor < 0 || config.LfuDecayTime < 0 {
+		return errors.New("lfu-log-factor and lfu-decay-time can't be negative")
+	}
+	server.lfuLogFactor = config.LfuLogFactor
+	server.lfuDecayTime = config.LfuDecayTime
+	server.lruclock = getLRUClock()
+	server.lazyfreeLazyEviction = config.LazyfreeLazyEviction
+	server.lazyfreeLazyExpire = config.LazyfreeLazyExpire
+	server.lazyfreeLazyServerDel = config.LazyfreeLazyServerDel
+	bioInit()
+	if config.IoThreads < 1 || config.IoThreads > IO_THREADS_MAX_NUM {
+		return fmt.Errorf("io-threads must be between 1 and %d", IO_THREADS_MAX_NUM)
+	}
+	server.ioThreadsNum = config.IoThreads
+	server.clientObufLimits = make([]clientBufferLimitsConfig, CLIENT_TYPE_OBUF_COUNT)
+	if err = parseClientOutputBufferLimit(config.ClientOutputBufferLimit, server.clientObufLimits); err != nil {
+		return err
+	}
+	server.clientMaxQuerybufLen, err = memtoll(config.ClientQueryBufferLimit)
+	if err != nil {
+		return err
+	}
+	if server.clientMaxQuerybufLen < 1024*1024 {
+		return errors.New("client-query-buffer-limit must be at least 1mb")
+	}
+	server.clientsToClose = nil
+	server.masterauth = config.Masterauth
+	if config.AcllogMaxLen < 0 {
+		return errors.New("acllog-max-len can't be negative")
+	}
+	server.acllogMaxLen = config.AcllogMaxLen
+	aclInit(config.Requirepass)
+	server.aclFilename = config.Aclfile
+	if server.aclFilename != "" {
+		if err = aclLoadFromFile(server.aclFilename); err != nil {
+			return err
+		}
+	}
+	server.protoMaxBulkLen, err = memtoll(config.ProtoMaxBulkLen)
+	if err != nil {
+		return err
+	}
+	if server.protoMaxBulkLen < 1024*1024 {
+		return errors.New("proto-max-bulk-len must be at least 1mb")
+	}
+	if config.ProtoMaxMultibulkLen < 1 {
+		return errors.New("proto-max-multibulk-len must be positive")
+	}
+	server.protoMaxMultibulkLen = config.ProtoMaxMultibulkLen
+	server.latencyTrackingEnabled = config.LatencyTracking
+	server.latencyTrackingInfoPercentiles, err = parseLatencyTrackingPercentiles(config.LatencyTrackingInfoPercentiles)
+	if err != nil {
+		return err
+	}
+	resetServerStats()
+	if config.SlowlogMaxLen < 0 {
+		return errors.New("slowlog-max-len can't be negative")
+	}
+	if config.LatencyMonitorThreshold < 0 {
+		return errors.New("latency-monitor-threshold can't be negative")
+	}
+	server.latencyMonitorThreshold = config.LatencyMonitorThreshold
+	latencyMonitorInit()
+	server.slowlogLogSlowerThan = config.SlowlogLogSlowerThan
+	server.slowlogMaxLen = config.SlowlogMaxLen
+	slowlogInit()
+	server.statStarttime = time.Now().Unix()
+	server.lastsave = server.statStarttime
+	server.dirtyAtLastSave = server.dirty
+	server.statRdbSaves = 0
+	server.clientsPendingRead = nil
+	server.clientsPendingWrite = nil
+	initThreadedIO()
+	server.notifyKeyspaceEvents, err = keyspaceEventsStringToFlags(config.NotifyKeyspaceEvents)
+	if err != nil {
+		return err
+	}
+	runid := make([]byte, CONFIG_RUN_ID_SIZE/2)
+	rand.Read(runid)
+	server.runid = hex.EncodeToString(runid)
+	changeReplicationId()
+	clearReplicationId2()
+	if config.Databases < 1 {
+		return errors.New("invalid number of databases")
+	}
+	server.dbnum = config.Databases
+	server.db = createDBArray()
+	server.slaveseldb = -1
 	server.fd, err = net.TcpServer(server.port)
 	if err != nil {
 		return err
@@ -431,6 +1410,19 @@ func initServer(config *conf.Config) error {
 	if err != nil {
 		return err
 	}
+	if config.ReplicaOf != "" {
+		fields := strings.Fields(config.ReplicaOf)
+		if len(fields) != 2 {
+			return errors.New("replicaof must be \"host port\"")
+		}
+		port, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		server.masterhost = fields[0]
+		server.masterport = port
+		server.replState = REPL_STATE_CONNECT
+	}
 	return nil
 }
 
@@ -445,40 +1437,146 @@ func main() {
 		log.Fatalf("init server error: %v\n", err)
 	}
 	server.aeLoop.AddFileEvent(server.fd, ae.FE_READABLE, AcceptHandler, nil)
-	server.aeLoop.AddTimeEvent(ae.TE_NORMAL, 100, ServerCron, nil)
+	server.aeLoop.AddTimeEvent(ae.TE_NORMAL, SERVER_CRON_PERIOD, ServerCron, nil)
+	server.aeLoop.SetBeforeSleepProc(beforeSleep)
+	server.aeLoop.SetAfterSleepProc(afterSleep)
 	log.Println("redis server is up.")
 
 	if config.HttpAddr != "" {
-		http.StartHttpListen(config.HttpAddr).
+		if err := initHttpJobs(); err != nil {
+			log.Fatalf("init http error: %v\n", err)
+		}
+		router := http.StartHttpListen(config.HttpAddr).
 			AddRoute("/key/get", getCommandHttp).
 			AddRoute("/key/set", setCommandHttp).
 			AddRoute("/key/expire", expireCommandHttp)
+		router.SetAuth(httpAuth)
 	}
 
 	server.aeLoop.AeMain()
 }
 
+// initHttpJobs HTTP 请求在各自的 goroutine 中解析，命令通过管道唤醒事件循环后执行
+func initHttpJobs() error {
+	rfd, wfd, err := net.Pipe()
+	if err != nil {
+		return err
+	}
+	server.httpJobs = make(chan func(), 1024)
+	server.httpWakeFd = wfd
+	server.aeLoop.AddFileEvent(rfd, ae.FE_READABLE, processHttpJobs, nil)
+	return nil
+}
+
+// runInEventLoop 在事件循环中执行 fn，返回时 fn 已经执行完成
+func runInEventLoop(fn func()) {
+	runInEventLoopOrPostpone(func() bool {
+		fn()
+		return true
+	})
+}
+
+// runInEventLoopOrPostpone fn 返回 false 时推迟执行，解除暂停后重新执行
+func runInEventLoopOrPostpone(fn func() bool) {
+	done := make(chan struct{})
+	var job func()
+	job = func() {
+		if !fn() {
+			server.postponedHttpJobs = append(server.postponedHttpJobs, job)
+			return
+		}
+		close(done)
+	}
+	server.httpJobs <- job
+	wakeHttpJobs()
+	<-done
+}
+
+func wakeHttpJobs() {
+	net.Write(server.httpWakeFd, []byte{0})
+}
+
+func processHttpJobs(loop *ae.AeLoop, fd int, extra interface{}) {
+	buf := make([]byte, 64)
+	for {
+		if n, err := net.Read(fd, buf); n <= 0 || err != nil {
+			break
+		}
+	}
+	jobs := server.postponedHttpJobs
+	server.postponedHttpJobs = nil
+	for _, job := range jobs {
+		job()
+	}
+	for {
+		select {
+		case job := <-server.httpJobs:
+			job()
+		default:
+			return
+		}
+	}
+}
+
+// httpCall 与普通客户端一样检查只读从节点、暂停与内存限制后通过 call 执行命令，
+// 写命令会传播给从节点，返回回复内容；暂停期间返回 false，由调用者推迟执行
+func httpCall(args ...string) (string, bool) {
+	c := CreateClient(-1)
+	c.args = createStrArgs(args...)
+	cmd := lookupCommandByArgs(c.args)
+	if writeDeniedOnReplica(c, cmd) {
+		return READONLY_ERR + "\r\n", true
+	}
+	if commandPostponedByPause(c, cmd) {
+		return "", false
+	}
+	if server.maxmemory > 0 && cmd.flags&CMD_WRITE != 0 &&
+		performEvictions() == EVICT_FAIL && cmd.flags&CMD_DENYOOM != 0 {
+		return "-OOM command not allowed when used memory > 'maxmemory'.\r\n", true
+	}
+	call(c, cmd)
+	if len(server.readyKeys) > 0 {
+		handleClientsBlockedOnKeys()
+	}
+	var sb strings.Builder
+	for n := c.reply.Head; n != nil; n = n.Next() {
+		sb.WriteString(n.Val.StrVal())
+	}
+	return sb.String(), true
+}
+
 func getCommandHttp(arg ...string) string {
 	if len(arg) != 1 {
 		return "-1"
 	}
-	valObj := findKeyRead(&obj.RedisObj{Val: arg[0]})
-	if valObj == nil {
-		return "-1"
-	} else if valObj.Type != obj.STR {
-		return "wrong type"
-	} else {
-		return valObj.StrVal()
-	}
+	var ret string
+	runInEventLoop(func() {
+		valObj := findKeyRead(server.db[0], &obj.RedisObj{Val: arg[0]})
+		if valObj == nil {
+			ret = "-1"
+		} else if valObj.Type != obj.STR {
+			ret = "wrong type"
+		} else {
+			ret = valObj.StrVal()
+		}
+	})
+	return ret
 }
 
 func setCommandHttp(arg ...string) string {
 	if len(arg) != 2 {
 		return "-1"
 	}
-	keyObj := &obj.RedisObj{Val: arg[0]}
-	valObj := &obj.RedisObj{Val: arg[1]}
-	server.db.data.Set(keyObj, valObj)
+	var reply string
+	runInEventLoopOrPostpone(func() (ok bool) {
+		reply, ok = httpCall("set", arg[0], arg[1])
+		return ok
+	})
+	if strings.HasPrefix(reply, "-OOM") {
+		return "OOM"
+	} else if strings.HasPrefix(reply, "-READONLY") {
+		return "READONLY"
+	}
 	return "OK"
 }
 
@@ -486,18 +1584,18 @@ func expireCommandHttp(arg ...string) string {
 	if len(arg) != 2 {
 		return "-1"
 	}
-	keyObj := &obj.RedisObj{Val: arg[0]}
-	valObj := findKeyRead(&obj.RedisObj{Val: arg[0]})
-	if valObj == nil {
+	if _, err := strconv.Atoi(arg[1]); err != nil {
 		return "-1"
 	}
-
-	expire, err := strconv.Atoi(arg[1])
-	if err != nil {
+	var reply string
+	runInEventLoopOrPostpone(func() (ok bool) {
+		reply, ok = httpCall("expire", arg[0], arg[1])
+		return ok
+	})
+	if strings.HasPrefix(reply, "-READONLY") {
+		return "READONLY"
+	} else if reply != ":1\r\n" {
 		return "-1"
 	}
-	e := ae.GetMsTime() + int64(expire*1000)
-	expObj := obj.CreateFromInt(e)
-	server.db.expire.Set(keyObj, expObj)
 	return "OK"
 }
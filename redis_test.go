@@ -3,9 +3,11 @@ package main
 import (
 	"go-redis/conf"
 	"go-redis/obj"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 func ReadQuery(client *RedisClient, query string) {
@@ -71,21 +73,194 @@ func TestBulkBuf(t *testing.T) {
 }
 
 func TestProcessQueryBuf(t *testing.T) {
-	var conf conf.Config
-	initServer(&conf)
+	initServer(conf.DefaultConfig())
 	client := CreateClient(server.fd)
 	ReadQuery(client, "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$3\r\nval\r\n")
 	err := ProcessQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, 3, len(client.args))
 	key := obj.CreateObject(obj.STR, "key")
-	val := server.db.data.Get(key)
+	val := server.db[0].data.Get(key)
 	assert.Equal(t, "val", val.StrVal())
 
 	ReadQuery(client, "set key val2\r\n")
 	err = ProcessQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, 3, len(client.args))
-	val2 := server.db.data.Get(key)
+	val2 := server.db[0].data.Get(key)
 	assert.Equal(t, "val2", val2.StrVal())
 }
+
+func TestBulkBufBigArg(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	val := strings.Repeat("x", 100000)
+	go unix.Write(peer, []byte("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$100000\r\n"+val+"\r\n"))
+
+	// 读到长度后按参数长度分配缓冲区，之后只读取剩余部分
+	k := obj.CreateObject(obj.STR, "k")
+	for server.db[0].data.Get(k) == nil {
+		assert.Nil(t, readFromClient(c))
+		assert.Nil(t, ProcessQueryBuf(c))
+		if c.bulkLen == 100000 {
+			assert.Equal(t, 100002, len(c.queryBuf))
+		}
+	}
+	assert.Equal(t, val, server.db[0].data.Get(k).StrVal())
+	assert.Equal(t, "+OK\r\n", allReplies(c))
+}
+
+func TestBulkBufLimits(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.ProtoMaxBulkLen = "1mb"
+	cfg.ProtoMaxMultibulkLen = 3
+	initServer(cfg)
+
+	c := CreateClient(-1)
+	ReadQuery(c, "*2\r\n$3\r\nget\r\n$1048577\r\n")
+	_, err := handleBulkBuf(c)
+	assert.EqualError(t, err, "Protocol error: invalid bulk length")
+
+	c = CreateClient(-1)
+	ReadQuery(c, "*4\r\n$3\r\ndel\r\n")
+	_, err = handleBulkBuf(c)
+	assert.EqualError(t, err, "Protocol error: invalid multibulk length")
+
+	c = CreateClient(-1)
+	ReadQuery(c, "*1\r\n+get\r\n")
+	_, err = handleBulkBuf(c)
+	assert.EqualError(t, err, "Protocol error: expected '$', got '+'")
+
+	c = CreateClient(-1)
+	ReadQuery(c, strings.Repeat("x", IO_BUF))
+	_, err = handleInlineBuf(c)
+	assert.Nil(t, err)
+
+	cfg.ProtoMaxBulkLen = "1kb"
+	assert.EqualError(t, initServer(cfg), "proto-max-bulk-len must be at least 1mb")
+}
+
+func TestInlineBufQuotes(t *testing.T) {
+	client := CreateClient(0)
+	ReadQuery(client, "set  \"k 1\" 'it\\'s'  \"\\x41\\n\\\"\"\r\n")
+	ok, err := handleInlineBuf(client)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	args := []string{}
+	for _, a := range client.args {
+		args = append(args, a.StrVal())
+	}
+	assert.Equal(t, []string{"set", "k 1", "it's", "A\n\""}, args)
+
+	// 空行没有参数
+	ReadQuery(client, "   \r\n")
+	ok, err = handleInlineBuf(client)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(client.args))
+
+	for _, line := range []string{"set \"k v\r\n", "set 'k\r\n", "set \"k\"v\r\n"} {
+		client = CreateClient(0)
+		ReadQuery(client, line)
+		_, err = handleInlineBuf(client)
+		assert.EqualError(t, err, "Protocol error: unbalanced quotes in request")
+	}
+}
+
+func TestProtocolErrorReply(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	// 出错之前的命令正常执行，之后的请求被丢弃
+	ReadQuery(c, "set k v\r\nget \"k\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "+OK\r\n-ERR Protocol error: unbalanced quotes in request\r\n", allReplies(c))
+	assert.NotZero(t, c.flags&CLIENT_CLOSE_AFTER_REPLY)
+	assert.Equal(t, 0, c.queryLen)
+
+	ReadQuery(c, "get k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "", allReplies(c))
+}
+
+func TestEmptyBulkLength(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "*\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR Protocol error: invalid multibulk length\r\n", allReplies(c))
+
+	c = CreateClient(-1)
+	ReadQuery(c, "*1\r\n$\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR Protocol error: invalid bulk length\r\n", allReplies(c))
+}
+
+// runHttpHandler 在 goroutine 中执行 HTTP 处理函数，当前 goroutine 充当事件循环
+func runHttpHandler(handler func(...string) string, args ...string) string {
+	ret := make(chan string)
+	go func() {
+		ret <- handler(args...)
+	}()
+	for {
+		processHttpJobs(server.aeLoop, -1, nil)
+		select {
+		case r := <-ret:
+			return r
+		default:
+		}
+	}
+}
+
+func TestHttpCommands(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	assert.Nil(t, initHttpJobs())
+	m := CreateClient(-1)
+	ReadQuery(m, "monitor\r\n")
+	assert.Nil(t, ProcessQueryBuf(m))
+	allReplies(m)
+
+	dirty := server.dirty
+	assert.Equal(t, "OK", runHttpHandler(setCommandHttp, "k", "v"))
+	assert.Equal(t, "v", runHttpHandler(getCommandHttp, "k"))
+	assert.Equal(t, "-1", runHttpHandler(expireCommandHttp, "nokey", "10"))
+	assert.Equal(t, "OK", runHttpHandler(expireCommandHttp, "k", "10"))
+	assert.Equal(t, dirty+2, server.dirty)
+	assert.NotNil(t, server.db[0].expire.Get(obj.CreateObject(obj.STR, "k")))
+	// 写命令与普通客户端一样经过 call
+	replies := allReplies(m)
+	assert.Contains(t, replies, "\"set\" \"k\" \"v\"\r\n")
+	assert.Contains(t, replies, "\"PEXPIREAT\" \"k\" ")
+
+	server.maxmemory = 1
+	assert.Equal(t, "OOM", runHttpHandler(setCommandHttp, "k2", "v"))
+	assert.Nil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "k2")))
+}
+
+func TestHttpWriteOnReplicaAndPause(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	assert.Nil(t, initHttpJobs())
+	server.masterhost = "127.0.0.1"
+	assert.Equal(t, "READONLY", runHttpHandler(setCommandHttp, "k", "v"))
+	assert.Equal(t, "READONLY", runHttpHandler(expireCommandHttp, "k", "10"))
+	assert.Nil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "k")))
+	server.masterhost = ""
+
+	// 暂停期间写请求被推迟，解除暂停后执行
+	c := CreateClient(-1)
+	ReadQuery(c, "client pause 100000 write\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	ret := make(chan string)
+	go func() {
+		ret <- setCommandHttp("k", "v")
+	}()
+	for len(server.postponedHttpJobs) == 0 {
+		processHttpJobs(server.aeLoop, -1, nil)
+	}
+	assert.Nil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "k")))
+	ReadQuery(c, "client unpause\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	processHttpJobs(server.aeLoop, -1, nil)
+	assert.Equal(t, "OK", <-ret)
+	assert.NotNil(t, server.db[0].data.Get(obj.CreateObject(obj.STR, "k")))
+}
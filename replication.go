@@ -0,0 +1,1317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-redis/ae"
+	"go-redis/net"
+	"go-redis/obj"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CONFIG_RUN_ID_SIZE  int   = 40
+	RDB_EOF_MARK_SIZE   int   = 40
+	REPL_SYNCIO_TIMEOUT int64 = 5000 // 握手阶段同步读写超时 ms
+)
+
+// 从节点视角下与主节点的连接状态
+type ReplState int
+
+const (
+	REPL_STATE_NONE ReplState = iota // 非从节点
+	REPL_STATE_CONNECT
+	REPL_STATE_RECEIVE_PING_REPLY
+	REPL_STATE_RECEIVE_AUTH_REPLY
+	REPL_STATE_RECEIVE_PORT_REPLY
+	REPL_STATE_RECEIVE_CAPA_REPLY
+	REPL_STATE_RECEIVE_PSYNC_REPLY
+	REPL_STATE_TRANSFER
+	REPL_STATE_CONNECTED
+)
+
+// 主节点视角下从节点的状态
+const (
+	SLAVE_STATE_WAIT_BGSAVE_START ReplState = iota + 1 // 等待生成 rdb
+	SLAVE_STATE_SEND_BULK
+	SLAVE_STATE_ONLINE
+)
+
+// 从节点能力
+const (
+	SLAVE_CAPA_EOF    = 1 << 0
+	SLAVE_CAPA_PSYNC2 = 1 << 1
+)
+
+// 复制积压缓冲区，环形结构
+type replBacklog struct {
+	buf     []byte
+	idx     int64 // 下一个写入位置
+	histlen int64 // 有效数据长度
+	offset  int64 // 缓冲区第一个字节对应的复制偏移量
+}
+
+func createReplicationBacklog() {
+	server.backlog = &replBacklog{
+		buf:    make([]byte, server.replBacklogSize),
+		offset: server.masterReplOffset + 1,
+	}
+}
+
+func freeReplicationBacklog() {
+	server.backlog = nil
+}
+
+// feedReplicationBacklog 写入积压缓冲区并推进全局复制偏移量
+func feedReplicationBacklog(p []byte) {
+	b := server.backlog
+	if b == nil {
+		return
+	}
+	size := int64(len(b.buf))
+	server.masterReplOffset += int64(len(p))
+	for len(p) > 0 {
+		n := int64(copy(b.buf[b.idx:], p))
+		b.idx = (b.idx + n) % size
+		b.histlen += n
+		p = p[n:]
+	}
+	if b.histlen > size {
+		b.histlen = size
+	}
+	b.offset = server.masterReplOffset - b.histlen + 1
+}
+
+// copyFrom 读取从 offset 开始到最新的积压数据
+func (b *replBacklog) copyFrom(offset int64) []byte {
+	size := int64(len(b.buf))
+	skip := offset - b.offset
+	j := (b.idx + size - b.histlen + skip) % size
+	l := b.histlen - skip
+	out := make([]byte, 0, l)
+	for l > 0 {
+		n := size - j
+		if n > l {
+			n = l
+		}
+		out = append(out, b.buf[j:j+n]...)
+		l -= n
+		j = 0
+	}
+	return out
+}
+
+func addReplyReplicationBacklog(c *RedisClient, offset int64) int64 {
+	buf := server.backlog.copyFrom(offset)
+	if len(buf) > 0 {
+		c.AddReplyStr(string(buf))
+	}
+	return int64(len(buf))
+}
+
+func changeReplicationId() {
+	buf := make([]byte, CONFIG_RUN_ID_SIZE/2)
+	rand.Read(buf)
+	server.replid = hex.EncodeToString(buf)
+}
+
+func clearReplicationId2() {
+	server.replid2 = strings.Repeat("0", CONFIG_RUN_ID_SIZE)
+	server.secondReplidOffset = -1
+}
+
+// shiftReplicationId 从节点提升为主节点时保留旧 replid，
+// 使原先的兄弟从节点仍可以部分重同步
+func shiftReplicationId() {
+	server.replid2 = server.replid
+	server.secondReplidOffset = server.masterReplOffset + 1
+	changeReplicationId()
+	log.Printf("Setting secondary replication ID to %v, valid up to offset: %v. New replication ID is %v\n",
+		server.replid2, server.secondReplidOffset, server.replid)
+}
+
+func catCommandResp(args []*obj.RedisObj) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		s := arg.StrVal()
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(s), s)
+	}
+	return buf.Bytes()
+}
+
+func createStrArgs(strs ...string) []*obj.RedisObj {
+	args := make([]*obj.RedisObj, len(strs))
+	for i, s := range strs {
+		args[i] = obj.CreateObject(obj.STR, s)
+	}
+	return args
+}
+
+// replicationFeedSlaves 将 dictid 数据库中的写命令写入积压缓冲区并发送给从节点，
+// 数据库与复制流当前选择的不同时先发送 SELECT；dictid 为 -1 表示与数据库无关
+func replicationFeedSlaves(dictid int, args []*obj.RedisObj) {
+	// 从节点只转发主节点的复制流
+	if server.masterhost != "" {
+		return
+	}
+	if server.backlog == nil && len(server.slaves) == 0 {
+		return
+	}
+	// 积压缓冲区通常在第一个从节点连接时创建，这里保证从节点的偏移量有据可查
+	if server.backlog == nil {
+		createReplicationBacklog()
+	}
+	var buf []byte
+	if dictid != -1 && server.slaveseldb != dictid {
+		buf = catCommandResp(createStrArgs("SELECT", strconv.Itoa(dictid)))
+		server.slaveseldb = dictid
+	}
+	buf = append(buf, catCommandResp(args)...)
+	feedReplicationBacklog(buf)
+	for _, slave := range server.slaves {
+		// 等待生成 rdb 的从节点会从 rdb 中得到这些修改
+		if slave.replState == SLAVE_STATE_WAIT_BGSAVE_START {
+			continue
+		}
+		slave.AddReplyStr(string(buf))
+	}
+}
+
+// replicationFeedMonitors 以 "+时间戳 [db 地址] 参数..." 的格式把命令发送给 MONITOR 客户端，
+// 与普通回复一样写入输出缓冲区，受输出缓冲区限制
+func replicationFeedMonitors(c *RedisClient, monitors []*RedisClient, dictid int, args []*obj.RedisObj) {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "+%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, dictid, getClientPeerId(c))
+	for _, arg := range args {
+		b.WriteByte(' ')
+		catRepr(&b, arg.StrVal())
+	}
+	b.WriteString("\r\n")
+	msg := b.String()
+	for _, monitor := range monitors {
+		monitor.AddReplyStr(msg)
+	}
+}
+
+// monitorCommand MONITOR，之后执行的命令都会发送给该客户端
+func monitorCommand(c *RedisClient) {
+	// 需要每条命令都有回复的客户端不能进入 MONITOR 模式，例如 EXEC 中
+	if c.flags&CLIENT_DENY_BLOCKING != 0 {
+		c.AddReplyError("MONITOR isn't allowed for DENY BLOCKING client")
+		return
+	}
+	// 已经是从节点或者 MONITOR 时忽略
+	if c.flags&(CLIENT_SLAVE|CLIENT_MONITOR) != 0 {
+		return
+	}
+	c.flags |= CLIENT_MONITOR
+	server.monitors = append(server.monitors, c)
+	c.AddReplyStr("+OK\r\n")
+}
+
+// replicationFeedStreamFromMasterStream 从节点原样转发主节点的复制流
+func replicationFeedStreamFromMasterStream(buf []byte) {
+	if server.backlog == nil {
+		return
+	}
+	feedReplicationBacklog(buf)
+	for _, slave := range server.slaves {
+		if slave.replState == SLAVE_STATE_WAIT_BGSAVE_START {
+			continue
+		}
+		slave.AddReplyStr(string(buf))
+	}
+}
+
+// masterTryPartialResynchronization 尝试部分重同步，成功时返回 true
+func masterTryPartialResynchronization(c *RedisClient) bool {
+	psyncReplid := c.args[1].StrVal()
+	psyncOffset, err := strconv.ParseInt(c.args[2].StrVal(), 10, 64)
+	if err != nil {
+		return false
+	}
+	if psyncReplid != server.replid &&
+		(psyncReplid != server.replid2 || psyncOffset > server.secondReplidOffset) {
+		if psyncReplid != "?" {
+			log.Printf("Partial resynchronization not accepted: Replication ID mismatch (Replica asked for '%v', my replication IDs are '%v' and '%v')\n",
+				psyncReplid, server.replid, server.replid2)
+		} else {
+			log.Printf("Full resync requested by replica %v\n", c.fd)
+		}
+		return false
+	}
+	if server.backlog == nil || psyncOffset < server.backlog.offset ||
+		psyncOffset > server.backlog.offset+server.backlog.histlen {
+		log.Printf("Unable to partial resync with replica %v for lack of backlog (Replica request was: %v).\n", c.fd, psyncOffset)
+		return false
+	}
+	c.flags |= CLIENT_SLAVE
+	c.replState = SLAVE_STATE_ONLINE
+	c.replAckTime = time.Now().Unix()
+	server.slaves[c.fd] = c
+	c.AddReplyStr(fmt.Sprintf("+CONTINUE %s\r\n", server.replid))
+	n := addReplyReplicationBacklog(c, psyncOffset)
+	log.Printf("Partial resynchronization request from %v accepted. Sending %v bytes of backlog starting from offset %v.\n",
+		c.fd, n, psyncOffset)
+	return true
+}
+
+// replicationSetupSlaveForFullResync 直接写出 +FULLRESYNC，绕过输出缓冲区
+func replicationSetupSlaveForFullResync(slave *RedisClient, offset int64) error {
+	slave.psyncInitialOffset = offset
+	// rdb 之后的命令流必须以 SELECT 开始
+	server.slaveseldb = -1
+	if slave.flags&CLIENT_PRE_PSYNC != 0 {
+		return nil
+	}
+	reply := fmt.Sprintf("+FULLRESYNC %s %d\r\n", server.replid, offset)
+	_, err := net.Write(slave.fd, []byte(reply))
+	return err
+}
+
+// startBgsaveForReplication 为所有等待中的从节点生成 rdb 并开始发送
+// 没有 fork，rdb 在事件循环中同步生成，生成期间的写命令不会交错；
+// 无盘复制时 rdb 只保存在内存中，以 EOF 标记分隔直接写入从节点 socket
+func startBgsaveForReplication(mincapa int) {
+	socketTarget := server.replDisklessSync && mincapa&SLAVE_CAPA_EOF != 0
+	var payload []byte
+	var err error
+	if socketTarget {
+		log.Printf("Starting BGSAVE for SYNC with target: replicas sockets\n")
+		var buf bytes.Buffer
+		err = rdbSaveRio(&buf)
+		payload = buf.Bytes()
+	} else {
+		log.Printf("Starting BGSAVE for SYNC with target: disk\n")
+		err = rdbSave(server.dbfilename)
+	}
+	for _, slave := range server.slaves {
+		if slave.replState != SLAVE_STATE_WAIT_BGSAVE_START {
+			continue
+		}
+		if err == nil {
+			err = replicationSetupSlaveForFullResync(slave, server.masterReplOffset)
+		}
+		if err == nil {
+			if socketTarget {
+				err = replicationSetupSlaveDiskless(slave, payload)
+			} else {
+				err = replicationSetupSlaveDisk(slave)
+			}
+		}
+		if err != nil {
+			log.Printf("BGSAVE for replication failed: %v\n", err)
+			freeClient(slave)
+			continue
+		}
+		slave.repldboff = 0
+		slave.replState = SLAVE_STATE_SEND_BULK
+		server.aeLoop.AddFileEvent(slave.fd, ae.FE_WRITABLE, sendBulkToSlave, slave)
+	}
+}
+
+func replicationSetupSlaveDisk(slave *RedisClient) error {
+	f, err := os.Open(server.dbfilename)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	slave.repldbfd = f
+	slave.repldbsize = st.Size()
+	slave.replPreamble = fmt.Sprintf("$%d\r\n", slave.repldbsize)
+	return nil
+}
+
+// replicationSetupSlaveDiskless $EOF:<mark>\r\n<rdb><mark>
+func replicationSetupSlaveDiskless(slave *RedisClient, payload []byte) error {
+	buf := make([]byte, RDB_EOF_MARK_SIZE/2)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	mark := hex.EncodeToString(buf)
+	slave.replPreamble = fmt.Sprintf("$EOF:%s\r\n", mark)
+	slave.replPayload = make([]byte, 0, len(payload)+RDB_EOF_MARK_SIZE)
+	slave.replPayload = append(slave.replPayload, payload...)
+	slave.replPayload = append(slave.replPayload, mark...)
+	slave.repldbsize = int64(len(slave.replPayload))
+	return nil
+}
+
+func sendBulkToSlave(loop *ae.AeLoop, fd int, extra interface{}) {
+	slave := extra.(*RedisClient)
+	if slave.replPreamble != "" {
+		n, err := net.Write(fd, []byte(slave.replPreamble))
+		if net.IsAgain(err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Write error sending RDB preamble to replica: %v\n", err)
+			freeClient(slave)
+			return
+		}
+		slave.replPreamble = slave.replPreamble[n:]
+		if slave.replPreamble != "" {
+			return
+		}
+	}
+	var buf []byte
+	if slave.replPayload != nil {
+		end := slave.repldboff + int64(IO_BUF)
+		if end > slave.repldbsize {
+			end = slave.repldbsize
+		}
+		buf = slave.replPayload[slave.repldboff:end]
+	} else {
+		buf = make([]byte, IO_BUF)
+		n, err := slave.repldbfd.ReadAt(buf, slave.repldboff)
+		if n == 0 {
+			log.Printf("Read error sending DB to replica: %v\n", err)
+			freeClient(slave)
+			return
+		}
+		buf = buf[:n]
+	}
+	nw, err := net.Write(fd, buf)
+	if net.IsAgain(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Write error sending DB to replica: %v\n", err)
+		freeClient(slave)
+		return
+	}
+	slave.repldboff += int64(nw)
+	if slave.repldboff == slave.repldbsize {
+		loop.RemoveFileEvent(fd, ae.FE_WRITABLE)
+		if slave.replPayload != nil {
+			// 从节点无法区分 rdb 与后续命令流，等它加载完成并发送 ACK 后再发送命令
+			slave.replPayload = nil
+			slave.replStartCmdStreamOnAck = true
+		} else {
+			slave.repldbfd.Close()
+			slave.repldbfd = nil
+		}
+		putSlaveOnline(slave)
+	}
+}
+
+func putSlaveOnline(slave *RedisClient) {
+	slave.replState = SLAVE_STATE_ONLINE
+	slave.replAckTime = time.Now().Unix()
+	if slave.reply.Length > 0 && !slave.replStartCmdStreamOnAck {
+		server.aeLoop.AddFileEvent(slave.fd, ae.FE_WRITABLE, SendReplyToClient, slave)
+	}
+	log.Printf("Synchronization with replica %v succeeded\n", slave.fd)
+}
+
+func replicaStartCommandStream(slave *RedisClient) {
+	slave.replStartCmdStreamOnAck = false
+	if slave.reply.Length > 0 {
+		server.aeLoop.AddFileEvent(slave.fd, ae.FE_WRITABLE, SendReplyToClient, slave)
+	}
+}
+
+// syncCommand SYNC 与 PSYNC
+func syncCommand(c *RedisClient) {
+	if c.flags&CLIENT_SLAVE != 0 {
+		return
+	}
+	if server.masterhost != "" && server.replState != REPL_STATE_CONNECTED {
+		c.AddReplyError("-NOMASTERLINK Can't SYNC while not connected with my master")
+		return
+	}
+	if c.reply.Length > 0 {
+		c.AddReplyError("SYNC and PSYNC are invalid with pending output")
+		return
+	}
+	log.Printf("Replica %v asks for synchronization\n", c.fd)
+	if strings.EqualFold(c.args[0].StrVal(), "psync") {
+		if masterTryPartialResynchronization(c) {
+			return
+		}
+	} else {
+		// 旧版 SYNC 协议不需要 +FULLRESYNC
+		c.flags |= CLIENT_PRE_PSYNC
+	}
+	c.flags |= CLIENT_SLAVE
+	server.slaves[c.fd] = c
+	c.replState = SLAVE_STATE_WAIT_BGSAVE_START
+	if server.backlog == nil {
+		changeReplicationId()
+		clearReplicationId2()
+		createReplicationBacklog()
+		server.slaveseldb = -1
+		log.Printf("Replication backlog created, my new replication IDs are '%v' and '%v'\n", server.replid, server.replid2)
+	}
+	// 无盘复制延迟开始，以便一次传输服务多个从节点
+	if server.replDisklessSync && c.slaveCapa&SLAVE_CAPA_EOF != 0 && server.replDisklessSyncDelay > 0 {
+		log.Printf("Delay next BGSAVE for diskless SYNC\n")
+		return
+	}
+	startBgsaveForReplication(c.slaveCapa)
+}
+
+// replconfCommand REPLCONF <option> <value> [<option> <value> ...]
+func replconfCommand(c *RedisClient) {
+	if len(c.args)%2 == 0 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	for j := 1; j < len(c.args); j += 2 {
+		opt := strings.ToLower(c.args[j].StrVal())
+		val := c.args[j+1].StrVal()
+		switch opt {
+		case "listening-port":
+			port, err := strconv.Atoi(val)
+			if err != nil {
+				c.AddReplyError("value is not an integer or out of range")
+				return
+			}
+			c.slaveListeningPort = port
+		case "capa":
+			if strings.EqualFold(val, "eof") {
+				c.slaveCapa |= SLAVE_CAPA_EOF
+			} else if strings.EqualFold(val, "psync2") {
+				c.slaveCapa |= SLAVE_CAPA_PSYNC2
+			}
+		case "ack":
+			// 从节点的心跳，不需要回复
+			if c.flags&CLIENT_SLAVE == 0 {
+				return
+			}
+			offset, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return
+			}
+			if offset > c.replAckOff {
+				c.replAckOff = offset
+			}
+			if c.replStartCmdStreamOnAck && c.replState == SLAVE_STATE_ONLINE {
+				replicaStartCommandStream(c)
+			}
+			// REPLCONF ACK <offset> FACK <aofoffset>
+			if j+3 < len(c.args) && strings.EqualFold(c.args[j+2].StrVal(), "fack") {
+				aofOffset, err := strconv.ParseInt(c.args[j+3].StrVal(), 10, 64)
+				if err == nil && aofOffset > c.replAofOff {
+					c.replAofOff = aofOffset
+				}
+			}
+			c.replAckTime = time.Now().Unix()
+			return
+		case "getack":
+			if server.masterhost != "" && server.master != nil {
+				replicationSendAck()
+			}
+			return
+		default:
+			c.AddReplyError(fmt.Sprintf("Unrecognized REPLCONF option: %s", c.args[j].StrVal()))
+			return
+		}
+	}
+	c.AddReplyStr("+OK\r\n")
+}
+
+// replicaofCommand REPLICAOF <host> <port> | NO ONE
+func replicaofCommand(c *RedisClient) {
+	host := c.args[1].StrVal()
+	portStr := c.args[2].StrVal()
+	if strings.EqualFold(host, "no") && strings.EqualFold(portStr, "one") {
+		if server.masterhost != "" {
+			replicationUnsetMaster()
+			log.Printf("MASTER MODE enabled (user request from fd:%v)\n", c.fd)
+		}
+		c.AddReplyStr("+OK\r\n")
+		return
+	}
+	if c.flags&CLIENT_SLAVE != 0 {
+		c.AddReplyError("Command is not valid when client is a replica.")
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		c.AddReplyError("Invalid master port")
+		return
+	}
+	if server.masterhost == host && server.masterport == port {
+		log.Printf("REPLICAOF would result into synchronization with the master we are already connected with. No operation performed.\n")
+		c.AddReplyStr("+OK Already connected to specified master\r\n")
+		return
+	}
+	replicationSetMaster(host, port)
+	log.Printf("REPLICAOF %v:%v enabled (user request from fd:%v)\n", host, port, c.fd)
+	c.AddReplyStr("+OK\r\n")
+}
+
+func replicationSetMaster(host string, port int) {
+	wasMaster := server.masterhost == ""
+	if server.master != nil {
+		freeClient(server.master)
+	}
+	server.masterhost = host
+	server.masterport = port
+	// 以自身的复制状态作为缓存的主节点，新主节点若曾是自己的从节点，可部分重同步
+	if wasMaster {
+		replicationDiscardCachedMaster()
+		replicationCacheMasterUsingMyself()
+	}
+	cancelReplicationHandshake()
+	server.replState = REPL_STATE_CONNECT
+	log.Printf("Connecting to MASTER %v:%v\n", host, port)
+	connectWithMaster()
+}
+
+func replicationUnsetMaster() {
+	if server.masterhost == "" {
+		return
+	}
+	server.masterhost = ""
+	server.masterport = 0
+	if server.master != nil {
+		freeClient(server.master)
+	}
+	replicationDiscardCachedMaster()
+	cancelReplicationHandshake()
+	shiftReplicationId()
+	// 子从节点需要感知 replid 的变化
+	disconnectSlaves()
+	// 作为主节点的复制流从 SELECT 开始
+	server.slaveseldb = -1
+	server.replState = REPL_STATE_NONE
+}
+
+func disconnectSlaves() {
+	for _, slave := range server.slaves {
+		freeClient(slave)
+	}
+}
+
+func replicationCacheMasterUsingMyself() {
+	log.Printf("Before turning into a replica, using my own master parameters to synthesize a cached master: I may be able to synchronize with the new master with just a partial transfer.\n")
+	c := CreateClient(-1)
+	c.flags |= CLIENT_MASTER | CLIENT_CLOSED
+	c.replid = server.replid
+	c.reploff = server.masterReplOffset
+	c.readReploff = c.reploff
+	server.cachedMaster = c
+}
+
+// replicationCacheMaster 缓存断开的主节点，用于之后的部分重同步
+func replicationCacheMaster(c *RedisClient) {
+	log.Printf("Caching the disconnected master state.\n")
+	server.master = nil
+	// 丢弃未执行完的命令，从已执行的偏移量继续同步
+	c.queryBuf = make([]byte, IO_BUF)
+	c.queryLen = 0
+	c.readReploff = c.reploff
+	c.sentLen = 0
+	resetClient(c)
+	server.cachedMaster = c
+	replicationHandleMasterDisconnection()
+}
+
+func replicationDiscardCachedMaster() {
+	if server.cachedMaster == nil {
+		return
+	}
+	log.Printf("Discarding previously cached master state.\n")
+	server.cachedMaster = nil
+}
+
+func replicationResurrectCachedMaster(fd int) {
+	c := server.cachedMaster
+	server.cachedMaster = nil
+	c.fd = fd
+	c.peerid = ""
+	c.sockname = ""
+	c.flags &^= CLIENT_CLOSED
+	c.lastinteraction = time.Now().Unix()
+	server.master = c
+	linkClient(c)
+	server.replState = REPL_STATE_CONNECTED
+	server.replTransferFd = -1
+	server.aeLoop.AddFileEvent(fd, ae.FE_READABLE, ReadQueryFromClient, c)
+}
+
+func replicationHandleMasterDisconnection() {
+	server.master = nil
+	if server.masterhost != "" {
+		server.replState = REPL_STATE_CONNECT
+	}
+}
+
+func replicationCreateMasterClient(fd int) {
+	c := CreateClient(fd)
+	c.flags |= CLIENT_MASTER
+	c.replid = server.masterReplid
+	c.reploff = server.masterInitialOffset
+	c.readReploff = c.reploff
+	c.lastinteraction = time.Now().Unix()
+	server.master = c
+	linkClient(c)
+	server.aeLoop.AddFileEvent(fd, ae.FE_READABLE, ReadQueryFromClient, c)
+}
+
+// replicationAttachToNewMaster 全量同步会替换数据集，子从节点与积压缓冲区都失效
+func replicationAttachToNewMaster() {
+	replicationDiscardCachedMaster()
+	disconnectSlaves()
+	freeReplicationBacklog()
+}
+
+// replicationSendAck 向主节点汇报已处理的复制偏移量
+func replicationSendAck() {
+	c := server.master
+	c.flags |= CLIENT_MASTER_FORCE_REPLY
+	args := createStrArgs("REPLCONF", "ACK", strconv.FormatInt(c.reploff, 10))
+	c.AddReplyStr(string(catCommandResp(args)))
+	c.flags &^= CLIENT_MASTER_FORCE_REPLY
+}
+
+func slaveIsInHandshakeState() bool {
+	return server.replState >= REPL_STATE_RECEIVE_PING_REPLY &&
+		server.replState <= REPL_STATE_RECEIVE_PSYNC_REPLY
+}
+
+func sendSynchronousCommand(fd int, strs ...string) error {
+	buf := catCommandResp(createStrArgs(strs...))
+	for len(buf) > 0 {
+		n, err := net.Write(fd, buf)
+		if err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// syncReadLine 逐字节读取一行，避免读走后续的复制流
+func syncReadLine(fd int) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < IO_BUF {
+		n, err := net.Read(fd, b)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			return "", errors.New("connection lost")
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("line too long")
+}
+
+func connectWithMaster() error {
+	ip, err := net.ResolveIPv4(server.masterhost)
+	if err != nil {
+		log.Printf("Unable to resolve MASTER %v: %v\n", server.masterhost, err)
+		return err
+	}
+	fd, err := net.Connect(ip, server.masterport)
+	if err != nil {
+		log.Printf("Unable to connect to MASTER: %v\n", err)
+		return err
+	}
+	net.SetTimeout(fd, REPL_SYNCIO_TIMEOUT)
+	server.replTransferFd = fd
+	server.replTransferLastIO = time.Now().Unix()
+	if err = sendSynchronousCommand(fd, "PING"); err != nil {
+		log.Printf("Error sending PING to MASTER: %v\n", err)
+		undoConnectWithMaster()
+		return err
+	}
+	server.replState = REPL_STATE_RECEIVE_PING_REPLY
+	server.aeLoop.AddFileEvent(fd, ae.FE_READABLE, syncWithMaster, nil)
+	log.Printf("MASTER <-> REPLICA sync started\n")
+	return nil
+}
+
+func undoConnectWithMaster() {
+	fd := server.replTransferFd
+	server.aeLoop.RemoveFileEvent(fd, ae.FE_READABLE)
+	net.Close(fd)
+	server.replTransferFd = -1
+}
+
+func replicationAbortSyncTransfer() {
+	undoConnectWithMaster()
+	if server.replTransferTmpfile != nil {
+		server.replTransferTmpfile.Close()
+		os.Remove(server.replTransferTmpfile.Name())
+		server.replTransferTmpfile = nil
+	}
+}
+
+// cancelReplicationHandshake 中止正在进行的握手或传输，由 replicationCron 重连
+func cancelReplicationHandshake() {
+	if server.replState == REPL_STATE_TRANSFER {
+		replicationAbortSyncTransfer()
+		server.replState = REPL_STATE_CONNECT
+	} else if slaveIsInHandshakeState() {
+		undoConnectWithMaster()
+		server.replState = REPL_STATE_CONNECT
+	}
+}
+
+// syncWithMaster 从节点握手：PING -> AUTH -> REPLCONF -> PSYNC
+func syncWithMaster(loop *ae.AeLoop, fd int, extra interface{}) {
+	if err := syncWithMasterStep(fd); err != nil {
+		log.Printf("MASTER <-> REPLICA sync error: %v\n", err)
+		cancelReplicationHandshake()
+	}
+}
+
+func syncWithMasterStep(fd int) error {
+	reply, err := syncReadLine(fd)
+	if err != nil {
+		return err
+	}
+	server.replTransferLastIO = time.Now().Unix()
+	switch server.replState {
+	case REPL_STATE_RECEIVE_PING_REPLY:
+		// 主节点开启认证时 PING 会返回 NOAUTH，握手仍可继续
+		if strings.HasPrefix(reply, "-") &&
+			!strings.HasPrefix(reply, "-NOAUTH") &&
+			!strings.HasPrefix(reply, "-NOPERM") &&
+			!strings.HasPrefix(reply, "-ERR operation not permitted") {
+			return fmt.Errorf("error reply to PING from master: '%s'", reply)
+		}
+		log.Printf("Master replied to PING, replication can continue...\n")
+		if server.masterauth != "" {
+			if err = sendSynchronousCommand(fd, "AUTH", server.masterauth); err != nil {
+				return err
+			}
+			server.replState = REPL_STATE_RECEIVE_AUTH_REPLY
+			return nil
+		}
+		if err = sendSynchronousCommand(fd, "REPLCONF", "listening-port", strconv.Itoa(server.port)); err != nil {
+			return err
+		}
+		server.replState = REPL_STATE_RECEIVE_PORT_REPLY
+	case REPL_STATE_RECEIVE_AUTH_REPLY:
+		if strings.HasPrefix(reply, "-") {
+			return fmt.Errorf("unable to AUTH to MASTER: %s", reply)
+		}
+		if err = sendSynchronousCommand(fd, "REPLCONF", "listening-port", strconv.Itoa(server.port)); err != nil {
+			return err
+		}
+		server.replState = REPL_STATE_RECEIVE_PORT_REPLY
+	case REPL_STATE_RECEIVE_PORT_REPLY:
+		if strings.HasPrefix(reply, "-") {
+			log.Printf("(Non critical) Master does not understand REPLCONF listening-port: %v\n", reply)
+		}
+		if err = sendSynchronousCommand(fd, "REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+			return err
+		}
+		server.replState = REPL_STATE_RECEIVE_CAPA_REPLY
+	case REPL_STATE_RECEIVE_CAPA_REPLY:
+		if strings.HasPrefix(reply, "-") {
+			log.Printf("(Non critical) Master does not understand REPLCONF capa: %v\n", reply)
+		}
+		if err = slaveSendPsync(fd); err != nil {
+			return err
+		}
+		server.replState = REPL_STATE_RECEIVE_PSYNC_REPLY
+	case REPL_STATE_RECEIVE_PSYNC_REPLY:
+		return slaveProcessPsyncReply(fd, reply)
+	}
+	return nil
+}
+
+func slaveSendPsync(fd int) error {
+	replid := "?"
+	offset := int64(-1)
+	if server.cachedMaster != nil {
+		replid = server.cachedMaster.replid
+		offset = server.cachedMaster.reploff + 1
+		log.Printf("Trying a partial resynchronization (request %v:%v).\n", replid, offset)
+	} else {
+		log.Printf("Partial resynchronization not possible (no cached master)\n")
+	}
+	return sendSynchronousCommand(fd, "PSYNC", replid, strconv.FormatInt(offset, 10))
+}
+
+func slaveProcessPsyncReply(fd int, reply string) error {
+	// 主节点在回复 PSYNC 之前可能发送空行保活
+	if reply == "" {
+		return nil
+	}
+	if strings.HasPrefix(reply, "+FULLRESYNC") {
+		fields := strings.Fields(reply)
+		server.masterReplid = ""
+		server.masterInitialOffset = -1
+		if len(fields) == 3 && len(fields[1]) == CONFIG_RUN_ID_SIZE {
+			server.masterReplid = fields[1]
+			server.masterInitialOffset, _ = strconv.ParseInt(fields[2], 10, 64)
+			log.Printf("Full resync from master: %v:%v\n", server.masterReplid, server.masterInitialOffset)
+		} else {
+			log.Printf("Master replied with wrong +FULLRESYNC syntax.\n")
+		}
+		replicationDiscardCachedMaster()
+		return readyForTransfer(fd)
+	}
+	if strings.HasPrefix(reply, "+CONTINUE") && server.cachedMaster != nil {
+		log.Printf("Successful partial resynchronization with master.\n")
+		fields := strings.Fields(reply)
+		if len(fields) == 2 && len(fields[1]) == CONFIG_RUN_ID_SIZE && fields[1] != server.cachedMaster.replid {
+			// 主节点发生了切换，保留旧 replid 供子从节点部分重同步
+			server.replid2 = server.cachedMaster.replid
+			server.secondReplidOffset = server.masterReplOffset + 1
+			server.replid = fields[1]
+			server.cachedMaster.replid = fields[1]
+			log.Printf("Master replication ID changed to %v\n", server.replid)
+			disconnectSlaves()
+		}
+		server.aeLoop.RemoveFileEvent(fd, ae.FE_READABLE)
+		replicationResurrectCachedMaster(fd)
+		// 主节点身份可能来自 replicationCacheMasterUsingMyself，积压缓冲区需要存在
+		if server.backlog == nil {
+			createReplicationBacklog()
+		}
+		return nil
+	}
+	if strings.HasPrefix(reply, "-NOMASTERLINK") || strings.HasPrefix(reply, "-LOADING") {
+		return fmt.Errorf("master is currently unable to PSYNC but should be in the future: %s", reply)
+	}
+	return fmt.Errorf("unexpected reply to PSYNC from master: %s", reply)
+}
+
+func readyForTransfer(fd int) error {
+	tmpfile := fmt.Sprintf("temp-%d.%d.rdb", time.Now().Unix(), os.Getpid())
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		return fmt.Errorf("opening the temp file needed for MASTER <-> REPLICA synchronization: %v", err)
+	}
+	server.replTransferTmpfile = f
+	server.replTransferSize = -1
+	server.replTransferRead = 0
+	server.replTransferLastIO = time.Now().Unix()
+	server.replState = REPL_STATE_TRANSFER
+	server.aeLoop.RemoveFileEvent(fd, ae.FE_READABLE)
+	server.aeLoop.AddFileEvent(fd, ae.FE_READABLE, readSyncBulkPayload, nil)
+	return nil
+}
+
+// useDisklessLoad 是否直接从 socket 加载 rdb
+func useDisklessLoad() bool {
+	return server.replDisklessLoad == "swapdb" ||
+		(server.replDisklessLoad == "on-empty-db" && dbTotalServerKeyCount() == 0)
+}
+
+// connReader 以阻塞方式从 socket 读取
+type connReader struct {
+	fd int
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	n, err := net.Read(r.fd, p)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	server.replTransferLastIO = time.Now().Unix()
+	return n, nil
+}
+
+// readSyncBulkPayload 接收主节点发送的 rdb
+// 格式为 $<len>\r\n<rdb> 或者无盘复制的 $EOF:<mark>\r\n<rdb><mark>
+func readSyncBulkPayload(loop *ae.AeLoop, fd int, extra interface{}) {
+	if server.replTransferSize == -1 {
+		line, err := syncReadLine(fd)
+		if err != nil {
+			log.Printf("I/O error reading bulk count from MASTER: %v\n", err)
+			cancelReplicationHandshake()
+			return
+		}
+		server.replTransferLastIO = time.Now().Unix()
+		if line == "" {
+			// 主节点生成 rdb 期间发送的换行，用于保活
+			return
+		}
+		if line[0] == '-' {
+			log.Printf("MASTER aborted replication with an error: %s\n", line)
+			cancelReplicationHandshake()
+			return
+		}
+		if line[0] != '$' {
+			log.Printf("Bad protocol from MASTER, the first byte is not '$' (we received '%s'), are you sure the host and port are right?\n", line)
+			cancelReplicationHandshake()
+			return
+		}
+		target := "disk"
+		if useDisklessLoad() {
+			target = "parser"
+		}
+		if strings.HasPrefix(line, "$EOF:") && len(line) >= 5+RDB_EOF_MARK_SIZE {
+			server.replTransferEofmark = line[5 : 5+RDB_EOF_MARK_SIZE]
+			server.replTransferLastbytes = server.replTransferLastbytes[:0]
+			server.replTransferSize = 0
+			log.Printf("MASTER <-> REPLICA sync: receiving streamed RDB from master with EOF to %v\n", target)
+		} else {
+			server.replTransferEofmark = ""
+			server.replTransferSize, err = strconv.ParseInt(line[1:], 10, 64)
+			if err != nil || server.replTransferSize < 0 {
+				log.Printf("Bad bulk length from MASTER: %s\n", line)
+				cancelReplicationHandshake()
+				return
+			}
+			log.Printf("MASTER <-> REPLICA sync: receiving %v bytes from master to %v\n", server.replTransferSize, target)
+		}
+		if target == "disk" {
+			return
+		}
+		// 无盘加载，rdb 直接从 socket 读取，加载期间阻塞事件循环
+		loop.RemoveFileEvent(fd, ae.FE_READABLE)
+		server.replTransferTmpfile.Close()
+		os.Remove(server.replTransferTmpfile.Name())
+		server.replTransferTmpfile = nil
+		if err = readSyncBulkPayloadDiskless(fd); err != nil {
+			log.Printf("Failed trying to load the MASTER synchronization DB from socket: %v\n", err)
+			cancelReplicationHandshake()
+			return
+		}
+		replicationFinishSync(fd)
+		replicationSendAck()
+		return
+	}
+
+	usemark := server.replTransferEofmark != ""
+	readlen := int64(IO_BUF)
+	if !usemark && server.replTransferSize-server.replTransferRead < readlen {
+		readlen = server.replTransferSize - server.replTransferRead
+	}
+	buf := make([]byte, readlen)
+	n, err := net.Read(fd, buf)
+	if err != nil || n == 0 {
+		log.Printf("I/O error trying to sync with MASTER: connection lost\n")
+		cancelReplicationHandshake()
+		return
+	}
+	buf = buf[:n]
+	server.replTransferLastIO = time.Now().Unix()
+	eofReached := false
+	if usemark {
+		// 保留最后 RDB_EOF_MARK_SIZE 个字节，用于判断是否读到了结束标记
+		server.replTransferLastbytes = append(server.replTransferLastbytes, buf...)
+		if l := len(server.replTransferLastbytes); l > RDB_EOF_MARK_SIZE {
+			server.replTransferLastbytes = server.replTransferLastbytes[l-RDB_EOF_MARK_SIZE:]
+		}
+		eofReached = string(server.replTransferLastbytes) == server.replTransferEofmark
+	}
+	if _, err = server.replTransferTmpfile.Write(buf); err != nil {
+		log.Printf("Write error or short write writing to the DB dump file needed for MASTER <-> REPLICA synchronization: %v\n", err)
+		cancelReplicationHandshake()
+		return
+	}
+	server.replTransferRead += int64(n)
+	if usemark {
+		if !eofReached {
+			return
+		}
+		// 去掉文件末尾的结束标记
+		if err = server.replTransferTmpfile.Truncate(server.replTransferRead - int64(RDB_EOF_MARK_SIZE)); err != nil {
+			log.Printf("Error truncating the RDB file received from the master for SYNC: %v\n", err)
+			cancelReplicationHandshake()
+			return
+		}
+	} else if server.replTransferRead < server.replTransferSize {
+		return
+	}
+
+	tmpfile := server.replTransferTmpfile
+	server.replTransferTmpfile = nil
+	err = tmpfile.Sync()
+	tmpfile.Close()
+	if err == nil {
+		err = os.Rename(tmpfile.Name(), server.dbfilename)
+	}
+	if err != nil {
+		log.Printf("Failed trying to rename the temp DB into %v in MASTER <-> REPLICA synchronization: %v\n", server.dbfilename, err)
+		os.Remove(tmpfile.Name())
+		cancelReplicationHandshake()
+		return
+	}
+	loop.RemoveFileEvent(fd, ae.FE_READABLE)
+	replicationAttachToNewMaster()
+	log.Printf("MASTER <-> REPLICA sync: Flushing old data\n")
+	emptyData()
+	log.Printf("MASTER <-> REPLICA sync: Loading DB in memory\n")
+	if err = rdbLoad(server.dbfilename, server.db); err != nil {
+		log.Printf("Failed trying to load the MASTER synchronization DB from disk: %v\n", err)
+		cancelReplicationHandshake()
+		emptyData()
+		return
+	}
+	replicationFinishSync(fd)
+	if usemark {
+		// 主节点收到 ACK 后才开始发送命令流
+		replicationSendAck()
+	}
+}
+
+// readSyncBulkPayloadDiskless 从 socket 加载 rdb
+// swapdb 模式加载到新的 db 中，成功后再替换，失败时保留旧数据
+func readSyncBulkPayloadDiskless(fd int) error {
+	net.SetTimeout(fd, server.replTimeout*1000)
+	defer net.SetTimeout(fd, REPL_SYNCIO_TIMEOUT)
+
+	var rd io.Reader = &connReader{fd: fd}
+	usemark := server.replTransferEofmark != ""
+	if !usemark {
+		rd = io.LimitReader(rd, server.replTransferSize)
+	}
+	// 主节点在收到 ACK 前不会发送命令流，预读不会读走后续数据
+	br := bufio.NewReaderSize(rd, IO_BUF)
+
+	replicationAttachToNewMaster()
+	dbs := server.db
+	if server.replDisklessLoad == "swapdb" {
+		dbs = createDBArray()
+	} else {
+		log.Printf("MASTER <-> REPLICA sync: Flushing old data\n")
+		emptyData()
+	}
+	log.Printf("MASTER <-> REPLICA sync: Loading DB in memory\n")
+	err := rdbLoadRio(br, dbs)
+	if err == nil && usemark {
+		mark := make([]byte, RDB_EOF_MARK_SIZE)
+		if _, err = io.ReadFull(br, mark); err == nil && string(mark) != server.replTransferEofmark {
+			err = errors.New("replication stream EOF marker is broken")
+		}
+	}
+	if err != nil {
+		if dbs[0] == server.db[0] {
+			emptyData()
+		} else {
+			log.Printf("MASTER <-> REPLICA sync: Discarding the half-loaded data\n")
+		}
+		return err
+	}
+	if dbs[0] != server.db[0] {
+		log.Printf("MASTER <-> REPLICA sync: Swapping the loaded data with the old one\n")
+		for i, db := range dbs {
+			replaceDBData(server.db[i], db)
+		}
+	}
+	return nil
+}
+
+// replicationFinishSync 数据加载完成，开始接收主节点的命令流
+func replicationFinishSync(fd int) {
+	replicationCreateMasterClient(fd)
+	server.replTransferFd = -1
+	server.replState = REPL_STATE_CONNECTED
+	server.replid = server.masterReplid
+	server.masterReplOffset = server.masterInitialOffset
+	clearReplicationId2()
+	createReplicationBacklog()
+	log.Printf("MASTER <-> REPLICA sync: Finished with success\n")
+}
+
+// replicationCron 每秒执行一次
+func replicationCron() {
+	now := time.Now().Unix()
+
+	if server.masterhost != "" && (slaveIsInHandshakeState() || server.replState == REPL_STATE_TRANSFER) &&
+		now-server.replTransferLastIO > server.replTimeout {
+		log.Printf("Timeout connecting to the MASTER...\n")
+		cancelReplicationHandshake()
+	}
+	if server.master != nil && now-server.master.lastinteraction > server.replTimeout {
+		log.Printf("MASTER timeout: no data nor PING received...\n")
+		freeClient(server.master)
+	}
+	if server.masterhost != "" && server.replState == REPL_STATE_CONNECT {
+		log.Printf("Connecting to MASTER %v:%v\n", server.masterhost, server.masterport)
+		connectWithMaster()
+	}
+	if server.master != nil {
+		replicationSendAck()
+	}
+
+	// 主节点定期 PING 从节点，让从节点可以检测超时
+	if server.masterhost == "" && len(server.slaves) > 0 && server.replPingPeriod > 0 &&
+		server.replCronLoops%server.replPingPeriod == 0 {
+		replicationFeedSlaves(server.slaveseldb, createStrArgs("PING"))
+	}
+	// 等待 rdb 的从节点没有命令流，发送换行防止超时
+	for _, slave := range server.slaves {
+		if slave.replState == SLAVE_STATE_WAIT_BGSAVE_START {
+			net.Write(slave.fd, []byte("\n"))
+		}
+	}
+	for _, slave := range server.slaves {
+		if slave.replState != SLAVE_STATE_ONLINE || slave.flags&CLIENT_PRE_PSYNC != 0 {
+			continue
+		}
+		if now-slave.replAckTime > server.replTimeout {
+			log.Printf("Disconnecting timedout replica: %v\n", slave.fd)
+			freeClient(slave)
+		}
+	}
+	replicationStartPendingFork(now)
+	server.replCronLoops++
+}
+
+// replicationStartPendingFork 无盘复制延迟结束后，为所有等待的从节点开始同步
+func replicationStartPendingFork(now int64) {
+	idle, mincapa, waiting := int64(0), -1, 0
+	for _, slave := range server.slaves {
+		if slave.replState != SLAVE_STATE_WAIT_BGSAVE_START {
+			continue
+		}
+		if t := now - slave.lastinteraction; t > idle {
+			idle = t
+		}
+		mincapa &= slave.slaveCapa
+		waiting++
+	}
+	if waiting > 0 && (!server.replDisklessSync || idle >= server.replDisklessSyncDelay) {
+		startBgsaveForReplication(mincapa)
+	}
+}
+
+func replicationCountAcksByOffset(offset int64) int {
+	count := 0
+	for _, slave := range server.slaves {
+		if slave.replState == SLAVE_STATE_ONLINE && slave.replAckOff >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// replicationCountAOFAcksByOffset 已将 offset 之前的数据 fsync 到 AOF 的从节点数量
+func replicationCountAOFAcksByOffset(offset int64) int {
+	count := 0
+	for _, slave := range server.slaves {
+		if slave.replState == SLAVE_STATE_ONLINE && slave.replAofOff >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// waitCommand WAIT numreplicas timeout
+func waitCommand(c *RedisClient) {
+	if server.masterhost != "" {
+		c.AddReplyError("WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+		return
+	}
+	numreplicas, err := strconv.Atoi(c.args[1].StrVal())
+	if err != nil {
+		c.AddReplyError("value is not an integer or out of range")
+		return
+	}
+	timeout, ok := getTimeoutFromObjectOrReply(c, c.args[2], UNIT_MILLISECONDS)
+	if !ok {
+		return
+	}
+	ackreplicas := replicationCountAcksByOffset(c.woff)
+	if ackreplicas >= numreplicas || c.flags&CLIENT_DENY_BLOCKING != 0 {
+		c.AddReplyInt(int64(ackreplicas))
+		return
+	}
+	c.bpop.timeout = timeout
+	c.bpop.reploffset = c.woff
+	c.bpop.numreplicas = numreplicas
+	server.clientsWaitingAcks = append(server.clientsWaitingAcks, c)
+	blockClient(c, BLOCKED_WAIT)
+	// 在 beforeSleep 中请求从节点尽快确认
+	server.getAckFromSlaves = true
+}
+
+func addReplyWaitaof(c *RedisClient, numlocal, numreplicas int) {
+	c.AddReplyArrayLen(2)
+	c.AddReplyInt(int64(numlocal))
+	c.AddReplyInt(int64(numreplicas))
+}
+
+// waitaofCommand WAITAOF numlocal numreplicas timeout
+// 本实例没有 AOF，numlocal 只能为 0；从节点的 fsync 偏移量通过 REPLCONF ACK ... FACK 汇报
+func waitaofCommand(c *RedisClient) {
+	if server.masterhost != "" {
+		c.AddReplyError("WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+		return
+	}
+	numlocal, err := strconv.Atoi(c.args[1].StrVal())
+	if err != nil || numlocal < 0 {
+		c.AddReplyError("value is out of range, must be positive")
+		return
+	}
+	numreplicas, err := strconv.Atoi(c.args[2].StrVal())
+	if err != nil || numreplicas < 0 {
+		c.AddReplyError("value is out of range, must be positive")
+		return
+	}
+	timeout, ok := getTimeoutFromObjectOrReply(c, c.args[3], UNIT_MILLISECONDS)
+	if !ok {
+		return
+	}
+	if numlocal > 0 {
+		c.AddReplyError("WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+		return
+	}
+	ackreplicas := replicationCountAOFAcksByOffset(c.woff)
+	if ackreplicas >= numreplicas || c.flags&CLIENT_DENY_BLOCKING != 0 {
+		addReplyWaitaof(c, 0, ackreplicas)
+		return
+	}
+	c.bpop.timeout = timeout
+	c.bpop.reploffset = c.woff
+	c.bpop.numreplicas = numreplicas
+	c.bpop.numlocal = numlocal
+	server.clientsWaitingAcks = append(server.clientsWaitingAcks, c)
+	blockClient(c, BLOCKED_WAITAOF)
+	server.getAckFromSlaves = true
+}
+
+func unblockClientWaitingReplicas(c *RedisClient) {
+	for i, wc := range server.clientsWaitingAcks {
+		if wc == c {
+			server.clientsWaitingAcks = append(server.clientsWaitingAcks[:i], server.clientsWaitingAcks[i+1:]...)
+			break
+		}
+	}
+}
+
+// processClientsWaitingReplicas 在 beforeSleep 中检查等待从节点确认的客户端
+func processClientsWaitingReplicas() {
+	waiting := make([]*RedisClient, len(server.clientsWaitingAcks))
+	copy(waiting, server.clientsWaitingAcks)
+	for _, c := range waiting {
+		if c.btype == BLOCKED_WAIT {
+			numreplicas := replicationCountAcksByOffset(c.bpop.reploffset)
+			if numreplicas >= c.bpop.numreplicas {
+				c.AddReplyInt(int64(numreplicas))
+				unblockClient(c)
+			}
+		} else {
+			numreplicas := replicationCountAOFAcksByOffset(c.bpop.reploffset)
+			if numreplicas >= c.bpop.numreplicas {
+				addReplyWaitaof(c, 0, numreplicas)
+				unblockClient(c)
+			}
+		}
+	}
+}
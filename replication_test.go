@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"go-redis/ae"
+	"go-redis/conf"
+	"go-redis/obj"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestReplBacklog(t *testing.T) {
+	server.replBacklogSize = 8
+	server.masterReplOffset = 0
+	createReplicationBacklog()
+
+	feedReplicationBacklog([]byte("abcde"))
+	assert.Equal(t, int64(5), server.masterReplOffset)
+	assert.Equal(t, int64(1), server.backlog.offset)
+	assert.Equal(t, "cde", string(server.backlog.copyFrom(3)))
+
+	// 写满后覆盖最旧的数据
+	feedReplicationBacklog([]byte("fghij"))
+	assert.Equal(t, int64(10), server.masterReplOffset)
+	assert.Equal(t, int64(8), server.backlog.histlen)
+	assert.Equal(t, int64(3), server.backlog.offset)
+	assert.Equal(t, "cdefghij", string(server.backlog.copyFrom(3)))
+	assert.Equal(t, "hij", string(server.backlog.copyFrom(8)))
+	assert.Equal(t, "", string(server.backlog.copyFrom(11)))
+}
+
+func TestReplicationFeedSlavesWithoutBacklog(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	freeReplicationBacklog()
+	server.masterReplOffset = 0
+	feedReplicationBacklog([]byte("abc"))
+	assert.Nil(t, server.backlog)
+
+	slave := CreateClient(-1)
+	slave.flags |= CLIENT_SLAVE
+	slave.replState = SLAVE_STATE_ONLINE
+	server.slaves[slave.fd] = slave
+	replicationFeedSlaves(0, createStrArgs("SET", "k", "v"))
+	assert.NotNil(t, server.backlog)
+	assert.Equal(t, server.masterReplOffset, server.backlog.histlen)
+	assert.Equal(t, string(server.backlog.copyFrom(1)), slave.reply.Head.Val.StrVal())
+}
+
+func TestReplicationWriteDuringDisklessSyncDelay(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	freeReplicationBacklog()
+	slave, peer := createSocketClient(t)
+	defer unix.Close(peer)
+	slave.slaveCapa = SLAVE_CAPA_EOF
+	ReadQuery(slave, "psync ? -1\r\n")
+	assert.Nil(t, ProcessQueryBuf(slave))
+	assert.Equal(t, SLAVE_STATE_WAIT_BGSAVE_START, slave.replState)
+
+	// 延迟期间的写命令只进入积压缓冲区，从节点从 rdb 中得到这些数据
+	c := CreateClient(-1)
+	ReadQuery(c, "rpush l a b\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, 0, slave.reply.Length)
+
+	startBgsaveForReplication(SLAVE_CAPA_EOF)
+	assert.Equal(t, SLAVE_STATE_SEND_BULK, slave.replState)
+	assert.Equal(t, server.masterReplOffset, slave.psyncInitialOffset)
+	assert.Equal(t, 0, slave.reply.Length)
+	dbs := createDBArray()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(slave.replPayload), dbs))
+	assert.Equal(t, 2, dbs[0].data.Get(obj.CreateObject(obj.STR, "l")).Val.(*obj.List).Length)
+}
+
+func TestMasterTryPartialResynchronization(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	createReplicationBacklog()
+	feedReplicationBacklog([]byte("*1\r\n$4\r\nPING\r\n"))
+
+	c := CreateClient(-1)
+	c.args = createStrArgs("PSYNC", "?", "-1")
+	assert.False(t, masterTryPartialResynchronization(c))
+
+	start := server.backlog.offset
+	c.args = createStrArgs("PSYNC", server.replid, strconv.FormatInt(start+100, 10))
+	assert.False(t, masterTryPartialResynchronization(c))
+
+	c.args = createStrArgs("PSYNC", server.replid, strconv.FormatInt(start, 10))
+	assert.True(t, masterTryPartialResynchronization(c))
+	assert.Equal(t, SLAVE_STATE_ONLINE, c.replState)
+	assert.Equal(t, "+CONTINUE "+server.replid+"\r\n", c.reply.Head.Val.StrVal())
+	assert.Equal(t, "*1\r\n$4\r\nPING\r\n", c.reply.Tail.Val.StrVal())
+}
+
+func TestRdbSaveLoad(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	server.db[0].data.Set(obj.CreateObject(obj.STR, "k1"), obj.CreateObject(obj.STR, "v1"))
+	server.db[0].data.Set(obj.CreateObject(obj.STR, "k2"), obj.CreateObject(obj.STR, string(make([]byte, 20000))))
+	when := ae.GetMsTime() + 100000
+	server.db[0].expire.Set(obj.CreateObject(obj.STR, "k1"), obj.CreateFromInt(when))
+
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+
+	emptyData()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db))
+	assert.Equal(t, int64(2), server.db[0].data.Len())
+	assert.Equal(t, "v1", server.db[0].data.Get(obj.CreateObject(obj.STR, "k1")).StrVal())
+	assert.Equal(t, 20000, len(server.db[0].data.Get(obj.CreateObject(obj.STR, "k2")).StrVal()))
+	assert.Equal(t, when, server.db[0].expire.Get(obj.CreateObject(obj.STR, "k1")).IntVal())
+
+	// 校验和错误
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+	emptyData()
+	assert.NotNil(t, rdbLoadRio(bytes.NewReader(data), server.db))
+}
+
+func TestWaitCommand(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	createReplicationBacklog()
+	slave := CreateClient(-2)
+	slave.flags |= CLIENT_SLAVE
+	slave.replState = SLAVE_STATE_ONLINE
+	server.slaves[slave.fd] = slave
+
+	c := CreateClient(-1)
+	ReadQuery(c, "set k v\r\nwait 1 0\r\nget k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, server.masterReplOffset, c.woff)
+	assert.NotEqual(t, 0, c.flags&CLIENT_BLOCKED)
+	// GET 留在缓冲区中，等待 WAIT 返回
+	assert.Equal(t, 1, c.reply.Length)
+
+	slave.args = createStrArgs("REPLCONF", "ACK", strconv.FormatInt(c.woff, 10))
+	ProcessCommand(slave)
+	beforeSleep(server.aeLoop)
+	assert.Equal(t, 0, c.flags&CLIENT_BLOCKED)
+	assert.Equal(t, 3, c.reply.Length)
+	c.reply.DelNode(c.reply.Head)
+	assert.Equal(t, ":1\r\n", c.reply.Head.Val.StrVal())
+	assert.Equal(t, "$1\r\nv\r\n", c.reply.Tail.Val.StrVal())
+}
+
+func TestReplicationSetupSlaveDiskless(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	server.db[0].data.Set(obj.CreateObject(obj.STR, "k"), obj.CreateObject(obj.STR, "v"))
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+
+	slave := CreateClient(-1)
+	assert.Nil(t, replicationSetupSlaveDiskless(slave, buf.Bytes()))
+	assert.Equal(t, 5+RDB_EOF_MARK_SIZE+2, len(slave.replPreamble))
+	mark := slave.replPreamble[5 : 5+RDB_EOF_MARK_SIZE]
+	assert.Equal(t, int64(buf.Len()+RDB_EOF_MARK_SIZE), slave.repldbsize)
+	assert.Equal(t, mark, string(slave.replPayload[buf.Len():]))
+
+	dbs := createDBArray()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(slave.replPayload), dbs))
+	assert.Equal(t, "v", dbs[0].data.Get(obj.CreateObject(obj.STR, "k")).StrVal())
+}
+
+func TestMonitor(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	m := CreateClient(-1)
+	ReadQuery(m, "monitor\r\nmonitor\r\n")
+	assert.Nil(t, ProcessQueryBuf(m))
+	assert.Equal(t, "+OK\r\n", allReplies(m))
+	assert.Equal(t, "O", clientFlagsString(m))
+
+	c := CreateClient(-1)
+	ReadQuery(c, "select 2\r\nset k \"a b\\n\"\r\nauth foo bar\r\nclient list\r\nmulti\r\nget k\r\nmonitor\r\nexec\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Contains(t, allReplies(c), "-ERR MONITOR isn't allowed for DENY BLOCKING client\r\n")
+	lines := regexp.MustCompile(`\+\d+\.\d{6} `).ReplaceAllString(allReplies(m), "")
+	assert.Equal(t, "[2 ?:0] \"select\" \"2\"\r\n"+
+		"[2 ?:0] \"set\" \"k\" \"a b\\n\"\r\n"+
+		"[2 ?:0] \"auth\" \"(redacted)\" \"(redacted)\"\r\n"+
+		"[2 ?:0] \"multi\"\r\n"+
+		"[2 ?:0] \"get\" \"k\"\r\n"+
+		"[2 ?:0] \"exec\"\r\n", lines)
+
+	freeClient(m)
+	assert.Equal(t, 0, len(server.monitors))
+}
+
+func TestMonitorNotReading(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer unix.Close(fds[1])
+	m := acceptCommonHandler(fds[0], "127.0.0.1")
+	_, err = unix.Write(fds[1], []byte("monitor\r\n"))
+	assert.Nil(t, err)
+	ReadQueryFromClient(server.aeLoop, m.fd, m)
+	// 没有数据可读时不断开连接
+	ReadQueryFromClient(server.aeLoop, m.fd, m)
+	assert.Equal(t, 0, m.flags&CLIENT_CLOSED)
+
+	// 监视器不读取回复，socket 缓冲区写满后停止发送，保留写事件
+	c := CreateClient(-1)
+	args := createStrArgs("set", "k", strings.Repeat("x", 64*1024))
+	for i := 0; i < 64; i++ {
+		replicationFeedMonitors(c, server.monitors, 0, args)
+	}
+	SendReplyToClient(server.aeLoop, m.fd, m)
+	assert.Equal(t, 0, m.flags&CLIENT_CLOSED)
+	assert.True(t, m.reply.Length > 0)
+	assert.NotNil(t, server.aeLoop.FileEvents[-m.fd])
+	freeClient(m)
+}
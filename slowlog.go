@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// 慢日志记录的参数个数与单个参数长度上限，超出的部分以说明代替
+const (
+	SLOWLOG_ENTRY_MAX_ARGC   = 32
+	SLOWLOG_ENTRY_MAX_STRING = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     int64 // 秒
+	duration int64 // 微秒
+	args     []string
+	peerid   string
+	cname    string
+}
+
+func slowlogInit() {
+	server.slowlog = nil
+	server.slowlogEntryId = 0
+}
+
+// slowlogCreateEntry 复制并截断命令参数
+func slowlogCreateEntry(c *RedisClient, duration int64) *slowlogEntry {
+	argc := len(c.args)
+	if argc > SLOWLOG_ENTRY_MAX_ARGC {
+		argc = SLOWLOG_ENTRY_MAX_ARGC
+	}
+	args := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		if i == argc-1 && argc != len(c.args) {
+			args[i] = fmt.Sprintf("... (%d more arguments)", len(c.args)-argc+1)
+			break
+		}
+		arg := c.args[i].StrVal()
+		if len(arg) > SLOWLOG_ENTRY_MAX_STRING {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:SLOWLOG_ENTRY_MAX_STRING], len(arg)-SLOWLOG_ENTRY_MAX_STRING)
+		}
+		args[i] = arg
+	}
+	e := &slowlogEntry{
+		id:       server.slowlogEntryId,
+		time:     time.Now().Unix(),
+		duration: duration,
+		args:     args,
+		peerid:   getClientPeerId(c),
+		cname:    c.name,
+	}
+	server.slowlogEntryId++
+	return e
+}
+
+// slowlogPushEntryIfNeeded 执行时间超过 slowlog-log-slower-than 微秒时记录命令，
+// 配置为负数时不记录，最新的记录在前，超过 slowlog-max-len 时删除最旧的记录
+func slowlogPushEntryIfNeeded(c *RedisClient, duration int64) {
+	if server.slowlogLogSlowerThan < 0 || duration < server.slowlogLogSlowerThan {
+		return
+	}
+	server.slowlog = append([]*slowlogEntry{slowlogCreateEntry(c, duration)}, server.slowlog...)
+	if len(server.slowlog) > server.slowlogMaxLen {
+		server.slowlog = server.slowlog[:server.slowlogMaxLen]
+	}
+}
+
+// slowlogCommand SLOWLOG GET [count] | LEN | RESET | HELP
+func slowlogCommand(c *RedisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "reset" && len(c.args) == 2:
+		server.slowlog = nil
+		c.AddReplyStr("+OK\r\n")
+	case sub == "len" && len(c.args) == 2:
+		c.AddReplyInt(int64(len(server.slowlog)))
+	case sub == "get" && (len(c.args) == 2 || len(c.args) == 3):
+		count := int64(10)
+		if len(c.args) == 3 {
+			var ok bool
+			if count, ok = getRangeLongFromObjectOrReply(c, c.args[2], -1, math.MaxInt64, "count should be greater than or equal to -1"); !ok {
+				return
+			}
+		}
+		if count == -1 || count > int64(len(server.slowlog)) {
+			count = int64(len(server.slowlog))
+		}
+		c.AddReplyArrayLen(int(count))
+		for _, e := range server.slowlog[:count] {
+			c.AddReplyArrayLen(6)
+			c.AddReplyInt(e.id)
+			c.AddReplyInt(e.time)
+			c.AddReplyInt(e.duration)
+			c.AddReplyArrayLen(len(e.args))
+			for _, arg := range e.args {
+				c.AddReplyBulk(arg)
+			}
+			c.AddReplyBulk(e.peerid)
+			c.AddReplyBulk(e.cname)
+		}
+	case sub == "help" && len(c.args) == 2:
+		addReplyHelp(c, []string{
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"    Entries are made of:",
+			"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+			"    client name",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+		})
+	default:
+		addReplySubcommandSyntaxError(c)
+	}
+}
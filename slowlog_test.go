@@ -0,0 +1,57 @@
+package main
+
+import (
+	"go-redis/conf"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowlog(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.SlowlogLogSlowerThan = 0
+	cfg.SlowlogMaxLen = 3
+	initServer(cfg)
+	c := CreateClient(-1)
+	c.name = "conn"
+	ReadQuery(c, "set k v\r\nmulti\r\nget k\r\nexec\r\nslowlog len\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	allReplies(c)
+	// EXEC 本身不记录，其中的命令单独记录
+	assert.Equal(t, 3, len(server.slowlog))
+	assert.Equal(t, []string{"slowlog", "len"}, server.slowlog[0].args)
+	assert.Equal(t, []string{"get", "k"}, server.slowlog[1].args)
+	assert.Equal(t, []string{"multi"}, server.slowlog[2].args)
+	assert.Equal(t, int64(3), server.slowlog[0].id)
+	assert.Equal(t, "?:0", server.slowlog[0].peerid)
+	assert.Equal(t, "conn", server.slowlog[0].cname)
+
+	ReadQuery(c, "slowlog reset\r\nslowlog get 1\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	e := server.slowlog[1]
+	assert.Equal(t, "+OK\r\n*1\r\n*6\r\n:4\r\n:"+strconv.FormatInt(e.time, 10)+"\r\n:"+strconv.FormatInt(e.duration, 10)+
+		"\r\n*2\r\n$7\r\nslowlog\r\n$5\r\nreset\r\n$3\r\n?:0\r\n$4\r\nconn\r\n", allReplies(c))
+	ReadQuery(c, "slowlog get -2\r\nslowlog foo\r\nslowlog len\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR count should be greater than or equal to -1\r\n"+
+		"-ERR unknown subcommand or wrong number of arguments for 'foo'. Try SLOWLOG HELP.\r\n:3\r\n", allReplies(c))
+
+	// 过多、过长的参数被截断
+	c.args = nil
+	for i := 0; i < 40; i++ {
+		c.args = append(c.args, createStrArgs(strings.Repeat("x", 130))...)
+	}
+	slowlogPushEntryIfNeeded(c, 0)
+	args := server.slowlog[0].args
+	assert.Equal(t, SLOWLOG_ENTRY_MAX_ARGC, len(args))
+	assert.Equal(t, strings.Repeat("x", 128)+"... (2 more bytes)", args[0])
+	assert.Equal(t, "... (9 more arguments)", args[31])
+
+	server.slowlogLogSlowerThan = -1
+	server.slowlog = nil
+	ReadQuery(c, "get k\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, 0, len(server.slowlog))
+}
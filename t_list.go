@@ -0,0 +1,426 @@
+package main
+
+import (
+	"go-redis/obj"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	LIST_HEAD = 0
+	LIST_TAIL = 1
+)
+
+func createListObject() *obj.RedisObj {
+	return obj.CreateObject(obj.LIST, obj.ListCreate(obj.ListType{EqualFunc: GStrEqual}))
+}
+
+func listTypePush(l *obj.List, val *obj.RedisObj, where int) {
+	if where == LIST_HEAD {
+		l.LPush(val)
+	} else {
+		l.Append(val)
+	}
+}
+
+func listTypePop(l *obj.List, where int) *obj.RedisObj {
+	n := l.Head
+	if where == LIST_TAIL {
+		n = l.Tail
+	}
+	if n == nil {
+		return nil
+	}
+	l.DelNode(n)
+	return n.Val
+}
+
+// getListPositionFromObjectOrReply 解析 LEFT | RIGHT
+func getListPositionFromObjectOrReply(c *RedisClient, o *obj.RedisObj) (int, bool) {
+	switch strings.ToLower(o.StrVal()) {
+	case "left":
+		return LIST_HEAD, true
+	case "right":
+		return LIST_TAIL, true
+	}
+	c.AddReplyError("syntax error")
+	return 0, false
+}
+
+func listPopCommandName(where int) string {
+	if where == LIST_HEAD {
+		return "LPOP"
+	}
+	return "RPOP"
+}
+
+func pushGenericCommand(c *RedisClient, where int) {
+	key := c.args[1]
+	lobj := lookupKeyWrite(c.db, key)
+	if lobj != nil && lobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	if lobj == nil {
+		lobj = createListObject()
+		dbAdd(c.db, key, lobj)
+	}
+	l := lobj.Val.(*obj.List)
+	for _, val := range c.args[2:] {
+		listTypePush(l, val, where)
+	}
+	c.AddReplyInt(int64(l.Length))
+	event := "lpush"
+	if where == LIST_TAIL {
+		event = "rpush"
+	}
+	signalModifiedKey(c.db, key)
+	notifyKeyspaceEvent(NOTIFY_LIST, event, key, c.db.id)
+	server.dirty += int64(len(c.args) - 2)
+}
+
+func lpushCommand(c *RedisClient) {
+	pushGenericCommand(c, LIST_HEAD)
+}
+
+func rpushCommand(c *RedisClient) {
+	pushGenericCommand(c, LIST_TAIL)
+}
+
+// listPopElements 弹出最多 count 个元素，列表为空时删除键
+func listPopElements(c *RedisClient, key, lobj *obj.RedisObj, where int, count int64) []*obj.RedisObj {
+	l := lobj.Val.(*obj.List)
+	var elems []*obj.RedisObj
+	for count > 0 && l.Length > 0 {
+		elems = append(elems, listTypePop(l, where))
+		count--
+	}
+	notifyKeyspaceEvent(NOTIFY_LIST, strings.ToLower(listPopCommandName(where)), key, c.db.id)
+	if l.Length == 0 {
+		dbDelete(c.db, key)
+		notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key, c.db.id)
+	}
+	signalModifiedKey(c.db, key)
+	server.dirty += int64(len(elems))
+	return elems
+}
+
+func addReplyListElements(c *RedisClient, elems []*obj.RedisObj) {
+	c.AddReplyArrayLen(len(elems))
+	for _, e := range elems {
+		c.AddReplyBulk(e.StrVal())
+	}
+}
+
+// popGenericCommand LPOP/RPOP key [count]
+func popGenericCommand(c *RedisClient, where int) {
+	if len(c.args) > 3 {
+		c.AddReplyError("wrong number of arguments for '" + c.cmd.name + "' command")
+		return
+	}
+	hasCount := len(c.args) == 3
+	count := int64(1)
+	if hasCount {
+		var ok bool
+		if count, ok = getRangeLongFromObjectOrReply(c, c.args[2], 0, math.MaxInt64, "value is out of range, must be positive"); !ok {
+			return
+		}
+	}
+	key := c.args[1]
+	lobj := lookupKeyWrite(c.db, key)
+	if lobj == nil {
+		if hasCount {
+			c.AddReplyStr("*-1\r\n")
+		} else {
+			c.AddReplyStr("$-1\r\n")
+		}
+		return
+	}
+	if lobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	if hasCount && count == 0 {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	elems := listPopElements(c, key, lobj, where, count)
+	if hasCount {
+		addReplyListElements(c, elems)
+	} else {
+		c.AddReplyBulk(elems[0].StrVal())
+	}
+}
+
+func lpopCommand(c *RedisClient) {
+	popGenericCommand(c, LIST_HEAD)
+}
+
+func rpopCommand(c *RedisClient) {
+	popGenericCommand(c, LIST_TAIL)
+}
+
+func llenCommand(c *RedisClient) {
+	lobj := findKeyRead(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if lobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	c.AddReplyInt(int64(lobj.Val.(*obj.List).Length))
+}
+
+func lrangeCommand(c *RedisClient) {
+	start, ok := getLongFromObjectOrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	end, ok := getLongFromObjectOrReply(c, c.args[3], "")
+	if !ok {
+		return
+	}
+	lobj := findKeyRead(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	if lobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	l := lobj.Val.(*obj.List)
+	start, end, ok = normalizeRange(start, end, int64(l.Length))
+	if !ok {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	c.AddReplyArrayLen(int(end - start + 1))
+	n := l.Index(int(start))
+	for i := start; i <= end; i++ {
+		c.AddReplyBulk(n.Val.StrVal())
+		n = n.Next()
+	}
+}
+
+// normalizeRange 处理负数下标，范围为空时返回 false
+func normalizeRange(start, end, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= length {
+		return 0, 0, false
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end, true
+}
+
+// lmoveHandlePush 将 value 推入目标列表并回复
+func lmoveHandlePush(c *RedisClient, dstkey, dstobj, value *obj.RedisObj, where int) {
+	if dstobj == nil {
+		dstobj = createListObject()
+		dbAdd(c.db, dstkey, dstobj)
+	}
+	signalModifiedKey(c.db, dstkey)
+	listTypePush(dstobj.Val.(*obj.List), value, where)
+	event := "lpush"
+	if where == LIST_TAIL {
+		event = "rpush"
+	}
+	notifyKeyspaceEvent(NOTIFY_LIST, event, dstkey, c.db.id)
+	c.AddReplyBulk(value.StrVal())
+}
+
+func lmoveGenericCommand(c *RedisClient, wherefrom, whereto int) {
+	srckey, dstkey := c.args[1], c.args[2]
+	sobj := lookupKeyWrite(c.db, srckey)
+	if sobj == nil {
+		c.AddReplyStr("$-1\r\n")
+		return
+	}
+	if sobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	dobj := lookupKeyWrite(c.db, dstkey)
+	if dobj != nil && dobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	// 源与目标相同时先推入再判断是否为空，实现列表旋转
+	l := sobj.Val.(*obj.List)
+	value := listTypePop(l, wherefrom)
+	lmoveHandlePush(c, dstkey, dobj, value, whereto)
+	notifyKeyspaceEvent(NOTIFY_LIST, strings.ToLower(listPopCommandName(wherefrom)), srckey, c.db.id)
+	if l.Length == 0 {
+		dbDelete(c.db, srckey)
+		notifyKeyspaceEvent(NOTIFY_GENERIC, "del", srckey, c.db.id)
+	}
+	signalModifiedKey(c.db, srckey)
+	server.dirty++
+}
+
+func lmoveCommand(c *RedisClient) {
+	wherefrom, ok := getListPositionFromObjectOrReply(c, c.args[3])
+	if !ok {
+		return
+	}
+	whereto, ok := getListPositionFromObjectOrReply(c, c.args[4])
+	if !ok {
+		return
+	}
+	lmoveGenericCommand(c, wherefrom, whereto)
+}
+
+func blmoveCommand(c *RedisClient) {
+	wherefrom, ok := getListPositionFromObjectOrReply(c, c.args[3])
+	if !ok {
+		return
+	}
+	whereto, ok := getListPositionFromObjectOrReply(c, c.args[4])
+	if !ok {
+		return
+	}
+	timeout, ok := getTimeoutFromObjectOrReply(c, c.args[5], UNIT_SECONDS)
+	if !ok {
+		return
+	}
+	sobj := lookupKeyWrite(c.db, c.args[1])
+	if sobj == nil {
+		if c.flags&CLIENT_DENY_BLOCKING != 0 {
+			c.AddReplyStr("$-1\r\n")
+			return
+		}
+		blockForKeys(c, BLOCKED_LIST, c.args[1:2], timeout, false)
+		return
+	}
+	if sobj.Type != obj.LIST {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	lmoveGenericCommand(c, wherefrom, whereto)
+	// 以非阻塞的形式传播
+	c.args = append(createStrArgs("LMOVE"), c.args[1:5]...)
+}
+
+// blockingPopGenericCommand BLPOP/BRPOP key [key ...] timeout
+func blockingPopGenericCommand(c *RedisClient, where int) {
+	timeout, ok := getTimeoutFromObjectOrReply(c, c.args[len(c.args)-1], UNIT_SECONDS)
+	if !ok {
+		return
+	}
+	keys := c.args[1 : len(c.args)-1]
+	for _, key := range keys {
+		lobj := lookupKeyWrite(c.db, key)
+		if lobj == nil {
+			continue
+		}
+		if lobj.Type != obj.LIST {
+			c.AddReplyError(WRONGTYPE_ERR)
+			return
+		}
+		elems := listPopElements(c, key, lobj, where, 1)
+		c.AddReplyArrayLen(2)
+		c.AddReplyBulk(key.StrVal())
+		c.AddReplyBulk(elems[0].StrVal())
+		c.args = []*obj.RedisObj{obj.CreateObject(obj.STR, listPopCommandName(where)), key}
+		return
+	}
+	// EXEC 中不阻塞
+	if c.flags&CLIENT_DENY_BLOCKING != 0 {
+		c.AddReplyStr("*-1\r\n")
+		return
+	}
+	blockForKeys(c, BLOCKED_LIST, keys, timeout, false)
+}
+
+func blpopCommand(c *RedisClient) {
+	blockingPopGenericCommand(c, LIST_HEAD)
+}
+
+func brpopCommand(c *RedisClient) {
+	blockingPopGenericCommand(c, LIST_TAIL)
+}
+
+// lmpopGenericCommand [BLMPOP timeout] numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// numkeysIdx 为 numkeys 参数的位置，阻塞版本 timeout 在它之前
+func lmpopGenericCommand(c *RedisClient, numkeysIdx int, blocking bool) {
+	var timeout int64
+	var ok bool
+	if blocking {
+		if timeout, ok = getTimeoutFromObjectOrReply(c, c.args[1], UNIT_SECONDS); !ok {
+			return
+		}
+	}
+	numkeys, ok := getRangeLongFromObjectOrReply(c, c.args[numkeysIdx], 1, math.MaxInt32, "numkeys should be greater than 0")
+	if !ok {
+		return
+	}
+	whereIdx := numkeysIdx + 1 + int(numkeys)
+	if whereIdx >= len(c.args) {
+		c.AddReplyError("syntax error")
+		return
+	}
+	where, ok := getListPositionFromObjectOrReply(c, c.args[whereIdx])
+	if !ok {
+		return
+	}
+	count := int64(-1)
+	for j := whereIdx + 1; j < len(c.args); j++ {
+		if strings.EqualFold(c.args[j].StrVal(), "count") && count == -1 && j+1 < len(c.args) {
+			if count, ok = getRangeLongFromObjectOrReply(c, c.args[j+1], 1, math.MaxInt64, "count should be greater than 0"); !ok {
+				return
+			}
+			j++
+		} else {
+			c.AddReplyError("syntax error")
+			return
+		}
+	}
+	if count == -1 {
+		count = 1
+	}
+	keys := c.args[numkeysIdx+1 : whereIdx]
+	for _, key := range keys {
+		lobj := lookupKeyWrite(c.db, key)
+		if lobj == nil {
+			continue
+		}
+		if lobj.Type != obj.LIST {
+			c.AddReplyError(WRONGTYPE_ERR)
+			return
+		}
+		elems := listPopElements(c, key, lobj, where, count)
+		c.AddReplyArrayLen(2)
+		c.AddReplyBulk(key.StrVal())
+		addReplyListElements(c, elems)
+		// 以 LPOP/RPOP key count 的形式传播
+		c.args = []*obj.RedisObj{obj.CreateObject(obj.STR, listPopCommandName(where)), key,
+			obj.CreateObject(obj.STR, strconv.Itoa(len(elems)))}
+		return
+	}
+	if !blocking || c.flags&CLIENT_DENY_BLOCKING != 0 {
+		c.AddReplyStr("*-1\r\n")
+		return
+	}
+	blockForKeys(c, BLOCKED_LIST, keys, timeout, false)
+}
+
+func lmpopCommand(c *RedisClient) {
+	lmpopGenericCommand(c, 1, false)
+}
+
+func blmpopCommand(c *RedisClient) {
+	lmpopGenericCommand(c, 2, true)
+}
@@ -0,0 +1,1686 @@
+package main
+
+import (
+	"fmt"
+	"go-redis/ae"
+	"go-redis/obj"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const STREAM_ID_ERR = "Invalid stream ID specified as stream command argument"
+
+func createStreamObject() *obj.RedisObj {
+	return obj.CreateObject(obj.STREAM, obj.StreamCreate())
+}
+
+// streamGenericParseIDOrReply 解析 <ms>-<seq>，缺少 seq 时使用 missingSeq。
+// strict 时不接受 - 和 +；seqGiven 不为 nil 时接受 <ms>-* 的形式；c 为 nil 时不回复错误
+func streamGenericParseIDOrReply(c *RedisClient, o *obj.RedisObj, missingSeq uint64, strict bool, seqGiven *bool) (obj.StreamID, bool) {
+	str := o.StrVal()
+	invalid := func() (obj.StreamID, bool) {
+		if c != nil {
+			c.AddReplyError(STREAM_ID_ERR)
+		}
+		return obj.StreamID{}, false
+	}
+	if len(str) > 127 || (strict && (str == "-" || str == "+")) {
+		return invalid()
+	}
+	if seqGiven != nil {
+		*seqGiven = true
+	}
+	if str == "-" {
+		return obj.StreamID{}, true
+	} else if str == "+" {
+		return obj.StreamIDMax, true
+	}
+	msStr, seqStr := str, ""
+	dot := strings.IndexByte(str, '-')
+	if dot >= 0 {
+		msStr, seqStr = str[:dot], str[dot+1:]
+	}
+	ms, err := strconv.ParseUint(msStr, 10, 64)
+	if err != nil {
+		return invalid()
+	}
+	seq := missingSeq
+	if dot >= 0 {
+		if seqGiven != nil && seqStr == "*" {
+			seq = 0
+			*seqGiven = false
+		} else if seq, err = strconv.ParseUint(seqStr, 10, 64); err != nil {
+			return invalid()
+		}
+	}
+	return obj.StreamID{Ms: ms, Seq: seq}, true
+}
+
+func streamParseIDOrReply(c *RedisClient, o *obj.RedisObj, missingSeq uint64) (obj.StreamID, bool) {
+	return streamGenericParseIDOrReply(c, o, missingSeq, false, nil)
+}
+
+func streamParseStrictIDOrReply(c *RedisClient, o *obj.RedisObj, missingSeq uint64, seqGiven *bool) (obj.StreamID, bool) {
+	return streamGenericParseIDOrReply(c, o, missingSeq, true, seqGiven)
+}
+
+// streamParseIntervalIDOrReply 解析区间的边界，以 ( 开头表示不包含
+func streamParseIntervalIDOrReply(c *RedisClient, o *obj.RedisObj, missingSeq uint64) (obj.StreamID, bool, bool) {
+	str := o.StrVal()
+	if len(str) > 1 && str[0] == '(' {
+		id, ok := streamParseStrictIDOrReply(c, obj.CreateObject(obj.STR, str[1:]), missingSeq, nil)
+		return id, true, ok
+	}
+	id, ok := streamParseIDOrReply(c, o, missingSeq)
+	return id, false, ok
+}
+
+// streamParseRangeOrReply 解析 start end，不包含的边界转换为包含的边界
+func streamParseRangeOrReply(c *RedisClient, startArg, endArg *obj.RedisObj) (obj.StreamID, obj.StreamID, bool) {
+	start, startex, ok := streamParseIntervalIDOrReply(c, startArg, 0)
+	if !ok {
+		return start, start, false
+	}
+	if startex {
+		if start, ok = start.Incr(); !ok {
+			c.AddReplyError("invalid start ID for the interval")
+			return start, start, false
+		}
+	}
+	end, endex, ok := streamParseIntervalIDOrReply(c, endArg, math.MaxUint64)
+	if !ok {
+		return start, end, false
+	}
+	if endex {
+		if end, ok = end.Decr(); !ok {
+			c.AddReplyError("invalid end ID for the interval")
+			return start, end, false
+		}
+	}
+	return start, end, true
+}
+
+func addReplyStreamID(c *RedisClient, id obj.StreamID) {
+	c.AddReplyBulk(id.String())
+}
+
+func addReplyStreamEntry(c *RedisClient, e *obj.StreamEntry) {
+	c.AddReplyArrayLen(2)
+	addReplyStreamID(c, e.ID)
+	c.AddReplyArrayLen(len(e.Fields))
+	for _, f := range e.Fields {
+		c.AddReplyBulk(f)
+	}
+}
+
+func addReplyStreamEntries(c *RedisClient, entries []*obj.StreamEntry) {
+	c.AddReplyArrayLen(len(entries))
+	for _, e := range entries {
+		addReplyStreamEntry(c, e)
+	}
+}
+
+// streamRange 返回 [start, end] 内最多 count 条消息，count 为 0 表示不限制
+func streamRange(s *obj.Stream, start, end obj.StreamID, count int64, rev bool) []*obj.StreamEntry {
+	var entries []*obj.StreamEntry
+	s.Range(start, end, rev, func(e *obj.StreamEntry) bool {
+		entries = append(entries, e)
+		return count == 0 || int64(len(entries)) < count
+	})
+	return entries
+}
+
+// 消费组命令传播时使用的键名与消费组名
+type streamPropInfo struct {
+	keyname   *obj.RedisObj
+	groupname *obj.RedisObj
+}
+
+// streamPropagateXCLAIM 以 XCLAIM 的形式传播消息在消费组中的状态，
+// 从节点据此创建或更新 PEL 中的消息，消息不存在时从 PEL 中删除
+func streamPropagateXCLAIM(key, groupname *obj.RedisObj, group *obj.StreamCG, id obj.StreamID, nack *obj.StreamNACK) {
+	alsoPropagate(createStrArgs("XCLAIM", key.StrVal(), groupname.StrVal(), nack.Consumer.Name, "0", id.String(),
+		"TIME", strconv.FormatInt(nack.DeliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatUint(nack.DeliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", group.LastId.String()))
+}
+
+// streamPropagateGroupID 传播消费组的 last_id 与 entries_read
+func streamPropagateGroupID(key, groupname *obj.RedisObj, group *obj.StreamCG) {
+	alsoPropagate(createStrArgs("XGROUP", "SETID", key.StrVal(), groupname.StrVal(), group.LastId.String(),
+		"ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10)))
+}
+
+// streamPropagateConsumerCreation XREADGROUP 没有读到消息时也需要在从节点上创建消费者
+func streamPropagateConsumerCreation(key, groupname *obj.RedisObj, consumer string) {
+	alsoPropagate(createStrArgs("XGROUP", "CREATECONSUMER", key.StrVal(), groupname.StrVal(), consumer))
+}
+
+// streamCreateConsumer 创建消费者并通知
+func streamCreateConsumer(c *RedisClient, cg *obj.StreamCG, name string, key *obj.RedisObj) *obj.StreamConsumer {
+	consumer := cg.CreateConsumer(name, ae.GetMsTime())
+	if consumer == nil {
+		return nil
+	}
+	notifyKeyspaceEvent(NOTIFY_STREAM, "xgroup-createconsumer", key, c.db.id)
+	server.dirty++
+	return consumer
+}
+
+// streamLookupOrCreateConsumer 查找消费者，不存在时创建，并更新 seen_time
+func streamLookupOrCreateConsumer(c *RedisClient, cg *obj.StreamCG, name string, key *obj.RedisObj) (*obj.StreamConsumer, bool) {
+	created := false
+	consumer := cg.LookupConsumer(name)
+	if consumer == nil {
+		consumer = streamCreateConsumer(c, cg, name, key)
+		created = true
+	}
+	consumer.SeenTime = ae.GetMsTime()
+	return consumer, created
+}
+
+// streamReplyWithCGLag 回复消费组的 lag，无法计算时回复 nil
+func streamReplyWithCGLag(c *RedisClient, s *obj.Stream, cg *obj.StreamCG) {
+	if s.EntriesAdded == 0 {
+		c.AddReplyInt(0)
+		return
+	}
+	if cg.EntriesRead != obj.SCG_INVALID_ENTRIES_READ && !s.RangeHasTombstones(cg.LastId, obj.StreamIDMax) {
+		c.AddReplyInt(int64(s.EntriesAdded) - cg.EntriesRead)
+		return
+	}
+	if entriesRead := s.EstimateDistanceFromFirstEverEntry(cg.LastId); entriesRead != obj.SCG_INVALID_ENTRIES_READ {
+		c.AddReplyInt(int64(s.EntriesAdded) - entriesRead)
+		return
+	}
+	c.AddReplyStr("$-1\r\n")
+}
+
+// streamDeliverToConsumer 将 XREADGROUP 读到的新消息投递给消费者：
+// 推进消费组的 last_id，NOACK 以外的消息加入 PEL
+func streamDeliverToConsumer(s *obj.Stream, entries []*obj.StreamEntry, group *obj.StreamCG,
+	consumer *obj.StreamConsumer, noack bool, spi *streamPropInfo) {
+	now := ae.GetMsTime()
+	propagateLastId := false
+	for _, e := range entries {
+		if e.ID.Compare(group.LastId) > 0 {
+			if group.EntriesRead != obj.SCG_INVALID_ENTRIES_READ && !s.RangeHasTombstones(e.ID, obj.StreamIDMax) {
+				group.EntriesRead++
+			} else if s.EntriesAdded > 0 {
+				group.EntriesRead = s.EstimateDistanceFromFirstEverEntry(e.ID)
+			}
+			group.LastId = e.ID
+			propagateLastId = true
+		}
+		consumer.ActiveTime = now
+		if noack {
+			continue
+		}
+		// 消费组的 last_id 可以被 XGROUP SETID 回退，消息可能已经属于其他消费者
+		key := e.ID.Encode()
+		var nack *obj.StreamNACK
+		if v, ok := group.PEL.Find(key); ok {
+			nack = v.(*obj.StreamNACK)
+			nack.Consumer.PEL.Remove(key)
+			nack.Consumer = consumer
+			nack.DeliveryTime = now
+			nack.DeliveryCount = 1
+		} else {
+			nack = &obj.StreamNACK{DeliveryTime: now, DeliveryCount: 1, Consumer: consumer}
+			group.PEL.Insert(key, nack)
+		}
+		consumer.PEL.Insert(key, nack)
+		streamPropagateXCLAIM(spi.keyname, spi.groupname, group, e.ID, nack)
+	}
+	if propagateLastId {
+		streamPropagateGroupID(spi.keyname, spi.groupname, group)
+	}
+}
+
+// streamReplyWithRangeFromConsumerPEL 回复消费者 PEL 中从 start 开始的消息，
+// 已被删除的消息回复 nil 的字段
+func streamReplyWithRangeFromConsumerPEL(c *RedisClient, s *obj.Stream, start obj.StreamID, count int64, consumer *obj.StreamConsumer) {
+	type pendingEntry struct {
+		id    obj.StreamID
+		entry *obj.StreamEntry
+	}
+	var items []pendingEntry
+	now := ae.GetMsTime()
+	it := consumer.PEL.Iterator()
+	it.Seek(">=", start.Encode())
+	for (count == 0 || int64(len(items)) < count) && it.Next() {
+		id := obj.DecodeStreamID(it.Key)
+		e := s.Lookup(id)
+		if e != nil {
+			nack := it.Val.(*obj.StreamNACK)
+			nack.DeliveryTime = now
+			nack.DeliveryCount++
+		}
+		items = append(items, pendingEntry{id, e})
+	}
+	c.AddReplyArrayLen(len(items))
+	for _, item := range items {
+		if item.entry != nil {
+			addReplyStreamEntry(c, item.entry)
+			continue
+		}
+		c.AddReplyArrayLen(2)
+		addReplyStreamID(c, item.id)
+		c.AddReplyStr("*-1\r\n")
+	}
+}
+
+// streamTypeLookupWriteOrCreate 查找 stream，不存在时创建，noCreate 时回复 nil
+func streamTypeLookupWriteOrCreate(c *RedisClient, key *obj.RedisObj, noCreate bool) *obj.RedisObj {
+	o := lookupKeyWrite(c.db, key)
+	if o != nil {
+		if o.Type != obj.STREAM {
+			c.AddReplyError(WRONGTYPE_ERR)
+			return nil
+		}
+		return o
+	}
+	if noCreate {
+		c.AddReplyStr("$-1\r\n")
+		return nil
+	}
+	o = createStreamObject()
+	dbAdd(c.db, key, o)
+	return o
+}
+
+// XADD/XTRIM 的参数
+type streamAddTrimArgs struct {
+	trim               obj.StreamTrimArgs
+	trimStrategyArgIdx int // MAXLEN/MINID 参数的位置，用于改写传播的命令
+
+	// XADD
+	id         obj.StreamID
+	idGiven    bool
+	seqGiven   bool
+	noMkstream bool
+}
+
+// streamParseAddOrTrimArgsOrReply 解析 XADD/XTRIM 的选项，XADD 返回 ID 参数的位置
+func streamParseAddOrTrimArgsOrReply(c *RedisClient, args *streamAddTrimArgs, xadd bool) (int, bool) {
+	limitGiven := false
+	i := 2
+	for ; i < len(c.args); i++ {
+		moreargs := len(c.args) - 1 - i
+		opt := c.args[i].StrVal()
+		if xadd && opt == "*" {
+			break
+		} else if strings.EqualFold(opt, "maxlen") && moreargs > 0 {
+			if args.trim.Strategy != obj.TRIM_STRATEGY_NONE {
+				c.AddReplyError("syntax error, MAXLEN and MINID options at the same time are not compatible")
+				return 0, false
+			}
+			args.trim.Approx = false
+			next := c.args[i+1].StrVal()
+			if moreargs >= 2 && next == "~" {
+				args.trim.Approx = true
+				i++
+			} else if moreargs >= 2 && next == "=" {
+				i++
+			}
+			maxlen, ok := getLongFromObjectOrReply(c, c.args[i+1], "")
+			if !ok {
+				return 0, false
+			}
+			if maxlen < 0 {
+				c.AddReplyError("The MAXLEN argument must be >= 0.")
+				return 0, false
+			}
+			i++
+			args.trim.MaxLen = maxlen
+			args.trim.Strategy = obj.TRIM_STRATEGY_MAXLEN
+			args.trimStrategyArgIdx = i
+		} else if strings.EqualFold(opt, "minid") && moreargs > 0 {
+			if args.trim.Strategy != obj.TRIM_STRATEGY_NONE {
+				c.AddReplyError("syntax error, MAXLEN and MINID options at the same time are not compatible")
+				return 0, false
+			}
+			args.trim.Approx = false
+			next := c.args[i+1].StrVal()
+			if moreargs >= 2 && next == "~" {
+				args.trim.Approx = true
+				i++
+			} else if moreargs >= 2 && next == "=" {
+				i++
+			}
+			minid, ok := streamParseStrictIDOrReply(c, c.args[i+1], 0, nil)
+			if !ok {
+				return 0, false
+			}
+			i++
+			args.trim.MinID = minid
+			args.trim.Strategy = obj.TRIM_STRATEGY_MINID
+			args.trimStrategyArgIdx = i
+		} else if strings.EqualFold(opt, "limit") && moreargs > 0 {
+			limit, ok := getLongFromObjectOrReply(c, c.args[i+1], "")
+			if !ok {
+				return 0, false
+			}
+			if limit < 0 {
+				c.AddReplyError("The LIMIT argument must be >= 0.")
+				return 0, false
+			}
+			args.trim.Limit = limit
+			limitGiven = true
+			i++
+		} else if xadd && strings.EqualFold(opt, "nomkstream") {
+			args.noMkstream = true
+		} else if xadd {
+			// 语法错误或者指定的 ID
+			id, ok := streamParseStrictIDOrReply(c, c.args[i], 0, &args.seqGiven)
+			if !ok {
+				return 0, false
+			}
+			args.id = id
+			args.idGiven = true
+			break
+		} else {
+			c.AddReplyError("syntax error")
+			return 0, false
+		}
+	}
+
+	if args.trim.Limit > 0 && args.trim.Strategy == obj.TRIM_STRATEGY_NONE {
+		c.AddReplyError("syntax error, LIMIT cannot be used without specifying a trimming strategy")
+		return 0, false
+	}
+	if !xadd && args.trim.Strategy == obj.TRIM_STRATEGY_NONE {
+		c.AddReplyError("syntax error, XTRIM must be called with a trimming strategy")
+		return 0, false
+	}
+
+	if c.flags&CLIENT_MASTER != 0 {
+		// 主节点传播的命令已经改写为精确裁剪
+		args.trim.Limit = 0
+	} else if limitGiven {
+		if !args.trim.Approx {
+			c.AddReplyError("syntax error, LIMIT cannot be used without the special ~ option")
+			return 0, false
+		}
+	} else if args.trim.Approx {
+		// 避免近似裁剪一次删除太多消息
+		args.trim.Limit = 100 * server.streamNodeMaxEntries
+		if args.trim.Limit <= 0 {
+			args.trim.Limit = 10000
+		}
+	} else {
+		args.trim.Limit = 0
+	}
+	return i, true
+}
+
+// streamRewriteTrimArgument 近似裁剪以实际的裁剪结果传播，保证从节点的数据一致
+func streamRewriteTrimArgument(c *RedisClient, s *obj.Stream, args *streamAddTrimArgs) {
+	c.args[args.trimStrategyArgIdx-1] = obj.CreateObject(obj.STR, "=")
+	var arg string
+	if args.trim.Strategy == obj.TRIM_STRATEGY_MAXLEN {
+		arg = strconv.FormatUint(s.Length, 10)
+	} else {
+		first, _ := s.FirstEntryID()
+		arg = first.String()
+	}
+	c.args[args.trimStrategyArgIdx] = obj.CreateObject(obj.STR, arg)
+}
+
+// streamGenerateID 生成新消息的 ID，不大于最后一条消息的 ID 时返回 false
+func streamGenerateID(s *obj.Stream, args *streamAddTrimArgs) (obj.StreamID, bool) {
+	var id obj.StreamID
+	if args.idGiven {
+		if args.seqGiven {
+			id = args.id
+		} else if s.LastId.Ms == args.id.Ms {
+			// <ms>-* 在同一毫秒内自增序号
+			if s.LastId.Seq == math.MaxUint64 {
+				return id, false
+			}
+			id = obj.StreamID{Ms: s.LastId.Ms, Seq: s.LastId.Seq + 1}
+		} else {
+			id = args.id
+		}
+	} else {
+		ms := uint64(ae.GetMsTime())
+		if ms > s.LastId.Ms {
+			id = obj.StreamID{Ms: ms}
+		} else {
+			id, _ = s.LastId.Incr()
+		}
+	}
+	return id, id.Compare(s.LastId) > 0
+}
+
+// xaddCommand XADD key [NOMKSTREAM] [<MAXLEN|MINID> [=|~] threshold [LIMIT count]] <*|id> field value [field value ...]
+func xaddCommand(c *RedisClient) {
+	var args streamAddTrimArgs
+	i, ok := streamParseAddOrTrimArgsOrReply(c, &args, true)
+	if !ok {
+		return
+	}
+	fieldPos := i + 1
+	if len(c.args)-fieldPos < 2 || (len(c.args)-fieldPos)%2 == 1 {
+		c.AddReplyError("wrong number of arguments for 'xadd' command")
+		return
+	}
+	// 提前拒绝 0-0，避免创建空的 stream
+	if args.idGiven && args.seqGiven && args.id.IsZero() {
+		c.AddReplyError("The ID specified in XADD must be greater than 0-0")
+		return
+	}
+	key := c.args[1]
+	o := streamTypeLookupWriteOrCreate(c, key, args.noMkstream)
+	if o == nil {
+		return
+	}
+	s := o.Val.(*obj.Stream)
+	if s.LastId == obj.StreamIDMax {
+		c.AddReplyError("The stream has exhausted the last possible ID, unable to add more items")
+		return
+	}
+	id, ok := streamGenerateID(s, &args)
+	if !ok {
+		c.AddReplyError("The ID specified in XADD is equal or smaller than the target stream top item")
+		return
+	}
+	fields := make([]string, 0, len(c.args)-fieldPos)
+	for _, f := range c.args[fieldPos:] {
+		fields = append(fields, f.StrVal())
+	}
+	s.Append(id, fields, server.streamNodeMaxBytes, server.streamNodeMaxEntries)
+	addReplyStreamID(c, id)
+
+	signalModifiedKey(c.db, key)
+	notifyKeyspaceEvent(NOTIFY_STREAM, "xadd", key, c.db.id)
+	server.dirty++
+
+	if args.trim.Strategy != obj.TRIM_STRATEGY_NONE {
+		if s.Trim(&args.trim) > 0 {
+			notifyKeyspaceEvent(NOTIFY_STREAM, "xtrim", key, c.db.id)
+		}
+		if args.trim.Approx {
+			streamRewriteTrimArgument(c, s, &args)
+		}
+	}
+	// 以实际生成的 ID 传播
+	if !args.idGiven || !args.seqGiven {
+		c.args[i] = obj.CreateObject(obj.STR, id.String())
+	}
+	signalKeyAsReady(c.db, key, obj.STREAM)
+}
+
+// xrangeGenericCommand XRANGE key start end [COUNT count]
+func xrangeGenericCommand(c *RedisClient, rev bool) {
+	startArg, endArg := c.args[2], c.args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, end, ok := streamParseRangeOrReply(c, startArg, endArg)
+	if !ok {
+		return
+	}
+	count := int64(-1)
+	for j := 4; j < len(c.args); j++ {
+		if strings.EqualFold(c.args[j].StrVal(), "count") && j+1 < len(c.args) {
+			if count, ok = getLongFromObjectOrReply(c, c.args[j+1], ""); !ok {
+				return
+			}
+			if count < 0 {
+				count = 0
+			}
+			j++
+		} else {
+			c.AddReplyError("syntax error")
+			return
+		}
+	}
+	o := findKeyRead(c.db, c.args[1])
+	if o == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	if o.Type != obj.STREAM {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	if count == 0 {
+		c.AddReplyStr("*-1\r\n")
+		return
+	}
+	if count == -1 {
+		count = 0
+	}
+	addReplyStreamEntries(c, streamRange(o.Val.(*obj.Stream), start, end, count, rev))
+}
+
+func xrangeCommand(c *RedisClient) {
+	xrangeGenericCommand(c, false)
+}
+
+func xrevrangeCommand(c *RedisClient) {
+	xrangeGenericCommand(c, true)
+}
+
+func xlenCommand(c *RedisClient) {
+	o := findKeyRead(c.db, c.args[1])
+	if o == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if o.Type != obj.STREAM {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	c.AddReplyInt(int64(o.Val.(*obj.Stream).Length))
+}
+
+// xdelCommand XDEL key id [id ...]
+func xdelCommand(c *RedisClient) {
+	key := c.args[1]
+	o := lookupKeyWrite(c.db, key)
+	if o == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if o.Type != obj.STREAM {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	// 先检查所有 ID，避免只删除一部分
+	ids := make([]obj.StreamID, 0, len(c.args)-2)
+	for _, arg := range c.args[2:] {
+		id, ok := streamParseStrictIDOrReply(c, arg, 0, nil)
+		if !ok {
+			return
+		}
+		ids = append(ids, id)
+	}
+	s := o.Val.(*obj.Stream)
+	var deleted int64
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		signalModifiedKey(c.db, key)
+		notifyKeyspaceEvent(NOTIFY_STREAM, "xdel", key, c.db.id)
+		server.dirty += deleted
+	}
+	c.AddReplyInt(deleted)
+}
+
+// xtrimCommand XTRIM key <MAXLEN|MINID> [=|~] threshold [LIMIT count]
+func xtrimCommand(c *RedisClient) {
+	key := c.args[1]
+	o := lookupKeyWrite(c.db, key)
+	if o == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if o.Type != obj.STREAM {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	var args streamAddTrimArgs
+	if _, ok := streamParseAddOrTrimArgsOrReply(c, &args, false); !ok {
+		return
+	}
+	s := o.Val.(*obj.Stream)
+	deleted := s.Trim(&args.trim)
+	if deleted > 0 {
+		notifyKeyspaceEvent(NOTIFY_STREAM, "xtrim", key, c.db.id)
+		if args.trim.Approx {
+			streamRewriteTrimArgument(c, s, &args)
+		}
+		signalModifiedKey(c.db, key)
+		server.dirty += deleted
+	}
+	c.AddReplyInt(deleted)
+}
+
+// xreadCommand XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func xreadCommand(c *RedisClient) {
+	// XREADGROUP 以 XCLAIM 与 XGROUP SETID 的形式传播
+	defer preventCommandPropagation(c)
+
+	xreadgroup := len(c.args[0].StrVal()) == 10
+	timeout := int64(-1)
+	var count int64
+	var streamsArg, streamsCount int
+	var groupname, consumername *obj.RedisObj
+	noack := false
+	for i := 1; i < len(c.args); i++ {
+		moreargs := len(c.args) - i - 1
+		opt := c.args[i].StrVal()
+		if strings.EqualFold(opt, "block") && moreargs > 0 {
+			i++
+			var ok bool
+			if timeout, ok = getTimeoutFromObjectOrReply(c, c.args[i], UNIT_MILLISECONDS); !ok {
+				return
+			}
+		} else if strings.EqualFold(opt, "count") && moreargs > 0 {
+			i++
+			var ok bool
+			if count, ok = getLongFromObjectOrReply(c, c.args[i], ""); !ok {
+				return
+			}
+			if count < 0 {
+				count = 0
+			}
+		} else if strings.EqualFold(opt, "streams") && moreargs > 0 {
+			streamsArg = i + 1
+			streamsCount = len(c.args) - streamsArg
+			if streamsCount%2 != 0 {
+				name, symbol := "xread", '$'
+				if xreadgroup {
+					name, symbol = "xreadgroup", '>'
+				}
+				c.AddReplyError(fmt.Sprintf("Unbalanced '%s' list of streams: for each stream key an ID or '%c' must be specified.", name, symbol))
+				return
+			}
+			streamsCount /= 2
+			break
+		} else if strings.EqualFold(opt, "group") && moreargs >= 2 {
+			if !xreadgroup {
+				c.AddReplyError("The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+				return
+			}
+			groupname = c.args[i+1]
+			consumername = c.args[i+2]
+			i += 2
+		} else if strings.EqualFold(opt, "noack") {
+			if !xreadgroup {
+				c.AddReplyError("The NOACK option is only supported by XREADGROUP. You called XREAD instead.")
+				return
+			}
+			noack = true
+		} else {
+			c.AddReplyError("syntax error")
+			return
+		}
+	}
+	if streamsArg == 0 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	if xreadgroup && groupname == nil {
+		c.AddReplyError("Missing GROUP option for XREADGROUP")
+		return
+	}
+
+	// 解析 ID，XREADGROUP 的 > 以最大 ID 表示
+	keys := c.args[streamsArg : streamsArg+streamsCount]
+	ids := make([]obj.StreamID, streamsCount)
+	groups := make([]*obj.StreamCG, streamsCount)
+	for idx, key := range keys {
+		arg := c.args[streamsArg+streamsCount+idx]
+		o := findKeyRead(c.db, key)
+		if o != nil && o.Type != obj.STREAM {
+			c.AddReplyError(WRONGTYPE_ERR)
+			return
+		}
+		if groupname != nil {
+			if o != nil {
+				groups[idx] = o.Val.(*obj.Stream).LookupCG(groupname.StrVal())
+			}
+			if groups[idx] == nil {
+				c.AddReplyError(fmt.Sprintf("-NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option",
+					key.StrVal(), groupname.StrVal()))
+				return
+			}
+		}
+		switch arg.StrVal() {
+		case "$":
+			if xreadgroup {
+				c.AddReplyError("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+				return
+			}
+			if o != nil {
+				ids[idx] = o.Val.(*obj.Stream).LastId
+			}
+		case ">":
+			if !xreadgroup {
+				c.AddReplyError("The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+				return
+			}
+			ids[idx] = obj.StreamIDMax
+		default:
+			id, ok := streamParseStrictIDOrReply(c, arg, 0, nil)
+			if !ok {
+				return
+			}
+			ids[idx] = id
+		}
+	}
+
+	// 尝试同步读取
+	type readResult struct {
+		key         *obj.RedisObj
+		entries     []*obj.StreamEntry
+		s           *obj.Stream
+		start       obj.StreamID
+		consumer    *obj.StreamConsumer
+		fromHistory bool
+	}
+	var results []readResult
+	for idx, key := range keys {
+		o := findKeyRead(c.db, key)
+		if o == nil {
+			continue
+		}
+		s := o.Val.(*obj.Stream)
+		gt := ids[idx]
+		serve, serveHistory := false, false
+		var consumer *obj.StreamConsumer
+		if groups[idx] != nil {
+			group := groups[idx]
+			if gt != obj.StreamIDMax {
+				// 指定了 ID 时读取消费者的历史消息
+				serve, serveHistory = true, true
+			} else if last, ok := s.LastEntryID(); ok && last.Compare(group.LastId) > 0 {
+				serve = true
+				gt = group.LastId
+			}
+			var created bool
+			consumer, created = streamLookupOrCreateConsumer(c, group, consumername.StrVal(), key)
+			if created {
+				streamPropagateConsumerCreation(key, groupname, consumer.Name)
+			}
+		} else if last, ok := s.LastEntryID(); ok && last.Compare(gt) > 0 {
+			serve = true
+		}
+		if !serve {
+			continue
+		}
+		start, _ := gt.Incr()
+		if serveHistory {
+			start = gt
+		}
+		r := readResult{key: key, s: s, start: start, consumer: consumer, fromHistory: serveHistory}
+		if !serveHistory {
+			r.entries = streamRange(s, start, obj.StreamIDMax, count, false)
+			if groups[idx] != nil {
+				streamDeliverToConsumer(s, r.entries, groups[idx], consumer, noack,
+					&streamPropInfo{keyname: key, groupname: groupname})
+			}
+		}
+		if groups[idx] != nil {
+			server.dirty++
+		}
+		results = append(results, r)
+	}
+	if len(results) > 0 {
+		c.AddReplyArrayLen(len(results))
+		for _, r := range results {
+			c.AddReplyArrayLen(2)
+			c.AddReplyBulk(r.key.StrVal())
+			if r.fromHistory {
+				streamReplyWithRangeFromConsumerPEL(c, r.s, r.start, count, r.consumer)
+			} else {
+				addReplyStreamEntries(c, r.entries)
+			}
+		}
+		return
+	}
+
+	if timeout != -1 {
+		// 不允许阻塞时视为超时
+		if c.flags&CLIENT_DENY_BLOCKING != 0 {
+			c.AddReplyStr("*-1\r\n")
+			return
+		}
+		// $ 改写为当前的最后一个 ID，解除阻塞后重新执行时只读取新消息
+		c.bpop.xreadIds = make(map[string]obj.StreamID)
+		for idx, key := range keys {
+			argIdx := streamsArg + streamsCount + idx
+			if c.args[argIdx].StrVal() == "$" {
+				c.args[argIdx] = obj.CreateObject(obj.STR, ids[idx].String())
+			}
+			c.bpop.xreadIds[key.StrVal()] = ids[idx]
+		}
+		if groupname != nil {
+			c.bpop.xreadGroup = groupname.StrVal()
+		}
+		blockForKeys(c, BLOCKED_STREAM, keys, timeout, xreadgroup)
+		return
+	}
+	c.AddReplyStr("*-1\r\n")
+}
+
+// streamKeyIsReady 阻塞在 key 上的 XREAD/XREADGROUP 是否可以读到新消息
+func streamKeyIsReady(c *RedisClient, key *obj.RedisObj, s *obj.Stream) bool {
+	last, ok := s.LastEntryID()
+	if c.bpop.xreadGroup != "" {
+		group := s.LookupCG(c.bpop.xreadGroup)
+		// 消费组被删除时重新执行命令，回复 NOGROUP
+		return group == nil || (ok && last.Compare(group.LastId) > 0)
+	}
+	return ok && last.Compare(c.bpop.xreadIds[key.StrVal()]) > 0
+}
+
+var xgroupHelp = []string{
+	"CREATE <key> <groupname> <id|$> [option]",
+	"    Create a new consumer group. Options are:",
+	"    * MKSTREAM",
+	"      Create the empty stream if it does not exist.",
+	"    * ENTRIESREAD entries_read",
+	"      Set the group's entries_read counter (internal use).",
+	"CREATECONSUMER <key> <groupname> <consumer>",
+	"    Create a new consumer in the specified group.",
+	"DELCONSUMER <key> <groupname> <consumer>",
+	"    Remove the specified consumer.",
+	"DESTROY <key> <groupname>",
+	"    Remove the specified group.",
+	"SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]",
+	"    Set the current group ID and entries_read counter.",
+}
+
+// xgroupCommand XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER|HELP
+func xgroupCommand(c *RedisClient) {
+	opt := strings.ToLower(c.args[1].StrVal())
+	var s *obj.Stream
+	var cg *obj.StreamCG
+	var grpname string
+	mkstream := false
+	entriesRead := obj.SCG_INVALID_ENTRIES_READ
+
+	if len(c.args) >= 4 {
+		// CREATE 与 SETID 的选项
+		if len(c.args) >= 6 && (opt == "create" || opt == "setid") {
+			for i := 5; i < len(c.args); {
+				arg := c.args[i].StrVal()
+				if opt == "create" && strings.EqualFold(arg, "mkstream") {
+					mkstream = true
+					i++
+				} else if strings.EqualFold(arg, "entriesread") && i+1 < len(c.args) {
+					var ok bool
+					if entriesRead, ok = getLongFromObjectOrReply(c, c.args[i+1], ""); !ok {
+						return
+					}
+					if entriesRead < 0 && entriesRead != obj.SCG_INVALID_ENTRIES_READ {
+						c.AddReplyError("value for ENTRIESREAD must be positive or -1")
+						return
+					}
+					i += 2
+				} else {
+					addReplySubcommandSyntaxError(c)
+					return
+				}
+			}
+		}
+		o := lookupKeyWrite(c.db, c.args[2])
+		if o != nil {
+			if o.Type != obj.STREAM {
+				c.AddReplyError(WRONGTYPE_ERR)
+				return
+			}
+			s = o.Val.(*obj.Stream)
+		}
+		grpname = c.args[3].StrVal()
+	}
+
+	if len(c.args) >= 4 && !mkstream {
+		if s == nil {
+			c.AddReplyError("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+			return
+		}
+		if cg = s.LookupCG(grpname); cg == nil && (opt == "setid" || opt == "createconsumer" || opt == "delconsumer") {
+			c.AddReplyError(fmt.Sprintf("-NOGROUP No such consumer group '%s' for key name '%s'", grpname, c.args[2].StrVal()))
+			return
+		}
+	}
+
+	var key *obj.RedisObj
+	if len(c.args) >= 3 {
+		key = c.args[2]
+	}
+	switch {
+	case opt == "help" && len(c.args) == 2:
+		addReplyHelp(c, xgroupHelp)
+	case opt == "create" && len(c.args) >= 5 && len(c.args) <= 8:
+		var id obj.StreamID
+		if c.args[4].StrVal() == "$" {
+			if s != nil {
+				id = s.LastId
+			}
+		} else {
+			var ok bool
+			if id, ok = streamParseStrictIDOrReply(c, c.args[4], 0, nil); !ok {
+				return
+			}
+		}
+		// 命令不会再失败，此时处理 MKSTREAM
+		if s == nil {
+			o := createStreamObject()
+			dbAdd(c.db, key, o)
+			s = o.Val.(*obj.Stream)
+			signalModifiedKey(c.db, key)
+		}
+		if s.CreateCG(grpname, id, entriesRead) == nil {
+			c.AddReplyError("-BUSYGROUP Consumer Group name already exists")
+			return
+		}
+		c.AddReplyStr("+OK\r\n")
+		server.dirty++
+		notifyKeyspaceEvent(NOTIFY_STREAM, "xgroup-create", key, c.db.id)
+	case opt == "setid" && (len(c.args) == 5 || len(c.args) == 7):
+		var id obj.StreamID
+		if c.args[4].StrVal() == "$" {
+			id = s.LastId
+		} else {
+			var ok bool
+			if id, ok = streamParseIDOrReply(c, c.args[4], 0); !ok {
+				return
+			}
+		}
+		cg.LastId = id
+		cg.EntriesRead = entriesRead
+		c.AddReplyStr("+OK\r\n")
+		server.dirty++
+		notifyKeyspaceEvent(NOTIFY_STREAM, "xgroup-setid", key, c.db.id)
+	case opt == "destroy" && len(c.args) == 4:
+		if cg == nil {
+			c.AddReplyInt(0)
+			return
+		}
+		s.DestroyCG(grpname)
+		c.AddReplyInt(1)
+		server.dirty++
+		notifyKeyspaceEvent(NOTIFY_STREAM, "xgroup-destroy", key, c.db.id)
+		// 阻塞在该消费组上的 XREADGROUP 回复 NOGROUP
+		signalKeyAsReady(c.db, key, obj.STREAM)
+	case opt == "createconsumer" && len(c.args) == 5:
+		if streamCreateConsumer(c, cg, c.args[4].StrVal(), key) != nil {
+			c.AddReplyInt(1)
+		} else {
+			c.AddReplyInt(0)
+		}
+	case opt == "delconsumer" && len(c.args) == 5:
+		var pending int
+		if consumer := cg.LookupConsumer(c.args[4].StrVal()); consumer != nil {
+			pending = consumer.PEL.Len()
+			cg.DelConsumer(consumer)
+			server.dirty++
+			notifyKeyspaceEvent(NOTIFY_STREAM, "xgroup-delconsumer", key, c.db.id)
+		}
+		c.AddReplyInt(int64(pending))
+	default:
+		addReplySubcommandSyntaxError(c)
+	}
+}
+
+func addReplySubcommandSyntaxError(c *RedisClient) {
+	c.AddReplyError(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try %s HELP.",
+		c.args[1].StrVal(), strings.ToUpper(c.args[0].StrVal())))
+}
+
+// lookupStreamGroupOrReply 查找 XACK 等命令的 stream 与消费组，类型错误时回复
+func lookupStreamGroup(c *RedisClient) (*obj.Stream, *obj.StreamCG, bool) {
+	o := findKeyRead(c.db, c.args[1])
+	if o == nil {
+		return nil, nil, true
+	}
+	if o.Type != obj.STREAM {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return nil, nil, false
+	}
+	s := o.Val.(*obj.Stream)
+	return s, s.LookupCG(c.args[2].StrVal()), true
+}
+
+func addReplyNoGroupError(c *RedisClient) {
+	c.AddReplyError(fmt.Sprintf("-NOGROUP No such key '%s' or consumer group '%s'", c.args[1].StrVal(), c.args[2].StrVal()))
+}
+
+// xackCommand XACK key group id [id ...]
+func xackCommand(c *RedisClient) {
+	_, group, ok := lookupStreamGroup(c)
+	if !ok {
+		return
+	}
+	if group == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	// 先检查所有 ID，命令要么全部执行要么报错
+	ids := make([]obj.StreamID, 0, len(c.args)-3)
+	for _, arg := range c.args[3:] {
+		id, ok := streamParseStrictIDOrReply(c, arg, 0, nil)
+		if !ok {
+			return
+		}
+		ids = append(ids, id)
+	}
+	var acknowledged int64
+	for _, id := range ids {
+		key := id.Encode()
+		if v, ok := group.PEL.Remove(key); ok {
+			v.(*obj.StreamNACK).Consumer.PEL.Remove(key)
+			acknowledged++
+			server.dirty++
+		}
+	}
+	c.AddReplyInt(acknowledged)
+}
+
+// xpendingCommand XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xpendingCommand(c *RedisClient) {
+	justinfo := len(c.args) == 3
+	if len(c.args) != 3 && (len(c.args) < 6 || len(c.args) > 9) {
+		c.AddReplyError("syntax error")
+		return
+	}
+	var start, end obj.StreamID
+	var count, minidle int64
+	var consumername *obj.RedisObj
+	if len(c.args) >= 6 {
+		startidx := 3
+		var ok bool
+		if strings.EqualFold(c.args[3].StrVal(), "idle") {
+			if minidle, ok = getLongFromObjectOrReply(c, c.args[4], ""); !ok {
+				return
+			}
+			if len(c.args) < 8 {
+				c.AddReplyError("syntax error")
+				return
+			}
+			startidx += 2
+		}
+		if count, ok = getLongFromObjectOrReply(c, c.args[startidx+2], ""); !ok {
+			return
+		}
+		if count < 0 {
+			count = 0
+		}
+		if start, end, ok = streamParseRangeOrReply(c, c.args[startidx], c.args[startidx+1]); !ok {
+			return
+		}
+		if startidx+3 < len(c.args) {
+			consumername = c.args[startidx+3]
+		}
+	}
+
+	_, group, ok := lookupStreamGroup(c)
+	if !ok {
+		return
+	}
+	if group == nil {
+		addReplyNoGroupError(c)
+		return
+	}
+
+	if justinfo {
+		c.AddReplyArrayLen(4)
+		c.AddReplyInt(int64(group.PEL.Len()))
+		if group.PEL.Len() == 0 {
+			c.AddReplyStr("$-1\r\n")
+			c.AddReplyStr("$-1\r\n")
+			c.AddReplyStr("*-1\r\n")
+			return
+		}
+		first, _, _ := group.PEL.Seek("^", nil)
+		last, _, _ := group.PEL.Seek("$", nil)
+		addReplyStreamID(c, obj.DecodeStreamID(first))
+		addReplyStreamID(c, obj.DecodeStreamID(last))
+		type consumerPending struct {
+			name    string
+			pending int
+		}
+		var consumers []consumerPending
+		it := group.Consumers.Iterator()
+		it.Seek("^", nil)
+		for it.Next() {
+			consumer := it.Val.(*obj.StreamConsumer)
+			if consumer.PEL.Len() > 0 {
+				consumers = append(consumers, consumerPending{consumer.Name, consumer.PEL.Len()})
+			}
+		}
+		c.AddReplyArrayLen(len(consumers))
+		for _, cp := range consumers {
+			c.AddReplyArrayLen(2)
+			c.AddReplyBulk(cp.name)
+			c.AddReplyBulk(strconv.Itoa(cp.pending))
+		}
+		return
+	}
+
+	pel := group.PEL
+	if consumername != nil {
+		consumer := group.LookupConsumer(consumername.StrVal())
+		if consumer == nil {
+			c.AddReplyArrayLen(0)
+			return
+		}
+		pel = consumer.PEL
+	}
+	type pendingEntry struct {
+		id   obj.StreamID
+		nack *obj.StreamNACK
+	}
+	var items []pendingEntry
+	now := ae.GetMsTime()
+	it := pel.Iterator()
+	it.Seek(">=", start.Encode())
+	for count > 0 && it.Next() {
+		id := obj.DecodeStreamID(it.Key)
+		if id.Compare(end) > 0 {
+			break
+		}
+		nack := it.Val.(*obj.StreamNACK)
+		if minidle > 0 && now-nack.DeliveryTime < minidle {
+			continue
+		}
+		items = append(items, pendingEntry{id, nack})
+		count--
+	}
+	c.AddReplyArrayLen(len(items))
+	for _, item := range items {
+		c.AddReplyArrayLen(4)
+		addReplyStreamID(c, item.id)
+		c.AddReplyBulk(item.nack.Consumer.Name)
+		elapsed := now - item.nack.DeliveryTime
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		c.AddReplyInt(elapsed)
+		c.AddReplyInt(int64(item.nack.DeliveryCount))
+	}
+}
+
+// streamClaimEntry 将 PEL 中的消息转移给 consumer
+func streamClaimEntry(key []byte, nack *obj.StreamNACK, consumer *obj.StreamConsumer) {
+	if nack.Consumer == consumer {
+		return
+	}
+	// FORCE 创建的消息没有所属的消费者
+	if nack.Consumer != nil {
+		nack.Consumer.PEL.Remove(key)
+	}
+	consumer.PEL.Insert(key, nack)
+	nack.Consumer = consumer
+}
+
+// xclaimCommand XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func xclaimCommand(c *RedisClient) {
+	s, group, ok := lookupStreamGroup(c)
+	if !ok {
+		return
+	}
+	if group == nil {
+		addReplyNoGroupError(c)
+		return
+	}
+	minidle, ok := getLongFromObjectOrReply(c, c.args[4], "Invalid min-idle-time argument for XCLAIM")
+	if !ok {
+		return
+	}
+	if minidle < 0 {
+		minidle = 0
+	}
+
+	// 先解析 ID，无法解析的参数可能是选项
+	j := 5
+	for ; j < len(c.args); j++ {
+		if _, ok := streamParseStrictIDOrReply(nil, c.args[j], 0, nil); !ok {
+			break
+		}
+	}
+	lastIdArg := j - 1
+
+	now := ae.GetMsTime()
+	deliverytime, retrycount := int64(-1), int64(-1)
+	force, justid := false, false
+	var lastId obj.StreamID
+	for ; j < len(c.args); j++ {
+		moreargs := len(c.args) - 1 - j
+		opt := c.args[j].StrVal()
+		switch {
+		case strings.EqualFold(opt, "force"):
+			force = true
+		case strings.EqualFold(opt, "justid"):
+			justid = true
+		case strings.EqualFold(opt, "idle") && moreargs > 0:
+			j++
+			idle, ok := getLongFromObjectOrReply(c, c.args[j], "Invalid IDLE option argument for XCLAIM")
+			if !ok {
+				return
+			}
+			deliverytime = now - idle
+		case strings.EqualFold(opt, "time") && moreargs > 0:
+			j++
+			if deliverytime, ok = getLongFromObjectOrReply(c, c.args[j], "Invalid TIME option argument for XCLAIM"); !ok {
+				return
+			}
+		case strings.EqualFold(opt, "retrycount") && moreargs > 0:
+			j++
+			if retrycount, ok = getLongFromObjectOrReply(c, c.args[j], "Invalid RETRYCOUNT option argument for XCLAIM"); !ok {
+				return
+			}
+		case strings.EqualFold(opt, "lastid") && moreargs > 0:
+			j++
+			if lastId, ok = streamParseStrictIDOrReply(c, c.args[j], 0, nil); !ok {
+				return
+			}
+		default:
+			c.AddReplyError(fmt.Sprintf("Unrecognized XCLAIM option '%s'", opt))
+			return
+		}
+	}
+	// XCLAIM 以自身的形式传播
+	preventCommandPropagation(c)
+
+	propagateLastId := false
+	if lastId.Compare(group.LastId) > 0 {
+		group.LastId = lastId
+		propagateLastId = true
+	}
+	// 客户端的时钟可能与服务器不一致，不合理的时间视为当前时间
+	if deliverytime < 0 || deliverytime > now {
+		deliverytime = now
+	}
+
+	consumer, _ := streamLookupOrCreateConsumer(c, group, c.args[3].StrVal(), c.args[1])
+	var claimed []*obj.StreamEntry
+	var claimedIds []obj.StreamID
+	for _, arg := range c.args[5 : lastIdArg+1] {
+		id, _ := streamParseStrictIDOrReply(nil, arg, 0, nil)
+		key := id.Encode()
+		var nack *obj.StreamNACK
+		if v, ok := group.PEL.Find(key); ok {
+			nack = v.(*obj.StreamNACK)
+		}
+		entry := s.Lookup(id)
+		// 消息已经被删除，从 PEL 中移除
+		if entry == nil {
+			if nack != nil {
+				streamPropagateXCLAIM(c.args[1], c.args[2], group, id, nack)
+				propagateLastId = false
+				server.dirty++
+				group.PEL.Remove(key)
+				nack.Consumer.PEL.Remove(key)
+			}
+			continue
+		}
+		// FORCE 时为消息创建 PEL 项，用于复制消费组的状态
+		if force && nack == nil {
+			nack = &obj.StreamNACK{}
+			group.PEL.Insert(key, nack)
+		}
+		if nack == nil {
+			continue
+		}
+		if nack.Consumer != nil && minidle > 0 && now-nack.DeliveryTime < minidle {
+			continue
+		}
+		streamClaimEntry(key, nack, consumer)
+		nack.DeliveryTime = deliverytime
+		if retrycount >= 0 {
+			nack.DeliveryCount = uint64(retrycount)
+		} else if !justid {
+			nack.DeliveryCount++
+		}
+		claimed = append(claimed, entry)
+		claimedIds = append(claimedIds, id)
+		consumer.ActiveTime = now
+		streamPropagateXCLAIM(c.args[1], c.args[2], group, id, nack)
+		propagateLastId = false
+		server.dirty++
+	}
+	if propagateLastId {
+		streamPropagateGroupID(c.args[1], c.args[2], group)
+		server.dirty++
+	}
+	if justid {
+		c.AddReplyArrayLen(len(claimedIds))
+		for _, id := range claimedIds {
+			addReplyStreamID(c, id)
+		}
+	} else {
+		addReplyStreamEntries(c, claimed)
+	}
+}
+
+// xautoclaimCommand XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func xautoclaimCommand(c *RedisClient) {
+	const attemptsFactor = 10
+	minidle, ok := getLongFromObjectOrReply(c, c.args[4], "Invalid min-idle-time argument for XAUTOCLAIM")
+	if !ok {
+		return
+	}
+	if minidle < 0 {
+		minidle = 0
+	}
+	start, startex, ok := streamParseIntervalIDOrReply(c, c.args[5], 0)
+	if !ok {
+		return
+	}
+	if startex {
+		if start, ok = start.Incr(); !ok {
+			c.AddReplyError("invalid start ID for the interval")
+			return
+		}
+	}
+	count := int64(100)
+	justid := false
+	for j := 6; j < len(c.args); j++ {
+		moreargs := len(c.args) - 1 - j
+		opt := c.args[j].StrVal()
+		if strings.EqualFold(opt, "count") && moreargs > 0 {
+			if count, ok = getRangeLongFromObjectOrReply(c, c.args[j+1], 1, math.MaxInt64/16, "COUNT must be > 0"); !ok {
+				return
+			}
+			j++
+		} else if strings.EqualFold(opt, "justid") {
+			justid = true
+		} else {
+			c.AddReplyError("syntax error")
+			return
+		}
+	}
+
+	s, group, ok := lookupStreamGroup(c)
+	if !ok {
+		return
+	}
+	if group == nil {
+		addReplyNoGroupError(c)
+		return
+	}
+	preventCommandPropagation(c)
+
+	consumer, _ := streamLookupOrCreateConsumer(c, group, c.args[3].StrVal(), c.args[1])
+	attempts := count * attemptsFactor
+	now := ae.GetMsTime()
+	var claimed []*obj.StreamEntry
+	var claimedIds, deletedIds []obj.StreamID
+	it := group.PEL.Iterator()
+	it.Seek(">=", start.Encode())
+	for attempts > 0 && count > 0 && it.Next() {
+		attempts--
+		id := obj.DecodeStreamID(it.Key)
+		nack := it.Val.(*obj.StreamNACK)
+		entry := s.Lookup(id)
+		// 消息已经被删除，从 PEL 中移除
+		if entry == nil {
+			streamPropagateXCLAIM(c.args[1], c.args[2], group, id, nack)
+			server.dirty++
+			key := it.Key
+			group.PEL.Remove(key)
+			nack.Consumer.PEL.Remove(key)
+			deletedIds = append(deletedIds, id)
+			it.Seek(">=", key)
+			count--
+			continue
+		}
+		if minidle > 0 && now-nack.DeliveryTime < minidle {
+			continue
+		}
+		streamClaimEntry(it.Key, nack, consumer)
+		nack.DeliveryTime = now
+		if !justid {
+			nack.DeliveryCount++
+		}
+		claimed = append(claimed, entry)
+		claimedIds = append(claimedIds, id)
+		count--
+		consumer.ActiveTime = now
+		streamPropagateXCLAIM(c.args[1], c.args[2], group, id, nack)
+		server.dirty++
+	}
+	// 下一次调用的起点
+	var endid obj.StreamID
+	if it.Next() {
+		endid = obj.DecodeStreamID(it.Key)
+	}
+
+	c.AddReplyArrayLen(3)
+	addReplyStreamID(c, endid)
+	if justid {
+		c.AddReplyArrayLen(len(claimedIds))
+		for _, id := range claimedIds {
+			addReplyStreamID(c, id)
+		}
+	} else {
+		addReplyStreamEntries(c, claimed)
+	}
+	c.AddReplyArrayLen(len(deletedIds))
+	for _, id := range deletedIds {
+		addReplyStreamID(c, id)
+	}
+}
+
+var xinfoHelp = []string{
+	"CONSUMERS <key> <groupname>",
+	"    Show consumers of <groupname>.",
+	"GROUPS <key>",
+	"    Show the stream consumer groups.",
+	"STREAM <key> [FULL [COUNT <count>]",
+	"    Show information about the stream.",
+}
+
+// xinfoCommand XINFO CONSUMERS|GROUPS|STREAM|HELP
+func xinfoCommand(c *RedisClient) {
+	opt := strings.ToLower(c.args[1].StrVal())
+	if opt == "help" && len(c.args) == 2 {
+		addReplyHelp(c, xinfoHelp)
+		return
+	}
+	if !(opt == "consumers" && len(c.args) == 4) && !(opt == "groups" && len(c.args) == 3) &&
+		!(opt == "stream" && len(c.args) >= 3) {
+		addReplySubcommandSyntaxError(c)
+		return
+	}
+	o := findKeyRead(c.db, c.args[2])
+	if o == nil {
+		c.AddReplyError("no such key")
+		return
+	}
+	if o.Type != obj.STREAM {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	s := o.Val.(*obj.Stream)
+	now := ae.GetMsTime()
+	switch opt {
+	case "consumers":
+		cg := s.LookupCG(c.args[3].StrVal())
+		if cg == nil {
+			c.AddReplyError(fmt.Sprintf("-NOGROUP No such consumer group '%s' for key name '%s'",
+				c.args[3].StrVal(), c.args[2].StrVal()))
+			return
+		}
+		c.AddReplyArrayLen(cg.Consumers.Len())
+		it := cg.Consumers.Iterator()
+		it.Seek("^", nil)
+		for it.Next() {
+			consumer := it.Val.(*obj.StreamConsumer)
+			inactive := int64(-1)
+			if consumer.ActiveTime != -1 {
+				inactive = now - consumer.ActiveTime
+			}
+			c.AddReplyArrayLen(8)
+			c.AddReplyBulk("name")
+			c.AddReplyBulk(consumer.Name)
+			c.AddReplyBulk("pending")
+			c.AddReplyInt(int64(consumer.PEL.Len()))
+			c.AddReplyBulk("idle")
+			c.AddReplyInt(now - consumer.SeenTime)
+			c.AddReplyBulk("inactive")
+			c.AddReplyInt(inactive)
+		}
+	case "groups":
+		if s.CGroups == nil {
+			c.AddReplyArrayLen(0)
+			return
+		}
+		c.AddReplyArrayLen(s.CGroups.Len())
+		it := s.CGroups.Iterator()
+		it.Seek("^", nil)
+		for it.Next() {
+			cg := it.Val.(*obj.StreamCG)
+			c.AddReplyArrayLen(12)
+			c.AddReplyBulk("name")
+			c.AddReplyBulk(string(it.Key))
+			c.AddReplyBulk("consumers")
+			c.AddReplyInt(int64(cg.Consumers.Len()))
+			c.AddReplyBulk("pending")
+			c.AddReplyInt(int64(cg.PEL.Len()))
+			c.AddReplyBulk("last-delivered-id")
+			addReplyStreamID(c, cg.LastId)
+			c.AddReplyBulk("entries-read")
+			addReplyEntriesRead(c, cg)
+			c.AddReplyBulk("lag")
+			streamReplyWithCGLag(c, s, cg)
+		}
+	case "stream":
+		full := false
+		count := int64(10)
+		if len(c.args) > 3 {
+			if !strings.EqualFold(c.args[3].StrVal(), "full") || (len(c.args) != 4 && len(c.args) != 6) {
+				c.AddReplyError("syntax error")
+				return
+			}
+			full = true
+			if len(c.args) == 6 {
+				if !strings.EqualFold(c.args[4].StrVal(), "count") {
+					c.AddReplyError("syntax error")
+					return
+				}
+				var ok bool
+				if count, ok = getLongFromObjectOrReply(c, c.args[5], ""); !ok {
+					return
+				}
+				if count < 0 {
+					count = 10
+				}
+			}
+		}
+		xinfoReplyWithStreamInfo(c, s, full, count)
+	}
+}
+
+func addReplyEntriesRead(c *RedisClient, cg *obj.StreamCG) {
+	if cg.EntriesRead == obj.SCG_INVALID_ENTRIES_READ {
+		c.AddReplyStr("$-1\r\n")
+	} else {
+		c.AddReplyInt(cg.EntriesRead)
+	}
+}
+
+// xinfoReplyWithStreamInfo XINFO STREAM，FULL 时回复所有消费组与消费者的详细信息，count 为 0 表示不限制
+func xinfoReplyWithStreamInfo(c *RedisClient, s *obj.Stream, full bool, count int64) {
+	if full {
+		c.AddReplyArrayLen(18)
+	} else {
+		c.AddReplyArrayLen(20)
+	}
+	c.AddReplyBulk("length")
+	c.AddReplyInt(int64(s.Length))
+	c.AddReplyBulk("radix-tree-keys")
+	c.AddReplyInt(int64(s.Rax.Len()))
+	c.AddReplyBulk("radix-tree-nodes")
+	c.AddReplyInt(int64(s.Rax.NumNodes()))
+	c.AddReplyBulk("last-generated-id")
+	addReplyStreamID(c, s.LastId)
+	c.AddReplyBulk("max-deleted-entry-id")
+	addReplyStreamID(c, s.MaxDeletedEntryId)
+	c.AddReplyBulk("entries-added")
+	c.AddReplyInt(int64(s.EntriesAdded))
+	c.AddReplyBulk("recorded-first-entry-id")
+	addReplyStreamID(c, s.FirstId)
+
+	if !full {
+		c.AddReplyBulk("groups")
+		if s.CGroups == nil {
+			c.AddReplyInt(0)
+		} else {
+			c.AddReplyInt(int64(s.CGroups.Len()))
+		}
+		for _, name := range []string{"first-entry", "last-entry"} {
+			c.AddReplyBulk(name)
+			entries := streamRange(s, obj.StreamID{}, obj.StreamIDMax, 1, name == "last-entry")
+			if len(entries) == 0 {
+				c.AddReplyStr("$-1\r\n")
+			} else {
+				addReplyStreamEntry(c, entries[0])
+			}
+		}
+		return
+	}
+
+	c.AddReplyBulk("entries")
+	addReplyStreamEntries(c, streamRange(s, obj.StreamID{}, obj.StreamIDMax, count, false))
+	c.AddReplyBulk("groups")
+	if s.CGroups == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	c.AddReplyArrayLen(s.CGroups.Len())
+	it := s.CGroups.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		cg := it.Val.(*obj.StreamCG)
+		c.AddReplyArrayLen(14)
+		c.AddReplyBulk("name")
+		c.AddReplyBulk(string(it.Key))
+		c.AddReplyBulk("last-delivered-id")
+		addReplyStreamID(c, cg.LastId)
+		c.AddReplyBulk("entries-read")
+		addReplyEntriesRead(c, cg)
+		c.AddReplyBulk("lag")
+		streamReplyWithCGLag(c, s, cg)
+		c.AddReplyBulk("pel-count")
+		c.AddReplyInt(int64(cg.PEL.Len()))
+		c.AddReplyBulk("pending")
+		addReplyPendingEntries(c, cg.PEL, count, true)
+		c.AddReplyBulk("consumers")
+		c.AddReplyArrayLen(cg.Consumers.Len())
+		cit := cg.Consumers.Iterator()
+		cit.Seek("^", nil)
+		for cit.Next() {
+			consumer := cit.Val.(*obj.StreamConsumer)
+			c.AddReplyArrayLen(10)
+			c.AddReplyBulk("name")
+			c.AddReplyBulk(consumer.Name)
+			c.AddReplyBulk("seen-time")
+			c.AddReplyInt(consumer.SeenTime)
+			c.AddReplyBulk("active-time")
+			c.AddReplyInt(consumer.ActiveTime)
+			c.AddReplyBulk("pel-count")
+			c.AddReplyInt(int64(consumer.PEL.Len()))
+			c.AddReplyBulk("pending")
+			addReplyPendingEntries(c, consumer.PEL, count, false)
+		}
+	}
+}
+
+// addReplyPendingEntries 回复 PEL 中最多 count 项，withConsumer 时包含所属的消费者
+func addReplyPendingEntries(c *RedisClient, pel *obj.Rax, count int64, withConsumer bool) {
+	n := int64(pel.Len())
+	if count > 0 && count < n {
+		n = count
+	}
+	c.AddReplyArrayLen(int(n))
+	it := pel.Iterator()
+	it.Seek("^", nil)
+	for ; n > 0 && it.Next(); n-- {
+		nack := it.Val.(*obj.StreamNACK)
+		if withConsumer {
+			c.AddReplyArrayLen(4)
+		} else {
+			c.AddReplyArrayLen(3)
+		}
+		addReplyStreamID(c, obj.DecodeStreamID(it.Key))
+		if withConsumer {
+			c.AddReplyBulk(nack.Consumer.Name)
+		}
+		c.AddReplyInt(nack.DeliveryTime)
+		c.AddReplyInt(int64(nack.DeliveryCount))
+	}
+}
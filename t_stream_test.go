@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"go-redis/conf"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// allReplies 取出并清空客户端的所有回复
+func allReplies(c *RedisClient) string {
+	var sb strings.Builder
+	for n := c.reply.Head; n != nil; n = n.Next() {
+		sb.WriteString(n.Val.StrVal())
+	}
+	freeReplyList(c)
+	return sb.String()
+}
+
+func TestStreamAddRange(t *testing.T) {
+	cfg := conf.DefaultConfig()
+	cfg.StreamNodeMaxEntries = 2
+	initServer(cfg)
+	c := CreateClient(-1)
+	ReadQuery(c, "xadd s 1-1 a 1\r\nxadd s 1-* b 2\r\nxadd s 2 c 3 d 4\r\nxadd s 1-5 e 5\r\nxlen s\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$3\r\n1-1\r\n$3\r\n1-2\r\n$3\r\n2-0\r\n"+
+		"-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n:3\r\n", allReplies(c))
+
+	ReadQuery(c, "xrange s - +\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*3\r\n"+
+		"*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"+
+		"*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"+
+		"*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n", allReplies(c))
+
+	ReadQuery(c, "xrevrange s + (1-1 count 1\r\nxrange s (1-2 + count 0\r\nxdel s 1-2 9-9\r\nxrange s 1 1\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*1\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n"+
+		"*-1\r\n:1\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n", allReplies(c))
+
+	// 精确裁剪
+	ReadQuery(c, "xadd s maxlen 2 3 e 5\r\nxlen s\r\nxtrim s minid 3\r\nxrange s - +\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$3\r\n3-0\r\n:2\r\n:1\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\ne\r\n$1\r\n5\r\n", allReplies(c))
+
+	ReadQuery(c, "xadd s 0-0 a 1\r\nxadd nokey nomkstream * a 1\r\nxtrim s limit 1\r\nxadd s maxlen 1 limit 1 * a 1\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR The ID specified in XADD must be greater than 0-0\r\n$-1\r\n"+
+		"-ERR syntax error, LIMIT cannot be used without specifying a trimming strategy\r\n"+
+		"-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n", allReplies(c))
+}
+
+func TestStreamConsumerGroup(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "xgroup create s g $\r\nxgroup create s g $ mkstream\r\nxgroup create s g $\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"+
+		"+OK\r\n-BUSYGROUP Consumer Group name already exists\r\n", allReplies(c))
+
+	ReadQuery(c, "xadd s 1 a 1\r\nxadd s 2 b 2\r\nxreadgroup group g alice count 1 streams s >\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "$3\r\n1-0\r\n$3\r\n2-0\r\n*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n", allReplies(c))
+
+	ReadQuery(c, "xreadgroup group g bob streams s >\r\nxpending s g\r\nxack s g 1-0\r\nxpending s g - + 10 bob\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	replies := allReplies(c)
+	assert.True(t, strings.HasPrefix(replies, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"+
+		"*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n"+
+		":1\r\n*1\r\n*4\r\n$3\r\n2-0\r\n$3\r\nbob\r\n"), replies)
+	assert.True(t, strings.HasSuffix(replies, ":1\r\n"))
+
+	// 读取消费者的历史消息，已删除的消息回复 nil
+	ReadQuery(c, "xclaim s g alice 0 2-0 justid\r\nxdel s 2-0\r\nxreadgroup group g alice streams s 0\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*1\r\n$3\r\n2-0\r\n:1\r\n*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*-1\r\n", allReplies(c))
+
+	// XAUTOCLAIM 删除已不存在的消息
+	ReadQuery(c, "xautoclaim s g bob 0 0\r\nxpending s g\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "*3\r\n$3\r\n0-0\r\n*0\r\n*1\r\n$3\r\n2-0\r\n*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n", allReplies(c))
+
+	ReadQuery(c, "xreadgroup group nogroup alice streams s >\r\nxgroup delconsumer s g alice\r\nxinfo groups s\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, "-NOGROUP No such key 's' or consumer group 'nogroup' in XREADGROUP with GROUP option\r\n:0\r\n"+
+		"*1\r\n*12\r\n$4\r\nname\r\n$1\r\ng\r\n$9\r\nconsumers\r\n:1\r\n$7\r\npending\r\n:0\r\n"+
+		"$17\r\nlast-delivered-id\r\n$3\r\n2-0\r\n$12\r\nentries-read\r\n:2\r\n$3\r\nlag\r\n:0\r\n", allReplies(c))
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c1 := CreateClient(-1)
+	c2 := CreateClient(-2)
+	ReadQuery(c1, "xread block 0 streams s $\r\n")
+	assert.Nil(t, ProcessQueryBuf(c1))
+	assert.NotEqual(t, 0, c1.flags&CLIENT_BLOCKED)
+	ReadQuery(c2, "xgroup create s g $ mkstream\r\nxreadgroup group g alice block 0 streams s >\r\n")
+	assert.Nil(t, ProcessQueryBuf(c2))
+	assert.NotEqual(t, 0, c2.flags&CLIENT_BLOCKED)
+
+	p := CreateClient(-3)
+	ReadQuery(p, "xadd s 5 f v\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, 0, c1.flags&CLIENT_BLOCKED)
+	assert.Equal(t, 0, c2.flags&CLIENT_BLOCKED)
+	entry := "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"
+	assert.Equal(t, entry, allReplies(c1))
+	assert.Equal(t, "+OK\r\n"+entry, allReplies(c2))
+
+	// 删除 stream 时阻塞的 XREADGROUP 收到错误
+	ReadQuery(c2, "xreadgroup group g alice block 0 streams s >\r\n")
+	assert.Nil(t, ProcessQueryBuf(c2))
+	ReadQuery(p, "del s\r\n")
+	assert.Nil(t, ProcessQueryBuf(p))
+	assert.Equal(t, "-UNBLOCKED the stream key no longer exists\r\n", allReplies(c2))
+	assert.Equal(t, 0, len(server.db[0].blockingKeys))
+}
+
+func TestRdbStream(t *testing.T) {
+	initServer(conf.DefaultConfig())
+	c := CreateClient(-1)
+	ReadQuery(c, "xadd s 1 a 1\r\nxadd s 2 b 2\r\nxadd s 3 c 3\r\nxdel s 3\r\n"+
+		"xgroup create s g 0\r\nxreadgroup group g alice streams s >\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	freeReplyList(c)
+	ReadQuery(c, "xinfo stream s full\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	before := allReplies(c)
+
+	var buf bytes.Buffer
+	assert.Nil(t, rdbSaveRio(&buf))
+	emptyData()
+	assert.Nil(t, rdbLoadRio(bytes.NewReader(buf.Bytes()), server.db))
+	ReadQuery(c, "xinfo stream s full\r\n")
+	assert.Nil(t, ProcessQueryBuf(c))
+	assert.Equal(t, before, allReplies(c))
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"go-redis/obj"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	ZSET_MIN = 0
+	ZSET_MAX = 1
+)
+
+func createZsetObject() *obj.RedisObj {
+	return obj.CreateObject(obj.ZSET, obj.ZSetCreate())
+}
+
+// formatScore 与 Redis 一致使用最短的表示形式
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	} else if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ZADD 选项
+const (
+	ZADD_NX = 1 << 0
+	ZADD_XX = 1 << 1
+	ZADD_GT = 1 << 2
+	ZADD_LT = 1 << 3
+	ZADD_CH = 1 << 4
+)
+
+// zaddCommand ZADD key [NX|XX] [GT|LT] [CH] score member [score member ...]
+func zaddCommand(c *RedisClient) {
+	flags := 0
+	idx := 2
+	for ; idx < len(c.args); idx++ {
+		opt := strings.ToLower(c.args[idx].StrVal())
+		if opt == "nx" {
+			flags |= ZADD_NX
+		} else if opt == "xx" {
+			flags |= ZADD_XX
+		} else if opt == "gt" {
+			flags |= ZADD_GT
+		} else if opt == "lt" {
+			flags |= ZADD_LT
+		} else if opt == "ch" {
+			flags |= ZADD_CH
+		} else {
+			break
+		}
+	}
+	elements := len(c.args) - idx
+	if elements == 0 || elements%2 != 0 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	if flags&ZADD_NX != 0 && flags&ZADD_XX != 0 {
+		c.AddReplyError("XX and NX options at the same time are not compatible")
+		return
+	}
+	if (flags&ZADD_GT != 0 && flags&ZADD_NX != 0) || (flags&ZADD_LT != 0 && flags&ZADD_NX != 0) ||
+		(flags&ZADD_GT != 0 && flags&ZADD_LT != 0) {
+		c.AddReplyError("GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	scores := make([]float64, elements/2)
+	for j := range scores {
+		var ok bool
+		if scores[j], ok = getDoubleFromObjectOrReply(c, c.args[idx+j*2], ""); !ok {
+			return
+		}
+	}
+
+	key := c.args[1]
+	zobj := lookupKeyWrite(c.db, key)
+	if zobj != nil && zobj.Type != obj.ZSET {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	if zobj == nil {
+		if flags&ZADD_XX != 0 {
+			c.AddReplyInt(0)
+			return
+		}
+		zobj = createZsetObject()
+		dbAdd(c.db, key, zobj)
+	}
+	zs := zobj.Val.(*obj.ZSet)
+	added, updated := 0, 0
+	for j, score := range scores {
+		member := c.args[idx+j*2+1].StrVal()
+		cur, exists := zs.Score(member)
+		if exists {
+			if flags&ZADD_NX != 0 || score == cur ||
+				(flags&ZADD_GT != 0 && score <= cur) || (flags&ZADD_LT != 0 && score >= cur) {
+				continue
+			}
+			zs.Add(member, score)
+			updated++
+		} else if flags&ZADD_XX == 0 {
+			zs.Add(member, score)
+			added++
+		}
+	}
+	if added+updated > 0 {
+		signalModifiedKey(c.db, key)
+		notifyKeyspaceEvent(NOTIFY_ZSET, "zadd", key, c.db.id)
+		server.dirty += int64(added + updated)
+	}
+	if flags&ZADD_CH != 0 {
+		c.AddReplyInt(int64(added + updated))
+	} else {
+		c.AddReplyInt(int64(added))
+	}
+}
+
+func zremCommand(c *RedisClient) {
+	key := c.args[1]
+	zobj := lookupKeyWrite(c.db, key)
+	if zobj == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if zobj.Type != obj.ZSET {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	zs := zobj.Val.(*obj.ZSet)
+	deleted := 0
+	for _, member := range c.args[2:] {
+		if zs.Remove(member.StrVal()) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		notifyKeyspaceEvent(NOTIFY_ZSET, "zrem", key, c.db.id)
+		if zs.Len() == 0 {
+			dbDelete(c.db, key)
+			notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key, c.db.id)
+		}
+		signalModifiedKey(c.db, key)
+		server.dirty += int64(deleted)
+	}
+	c.AddReplyInt(int64(deleted))
+}
+
+func zcardCommand(c *RedisClient) {
+	zobj := findKeyRead(c.db, c.args[1])
+	if zobj == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if zobj.Type != obj.ZSET {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	c.AddReplyInt(int64(zobj.Val.(*obj.ZSet).Len()))
+}
+
+func zscoreCommand(c *RedisClient) {
+	zobj := findKeyRead(c.db, c.args[1])
+	if zobj == nil {
+		c.AddReplyStr("$-1\r\n")
+		return
+	}
+	if zobj.Type != obj.ZSET {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	score, ok := zobj.Val.(*obj.ZSet).Score(c.args[2].StrVal())
+	if !ok {
+		c.AddReplyStr("$-1\r\n")
+		return
+	}
+	c.AddReplyBulk(formatScore(score))
+}
+
+// zrangeCommand ZRANGE key start stop [WITHSCORES]
+func zrangeCommand(c *RedisClient) {
+	withscores := false
+	if len(c.args) == 5 && strings.EqualFold(c.args[4].StrVal(), "withscores") {
+		withscores = true
+	} else if len(c.args) != 4 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	start, ok := getLongFromObjectOrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	end, ok := getLongFromObjectOrReply(c, c.args[3], "")
+	if !ok {
+		return
+	}
+	zobj := findKeyRead(c.db, c.args[1])
+	if zobj == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	if zobj.Type != obj.ZSET {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	zs := zobj.Val.(*obj.ZSet)
+	start, end, ok = normalizeRange(start, end, int64(zs.Len()))
+	if !ok {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	n := int(end - start + 1)
+	if withscores {
+		c.AddReplyArrayLen(n * 2)
+	} else {
+		c.AddReplyArrayLen(n)
+	}
+	ln := zs.ByRank(int(start))
+	for i := 0; i < n; i++ {
+		c.AddReplyBulk(ln.Member)
+		if withscores {
+			c.AddReplyBulk(formatScore(ln.Score))
+		}
+		ln = ln.Next()
+	}
+}
+
+// zsetPopElements 弹出分值最小或最大的 count 个成员，集合为空时删除键
+func zsetPopElements(c *RedisClient, key, zobj *obj.RedisObj, where int, count int64) []*obj.ZSkiplistNode {
+	zs := zobj.Val.(*obj.ZSet)
+	var elems []*obj.ZSkiplistNode
+	for count > 0 && zs.Len() > 0 {
+		ln := zs.First()
+		if where == ZSET_MAX {
+			ln = zs.Last()
+		}
+		zs.Remove(ln.Member)
+		elems = append(elems, ln)
+		count--
+	}
+	event := "zpopmin"
+	if where == ZSET_MAX {
+		event = "zpopmax"
+	}
+	notifyKeyspaceEvent(NOTIFY_ZSET, event, key, c.db.id)
+	if zs.Len() == 0 {
+		dbDelete(c.db, key)
+		notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key, c.db.id)
+	}
+	signalModifiedKey(c.db, key)
+	server.dirty += int64(len(elems))
+	return elems
+}
+
+// genericZpopCommand ZPOPMIN/ZPOPMAX key [count]
+func genericZpopCommand(c *RedisClient, where int) {
+	if len(c.args) > 3 {
+		c.AddReplyError("syntax error")
+		return
+	}
+	count := int64(1)
+	if len(c.args) == 3 {
+		var ok bool
+		if count, ok = getRangeLongFromObjectOrReply(c, c.args[2], 0, math.MaxInt64, "value is out of range, must be positive"); !ok {
+			return
+		}
+	}
+	key := c.args[1]
+	zobj := lookupKeyWrite(c.db, key)
+	if zobj == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	if zobj.Type != obj.ZSET {
+		c.AddReplyError(WRONGTYPE_ERR)
+		return
+	}
+	if count == 0 {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	elems := zsetPopElements(c, key, zobj, where, count)
+	c.AddReplyArrayLen(len(elems) * 2)
+	for _, ln := range elems {
+		c.AddReplyBulk(ln.Member)
+		c.AddReplyBulk(formatScore(ln.Score))
+	}
+}
+
+func zpopminCommand(c *RedisClient) {
+	genericZpopCommand(c, ZSET_MIN)
+}
+
+func zpopmaxCommand(c *RedisClient) {
+	genericZpopCommand(c, ZSET_MAX)
+}
+
+// blockingGenericZpopCommand BZPOPMIN/BZPOPMAX key [key ...] timeout
+func blockingGenericZpopCommand(c *RedisClient, where int) {
+	timeout, ok := getTimeoutFromObjectOrReply(c, c.args[len(c.args)-1], UNIT_SECONDS)
+	if !ok {
+		return
+	}
+	keys := c.args[1 : len(c.args)-1]
+	for _, key := range keys {
+		zobj := lookupKeyWrite(c.db, key)
+		if zobj == nil {
+			continue
+		}
+		if zobj.Type != obj.ZSET {
+			c.AddReplyError(WRONGTYPE_ERR)
+			return
+		}
+		ln := zsetPopElements(c, key, zobj, where, 1)[0]
+		c.AddReplyArrayLen(3)
+		c.AddReplyBulk(key.StrVal())
+		c.AddReplyBulk(ln.Member)
+		c.AddReplyBulk(formatScore(ln.Score))
+		name := "ZPOPMIN"
+		if where == ZSET_MAX {
+			name = "ZPOPMAX"
+		}
+		c.args = []*obj.RedisObj{obj.CreateObject(obj.STR, name), key}
+		return
+	}
+	if c.flags&CLIENT_DENY_BLOCKING != 0 {
+		c.AddReplyStr("*-1\r\n")
+		return
+	}
+	blockForKeys(c, BLOCKED_ZSET, keys, timeout, false)
+}
+
+func bzpopminCommand(c *RedisClient) {
+	blockingGenericZpopCommand(c, ZSET_MIN)
+}
+
+func bzpopmaxCommand(c *RedisClient) {
+	blockingGenericZpopCommand(c, ZSET_MAX)
+}
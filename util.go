@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/obj"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// stringMatch glob 风格的模式匹配，支持 * ? [...] 与 \ 转义
+func stringMatch(pattern, str string, nocase bool) bool {
+	p, s := []byte(pattern), []byte(str)
+	if nocase {
+		p, s = toLowerBytes(p), toLowerBytes(s)
+	}
+	return stringMatchImpl(p, s)
+}
+
+func stringMatchImpl(p, s []byte) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if stringMatchImpl(p[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			p = p[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			match := false
+			for len(p) > 0 && p[0] != ']' {
+				if p[0] == '\\' && len(p) >= 2 {
+					p = p[1:]
+					if p[0] == s[0] {
+						match = true
+					}
+				} else if len(p) >= 3 && p[1] == '-' {
+					start, end := p[0], p[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						match = true
+					}
+					p = p[2:]
+				} else if p[0] == s[0] {
+					match = true
+				}
+				p = p[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+			if len(p) == 0 {
+				// 缺少 ']'，视为匹配到模式末尾
+				return len(s) == 0
+			}
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || p[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		p = p[1:]
+	}
+	return len(s) == 0
+}
+
+func toLowerBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// getLongFromObjectOrReply 解析整数参数，失败时回复 msg，msg 为空时使用默认错误
+func getLongFromObjectOrReply(c *RedisClient, o *obj.RedisObj, msg string) (int64, bool) {
+	v, err := strconv.ParseInt(o.StrVal(), 10, 64)
+	if err != nil {
+		if msg == "" {
+			msg = "value is not an integer or out of range"
+		}
+		c.AddReplyError(msg)
+		return 0, false
+	}
+	return v, true
+}
+
+// getRangeLongFromObjectOrReply 解析 [min, max] 范围内的整数参数
+func getRangeLongFromObjectOrReply(c *RedisClient, o *obj.RedisObj, min, max int64, msg string) (int64, bool) {
+	v, err := strconv.ParseInt(o.StrVal(), 10, 64)
+	if err != nil || v < min || v > max {
+		if msg == "" {
+			msg = fmt.Sprintf("value is out of range, must be between %d and %d", min, max)
+		}
+		c.AddReplyError(msg)
+		return 0, false
+	}
+	return v, true
+}
+
+func getDoubleFromObjectOrReply(c *RedisClient, o *obj.RedisObj, msg string) (float64, bool) {
+	v, err := strconv.ParseFloat(o.StrVal(), 64)
+	if err != nil || math.IsNaN(v) {
+		if msg == "" {
+			msg = "value is not a valid float"
+		}
+		c.AddReplyError(msg)
+		return 0, false
+	}
+	return v, true
+}
+
+// memtoll 解析带单位的内存大小，例如 1gb、64mb、100k，k/m/g 为 1000 进制，kb/mb/gb 为 1024 进制
+func memtoll(s string) (int64, error) {
+	orig := s
+	s = strings.ToLower(s)
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSuffix(s, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", orig)
+	}
+	return n * mul, nil
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\v' || b == '\f'
+}
+
+func hexDigitToInt(b byte) (byte, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0', true
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10, true
+	case b >= 'A' && b <= 'F':
+		return b - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// splitArgs 与 redis 的 sdssplitargs 一致，按空白拆分参数，支持双引号中的 \xHH、\n 等转义，
+// 以及单引号中的 \'。引号不配对或者闭合引号后面不是空白时返回错误
+func splitArgs(line []byte) ([]string, error) {
+	var args []string
+	p := 0
+	for {
+		for p < len(line) && isSpace(line[p]) {
+			p++
+		}
+		if p == len(line) {
+			return args, nil
+		}
+		var cur []byte
+		inq, insq, done := false, false, false
+		for !done {
+			if inq {
+				if p == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+				if line[p] == '\\' && p+3 < len(line) && line[p+1] == 'x' {
+					hi, ok1 := hexDigitToInt(line[p+2])
+					lo, ok2 := hexDigitToInt(line[p+3])
+					if ok1 && ok2 {
+						cur = append(cur, hi<<4|lo)
+						p += 4
+						continue
+					}
+				}
+				if line[p] == '\\' && p+1 < len(line) {
+					p++
+					c := line[p]
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					cur = append(cur, c)
+				} else if line[p] == '"' {
+					// 闭合引号后面必须是空白或者结尾
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					done = true
+				} else {
+					cur = append(cur, line[p])
+				}
+			} else if insq {
+				if p == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+				if line[p] == '\\' && p+1 < len(line) && line[p+1] == '\'' {
+					p++
+					cur = append(cur, '\'')
+				} else if line[p] == '\'' {
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					done = true
+				} else {
+					cur = append(cur, line[p])
+				}
+			} else {
+				if p == len(line) {
+					break
+				}
+				switch line[p] {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					cur = append(cur, line[p])
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		args = append(args, string(cur))
+	}
+}
+
+// catRepr 与 redis 的 sdscatrepr 一致，以双引号包裹并转义不可打印的字符，splitArgs 可以解析回原值
+func catRepr(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		default:
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(b, "\\x%02x", c)
+			}
+		}
+	}
+	b.WriteByte('"')
+}